@@ -0,0 +1,139 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const runStatusMetric = "runStatus"
+
+// runStatus records an aborted run, its metrics can be indexed afterwards with the index subcommand using its timestamps
+type runStatus struct {
+	Timestamp    time.Time `json:"timestamp"`
+	EndTimestamp time.Time `json:"endTimestamp"`
+	UUID         string    `json:"uuid"`
+	Status       string    `json:"status"`
+	// Signal aborting the run, or the error of the context of embedded runs
+	Signal     string      `json:"signal"`
+	MetricName string      `json:"metricName"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+// gcFlags are the root flags forwarded to the gc subcommand garbage collecting an aborted run
+var gcFlags = []string{"gc-label-selector", "gc-exclude", "gc-dry-run", "gc-async", "reuse-namespaces", "timeout", "log-level"}
+
+// handleAbort traps SIGINT and SIGTERM until the returned function is called. kube-burner can't be cancelled once started, so on
+// the first signal it calls flush to index the measurements taken so far, indexes an aborted runStatus document and replaces the
+// process with the gc subcommand, which stops the creation of the objects of the run before garbage collecting them. Without
+// garbage collection, it exits with rcAborted. A second signal exits right away. Embedded runs are aborted by their context instead
+func handleAbort(cmd *cobra.Command, wh *workloads.WorkloadHelper, flush func()) func() {
+	// The signals of the process embedding the workload are its own
+	if embeddedRun.enabled {
+		return func() {}
+	}
+	start := time.Now().UTC()
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-sigCh:
+		case <-done:
+			return
+		}
+		log.Warnf("Received %v, aborting the run. Send it again to exit without cleaning up", sig)
+		go func() {
+			<-sigCh
+			log.Warn("Exiting without cleaning up")
+			os.Exit(rcAborted)
+		}()
+		flush()
+		indexAbortedRun(wh, start, sig.String())
+		StopProgressExporter()
+		StopTracing()
+		if gc, _ := cmd.Root().PersistentFlags().GetBool("gc"); gc {
+			err := execGC(cmd, wh.UUID)
+			log.Errorf("Error garbage collecting the run, its resources can be deleted with kube-burner-ocp gc --uuid=%s: %v", wh.UUID, err)
+		}
+		os.Exit(rcAborted)
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// execGC replaces the process with the gc subcommand deleting the resources of the given run, which exits with rcAborted.
+// It only returns on error
+func execGC(cmd *cobra.Command, uuid string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{executable, "gc", "--uuid=" + uuid, fmt.Sprintf("--exit-code=%d", rcAborted)}
+	for _, name := range gcFlags {
+		flag := cmd.Root().PersistentFlags().Lookup(name)
+		if !flag.Changed {
+			continue
+		}
+		value := flag.Value.String()
+		if flag.Value.Type() == "stringSlice" {
+			values, _ := cmd.Root().PersistentFlags().GetStringSlice(name)
+			value = strings.Join(values, ",")
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", name, value))
+	}
+	log.Infof("Stopping kube-burner and garbage collecting the run")
+	return syscall.Exec(executable, args, os.Environ())
+}
+
+// abortEmbeddedRun aborts an embedded run whose context is done once kube-burner stopped, calling flush to index the measurements
+// taken so far, indexing an aborted runStatus document and garbage collecting the resources of the run when enabled
+func abortEmbeddedRun(cmd *cobra.Command, wh *workloads.WorkloadHelper, start time.Time, flush func(), reason error) int {
+	log.Warnf("Aborting the run: %v", reason)
+	flush()
+	indexAbortedRun(wh, start, reason.Error())
+	if gc, _ := cmd.Root().PersistentFlags().GetBool("gc"); gc {
+		ctx, cancel := context.WithTimeout(context.Background(), wh.Timeout)
+		garbageCollect(ctx, "kube-burner-uuid="+wh.UUID, gcOptionsFromFlags(cmd))
+		cancel()
+	}
+	return rcAborted
+}
+
+// indexAbortedRun indexes the runStatus document of a run started at the given time and aborted now
+func indexAbortedRun(wh *workloads.WorkloadHelper, start time.Time, reason string) {
+	indexDocuments(runStatusMetric, []interface{}{runStatus{
+		Timestamp:    start,
+		EndTimestamp: time.Now().UTC(),
+		UUID:         wh.UUID,
+		Status:       "aborted",
+		Signal:       reason,
+		MetricName:   runStatusMetric,
+		Metadata:     wh.MetricsMetadata,
+	}})
+}
@@ -0,0 +1,318 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	aclConvergenceMetric          = "aclConvergenceMeasurement"
+	aclConvergenceQuantilesMetric = "aclConvergenceQuantilesMeasurement"
+	// Resolution of the convergence latencies
+	aclPollInterval = 2 * time.Second
+	// Lists the names of the network policies with ACLs in the northbound database of the node, then waits until the
+	// ovn-controller of the node programmed the northbound database contents, so the listed ACLs are programmed once it returns
+	aclCommand = `ovn-nbctl --no-leader-only --format=csv --no-headings --columns=external_ids find acl 'external_ids:"k8s.ovn.org/owner-type"=NetworkPolicy' && ovn-nbctl --no-leader-only --timeout=30 --wait=hv sync`
+)
+
+// aclPolicyName matches the namespace:name of the network policy owning an ACL in its external IDs, with CSV quoting
+var aclPolicyName = regexp.MustCompile(`k8s\.ovn\.org/name"*=+"*([^"\s,}]+)`)
+
+// aclConvergence holds the convergence of the network policies of a namespace, an iteration of the network policy workloads, with
+// the latencies in milliseconds since its first network policy was created
+type aclConvergence struct {
+	Timestamp          time.Time   `json:"timestamp"`
+	UUID               string      `json:"uuid"`
+	Namespace          string      `json:"namespace"`
+	NetworkPolicies    int         `json:"networkPolicies"`
+	Nodes              int         `json:"nodes"`
+	Converged          bool        `json:"converged"`
+	CreationLatency    int         `json:"creationLatency"`
+	ConvergenceLatency int         `json:"convergenceLatency"`
+	MetricName         string      `json:"metricName"`
+	JobName            string      `json:"jobName,omitempty"`
+	Metadata           interface{} `json:"metadata,omitempty"`
+}
+
+// netpolTimestamps holds when a network policy was created, and when its ACLs were programmed in each node
+type netpolTimestamps struct {
+	namespace, jobName string
+	created            time.Time
+	programmed         map[string]time.Time
+}
+
+type aclConvergenceWatcher struct {
+	clientSet  kubernetes.Interface
+	restConfig *rest.Config
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+	// policies holds the network policies with rules, the ones without rules, like deny-all, have no ACLs of their own
+	policies map[string]*netpolTimestamps
+	// nodes holds the nodes polled in the last round
+	nodes []string
+	mu    sync.Mutex
+}
+
+// startACLConvergenceWatcher watches the network policies created by the run with the given UUID, and polls the ACLs
+// programmed in each node to record when the ones of each network policy were programmed in all of them
+func startACLConvergenceWatcher(uuid string) *aclConvergenceWatcher {
+	clientSet, restConfig := newClientSet()
+	acw := &aclConvergenceWatcher{
+		clientSet:  clientSet,
+		restConfig: restConfig,
+		stopCh:     make(chan struct{}),
+		policies:   make(map[string]*netpolTimestamps),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	_, controller := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.NetworkingV1().RESTClient(), "networkpolicies", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &networkingv1.NetworkPolicy{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				acw.handleNetworkPolicy(obj.(*networkingv1.NetworkPolicy))
+			},
+		},
+	})
+	log.Infof("Watching ACL convergence, polling every %v", aclPollInterval)
+	go controller.Run(acw.stopCh)
+	acw.wg.Add(1)
+	go acw.run()
+	return acw
+}
+
+func (acw *aclConvergenceWatcher) handleNetworkPolicy(np *networkingv1.NetworkPolicy) {
+	if len(np.Spec.Ingress) == 0 && len(np.Spec.Egress) == 0 {
+		return
+	}
+	acw.mu.Lock()
+	defer acw.mu.Unlock()
+	key := np.Namespace + ":" + np.Name
+	// Churned network policies are created again with the same name
+	if pt, exists := acw.policies[key]; exists && !np.CreationTimestamp.After(pt.created) {
+		return
+	}
+	acw.policies[key] = &netpolTimestamps{
+		namespace:  np.Namespace,
+		jobName:    np.Labels["kube-burner-job"],
+		created:    np.CreationTimestamp.Time,
+		programmed: make(map[string]time.Time),
+	}
+}
+
+func (acw *aclConvergenceWatcher) run() {
+	defer acw.wg.Done()
+	ticker := time.NewTicker(aclPollInterval)
+	defer ticker.Stop()
+	for {
+		acw.poll()
+		select {
+		case <-ticker.C:
+		case <-acw.stopCh:
+			return
+		}
+	}
+}
+
+// poll records the network policies whose ACLs are programmed in each node
+func (acw *aclConvergenceWatcher) poll() {
+	pods, err := acw.clientSet.CoreV1().Pods("openshift-ovn-kubernetes").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "app=ovnkube-node",
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		log.Errorf("Error listing ovnkube-node pods: %v", err)
+		return
+	}
+	var nodes []string
+	var wg sync.WaitGroup
+	for _, pod := range pods.Items {
+		nodes = append(nodes, pod.Spec.NodeName)
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			out, err := execInPod(acw.clientSet, acw.restConfig, pod, "nbdb", "/bin/sh", "-c", aclCommand)
+			if err != nil {
+				log.Debugf("Error listing the ACLs of node %s: %v", pod.Spec.NodeName, err)
+				return
+			}
+			programmed := time.Now().UTC()
+			acw.mu.Lock()
+			defer acw.mu.Unlock()
+			for _, policy := range aclPolicies(out) {
+				pt, exists := acw.policies[policy]
+				if !exists || !pt.programmed[pod.Spec.NodeName].IsZero() || programmed.Before(pt.created) {
+					continue
+				}
+				pt.programmed[pod.Spec.NodeName] = programmed
+			}
+		}(pod)
+	}
+	wg.Wait()
+	acw.mu.Lock()
+	acw.nodes = nodes
+	acw.mu.Unlock()
+}
+
+// converged returns the time when the ACLs of the given network policy were programmed in all the polled nodes
+func (acw *aclConvergenceWatcher) converged(pt *netpolTimestamps) (time.Time, bool) {
+	var convergence time.Time
+	for _, node := range acw.nodes {
+		programmed, ok := pt.programmed[node]
+		if !ok {
+			return time.Time{}, false
+		}
+		if programmed.After(convergence) {
+			convergence = programmed
+		}
+	}
+	return convergence, len(acw.nodes) > 0
+}
+
+// wait waits until the ACLs of all the network policies are programmed in all the nodes, or the timeout expires
+func (acw *aclConvergenceWatcher) wait(timeout time.Duration) {
+	log.Infof("Waiting up to %v for the ACLs of the network policies to be programmed", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		var pending int
+		acw.mu.Lock()
+		for _, pt := range acw.policies {
+			if _, ok := acw.converged(pt); !ok {
+				pending++
+			}
+		}
+		total := len(acw.policies)
+		acw.mu.Unlock()
+		if pending == 0 {
+			log.Infof("ACLs of the %d network policies programmed", total)
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Errorf("ACLs of %d/%d network policies not programmed after %v", pending, total, timeout)
+			return
+		}
+		time.Sleep(aclPollInterval)
+	}
+}
+
+// stop stops polling and indexes the ACL convergence of each namespace, along with their quantiles per job
+func (acw *aclConvergenceWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(acw.stopCh)
+	acw.wg.Wait()
+	acw.mu.Lock()
+	defer acw.mu.Unlock()
+	if len(acw.policies) == 0 {
+		log.Warn("No network policies with ACLs found")
+		return
+	}
+	namespaces := make(map[string]*aclConvergence)
+	firstCreated := make(map[string]time.Time)
+	lastCreated := make(map[string]time.Time)
+	convergence := make(map[string]time.Time)
+	for _, key := range sortedKeys(acw.policies) {
+		pt := acw.policies[key]
+		ac, exists := namespaces[pt.namespace]
+		if !exists {
+			ac = &aclConvergence{
+				UUID:       wh.UUID,
+				Namespace:  pt.namespace,
+				Nodes:      len(acw.nodes),
+				Converged:  true,
+				MetricName: aclConvergenceMetric,
+				JobName:    pt.jobName,
+				Metadata:   wh.MetricsMetadata,
+			}
+			namespaces[pt.namespace] = ac
+			firstCreated[pt.namespace] = pt.created
+		}
+		ac.NetworkPolicies++
+		if pt.created.Before(firstCreated[pt.namespace]) {
+			firstCreated[pt.namespace] = pt.created
+		}
+		if pt.created.After(lastCreated[pt.namespace]) {
+			lastCreated[pt.namespace] = pt.created
+		}
+		converged, ok := acw.converged(pt)
+		ac.Converged = ac.Converged && ok
+		if converged.After(convergence[pt.namespace]) {
+			convergence[pt.namespace] = converged
+		}
+	}
+	var docs, quantiles []interface{}
+	var jobNames []string
+	var failed int
+	latencies := make(map[string]map[string][]float64)
+	for _, namespace := range sortedKeys(namespaces) {
+		ac := namespaces[namespace]
+		ac.Timestamp = firstCreated[namespace].UTC()
+		// Creation timestamps have a resolution of seconds
+		ac.CreationLatency = int(lastCreated[namespace].Sub(firstCreated[namespace]).Milliseconds())
+		if _, exists := latencies[ac.JobName]; !exists {
+			jobNames = append(jobNames, ac.JobName)
+			latencies[ac.JobName] = make(map[string][]float64)
+		}
+		latencies[ac.JobName]["Created"] = append(latencies[ac.JobName]["Created"], float64(ac.CreationLatency))
+		if ac.Converged {
+			ac.ConvergenceLatency = max(int(convergence[namespace].Sub(firstCreated[namespace]).Milliseconds()), 0)
+			latencies[ac.JobName]["Converged"] = append(latencies[ac.JobName]["Converged"], float64(ac.ConvergenceLatency))
+		} else {
+			failed++
+		}
+		docs = append(docs, ac)
+	}
+	if failed > 0 {
+		log.Warnf("ACLs of %d/%d namespaces didn't converge", failed, len(docs))
+	}
+	for _, jobName := range jobNames {
+		for _, condition := range []string{"Created", "Converged"} {
+			if len(latencies[jobName][condition]) == 0 {
+				continue
+			}
+			q := metrics.NewLatencySummary(latencies[jobName][condition], condition)
+			q.UUID = wh.UUID
+			q.MetricName = aclConvergenceQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(aclConvergenceMetric, docs)
+	indexDocuments(aclConvergenceQuantilesMetric, quantiles)
+}
+
+// aclPolicies returns the namespace:name of the network policies owning the ACLs in the given output of aclCommand
+func aclPolicies(out string) []string {
+	var policies []string
+	for _, match := range aclPolicyName.FindAllStringSubmatch(out, -1) {
+		policies = append(policies, strings.Trim(match[1], `\`))
+	}
+	return policies
+}
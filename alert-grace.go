@@ -0,0 +1,105 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/util/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+const alertGracePeriodJob = "alert-grace-period"
+
+// evaluateAlertGracePeriod waits for the grace period and evaluates the alert profiles of the workload from the end of the run.
+// Returns true when any alert with error severity fired
+func evaluateAlertGracePeriod(wh *workloads.WorkloadHelper, runEnd time.Time, gracePeriod time.Duration) bool {
+	defer StartSpan("alert-grace-period")()
+	var alertFired bool
+	configSpec := workloads.ConfigSpec
+	metricsEndpoints := workloads.ConfigSpec.MetricsEndpoints
+	if wh.MetricsEndpoint != "" {
+		metricsEndpoints = metrics.DecodeMetricsEndpoint(wh.MetricsEndpoint)
+	}
+	configSpec.MetricsEndpoints = make([]config.MetricsEndpoint, len(metricsEndpoints))
+	copy(configSpec.MetricsEndpoints, metricsEndpoints)
+	// The local indexer would overwrite the alerts of the run, so they're written to a temporary directory and merged afterwards
+	localDirectories := make(map[string]string)
+	for i, endpoint := range configSpec.MetricsEndpoints {
+		configSpec.MetricsEndpoints[i].Metrics = nil
+		if endpoint.Type == indexers.LocalIndexer {
+			tmpDir, err := os.MkdirTemp("", "alert-grace-period-")
+			if err != nil {
+				log.Error(err.Error())
+				return false
+			}
+			defer os.RemoveAll(tmpDir)
+			localDirectories[tmpDir] = endpoint.MetricsDirectory
+			configSpec.MetricsEndpoints[i].MetricsDirectory = tmpDir
+		}
+	}
+	metricsScraper := metrics.ProcessMetricsScraperConfig(metrics.ScraperConfig{
+		ConfigSpec:      &configSpec,
+		MetricsMetadata: wh.MetricsMetadata,
+	})
+	if len(metricsScraper.AlertMs) == 0 {
+		return false
+	}
+	log.Infof("⏳ Waiting %v to evaluate alerts after the workload", gracePeriod)
+	time.Sleep(gracePeriod)
+	job := prometheus.Job{
+		Start:     runEnd,
+		End:       time.Now(),
+		JobConfig: config.Job{Name: alertGracePeriodJob},
+	}
+	for _, alertM := range metricsScraper.AlertMs {
+		if err := alertM.Evaluate(job); err != nil {
+			log.Error(err.Error())
+			alertFired = true
+		}
+	}
+	for tmpDir, metricsDirectory := range localDirectories {
+		if err := mergeAlerts(path.Join(tmpDir, "alert.json"), path.Join(metricsDirectory, "alert.json")); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	return alertFired
+}
+
+// mergeAlerts appends the alerts of the source file to the destination file
+func mergeAlerts(src, dst string) error {
+	var srcAlerts, dstAlerts []interface{}
+	if err := readDocuments(src, &srcAlerts); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := readDocuments(dst, &dstAlerts); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := json.Marshal(append(dstAlerts, srcAlerts...))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// alertProfile mirrors the kube-burner alert profile format
+type alertProfile []struct {
+	Expr        string `yaml:"expr"`
+	Description string `yaml:"description"`
+	Severity    string `yaml:"severity"`
+}
+
+// kube-burner fails the run on error alerts, and exits immediately on critical ones
+var alertSeverities = map[string]int{
+	"warning":  1,
+	"error":    2,
+	"critical": 3,
+}
+
+// validateAlertSeverity returns an error when the given minimum alert severity isn't supported
+func validateAlertSeverity(severity string) error {
+	if _, ok := alertSeverities[severity]; !ok {
+		return fmt.Errorf("unsupported alert severity %s, supported values are: warning, error or critical", severity)
+	}
+	return nil
+}
+
+// gateAlerts returns the return code of the run gated at the given minimum alert severity. kube-burner evaluates the alert
+// profiles as they are, so their alerts are indexed with their own severity, and the return code is adjusted afterwards:
+// with critical, the alert return code is cleared when the error alerts of the given profiles are its only cause, and with
+// warning, the run fails when any warning alert of the given profiles fired between start and end
+func gateAlerts(wh *workloads.WorkloadHelper, rc int, severity string, alertProfiles []string, start, end time.Time) int {
+	switch severity {
+	case "critical":
+		if rc != rcAlert {
+			return rc
+		}
+		// The alert profiles of a metrics endpoints file replace the ones of the workload
+		if wh.MetricsEndpoint != "" {
+			log.Info("Error alerts fired from the alert profiles of the metrics endpoints, they still make the run fail")
+			return rc
+		}
+		// kube-burner returns the alert return code when measurements failed too
+		exceeded, err := measurementThresholdsExceeded()
+		if err != nil {
+			log.Errorf("Error evaluating the measurement thresholds, keeping the alert return code: %v", err)
+			return rc
+		}
+		if exceeded {
+			return rcMeasurement
+		}
+		log.Info("Error alerts fired, they don't make the run fail as the minimum alert severity is critical")
+		return 0
+	case "warning":
+		if rc != 0 {
+			return rc
+		}
+		fired, err := warningAlertsFired(wh, alertProfiles, start, end)
+		if err != nil {
+			log.Errorf("Error evaluating the warning alerts: %v", err)
+		} else if fired {
+			return rcAlert
+		}
+	}
+	return rc
+}
+
+// warningAlertsFired returns whether any warning alert of the given profiles fired between start and end
+func warningAlertsFired(wh *workloads.WorkloadHelper, alertProfiles []string, start, end time.Time) (bool, error) {
+	p, err := newPrometheusClient(wh)
+	if err != nil {
+		return false, err
+	}
+	// Rendered like kube-burner does
+	vars := util.EnvToMap()
+	vars["elapsed"] = fmt.Sprintf("%dm", int(end.Sub(start).Minutes()))
+	var fired bool
+	for _, profile := range alertProfiles {
+		var alerts alertProfile
+		f, err := util.GetReader(profile, &ocpConfig, configDir)
+		if err != nil {
+			return false, err
+		}
+		if err := yaml.NewDecoder(f).Decode(&alerts); err != nil {
+			return false, fmt.Errorf("error decoding alert profile %s: %v", profile, err)
+		}
+		for _, alert := range alerts {
+			if alert.Severity != "warning" {
+				continue
+			}
+			t, err := template.New("").Parse(alert.Expr)
+			if err != nil {
+				return false, fmt.Errorf("error parsing alert expression %s: %v", alert.Expr, err)
+			}
+			var expr bytes.Buffer
+			if err := t.Execute(&expr, vars); err != nil {
+				return false, fmt.Errorf("error rendering alert expression %s: %v", alert.Expr, err)
+			}
+			v, err := p.QueryRange(expr.String(), start, end, 30*time.Second)
+			if err != nil {
+				log.Warnf("Error performing query %s: %v", expr.String(), err)
+				continue
+			}
+			if matrix, ok := v.(model.Matrix); ok && len(matrix) > 0 {
+				log.Errorf("Warning alert %s fired, failing the run as the minimum alert severity is warning", expr.String())
+				fired = true
+			}
+		}
+	}
+	return fired, nil
+}
+
+// measurementThresholdsExceeded returns whether any latency quantile of the run exceeds the thresholds of its measurement,
+// evaluated like kube-burner does from the results of the local indexer
+func measurementThresholdsExceeded() (bool, error) {
+	thresholds := make(map[string][]mtypes.LatencyThreshold)
+	for _, measurement := range workloads.ConfigSpec.GlobalConfig.Measurements {
+		if len(measurement.LatencyThresholds) > 0 {
+			thresholds[measurement.Name+"QuantilesMeasurement"] = measurement.LatencyThresholds
+		}
+	}
+	if len(thresholds) == 0 {
+		return false, nil
+	}
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return false, fmt.Errorf("measurement thresholds can't be evaluated without a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return false, err
+	}
+	quantiles := make(map[string][]interface{})
+	for _, q := range results.latencyQuantiles {
+		quantiles[q.MetricName] = append(quantiles[q.MetricName], q)
+	}
+	var exceeded bool
+	for metricName, latencyThresholds := range thresholds {
+		if err := metrics.CheckThreshold(latencyThresholds, quantiles[metricName]); err != nil {
+			log.Error(err.Error())
+			exceeded = true
+		}
+	}
+	return exceeded, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+)
+
+func TestGateAlertsCritical(t *testing.T) {
+	defer func(configSpec config.Spec) { workloads.ConfigSpec = configSpec }(workloads.ConfigSpec)
+	podLatency := []mtypes.Measurement{{
+		Name:              "podLatency",
+		LatencyThresholds: []mtypes.LatencyThreshold{{ConditionType: "Ready", Metric: "P99", Threshold: 15 * time.Second}},
+	}}
+	tests := []struct {
+		name            string
+		rc              int
+		metricsEndpoint string
+		measurements    []mtypes.Measurement
+		localIndexing   bool
+		readyP99        int
+		want            int
+	}{
+		{
+			name:          "error alerts",
+			rc:            rcAlert,
+			localIndexing: true,
+			want:          0,
+		},
+		{
+			name:          "error alerts with the measurement thresholds met",
+			rc:            rcAlert,
+			measurements:  podLatency,
+			localIndexing: true,
+			readyP99:      10000,
+			want:          0,
+		},
+		{
+			name:          "error alerts masking a failed measurement",
+			rc:            rcAlert,
+			measurements:  podLatency,
+			localIndexing: true,
+			readyP99:      20000,
+			want:          rcMeasurement,
+		},
+		{
+			name:         "error alerts with measurement thresholds and no local indexer",
+			rc:           rcAlert,
+			measurements: podLatency,
+			want:         rcAlert,
+		},
+		{
+			name:            "error alerts of the metrics endpoints",
+			rc:              rcAlert,
+			metricsEndpoint: "metrics-endpoints.yml",
+			localIndexing:   true,
+			want:            rcAlert,
+		},
+		{
+			name:          "other return codes",
+			rc:            rcSLO,
+			localIndexing: true,
+			want:          rcSLO,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workloads.ConfigSpec = config.Spec{GlobalConfig: config.GlobalConfig{Measurements: tt.measurements}}
+			if tt.localIndexing {
+				metricsDirectory := t.TempDir()
+				workloads.ConfigSpec.MetricsEndpoints = []config.MetricsEndpoint{{
+					IndexerConfig: indexers.IndexerConfig{Type: indexers.LocalIndexer, MetricsDirectory: metricsDirectory},
+				}}
+				writeDocuments(t, path.Join(metricsDirectory, "jobSummary.json"), []interface{}{})
+				writeDocuments(t, path.Join(metricsDirectory, "podLatencyQuantilesMeasurement-cluster-density-v2.json"), []metrics.LatencyQuantiles{{
+					QuantileName: "Ready",
+					P99:          tt.readyP99,
+					MetricName:   "podLatencyQuantilesMeasurement",
+					JobName:      "cluster-density-v2",
+				}})
+			}
+			wh := &workloads.WorkloadHelper{Config: workloads.Config{MetricsEndpoint: tt.metricsEndpoint}}
+			if got := gateAlerts(wh, tt.rc, "critical", nil, time.Time{}, time.Time{}); got != tt.want {
+				t.Errorf("got return code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeDocuments(t *testing.T, fileName string, documents interface{}) {
+	t.Helper()
+	data, err := json.Marshal(documents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	annotationMetric = "annotation"
+	// Key of the annotations written to the annotation file without one
+	defaultAnnotationKey = "note"
+	annotationPollPeriod = time.Second
+)
+
+// annotation is a note attached to a point of the timeline of the run, to explain what's seen in the dashboards
+type annotation struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	Key        string      `json:"key"`
+	Value      string      `json:"value"`
+	Source     string      `json:"source"`
+	MetricName string      `json:"metricName"`
+	JobName    string      `json:"jobName,omitempty"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+type annotationWatcher struct {
+	file        string
+	offset      int64
+	annotations []annotation
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+}
+
+// parseAnnotation parses an annotation in key=value format, the text without a key is the value of a note
+func parseAnnotation(text string) (string, string) {
+	key, value, found := strings.Cut(text, "=")
+	if !found || strings.ContainsAny(strings.TrimSpace(key), " \t") {
+		return defaultAnnotationKey, strings.TrimSpace(text)
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value)
+}
+
+// startAnnotationWatcher records the annotations given by --annotation at the start of the run, and the lines appended to the
+// given file during the run, timestamped when they're read. The content of the file before the run is ignored
+func startAnnotationWatcher(annotations []string, file string) (*annotationWatcher, error) {
+	aw := &annotationWatcher{
+		file:   file,
+		stopCh: make(chan struct{}),
+	}
+	now := time.Now().UTC()
+	for _, text := range annotations {
+		key, value, _ := strings.Cut(text, "=")
+		if key, value = strings.TrimSpace(key), strings.TrimSpace(value); key == "" || value == "" {
+			return nil, fmt.Errorf("invalid annotation %q, expected format is key=value", text)
+		}
+		aw.add(now, key, value, "flag")
+	}
+	if file == "" {
+		return aw, nil
+	}
+	if info, err := os.Stat(file); err == nil {
+		aw.offset = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading annotation file: %v", err)
+	}
+	log.Infof("Watching %s for annotations", file)
+	aw.wg.Add(1)
+	go func() {
+		defer aw.wg.Done()
+		ticker := time.NewTicker(annotationPollPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-aw.stopCh:
+				aw.read()
+				return
+			case <-ticker.C:
+				aw.read()
+			}
+		}
+	}()
+	return aw, nil
+}
+
+func (aw *annotationWatcher) add(timestamp time.Time, key, value, source string) {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	log.Infof("Annotation %s: %s", key, value)
+	aw.annotations = append(aw.annotations, annotation{
+		Timestamp: timestamp,
+		Key:       key,
+		Value:     value,
+		Source:    source,
+	})
+}
+
+// read records the complete lines appended to the annotation file since the last read, a truncated file is read from the start
+func (aw *annotationWatcher) read() {
+	f, err := os.Open(aw.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() < aw.offset {
+		aw.offset = 0
+	}
+	if _, err := f.Seek(aw.offset, io.SeekStart); err != nil {
+		return
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	// A line still being written is read once it ends
+	end := strings.LastIndex(string(data), "\n")
+	if end == -1 {
+		return
+	}
+	aw.offset += int64(end + 1)
+	now := time.Now().UTC()
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value := parseAnnotation(line)
+		aw.add(now, key, value, "file")
+	}
+}
+
+// stop stops watching the annotation file and indexes the annotations, along with the job running when they were made
+func (aw *annotationWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(aw.stopCh)
+	aw.wg.Wait()
+	if len(aw.annotations) == 0 {
+		return
+	}
+	var jobSummaries []burner.JobSummary
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		readDocuments(path.Join(metricsDirectory, "jobSummary.json"), &jobSummaries)
+	}
+	var docs []interface{}
+	for _, a := range aw.annotations {
+		a.UUID = wh.UUID
+		a.MetricName = annotationMetric
+		a.JobName = jobAt(jobSummaries, a.Timestamp)
+		a.Metadata = wh.MetricsMetadata
+		docs = append(docs, a)
+	}
+	indexDocuments(annotationMetric, docs)
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const apiRequestLatencyMetric = "apiRequestLatencyQuantilesMeasurement"
+
+// apiRequestSelector excludes long running requests, whose duration isn't representative of the API server performance
+const apiRequestSelector = `apiserver="kube-apiserver",verb!~"WATCH|CONNECT",subresource!~"log|exec|portforward|attach|proxy"`
+
+// measureAPIRequestLatency computes the API request latency quantiles of each job by verb and resource,
+// from the kube-apiserver request duration histograms increase during the job
+func measureAPIRequestLatency(wh *workloads.WorkloadHelper) error {
+	defer StartSpan("api-request-latency")()
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return fmt.Errorf("API request latency measurement requires a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return err
+	}
+	p, err := newPrometheusClient(wh)
+	if err != nil {
+		return err
+	}
+	var docs []interface{}
+	for _, jobSummary := range results.jobSummaries {
+		// Prometheus requires a range of at least one scrape interval
+		window := fmt.Sprintf("%ds", int(math.Max(jobSummary.EndTimestamp.Sub(jobSummary.Timestamp).Seconds(), 60)))
+		quantiles := make(map[string]*metrics.LatencyQuantiles)
+		queries := map[string]string{
+			"P50": fmt.Sprintf(`histogram_quantile(0.5, sum(increase(apiserver_request_duration_seconds_bucket{%s}[%s])) by (le, verb, resource))`, apiRequestSelector, window),
+			"P95": fmt.Sprintf(`histogram_quantile(0.95, sum(increase(apiserver_request_duration_seconds_bucket{%s}[%s])) by (le, verb, resource))`, apiRequestSelector, window),
+			"P99": fmt.Sprintf(`histogram_quantile(0.99, sum(increase(apiserver_request_duration_seconds_bucket{%s}[%s])) by (le, verb, resource))`, apiRequestSelector, window),
+			"avg": fmt.Sprintf(`sum(increase(apiserver_request_duration_seconds_sum{%[1]s}[%[2]s])) by (verb, resource) / sum(increase(apiserver_request_duration_seconds_count{%[1]s}[%[2]s])) by (verb, resource)`, apiRequestSelector, window),
+		}
+		for stat, query := range queries {
+			v, err := p.Query(query, jobSummary.EndTimestamp)
+			if err != nil {
+				return fmt.Errorf("error querying API request latency: %v", err)
+			}
+			for _, sample := range v.(model.Vector) {
+				value := float64(sample.Value)
+				// Requests not issued during the job produce NaN values
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					continue
+				}
+				name := fmt.Sprintf("%s %s", sample.Metric["verb"], sample.Metric["resource"])
+				q, exists := quantiles[name]
+				if !exists {
+					q = &metrics.LatencyQuantiles{
+						QuantileName: name,
+						UUID:         wh.UUID,
+						Timestamp:    jobSummary.EndTimestamp,
+						MetricName:   apiRequestLatencyMetric,
+						JobName:      jobSummary.JobConfig.Name,
+						Metadata:     wh.MetricsMetadata,
+					}
+					quantiles[name] = q
+				}
+				ms := int(value * float64(time.Second/time.Millisecond))
+				switch stat {
+				case "P50":
+					q.P50 = ms
+				case "P95":
+					q.P95 = ms
+				case "P99":
+					q.P99 = ms
+				case "avg":
+					q.Avg = ms
+				}
+			}
+		}
+		for _, q := range quantiles {
+			docs = append(docs, *q)
+		}
+	}
+	log.Infof("Indexing API request latency of %d verb and resource combinations", len(docs))
+	indexDocuments(apiRequestLatencyMetric, docs)
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Files of the artifacts directory
+const (
+	artifactsLogFile     = "kube-burner-ocp.log"
+	artifactsResultFile  = "result.json"
+	artifactsJUnitFile   = "junit.xml"
+	artifactsHTMLFile    = "report.html"
+	artifactsConfigDir   = "config"
+	artifactsMetricsFile = "metrics.tar.gz"
+)
+
+// artifactJob is the outcome of a job in result.json
+type artifactJob struct {
+	Name         string    `json:"name"`
+	Iterations   int       `json:"iterations"`
+	Passed       bool      `json:"passed"`
+	Timestamp    time.Time `json:"timestamp"`
+	EndTimestamp time.Time `json:"endTimestamp"`
+	ElapsedTime  float64   `json:"elapsedTime"`
+}
+
+// artifactResult is the outcome of the run written to result.json
+type artifactResult struct {
+	UUID         string        `json:"uuid"`
+	Workload     string        `json:"workload"`
+	RC           int           `json:"rc"`
+	Passed       bool          `json:"passed"`
+	Timestamp    time.Time     `json:"timestamp"`
+	EndTimestamp time.Time     `json:"endTimestamp"`
+	ElapsedTime  float64       `json:"elapsedTime"`
+	Jobs         []artifactJob `json:"jobs"`
+	Alerts       int           `json:"alerts"`
+	SLOs         []sloResult   `json:"slos,omitempty"`
+	Metadata     interface{}   `json:"metadata,omitempty"`
+}
+
+// artifactsLog is the log file of the run in the artifacts directory
+var artifactsLog *os.File
+
+// setupArtifactsLogging writes the log of the run to the artifacts directory, along with the standard output
+func setupArtifactsLogging(artifactsDir string) error {
+	if err := os.MkdirAll(artifactsDir, 0755); err != nil {
+		return fmt.Errorf("error creating artifacts directory: %v", err)
+	}
+	f, err := os.Create(path.Join(artifactsDir, artifactsLogFile))
+	if err != nil {
+		return fmt.Errorf("error creating log file: %v", err)
+	}
+	artifactsLog = f
+	log.SetOutput(io.MultiWriter(os.Stdout, f))
+	return nil
+}
+
+// closeArtifactsLogging closes the log file of the run once it finishes, logging to the standard output only from then on
+func closeArtifactsLogging() {
+	if artifactsLog == nil {
+		return
+	}
+	log.SetOutput(os.Stdout)
+	if err := artifactsLog.Close(); err != nil {
+		log.Errorf("Error closing log file: %v", err)
+	}
+	artifactsLog = nil
+}
+
+// writeArtifacts writes result.json, junit.xml, the rendered configuration and the metrics tarball of the run to the artifacts directory.
+// The log and the requested reports are written there as the run goes
+func writeArtifacts(cmd *cobra.Command, wh *workloads.WorkloadHelper, artifactsDir string, rc int, start time.Time, sloResults []sloResult) error {
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return fmt.Errorf("artifacts require a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	result := artifactResult{
+		UUID:         wh.UUID,
+		Workload:     cmd.Name(),
+		RC:           rc,
+		Passed:       rc == 0,
+		Timestamp:    start,
+		EndTimestamp: time.Now().UTC(),
+		Alerts:       len(results.alerts),
+		SLOs:         sloResults,
+		Metadata:     wh.SummaryMetadata,
+	}
+	result.ElapsedTime = result.EndTimestamp.Sub(start).Round(time.Second).Seconds()
+	for _, jobSummary := range results.jobSummaries {
+		result.Jobs = append(result.Jobs, artifactJob{
+			Name:         jobSummary.JobConfig.Name,
+			Iterations:   jobSummary.JobConfig.JobIterations,
+			Passed:       jobSummary.Passed,
+			Timestamp:    jobSummary.Timestamp,
+			EndTimestamp: jobSummary.EndTimestamp,
+			ElapsedTime:  jobSummary.ElapsedTime,
+		})
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path.Join(artifactsDir, artifactsResultFile), data, 0644)
+	}
+	errs = append(errs, err)
+	// Already written when requested as a report
+	if reports, _ := cmd.Root().PersistentFlags().GetStringSlice("report"); !slices.Contains(reports, "junit") {
+		errs = append(errs, writeJUnitReport(cmd.Name(), wh, results, sloResults, path.Join(artifactsDir, artifactsJUnitFile)))
+	}
+	errs = append(errs, writeRenderedConfig(path.Join(artifactsDir, artifactsConfigDir)))
+	errs = append(errs, archiveDirectory(metricsDirectory, path.Join(artifactsDir, artifactsMetricsFile)))
+	log.Infof("Artifacts written to %s", artifactsDir)
+	return errors.Join(errs...)
+}
+
+// writeRenderedConfig writes the configuration of the workload as rendered by kube-burner, without credentials,
+// along with its object templates and profiles
+func writeRenderedConfig(configDirectory string) error {
+	if err := os.MkdirAll(configDirectory, 0755); err != nil {
+		return err
+	}
+	spec := workloads.ConfigSpec
+	spec.EmbedFS = nil
+	spec.MetricsEndpoints = nil
+	for _, endpoint := range workloads.ConfigSpec.MetricsEndpoints {
+		endpoint.Token, endpoint.Password = "", ""
+		if target, ok := metricsEndpointTargets[endpoint.Endpoint]; ok {
+			endpoint.Endpoint = target
+		}
+		// The servers may embed credentials, from --es-credentials too
+		servers := endpoint.Servers
+		endpoint.Servers = nil
+		for _, server := range servers {
+			if u, err := url.Parse(server); err == nil {
+				server = u.Redacted()
+			}
+			endpoint.Servers = append(endpoint.Servers, server)
+		}
+		spec.MetricsEndpoints = append(spec.MetricsEndpoints, endpoint)
+	}
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(configDirectory, "config.yml"), data, 0644); err != nil {
+		return err
+	}
+	if workloads.ConfigSpec.EmbedFS == nil {
+		return nil
+	}
+	return copyEmbeddedConfig(workloads.ConfigSpec, configDirectory)
+}
+
+// copyEmbeddedConfig copies the embedded directory of the workload, with its object templates
+func copyEmbeddedConfig(spec config.Spec, configDirectory string) error {
+	return fs.WalkDir(spec.EmbedFS, spec.EmbedFSDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := spec.EmbedFS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		dst := path.Join(configDirectory, name[len(spec.EmbedFSDir):])
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	})
+}
+
+// archiveDirectory writes a gzipped tarball of the given directory
+func archiveDirectory(directory, tarball string) error {
+	f, err := os.Create(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+	base := filepath.Dir(filepath.Clean(directory))
+	return filepath.Walk(directory, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(base, file); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+}
@@ -0,0 +1,285 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	auditRequestRateMetric = "auditRequestRate"
+	auditSlowRequestMetric = "auditSlowRequest"
+	// Directory of the kube-apiserver audit logs in the node log API
+	auditLogPath = "kube-apiserver"
+	// Layout of the timestamp of the rotated audit logs, i.e. audit-2024-05-01T10-00-00.000.log
+	auditLogRotationLayout = "2006-01-02T15-04-05.000"
+)
+
+var auditLogFileRegex = regexp.MustCompile(`href="(audit(?:-([0-9T:.-]+))?\.log)"`)
+
+// Long running requests, whose duration isn't representative of the API server performance
+var (
+	auditLongRunningVerbs        = []string{"watch", "connect"}
+	auditLongRunningSubresources = []string{"log", "exec", "portforward", "attach", "proxy"}
+)
+
+// auditEvent holds the fields of the kube-apiserver audit events used by the analysis
+type auditEvent struct {
+	Stage      string `json:"stage"`
+	Verb       string `json:"verb"`
+	RequestURI string `json:"requestURI"`
+	UserAgent  string `json:"userAgent"`
+	User       struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectRef *struct {
+		Resource    string `json:"resource"`
+		Namespace   string `json:"namespace"`
+		Name        string `json:"name"`
+		Subresource string `json:"subresource"`
+	} `json:"objectRef"`
+	ResponseStatus *struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+	RequestReceivedTimestamp time.Time `json:"requestReceivedTimestamp"`
+	StageTimestamp           time.Time `json:"stageTimestamp"`
+}
+
+// auditRequestRate document with the requests of a user by verb and resource during the run
+type auditRequestRate struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	User       string      `json:"user"`
+	Verb       string      `json:"verb"`
+	Resource   string      `json:"resource"`
+	Count      int         `json:"count"`
+	Rate       float64     `json:"rate"`
+	Errors     int         `json:"errors"`
+	P99        int         `json:"P99"`
+	Max        int         `json:"max"`
+	MetricName string      `json:"metricName"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+	// durations of the requests, in milliseconds
+	durations []int
+}
+
+// auditSlowRequest document of one of the slowest requests of the run
+type auditSlowRequest struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	Node       string      `json:"node"`
+	User       string      `json:"user"`
+	UserAgent  string      `json:"userAgent"`
+	Verb       string      `json:"verb"`
+	Resource   string      `json:"resource"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	RequestURI string      `json:"requestURI"`
+	Code       int         `json:"code"`
+	Duration   int         `json:"duration"`
+	MetricName string      `json:"metricName"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+// auditDigest aggregates the audit events of the run
+type auditDigest struct {
+	wh      *workloads.WorkloadHelper
+	start   time.Time
+	end     time.Time
+	top     int
+	events  int
+	rates   map[string]*auditRequestRate
+	slowest []auditSlowRequest
+}
+
+// analyzeAuditLogs reads the kube-apiserver audit logs of the control plane nodes through the node log API, computes the request
+// rate and latency of each user by verb and resource during the run, and indexes them along with its top slowest requests
+func analyzeAuditLogs(wh *workloads.WorkloadHelper, start, end time.Time, top int) error {
+	defer StartSpan("audit-log-analysis")()
+	clientSet, _ := newClientSet()
+	nodes, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "node-role.kubernetes.io/master"})
+	if err != nil {
+		return err
+	}
+	if len(nodes.Items) == 0 {
+		return fmt.Errorf("no control plane nodes found, audit logs of hosted control planes are not available")
+	}
+	digest := &auditDigest{
+		wh:    wh,
+		start: start,
+		end:   end,
+		top:   top,
+		rates: make(map[string]*auditRequestRate),
+	}
+	for _, node := range nodes.Items {
+		files, err := auditLogFiles(clientSet, node.Name, start)
+		if err != nil {
+			log.Warnf("Error listing the audit logs of node %s: %v", node.Name, err)
+			continue
+		}
+		for _, file := range files {
+			log.Debugf("Reading audit log %s of node %s", file, node.Name)
+			if err := digest.read(clientSet, node.Name, file); err != nil {
+				log.Warnf("Error reading audit log %s of node %s: %v", file, node.Name, err)
+			}
+		}
+	}
+	if digest.events == 0 {
+		return fmt.Errorf("no audit events found during the run")
+	}
+	var docs []interface{}
+	for _, rate := range digest.rates {
+		sort.Ints(rate.durations)
+		rate.P99 = rate.durations[(len(rate.durations)*99-1)/100]
+		rate.Max = rate.durations[len(rate.durations)-1]
+		rate.Rate = float64(rate.Count) / max(end.Sub(start).Seconds(), 1)
+		docs = append(docs, *rate)
+	}
+	log.Infof("Indexing audit request rates of %d user, verb and resource combinations from %d requests", len(docs), digest.events)
+	indexDocuments(auditRequestRateMetric, docs)
+	docs = nil
+	for _, slow := range digest.slowest {
+		docs = append(docs, slow)
+	}
+	indexDocuments(auditSlowRequestMetric, docs)
+	return nil
+}
+
+// auditLogFiles returns the audit logs of the given node which may hold events since the given time,
+// the rotated ones are named after the time they were rotated at
+func auditLogFiles(clientSet kubernetes.Interface, node string, since time.Time) ([]string, error) {
+	// The trailing slash lists the directory instead of redirecting to it
+	listing, err := clientSet.CoreV1().RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/logs/%s/", node, auditLogPath)).DoRaw(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range auditLogFileRegex.FindAllStringSubmatch(string(listing), -1) {
+		if match[2] != "" {
+			rotation, err := time.Parse(auditLogRotationLayout, match[2])
+			if err == nil && rotation.Before(since) {
+				continue
+			}
+		}
+		files = append(files, match[1])
+	}
+	return files, nil
+}
+
+// read aggregates the completed requests of the given audit log received during the run
+func (d *auditDigest) read(clientSet kubernetes.Interface, node, file string) error {
+	stream, err := clientSet.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", node, "proxy", "logs", auditLogPath, file).Stream(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	reader := bufio.NewReaderSize(stream, 1024*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var event auditEvent
+			if json.Unmarshal(line, &event) == nil {
+				d.add(node, event)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *auditDigest) add(node string, event auditEvent) {
+	if event.Stage != "ResponseComplete" || event.RequestReceivedTimestamp.Before(d.start) || event.RequestReceivedTimestamp.After(d.end) {
+		return
+	}
+	if slices.Contains(auditLongRunningVerbs, event.Verb) {
+		return
+	}
+	var resource, namespace, name string
+	if event.ObjectRef != nil {
+		if slices.Contains(auditLongRunningSubresources, event.ObjectRef.Subresource) {
+			return
+		}
+		resource, namespace, name = event.ObjectRef.Resource, event.ObjectRef.Namespace, event.ObjectRef.Name
+		if event.ObjectRef.Subresource != "" {
+			resource += "/" + event.ObjectRef.Subresource
+		}
+	}
+	var code int
+	if event.ResponseStatus != nil {
+		code = event.ResponseStatus.Code
+	}
+	d.events++
+	duration := int(event.StageTimestamp.Sub(event.RequestReceivedTimestamp).Milliseconds())
+	key := event.User.Username + " " + event.Verb + " " + resource
+	rate, exists := d.rates[key]
+	if !exists {
+		rate = &auditRequestRate{
+			Timestamp:  d.start,
+			UUID:       d.wh.UUID,
+			User:       event.User.Username,
+			Verb:       event.Verb,
+			Resource:   resource,
+			MetricName: auditRequestRateMetric,
+			Metadata:   d.wh.MetricsMetadata,
+		}
+		d.rates[key] = rate
+	}
+	rate.Count++
+	rate.durations = append(rate.durations, duration)
+	if code >= 400 {
+		rate.Errors++
+	}
+	// The slowest requests are kept sorted by duration
+	if d.top == 0 || len(d.slowest) == d.top && duration <= d.slowest[len(d.slowest)-1].Duration {
+		return
+	}
+	slow := auditSlowRequest{
+		Timestamp:  event.RequestReceivedTimestamp,
+		UUID:       d.wh.UUID,
+		Node:       node,
+		User:       event.User.Username,
+		UserAgent:  event.UserAgent,
+		Verb:       event.Verb,
+		Resource:   resource,
+		Namespace:  namespace,
+		Name:       name,
+		RequestURI: event.RequestURI,
+		Code:       code,
+		Duration:   duration,
+		MetricName: auditSlowRequestMetric,
+		Metadata:   d.wh.MetricsMetadata,
+	}
+	i := sort.Search(len(d.slowest), func(i int) bool { return d.slowest[i].Duration < duration })
+	d.slowest = slices.Insert(d.slowest, i, slow)
+	if len(d.slowest) > d.top {
+		d.slowest = d.slowest[:d.top]
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	autoSizeMetric = "autoSize"
+	// Share of the pods a worker node can hold filled by the node density workloads, 245 pods in the default 250 pods nodes
+	autoSizePodsFill = 0.98
+	// Share of the free CPU and memory of a worker node requested by the pods of node-density-heavy
+	autoSizeResourcesFill = 0.9
+	// Share of the free pods of the worker nodes filled by the pods of the cluster density workloads, which are sized by
+	// the control plane load rather than the node capacity
+	autoSizeClusterDensityFill = 0.4
+)
+
+// clusterDensityPodsPerIteration are the pods created by an iteration of the cluster density workloads, including the
+// build pod of cluster-density-v2
+var clusterDensityPodsPerIteration = map[string]int{
+	"cluster-density-v2": 11,
+	"cluster-density-ms": 8,
+}
+
+// workerCapacity holds the capacity of the worker nodes of the cluster. The per node values are the ones of the smallest
+// worker node, since the workloads spread their pods across all of them
+type workerCapacity struct {
+	Nodes int `json:"nodes"`
+	// Pods a worker node can hold
+	AllocatablePods int `json:"allocatablePods"`
+	// Pods running in the worker nodes
+	Pods int `json:"pods"`
+	// Pods, CPU and memory not used nor requested by the running pods in a worker node
+	FreePods   int               `json:"freePods"`
+	FreeCPU    resource.Quantity `json:"freeCPU"`
+	FreeMemory resource.Quantity `json:"freeMemory"`
+}
+
+// autoSizeResult holds the capacity of the cluster and the flags computed from it
+type autoSizeResult struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	UUID       string            `json:"uuid"`
+	Workload   string            `json:"workload"`
+	Capacity   workerCapacity    `json:"capacity"`
+	Flags      map[string]string `json:"flags"`
+	MetricName string            `json:"metricName"`
+	Metadata   interface{}       `json:"metadata,omitempty"`
+}
+
+// autoSizing is the sizing of the run, indexed once it finishes
+var autoSizing *autoSizeResult
+
+// autoSize sets the sizing flags of the given workload command from the capacity of the worker nodes, unless they were given
+// in the command line or the preset. The computed values are logged and added to the metadata of the run
+func autoSize(cmd *cobra.Command, wh *workloads.WorkloadHelper) error {
+	clientSet, _ := newClientSet()
+	capacity, err := workerNodesCapacity(clientSet)
+	if err != nil {
+		return err
+	}
+	log.Infof("%d worker nodes, smallest one with %d allocatable pods, %d free pods, %s free CPU and %s free memory", capacity.Nodes, capacity.AllocatablePods, capacity.FreePods, capacity.FreeCPU.String(), capacity.FreeMemory.String())
+	flags, err := autoSizeFlags(cmd, capacity)
+	if err != nil {
+		return err
+	}
+	if len(flags) == 0 {
+		log.Warnf("%s doesn't support auto-sizing, using its flags", cmd.Name())
+		return nil
+	}
+	result := &autoSizeResult{
+		Timestamp: time.Now().UTC(),
+		Workload:  cmd.Name(),
+		Capacity:  capacity,
+		Flags:     make(map[string]string),
+	}
+	for _, name := range sortedKeys(flags) {
+		if cmd.Flags().Changed(name) {
+			log.Infof("Auto-size: keeping --%s=%s, computed %s", name, cmd.Flags().Lookup(name).Value.String(), flags[name])
+			continue
+		}
+		if err := cmd.Flags().Set(name, flags[name]); err != nil {
+			return fmt.Errorf("error setting --%s: %v", name, err)
+		}
+		log.Infof("Auto-size: --%s=%s", name, flags[name])
+		result.Flags[name] = flags[name]
+	}
+	wh.SummaryMetadata["autoSize"] = result.Flags
+	autoSizing = result
+	return nil
+}
+
+// autoSizeFlags computes the sizing flags of the given workload command:
+//   - node-density and node-density-cni: pods per node filling the pods of the smallest worker node.
+//   - node-density-heavy: pods per node filling its pods or its free CPU and memory with app and database pairs.
+//   - cluster-density-v2 and cluster-density-ms: iterations whose pods fill a share of the free pods of the worker nodes.
+func autoSizeFlags(cmd *cobra.Command, capacity workerCapacity) (map[string]string, error) {
+	podsPerNode := int(float64(capacity.AllocatablePods) * autoSizePodsFill)
+	switch cmd.Name() {
+	case "node-density", "node-density-cni":
+		return map[string]string{"pods-per-node": fmt.Sprint(podsPerNode)}, nil
+	case "node-density-heavy":
+		var cpu, memory resource.Quantity
+		for _, flag := range []string{"app-cpu", "db-cpu", "app-memory", "db-memory"} {
+			value, _ := cmd.Flags().GetString(flag)
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s %s: %v", flag, value, err)
+			}
+			if flag == "app-cpu" || flag == "db-cpu" {
+				cpu.Add(quantity)
+			} else {
+				memory.Add(quantity)
+			}
+		}
+		pairs := math.MaxInt
+		if cpu.MilliValue() > 0 {
+			pairs = min(pairs, int(float64(capacity.FreeCPU.MilliValue())*autoSizeResourcesFill)/int(cpu.MilliValue()))
+		}
+		if memory.Value() > 0 {
+			pairs = min(pairs, int(float64(capacity.FreeMemory.Value())*autoSizeResourcesFill/float64(memory.Value())))
+		}
+		// The pods per node account for the running ones, which aren't created again
+		podsPerNode = min(podsPerNode, capacity.Pods/max(capacity.Nodes, 1)+2*pairs)
+		return map[string]string{"pods-per-node": fmt.Sprint(podsPerNode)}, nil
+	case "cluster-density-v2", "cluster-density-ms":
+		freePods := float64(capacity.FreePods*capacity.Nodes) * autoSizeClusterDensityFill
+		iterations := max(int(freePods)/clusterDensityPodsPerIteration[cmd.Name()], 1)
+		return map[string]string{"iterations": fmt.Sprint(iterations)}, nil
+	}
+	return nil, nil
+}
+
+// workerNodesCapacity returns the capacity of the schedulable worker nodes, not counting the infra and workload ones as
+// the cluster metadata does, subtracting the requests of the pods running in them
+func workerNodesCapacity(clientSet kubernetes.Interface) (workerCapacity, error) {
+	var capacity workerCapacity
+	nodes, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "node-role.kubernetes.io/worker,!node-role.kubernetes.io/infra,!node-role.kubernetes.io/workload"})
+	if err != nil {
+		return capacity, fmt.Errorf("error listing worker nodes: %v", err)
+	}
+	free := make(map[string]corev1.ResourceList)
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		free[node.Name] = node.Status.Allocatable.DeepCopy()
+	}
+	if len(free) == 0 {
+		return capacity, fmt.Errorf("no schedulable worker nodes found")
+	}
+	pods := make(map[string]int)
+	listOptions := metav1.ListOptions{FieldSelector: "status.phase!=Succeeded,status.phase!=Failed", Limit: 1000}
+	for {
+		podList, err := clientSet.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), listOptions)
+		if err != nil {
+			return capacity, fmt.Errorf("error listing pods: %v", err)
+		}
+		for _, pod := range podList.Items {
+			allocatable, ok := free[pod.Spec.NodeName]
+			if !ok {
+				continue
+			}
+			pods[pod.Spec.NodeName]++
+			capacity.Pods++
+			for _, container := range pod.Spec.Containers {
+				for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+					if request, ok := container.Resources.Requests[name]; ok {
+						quantity := allocatable[name]
+						quantity.Sub(request)
+						allocatable[name] = quantity
+					}
+				}
+			}
+		}
+		if listOptions.Continue = podList.Continue; listOptions.Continue == "" {
+			break
+		}
+	}
+	capacity.Nodes = len(free)
+	first := true
+	for name, allocatable := range free {
+		node := workerCapacity{
+			AllocatablePods: int(allocatable.Pods().Value()),
+			FreeCPU:         allocatable[corev1.ResourceCPU],
+			FreeMemory:      allocatable[corev1.ResourceMemory],
+		}
+		node.FreePods = node.AllocatablePods - pods[name]
+		if first {
+			capacity.AllocatablePods, capacity.FreePods, capacity.FreeCPU, capacity.FreeMemory = node.AllocatablePods, node.FreePods, node.FreeCPU, node.FreeMemory
+			first = false
+			continue
+		}
+		capacity.AllocatablePods = min(capacity.AllocatablePods, node.AllocatablePods)
+		capacity.FreePods = min(capacity.FreePods, node.FreePods)
+		if node.FreeCPU.Cmp(capacity.FreeCPU) < 0 {
+			capacity.FreeCPU = node.FreeCPU
+		}
+		if node.FreeMemory.Cmp(capacity.FreeMemory) < 0 {
+			capacity.FreeMemory = node.FreeMemory
+		}
+	}
+	return capacity, nil
+}
+
+// indexAutoSize indexes the capacity of the cluster and the flags computed from it
+func indexAutoSize(wh *workloads.WorkloadHelper) {
+	if autoSizing == nil {
+		return
+	}
+	autoSizing.UUID = wh.UUID
+	autoSizing.MetricName = autoSizeMetric
+	autoSizing.Metadata = wh.MetricsMetadata
+	indexDocuments(autoSizeMetric, []interface{}{autoSizing})
+}
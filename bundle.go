@@ -0,0 +1,267 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const (
+	// Prefix of the custom workload bundles stored in OCI registries, i.e. oci://quay.io/example/my-workload:v1.0.0
+	ociSourcePrefix = "oci://"
+	// Artifact type of the bundles, holding a single gzipped tarball layer with the directory of the workload
+	bundleArtifactType   = "application/vnd.kube-burner-ocp.workload.v1"
+	bundleLayerMediaType = "application/vnd.kube-burner-ocp.workload.layer.v1.tar+gzip"
+	// Annotation of the bundle manifest with the path of the configuration file in the bundle
+	bundleConfigAnnotation = "io.kube-burner.ocp.workload.config"
+	bundleTimeout          = 10 * time.Minute
+)
+
+// NewPackage packages the directory of a custom workload as an OCI artifact and pushes it to a registry
+func NewPackage() *cobra.Command {
+	var plainHTTP bool
+	cmd := &cobra.Command{
+		Use:   "package <config> oci://<registry>/<repository>:<tag>",
+		Short: "Packages a custom workload as an OCI artifact and pushes it to a registry",
+		Long: "Packages the directory of the given custom workload configuration, with its object templates and metrics and alert profiles, as an OCI artifact " +
+			"and pushes it to the given registry, so it can be run with init --config=oci://<registry>/<repository>:<tag>. The registry credentials are " +
+			"read from the Docker config file, or from the file given by REGISTRY_AUTH_FILE",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !strings.HasPrefix(args[1], ociSourcePrefix) {
+				return fmt.Errorf("invalid reference %s, expected format is oci://<registry>/<repository>:<tag>", args[1])
+			}
+			digest, err := pushBundle(args[0], strings.TrimPrefix(args[1], ociSourcePrefix), plainHTTP)
+			if err != nil {
+				return fmt.Errorf("error packaging workload: %v", err)
+			}
+			log.Infof("Workload pushed to %s@%s", args[1], digest)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&plainHTTP, "plain-http", false, "Access the registry through HTTP instead of HTTPS")
+	return cmd
+}
+
+// newBundleRepository returns the repository of the given reference, authenticated with the credentials of the Docker config file,
+// or the file given by REGISTRY_AUTH_FILE. Registries in localhost are accessed through HTTP
+func newBundleRepository(reference string, plainHTTP bool) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(reference)
+	if err != nil {
+		return nil, err
+	}
+	var store credentials.Store
+	if authFile := os.Getenv("REGISTRY_AUTH_FILE"); authFile != "" {
+		store, err = credentials.NewStore(authFile, credentials.StoreOptions{})
+	} else {
+		store, err = credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry credentials: %v", err)
+	}
+	repo.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}
+	repo.PlainHTTP = plainHTTP || strings.HasPrefix(repo.Reference.Registry, "localhost")
+	return repo, nil
+}
+
+// pushBundle packages the directory of the given configuration file and pushes it to the given reference, returning its digest
+func pushBundle(configFile, reference string, plainHTTP bool) (string, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return "", err
+	}
+	repo, err := newBundleRepository(reference, plainHTTP)
+	if err != nil {
+		return "", err
+	}
+	if repo.Reference.Reference == "" {
+		return "", fmt.Errorf("reference %s has no tag", reference)
+	}
+	layer, err := archiveBundle(filepath.Dir(configFile))
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bundleTimeout)
+	defer cancel()
+	store := memory.New()
+	layerDesc, err := oras.PushBytes(ctx, store, bundleLayerMediaType, layer)
+	if err != nil {
+		return "", err
+	}
+	manifestDesc, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, bundleArtifactType, oras.PackManifestOptions{
+		Layers: []ocispec.Descriptor{layerDesc},
+		ManifestAnnotations: map[string]string{
+			bundleConfigAnnotation:  filepath.ToSlash(filepath.Base(configFile)),
+			ocispec.AnnotationTitle: strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile)),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	tag := repo.Reference.Reference
+	if err := store.Tag(ctx, manifestDesc, tag); err != nil {
+		return "", err
+	}
+	log.Infof("Pushing %s, %d bytes", reference, len(layer))
+	if _, err := oras.Copy(ctx, store, tag, repo, tag, oras.DefaultCopyOptions); err != nil {
+		return "", err
+	}
+	return manifestDesc.Digest.String(), nil
+}
+
+// archiveBundle returns a gzipped tarball of the files of the given directory, skipping the hidden ones
+func archiveBundle(directory string) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	err := filepath.Walk(directory, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && file != directory {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(directory, file)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		// Without timestamps or owners, so the same directory produces the same digest
+		header := &tar.Header{Name: filepath.ToSlash(name), Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pullBundle pulls the bundle of the given reference, verifying its digests, extracts it to the given directory and returns the
+// path of its configuration file
+func pullBundle(reference, bundleDir string) (string, error) {
+	repo, err := newBundleRepository(reference, false)
+	if err != nil {
+		return "", err
+	}
+	if repo.Reference.Reference == "" {
+		return "", fmt.Errorf("reference %s has no tag or digest", reference)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bundleTimeout)
+	defer cancel()
+	manifestDesc, manifestData, err := oras.FetchBytes(ctx, repo, repo.Reference.Reference, oras.DefaultFetchBytesOptions)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %v", reference, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return "", err
+	}
+	if manifest.ArtifactType != bundleArtifactType || len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != bundleLayerMediaType {
+		return "", fmt.Errorf("%s is not a kube-burner-ocp workload bundle", reference)
+	}
+	configFile := manifest.Annotations[bundleConfigAnnotation]
+	if configFile == "" {
+		return "", fmt.Errorf("bundle %s has no %s annotation", reference, bundleConfigAnnotation)
+	}
+	layer, err := content.FetchAll(ctx, repo, manifest.Layers[0])
+	if err != nil {
+		return "", fmt.Errorf("error fetching the bundle layer: %v", err)
+	}
+	if err := extractBundle(layer, bundleDir); err != nil {
+		return "", fmt.Errorf("error extracting the bundle: %v", err)
+	}
+	log.Infof("Pulled workload bundle %s@%s", reference, manifestDesc.Digest)
+	return configFile, nil
+}
+
+// extractBundle extracts the regular files of the given gzipped tarball into the given directory
+func extractBundle(layer []byte, bundleDir string) error {
+	gr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		dst := filepath.Join(bundleDir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(dst, bundleDir+string(filepath.Separator)) {
+			return fmt.Errorf("%s is outside of the bundle", header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	chaosEventMetric = "chaosEvent"
+	// Chaos actions
+	chaosPodKill          = "pod-kill"
+	chaosNodeReboot       = "node-reboot"
+	chaosOVNLeaderDelete  = "ovn-leader-delete"
+	chaosAPIServerRollout = "apiserver-rollout"
+	chaosNetem            = "netem"
+	// Interface impaired by default, OVN-Kubernetes external bridge carrying the traffic between nodes
+	defaultNetemInterface = "br-ex"
+)
+
+// chaosAction defines a disruption injected during the workload
+type chaosAction struct {
+	// Action: pod-kill, node-reboot, ovn-leader-delete, apiserver-rollout or netem
+	Action string `yaml:"action"`
+	// Workloads restricts the action to the given workloads, all of them by default
+	Workloads []string `yaml:"workloads"`
+	// Delay since the start of the workload
+	Delay time.Duration `yaml:"delay"`
+	// Repeat the action this number of times, every interval
+	Repeat   int           `yaml:"repeat"`
+	Interval time.Duration `yaml:"interval"`
+	// Namespace and LabelSelector of the pods killed by pod-kill, or LabelSelector of the nodes rebooted by node-reboot
+	// or impaired by netem
+	Namespace     string `yaml:"namespace"`
+	LabelSelector string `yaml:"labelSelector"`
+	// Count of pods killed or nodes rebooted or impaired, 1 by default
+	Count int `yaml:"count"`
+	// Latency, Jitter and packet Loss percentage added by netem to the Interface of the nodes during Duration
+	Latency   time.Duration `yaml:"latency"`
+	Jitter    time.Duration `yaml:"jitter"`
+	Loss      float64       `yaml:"loss"`
+	Interface string        `yaml:"interface"`
+	Duration  time.Duration `yaml:"duration"`
+	// nodes impaired by netem, chosen when the actions are scheduled so the impairment windows are known beforehand
+	nodes []string
+}
+
+// networkImpairment is an impairment window added to the metadata of the run
+type networkImpairment struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Nodes     []string  `json:"nodes"`
+	Interface string    `json:"interface"`
+	Latency   string    `json:"latency,omitempty"`
+	Jitter    string    `json:"jitter,omitempty"`
+	Loss      float64   `json:"loss,omitempty"`
+}
+
+// chaosEvent document indexed for each injected disruption, so the results can be correlated with them
+type chaosEvent struct {
+	Timestamp    time.Time   `json:"timestamp"`
+	EndTimestamp time.Time   `json:"endTimestamp"`
+	UUID         string      `json:"uuid"`
+	Action       string      `json:"action"`
+	Targets      []string    `json:"targets,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	MetricName   string      `json:"metricName"`
+	Metadata     interface{} `json:"metadata,omitempty"`
+}
+
+type chaosRunner struct {
+	clientSet  kubernetes.Interface
+	restConfig *rest.Config
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	events     []chaosEvent
+}
+
+func readChaosFile(chaosFile string) ([]chaosAction, error) {
+	var actions []chaosAction
+	data, err := os.ReadFile(chaosFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chaos file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &actions); err != nil {
+		return nil, fmt.Errorf("error decoding chaos file %s: %v", chaosFile, err)
+	}
+	for i, action := range actions {
+		switch action.Action {
+		case chaosPodKill:
+			if action.Namespace == "" {
+				return nil, fmt.Errorf("chaos action %d: %s requires a namespace", i, action.Action)
+			}
+		case chaosNodeReboot:
+			if action.LabelSelector == "" {
+				actions[i].LabelSelector = "node-role.kubernetes.io/worker"
+			}
+		case chaosNetem:
+			if action.Duration == 0 || (action.Latency == 0 && action.Loss == 0) {
+				return nil, fmt.Errorf("chaos action %d: %s requires a duration and either latency or loss", i, action.Action)
+			}
+			if action.LabelSelector == "" {
+				actions[i].LabelSelector = "node-role.kubernetes.io/worker"
+			}
+			if action.Interface == "" {
+				actions[i].Interface = defaultNetemInterface
+			}
+		case chaosOVNLeaderDelete, chaosAPIServerRollout:
+		default:
+			return nil, fmt.Errorf("chaos action %d: unsupported action %s", i, action.Action)
+		}
+		actions[i].Repeat = max(action.Repeat, 1)
+		actions[i].Count = max(action.Count, 1)
+	}
+	return actions, nil
+}
+
+// startChaos schedules the chaos actions of the given file for the given workload from now on.
+// The windows of the network impairments are added to the jobSummary metadata
+func startChaos(wh *workloads.WorkloadHelper, chaosFile, workload string) (*chaosRunner, error) {
+	actions, err := readChaosFile(chaosFile)
+	if err != nil {
+		return nil, err
+	}
+	clientSet, restConfig := newClientSet()
+	cr := &chaosRunner{
+		clientSet:  clientSet,
+		restConfig: restConfig,
+		stopCh:     make(chan struct{}),
+	}
+	var impairments []networkImpairment
+	start := time.Now().UTC()
+	for _, action := range actions {
+		if len(action.Workloads) > 0 && !slices.Contains(action.Workloads, workload) {
+			continue
+		}
+		if action.Action == chaosNetem {
+			if action.nodes, err = cr.pickNodes(action.LabelSelector, action.Count); err != nil {
+				return nil, err
+			}
+			for i := 0; i < action.Repeat; i++ {
+				windowStart := start.Add(action.Delay + time.Duration(i)*action.Interval)
+				impairment := networkImpairment{
+					Start:     windowStart,
+					End:       windowStart.Add(action.Duration),
+					Nodes:     action.nodes,
+					Interface: action.Interface,
+					Loss:      action.Loss,
+				}
+				if action.Latency > 0 {
+					impairment.Latency, impairment.Jitter = action.Latency.String(), action.Jitter.String()
+				}
+				impairments = append(impairments, impairment)
+			}
+		}
+		log.Infof("Scheduling chaos action %s in %v", action.Action, action.Delay)
+		cr.wg.Add(1)
+		go cr.schedule(action)
+	}
+	if len(impairments) > 0 {
+		wh.SummaryMetadata["networkImpairments"] = impairments
+	}
+	return cr, nil
+}
+
+// pickNodes returns count random ready nodes matching the label selector
+func (cr *chaosRunner) pickNodes(labelSelector string, count int) ([]string, error) {
+	nodes, err := cr.clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(nodes.Items), func(i, j int) { nodes.Items[i], nodes.Items[j] = nodes.Items[j], nodes.Items[i] })
+	var picked []string
+	for _, node := range nodes.Items {
+		if len(picked) == count {
+			break
+		}
+		if nodeReady(node) {
+			picked = append(picked, node.Name)
+		}
+	}
+	if len(picked) == 0 {
+		return nil, fmt.Errorf("no ready nodes match %s", labelSelector)
+	}
+	return picked, nil
+}
+
+func (cr *chaosRunner) schedule(action chaosAction) {
+	defer cr.wg.Done()
+	wait := action.Delay
+	for i := 0; i < action.Repeat; i++ {
+		select {
+		case <-time.After(wait):
+		case <-cr.stopCh:
+			return
+		}
+		cr.inject(action)
+		if action.Action == chaosNetem {
+			// Reverted after its duration, or right away when the workload finishes
+			select {
+			case <-time.After(action.Duration):
+			case <-cr.stopCh:
+			}
+			cr.revertNetem(action)
+			if wait = action.Interval - action.Duration; wait < 0 {
+				wait = 0
+			}
+			continue
+		}
+		wait = action.Interval
+	}
+}
+
+// inject runs the chaos action and records its event
+func (cr *chaosRunner) inject(action chaosAction) {
+	defer StartSpan("chaos " + action.Action)()
+	event := chaosEvent{
+		Timestamp:  time.Now().UTC(),
+		Action:     action.Action,
+		MetricName: chaosEventMetric,
+	}
+	var err error
+	switch action.Action {
+	case chaosPodKill:
+		event.Targets, err = cr.killPods(action.Namespace, action.LabelSelector, action.Count)
+	case chaosNodeReboot:
+		event.Targets, err = cr.rebootNodes(action.LabelSelector, action.Count)
+	case chaosOVNLeaderDelete:
+		event.Targets, err = cr.deleteOVNLeader()
+	case chaosAPIServerRollout:
+		event.Targets, err = cr.rolloutAPIServer()
+	case chaosNetem:
+		event.Targets, err = cr.netem(action, "add", netemArgs(action)...)
+	}
+	event.EndTimestamp = time.Now().UTC()
+	if err != nil {
+		log.Errorf("Chaos action %s failed: %v", action.Action, err)
+		event.Error = err.Error()
+	} else {
+		log.Infof("💥 Chaos action %s: %v", action.Action, event.Targets)
+	}
+	cr.mu.Lock()
+	cr.events = append(cr.events, event)
+	cr.mu.Unlock()
+}
+
+// killPods deletes count random running pods of the given namespace matching the label selector
+func (cr *chaosRunner) killPods(namespace, labelSelector string, count int) ([]string, error) {
+	pods, err := cr.clientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var killed []string
+	rand.Shuffle(len(pods.Items), func(i, j int) { pods.Items[i], pods.Items[j] = pods.Items[j], pods.Items[i] })
+	for _, pod := range pods.Items[:min(count, len(pods.Items))] {
+		if err := cr.clientSet.CoreV1().Pods(namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)}); err != nil {
+			return killed, err
+		}
+		killed = append(killed, pod.Namespace+"/"+pod.Name)
+	}
+	return killed, nil
+}
+
+// rebootNodes reboots count random ready nodes matching the label selector through their machine-config-daemon pod
+func (cr *chaosRunner) rebootNodes(labelSelector string, count int) ([]string, error) {
+	nodes, err := cr.clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(nodes.Items), func(i, j int) { nodes.Items[i], nodes.Items[j] = nodes.Items[j], nodes.Items[i] })
+	var rebooted []string
+	for _, node := range nodes.Items {
+		if len(rebooted) == count {
+			break
+		}
+		if !nodeReady(node) {
+			continue
+		}
+		if err := nodeExec(cr.clientSet, cr.restConfig, node.Name, "systemctl", "reboot"); err != nil {
+			return rebooted, fmt.Errorf("error rebooting node %s: %v", node.Name, err)
+		}
+		rebooted = append(rebooted, node.Name)
+	}
+	return rebooted, nil
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// deleteOVNLeader deletes the ovnkube-control-plane pod holding the leader election lease
+func (cr *chaosRunner) deleteOVNLeader() ([]string, error) {
+	const namespace = "openshift-ovn-kubernetes"
+	lease, err := cr.clientSet.CoordinationV1().Leases(namespace).Get(context.TODO(), "ovn-kubernetes-master", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the OVN-Kubernetes leader lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return nil, fmt.Errorf("OVN-Kubernetes leader lease has no holder")
+	}
+	holder := *lease.Spec.HolderIdentity
+	pods, err := cr.clientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=ovnkube-control-plane"})
+	if err != nil {
+		return nil, err
+	}
+	// The holder identity is either the pod or the node name
+	for _, pod := range pods.Items {
+		if pod.Name == holder || pod.Spec.NodeName == holder {
+			if err := cr.clientSet.CoreV1().Pods(namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)}); err != nil {
+				return nil, err
+			}
+			return []string{pod.Namespace + "/" + pod.Name}, nil
+		}
+	}
+	return nil, fmt.Errorf("ovnkube-control-plane pod of the leader %s not found", holder)
+}
+
+// netemArgs returns the netem arguments of the tc qdisc command of the action
+func netemArgs(action chaosAction) []string {
+	args := []string{"netem"}
+	if action.Latency > 0 {
+		args = append(args, "delay", fmt.Sprintf("%dus", action.Latency.Microseconds()))
+		if action.Jitter > 0 {
+			args = append(args, fmt.Sprintf("%dus", action.Jitter.Microseconds()))
+		}
+	}
+	if action.Loss > 0 {
+		args = append(args, "loss", fmt.Sprintf("%g%%", action.Loss))
+	}
+	return args
+}
+
+// netem runs tc qdisc with the given operation and arguments in the root qdisc of the impaired interface of the nodes of the action
+func (cr *chaosRunner) netem(action chaosAction, operation string, args ...string) ([]string, error) {
+	var targets []string
+	for _, node := range action.nodes {
+		command := append([]string{"tc", "qdisc", operation, "dev", action.Interface, "root"}, args...)
+		if err := nodeExec(cr.clientSet, cr.restConfig, node, command...); err != nil {
+			return targets, fmt.Errorf("error running tc qdisc %s in node %s: %v", operation, node, err)
+		}
+		targets = append(targets, node+"/"+action.Interface)
+	}
+	return targets, nil
+}
+
+// revertNetem removes the netem qdisc added by the action, recording the end of the impairment
+func (cr *chaosRunner) revertNetem(action chaosAction) {
+	if _, err := cr.netem(action, "del"); err != nil {
+		log.Errorf("Error reverting the network impairment: %v", err)
+		return
+	}
+	log.Infof("Network impairment of %v reverted", action.nodes)
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	for i := len(cr.events) - 1; i >= 0; i-- {
+		if cr.events[i].Action == chaosNetem {
+			cr.events[i].EndTimestamp = time.Now().UTC()
+			break
+		}
+	}
+}
+
+// rolloutAPIServer forces a new revision of the kube-apiserver, rolled out one control plane node at a time
+func (cr *chaosRunner) rolloutAPIServer() ([]string, error) {
+	dynamicClient, err := dynamic.NewForConfig(cr.restConfig)
+	if err != nil {
+		return nil, err
+	}
+	patch := fmt.Sprintf(`{"spec":{"forceRedeploymentReason":"kube-burner-chaos-%d"}}`, time.Now().Unix())
+	_, err = dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    "operator.openshift.io",
+		Version:  "v1",
+		Resource: "kubeapiservers",
+	}).Patch(context.TODO(), "cluster", types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error forcing a kube-apiserver rollout: %v", err)
+	}
+	return []string{"kubeapiserver/cluster"}, nil
+}
+
+// stop cancels the pending chaos actions, waits for the running ones and indexes their events
+func (cr *chaosRunner) stop(wh *workloads.WorkloadHelper) {
+	close(cr.stopCh)
+	cr.wg.Wait()
+	if len(cr.events) == 0 {
+		return
+	}
+	var docs []interface{}
+	for _, event := range cr.events {
+		event.UUID = wh.UUID
+		event.Metadata = wh.MetricsMetadata
+		docs = append(docs, event)
+	}
+	indexDocuments(chaosEventMetric, docs)
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/disk"
+	"k8s.io/client-go/discovery/cached/memory"
+	clientfeatures "k8s.io/client-go/features"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Rate limits of the clients of kube-burner-ocp when they aren't derived from the cluster size
+const (
+	minClientQPS = 20
+	maxClientQPS = 200
+)
+
+// clients holds the kube client provider shared by kube-burner and kube-burner-ocp, and the clients of kube-burner-ocp,
+// created once so they reuse their connections and share the same rate limiter
+var clients struct {
+	providerOnce  sync.Once
+	provider      *config.KubeClientProvider
+	clientSetOnce sync.Once
+	clientSet     kubernetes.Interface
+	restConfig    *rest.Config
+	discoveryOnce sync.Once
+	discovery     discovery.CachedDiscoveryInterface
+}
+
+// resetClients discards the clients created so far, so the next ones are created from the current kubeconfig
+func resetClients() {
+	clients.providerOnce, clients.clientSetOnce, clients.discoveryOnce = sync.Once{}, sync.Once{}, sync.Once{}
+	clients.provider, clients.clientSet, clients.restConfig, clients.discovery = nil, nil, nil, nil
+}
+
+// KubeClientProvider returns the kube client provider shared by the workloads and the subsystems of kube-burner-ocp
+func KubeClientProvider() *config.KubeClientProvider {
+	clients.providerOnce.Do(func() {
+		clients.provider = config.NewKubeClientProvider("", "")
+	})
+	return clients.provider
+}
+
+// clientRateLimits returns the QPS and burst of the clients of kube-burner-ocp given by the CLIENT_QPS and CLIENT_BURST
+// environment variables. When not set, the QPS is the number of nodes of the cluster, between 20 and 200, and the burst twice the QPS
+func clientRateLimits(clientSet kubernetes.Interface) (float32, int) {
+	qps, _ := strconv.Atoi(os.Getenv("CLIENT_QPS"))
+	burst, _ := strconv.Atoi(os.Getenv("CLIENT_BURST"))
+	if qps == 0 {
+		qps = minClientQPS
+		// The remaining item count of a single item page gives the number of nodes without listing all of them
+		nodes, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{Limit: 1})
+		if err != nil {
+			log.Warnf("Error counting nodes, using the default client QPS: %v", err)
+		} else {
+			nodeCount := len(nodes.Items)
+			if nodes.RemainingItemCount != nil {
+				nodeCount += int(*nodes.RemainingItemCount)
+			}
+			qps = min(max(nodeCount, minClientQPS), maxClientQPS)
+		}
+	}
+	if burst == 0 {
+		burst = 2 * qps
+	}
+	return float32(qps), burst
+}
+
+// newClientSet returns the clientset of kube-burner-ocp, using protobuf to reduce the serialization overhead of its watchers
+// and samplers in the API server, along with its rest config for the clients of other API groups, which only support JSON.
+// All the clients created from them share the same rate limiter
+func newClientSet() (kubernetes.Interface, *rest.Config) {
+	clients.clientSetOnce.Do(func() {
+		defaultClientSet, restConfig := KubeClientProvider().DefaultClientSet()
+		restConfig.QPS, restConfig.Burst = clientRateLimits(defaultClientSet)
+		restConfig.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(restConfig.QPS, restConfig.Burst)
+		log.Debugf("kube-burner-ocp clients QPS: %v, burst: %d", restConfig.QPS, restConfig.Burst)
+		protobufConfig := rest.CopyConfig(restConfig)
+		protobufConfig.ContentType = runtime.ContentTypeProtobuf
+		protobufConfig.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+		clients.clientSet = kubernetes.NewForConfigOrDie(protobufConfig)
+		clients.restConfig = restConfig
+	})
+	// A copy, so the callers can tune it for their clients
+	return clients.clientSet, rest.CopyConfig(clients.restConfig)
+}
+
+// discoveryClient returns the discovery client of kube-burner-ocp, caching the API resources for the whole run so the
+// subsystems using them don't add discovery bursts to the API server metrics. When DISCOVERY_CACHE_DIR is set, they're
+// cached on disk between runs for the DISCOVERY_CACHE_TTL duration
+func discoveryClient() discovery.CachedDiscoveryInterface {
+	clients.discoveryOnce.Do(func() {
+		clientSet, restConfig := newClientSet()
+		if cacheDir := os.Getenv("DISCOVERY_CACHE_DIR"); cacheDir != "" {
+			ttl, _ := time.ParseDuration(os.Getenv("DISCOVERY_CACHE_TTL"))
+			cachedClient, err := disk.NewCachedDiscoveryClientForConfig(restConfig, path.Join(cacheDir, "discovery"), path.Join(cacheDir, "http"), ttl)
+			if err == nil {
+				clients.discovery = cachedClient
+				return
+			}
+			log.Warnf("Error creating the discovery cache in %s, caching in memory: %v", cacheDir, err)
+		}
+		clients.discovery = memory.NewMemCacheClient(clientSet.Discovery())
+	})
+	return clients.discovery
+}
+
+// EnableWatchList makes the informers of the run, including the ones of the kube-burner measurements, get their initial state
+// from a watch stream ending with a bookmark instead of a LIST, reducing the load of the measurements on the API server at scale.
+// The informers fall back to LIST when the API server doesn't support it
+func EnableWatchList() {
+	gates, ok := clientfeatures.FeatureGates().(interface {
+		Set(clientfeatures.Feature, bool) error
+	})
+	if !ok {
+		log.Warn("Unable to enable the WatchListClient feature gate")
+		return
+	}
+	if err := gates.Set(clientfeatures.WatchListClient, true); err != nil {
+		log.Warnf("Unable to enable the WatchListClient feature gate: %v", err)
+	}
+}
@@ -20,51 +20,51 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/kube-burner/kube-burner/pkg/config"
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 // NewClusterDensity holds cluster-density workload
 func NewClusterDensity(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
-	var iterations, churnPercent, churnCycles int
-	var churn, svcLatency, pprof bool
-	var churnDelay, churnDuration time.Duration
-	var churnDeletionStrategy string
+	var iterations int
+	var svcLatency, pprof bool
+	var churn *churnFlags
 	var podReadyThreshold time.Duration
-	var metricsProfiles []string
+	var metricsProfiles, extraTemplates []string
 	var rc int
 	cmd := &cobra.Command{
 		Use:   variant,
 		Short: fmt.Sprintf("Runs %v workload", variant),
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
-			os.Setenv("PPROF", fmt.Sprint(pprof))
-			os.Setenv("CHURN", fmt.Sprint(churn))
-			os.Setenv("CHURN_CYCLES", fmt.Sprintf("%v", churnCycles))
-			os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", churnDuration))
-			os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", churnDelay))
-			os.Setenv("CHURN_PERCENT", fmt.Sprint(churnPercent))
-			os.Setenv("CHURN_DELETION_STRATEGY", churnDeletionStrategy)
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
 			ingressDomain, err := wh.MetadataAgent.GetDefaultIngressDomain()
 			if err != nil {
-				log.Fatal("Error obtaining default ingress domain: ", err.Error())
+				return fmt.Errorf("error obtaining default ingress domain: %v", err)
 			}
 			os.Setenv("INGRESS_DOMAIN", ingressDomain)
+			if err := setExtraTemplatesEnv(extraTemplates); err != nil {
+				return err
+			}
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if cmd.Name() == "cluster-density-v2" {
-				kubeClientProvider := config.NewKubeClientProvider("", "")
-				clientSet, _ := kubeClientProvider.ClientSet(0, 0)
+				clientSet, _ := newClientSet()
 				if err := isClusterImageRegistryAvailable(clientSet); err != nil {
-					log.Fatal(err.Error())
+					return err
 				}
 			}
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -72,14 +72,10 @@ func NewClusterDensity(wh *workloads.WorkloadHelper, variant string) *cobra.Comm
 	}
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 2*time.Minute, "Pod ready timeout threshold")
 	cmd.Flags().IntVar(&iterations, "iterations", 0, fmt.Sprintf("%v iterations", variant))
-	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection")
-	cmd.Flags().BoolVar(&churn, "churn", true, "Enable churning")
-	cmd.Flags().IntVar(&churnCycles, "churn-cycles", 0, "Churn cycles to execute")
-	cmd.Flags().DurationVar(&churnDuration, "churn-duration", 1*time.Hour, "Churn duration")
-	cmd.Flags().DurationVar(&churnDelay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
-	cmd.Flags().IntVar(&churnPercent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
-	cmd.Flags().StringVar(&churnDeletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
+	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection of the OVN components, same as --pprof-targets=ovn")
+	churn = addChurnFlags(cmd, true, time.Hour)
 	cmd.Flags().BoolVar(&svcLatency, "service-latency", false, "Enable service latency measurement")
+	cmd.Flags().StringArrayVar(&extraTemplates, "extra-template", nil, "Additional object template to create in each iteration, file path or URL in path[:replicas] format, with 1 replica by default. Can be repeated")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-aggregated.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.MarkFlagRequired("iterations")
 	return cmd
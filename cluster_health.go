@@ -17,9 +17,7 @@ package ocp
 import (
 	"context"
 	"fmt"
-	"os"
 
-	"github.com/kube-burner/kube-burner/pkg/config"
 	"github.com/kube-burner/kube-burner/pkg/util"
 	v1 "github.com/openshift/api/config/v1"
 	"github.com/openshift/client-go/config/clientset/versioned"
@@ -33,28 +31,28 @@ import (
 // cluster health check
 func ClusterHealth() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "cluster-health",
-		Short: "Checks for ocp cluster health",
-		Run: func(cmd *cobra.Command, args []string) {
-			ClusterHealthCheck()
+		Use:          "cluster-health",
+		Short:        "Checks for ocp cluster health",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ClusterHealthCheck()
 		},
 	}
 	return cmd
 }
 
-func ClusterHealthCheck() {
+func ClusterHealthCheck() error {
 	log.Infof("❤️ Checking for Cluster Health")
-	kubeClientProvider := config.NewKubeClientProvider("", "")
-	clientSet, restConfig := kubeClientProvider.ClientSet(0, 0)
+	clientSet, restConfig := newClientSet()
 	openshiftClientset, err := versioned.NewForConfig(restConfig)
 	if err != nil {
-		log.Fatalf("Error creating OpenShift clientset: %v", err)
+		return fmt.Errorf("error creating OpenShift clientset: %v", err)
 	}
-	if util.ClusterHealthyVanillaK8s(clientSet) && isClusterHealthy(clientSet, openshiftClientset) {
-		log.Infof("Cluster is Healthy")
-	} else {
-		log.Fatalf("Cluster is Unhealthy")
+	if !util.ClusterHealthyVanillaK8s(clientSet) || !isClusterHealthy(clientSet, openshiftClientset) {
+		return fmt.Errorf("cluster is unhealthy")
 	}
+	log.Infof("Cluster is Healthy")
+	return nil
 }
 
 func isClusterHealthy(clientset kubernetes.Interface, openshiftClientset *versioned.Clientset) bool {
@@ -62,7 +60,7 @@ func isClusterHealthy(clientset kubernetes.Interface, openshiftClientset *versio
 	operators, err := openshiftClientset.ConfigV1().ClusterOperators().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		log.Errorf("Error retrieving Cluster Operators: %v", err)
-		os.Exit(1)
+		return false
 	}
 
 	for _, operator := range operators.Items {
@@ -15,35 +15,618 @@
 package ocp
 
 import (
+	"context"
+	"embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	ocpmetadata "github.com/cloud-bulldozer/go-commons/ocp-metadata"
+	"github.com/kube-burner/kube-burner/pkg/burner"
 	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/kube-burner/kube-burner/pkg/util/metrics"
 	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var clusterMetadata ocpmetadata.ClusterMetadata
 
-func setMetrics(cmd *cobra.Command, metricsProfiles []string) {
+func setMetrics(cmd *cobra.Command, metricsProfiles []string) error {
 	profileType, _ := cmd.Root().PersistentFlags().GetString("profile-type")
 	switch ProfileType(profileType) {
-	case Reporting:
+	case Reporting, MetricsReport:
 		metricsProfiles = []string{"metrics-report.yml"}
 	case Both:
 		metricsProfiles = append(metricsProfiles, "metrics-report.yml")
 	}
+	if ovnMetrics, _ := cmd.Root().PersistentFlags().GetBool("ovn-metrics"); ovnMetrics {
+		metricsProfiles = append(metricsProfiles, "metrics-ovn.yml")
+	}
+	extraQueries, err := extraQueriesProfile(cmd)
+	if err != nil {
+		return err
+	}
+	if extraQueries != "" {
+		metricsProfiles = append(metricsProfiles, extraQueries)
+	}
 	os.Setenv("METRICS", strings.Join(metricsProfiles, ","))
+	return nil
+}
+
+// churnFlags holds the churn flags shared by the workloads
+type churnFlags struct {
+	enabled          bool
+	cycles, percent  int
+	duration, delay  time.Duration
+	deletionStrategy string
+	pattern          string
+	patternPhases    int
+	patternMin       int
+}
+
+// addChurnFlags adds the churn flags to the given workload, churning is enabled by default when enabled is true
+func addChurnFlags(cmd *cobra.Command, enabled bool, duration time.Duration) *churnFlags {
+	churn := &churnFlags{}
+	cmd.Flags().BoolVar(&churn.enabled, "churn", enabled, "Enable churning")
+	cmd.Flags().IntVar(&churn.cycles, "churn-cycles", 0, "Churn cycles to execute")
+	cmd.Flags().DurationVar(&churn.duration, "churn-duration", duration, "Churn duration")
+	cmd.Flags().DurationVar(&churn.delay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
+	cmd.Flags().IntVar(&churn.percent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
+	cmd.Flags().StringVar(&churn.deletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
+	cmd.Flags().StringVar(&churn.pattern, "churn-pattern", churnPatternSteady, "Churn QPS pattern over the churn duration, supported options are: steady, spike or sinusoidal. Only cluster-density-v2, cluster-density-ms and node-density support patterns other than steady")
+	cmd.Flags().IntVar(&churn.patternPhases, "churn-pattern-phases", 12, "Number of phases the churn duration is split into for the spike and sinusoidal churn patterns")
+	cmd.Flags().IntVar(&churn.patternMin, "churn-pattern-min-percent", 10, "Percentage of the QPS and burst of the churn phases out of the spike, and of the lowest phases of the sinusoidal churn pattern")
+	return churn
+}
+
+// setEnv sets the environment variables consumed by the churn settings of the workload configs
+func (c *churnFlags) setEnv() error {
+	os.Setenv("CHURN", fmt.Sprint(c.enabled))
+	os.Setenv("CHURN_CYCLES", fmt.Sprint(c.cycles))
+	os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", c.duration))
+	os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", c.delay))
+	os.Setenv("CHURN_PERCENT", fmt.Sprint(c.percent))
+	os.Setenv("CHURN_DELETION_STRATEGY", c.deletionStrategy)
+	switch c.pattern {
+	case churnPatternSteady, churnPatternSpike, churnPatternSinusoidal:
+	default:
+		return fmt.Errorf("unsupported churn pattern %s, supported options are: steady, spike or sinusoidal", c.pattern)
+	}
+	if c.patternPhases < 1 || c.patternMin < 1 || c.patternMin > 100 {
+		return fmt.Errorf("--churn-pattern-phases must be positive and --churn-pattern-min-percent between 1 and 100")
+	}
+	os.Setenv("CHURN_PATTERN", c.pattern)
+	os.Setenv("CHURN_PATTERN_PHASES", fmt.Sprint(c.patternPhases))
+	os.Setenv("CHURN_PATTERN_MIN_PERCENT", fmt.Sprint(c.patternMin))
+	return nil
+}
+
+// metricQuery is an entry of a metrics profile
+type metricQuery struct {
+	Query        string `yaml:"query"`
+	MetricName   string `yaml:"metricName"`
+	Instant      bool   `yaml:"instant,omitempty"`
+	CaptureStart bool   `yaml:"captureStart,omitempty"`
+}
+
+// readMetricsProfile reads a metrics profile from the embedded configuration, a local file or a URL
+func readMetricsProfile(profile string, ocpConfig embed.FS, configDir string) ([]metricQuery, error) {
+	var metricsProfile []metricQuery
+	f, err := util.GetReader(profile, &ocpConfig, configDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metrics profile %s: %v", profile, err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metrics profile %s: %v", profile, err)
+	}
+	if err := yaml.Unmarshal(data, &metricsProfile); err != nil {
+		return nil, fmt.Errorf("error decoding metrics profile %s: %v", profile, err)
+	}
+	return metricsProfile, nil
+}
+
+// runWorkload runs the given workload along with the measurements, gates and outputs enabled by the flags, returning its rc
+func runWorkload(cmd *cobra.Command, wh *workloads.WorkloadHelper, workload string) (rc int, err error) {
+	// Recorded for Run, as the workload commands exit with it
+	defer func() { embeddedRun.rc = rc }()
+	defer closeArtifactsLogging()
+	defer StopTracing()
+	defer StopProgressExporter()
+	defer StopMetricsEndpointProxies()
+	if fleetSelector, _ := cmd.Root().PersistentFlags().GetString("fleet-selector"); fleetSelector != "" {
+		return runFleet(cmd, wh, fleetSelector)
+	}
+	cleanup, err := prepareWorkload(cmd, wh, workload)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+	snapshotBefore := takeSnapshotBefore(cmd, wh)
+	watchers, err := startWatchers(cmd, wh)
+	if err != nil {
+		watchers.stop()
+		return 0, err
+	}
+	stopAbortHandler := handleAbort(cmd, wh, watchers.stop)
+	runStart := time.Now().UTC()
+	endSpan := StartSpan("workload")
+	rc, err = runKubeBurner(wh, workload)
+	endSpan()
+	stopAbortHandler()
+	if err != nil {
+		watchers.stop()
+		return 0, err
+	}
+	// kube-burner can't be cancelled, so embedded runs whose context is done are aborted once it stops
+	if err := cmd.Context().Err(); err != nil {
+		return abortEmbeddedRun(cmd, wh, runStart, watchers.stop, err), nil
+	}
+	watchers.finish(cmd, rc)
+	cleanupWorkload(cmd, wh, rc, snapshotBefore)
+	runEnd := time.Now().UTC()
+	hcpAlertsFired := collectRunMetrics(cmd, wh, runStart, runEnd)
+	rc, sloResults := evaluateRun(cmd, wh, rc, hcpAlertsFired, runStart, runEnd)
+	writeRunOutputs(cmd, wh, rc, runStart, sloResults)
+	return rc, nil
+}
+
+// runKubeBurner runs kube-burner with the configuration of the given workload, like WorkloadHelper.Run does, returning the
+// errors reading or parsing it instead of exiting
+func runKubeBurner(wh *workloads.WorkloadHelper, workload string) (int, error) {
+	configFile := workload + ".yml"
+	var embedFS *embed.FS
+	var embedFSDir string
+	// The configuration in the current directory, i.e. an extracted one, takes precedence over the embedded one
+	if _, err := os.Stat(configFile); err != nil {
+		embedFS, embedFSDir = &ocpConfig, path.Join(wh.ConfigDir, workload)
+	}
+	f, err := util.GetReader(configFile, embedFS, embedFSDir)
+	if err != nil {
+		return 0, fmt.Errorf("error reading configuration file: %v", err)
+	}
+	workloads.ConfigSpec, err = config.ParseWithUserdata(wh.UUID, wh.Timeout, f, nil, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	workloads.ConfigSpec.EmbedFS, workloads.ConfigSpec.EmbedFSDir = embedFS, embedFSDir
+	for i := range workloads.ConfigSpec.MetricsEndpoints {
+		workloads.ConfigSpec.MetricsEndpoints[i].Endpoint = wh.PrometheusURL
+		workloads.ConfigSpec.MetricsEndpoints[i].Token = wh.PrometheusToken
+	}
+	metricsScraper := metrics.ProcessMetricsScraperConfig(metrics.ScraperConfig{
+		ConfigSpec:      &workloads.ConfigSpec,
+		MetricsEndpoint: wh.MetricsEndpoint,
+		SummaryMetadata: wh.SummaryMetadata,
+		MetricsMetadata: wh.MetricsMetadata,
+		UserMetaData:    wh.UserMetadata,
+	})
+	// The metrics of the jobs are scraped once kube-burner finishes, in windows of --chunk-duration, so the results of the range
+	// queries of long runs aren't kept in memory at once, and up to --scrape-concurrency windows or endpoints at once
+	prometheusClients := metricsScraper.PrometheusClients
+	metricsScraper.PrometheusClients = nil
+	recorder := &jobSummaryRecorder{}
+	for alias, indexer := range metricsScraper.IndexerList {
+		recorder.Indexer = indexer
+		metricsScraper.IndexerList[alias] = recorder
+		break
+	}
+	rc, err := burner.Run(workloads.ConfigSpec, KubeClientProvider(), metricsScraper)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	if len(prometheusClients) > 0 {
+		chunkDuration, _ := time.ParseDuration(os.Getenv("CHUNK_DURATION"))
+		concurrency, _ := strconv.Atoi(os.Getenv("SCRAPE_CONCURRENCY"))
+		if err := scrapeJobs(recorder.jobs, prometheusClients, ocpConfig, wh.ConfigDir, scrapeOptions{
+			chunkDuration: chunkDuration,
+			step:          prometheusClients[0].Step,
+			concurrency:   max(concurrency, 1),
+			metadata:      wh.MetricsMetadata,
+		}); err != nil {
+			log.Errorf("Error indexing metrics: %v", err)
+		}
+	}
+	log.Infof("👋 kube-burner run completed with rc %d for UUID %s", rc, wh.UUID)
+	return rc, nil
+}
+
+// prepareWorkload pre-pulls the images, creates the PriorityClass, runs the warm-up and reuses the namespaces of previous
+// runs before the workload, returning the function deleting the PriorityClass
+func prepareWorkload(cmd *cobra.Command, wh *workloads.WorkloadHelper, workload string) (func(), error) {
+	cleanup := func() {}
+	if prePull, _ := cmd.Root().PersistentFlags().GetBool("pre-pull"); prePull {
+		timeout, _ := cmd.Root().PersistentFlags().GetDuration("pre-pull-timeout")
+		if err := prePullImages(workload, timeout); err != nil {
+			log.Errorf("Error pre-pulling images: %v", err)
+		}
+	}
+	if priorityClass, _ := cmd.Root().PersistentFlags().GetString("priority-class"); priorityClass != "" {
+		value, _ := cmd.Root().PersistentFlags().GetInt32("priority-class-value")
+		deletePriorityClass, err := ensurePriorityClass(priorityClass, value)
+		if err != nil {
+			return nil, err
+		}
+		cleanup = deletePriorityClass
+	}
+	// JOB_ITERATIONS and the churn environment variables are set by the workloads before running
+	if warmupIterations, _ := cmd.Root().PersistentFlags().GetInt("warmup-iterations"); warmupIterations > 0 {
+		if err := runWarmup(wh, workload, warmupIterations); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	// After the warm-up, whose garbage collection deletes the namespaces of the run
+	if reuse, _ := cmd.Root().PersistentFlags().GetBool("reuse-namespaces"); reuse && slices.Contains(namespacePrefixWorkloads, workload) {
+		if err := reuseNamespaces(workload, wh.UUID); err != nil {
+			log.Errorf("Error reusing namespaces: %v", err)
+		}
+	}
+	if err := setJobStepsEnv(cmd, workload); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return cleanup, nil
+}
+
+// takeSnapshotBefore takes the cluster snapshot diffed once the workload finishes, if enabled
+func takeSnapshotBefore(cmd *cobra.Command, wh *workloads.WorkloadHelper) *clusterSnapshot {
+	if snapshot, _ := cmd.Root().PersistentFlags().GetBool("snapshot"); !snapshot {
+		return nil
+	}
+	snapshotBefore, err := takeSnapshot(wh.UUID, "before")
+	if err != nil {
+		log.Errorf("Error taking the cluster snapshot: %v", err)
+	}
+	return snapshotBefore
+}
+
+// runWatchers holds the probes, watchers and samplers running along with the workload
+type runWatchers struct {
+	wh                    *workloads.WorkloadHelper
+	probe                 *dataplaneProbe
+	imagePullWatcher      *imagePullWatcher
+	eventWatcher          *eventWatcher
+	podStartupWatcher     *podStartupWatcher
+	sampler               *nodeSampler
+	routeLatencyWatcher   *routeLatencyWatcher
+	vmiBootWatcher        *vmiBootWatcher
+	pvcLifecycleWatcher   *pvcLifecycleWatcher
+	egressIPWatcher       *egressIPWatcher
+	aclConvergenceWatcher *aclConvergenceWatcher
+	netpolEnforcement     *netpolEnforcementWatcher
+	tenantLabeler         *tenantLabeler
+	annotationWatcher     *annotationWatcher
+	iterationRetrier      *iterationRetrier
+	triageCollector       *triageCollector
+	chaos                 *chaosRunner
+	stopOnce              sync.Once
+}
+
+// startWatchers starts the watchers enabled by the flags. The watchers already started are returned along with the error
+// of the one failing to start, so they can be stopped
+func startWatchers(cmd *cobra.Command, wh *workloads.WorkloadHelper) (*runWatchers, error) {
+	w := &runWatchers{wh: wh}
+	var err error
+	if dataplaneProbes, _ := cmd.Root().PersistentFlags().GetBool("dataplane-probes"); dataplaneProbes {
+		interval, _ := cmd.Root().PersistentFlags().GetDuration("dataplane-probe-interval")
+		ipFamily, _ := cmd.Root().PersistentFlags().GetString("ip-family")
+		if w.probe, err = startDataplaneProbe(interval, ipFamily); err != nil {
+			log.Errorf("Error starting dataplane probes: %v", err)
+		}
+	}
+	if imagePullLatency, _ := cmd.Root().PersistentFlags().GetBool("image-pull-latency"); imagePullLatency {
+		w.imagePullWatcher = startImagePullWatcher(wh.UUID)
+	}
+	if events, _ := cmd.Root().PersistentFlags().GetBool("events"); events {
+		eventNamespaces, _ := cmd.Root().PersistentFlags().GetStringSlice("event-namespaces")
+		w.eventWatcher = startEventWatcher(wh.UUID, eventNamespaces)
+	}
+	if podStartupPhases, _ := cmd.Root().PersistentFlags().GetBool("pod-startup-phases"); podStartupPhases {
+		w.podStartupWatcher = startPodStartupWatcher(wh.UUID)
+	}
+	var nodeStats []nodeStat
+	if ovnMetrics, _ := cmd.Root().PersistentFlags().GetBool("ovn-metrics"); ovnMetrics {
+		nodeStats = append(nodeStats, ovnStats...)
+	}
+	if networkTables, _ := cmd.Root().PersistentFlags().GetBool("network-tables"); networkTables {
+		nodeStats = append(nodeStats, networkTableStats...)
+	}
+	if len(nodeStats) > 0 {
+		interval, _ := cmd.Root().PersistentFlags().GetDuration("node-sample-interval")
+		w.sampler = startNodeSampler(nodeStats, interval)
+	}
+	if routeLatency, _ := cmd.Root().PersistentFlags().GetBool("route-latency"); routeLatency {
+		w.routeLatencyWatcher = startRouteLatencyWatcher(wh.UUID)
+	}
+	if guestBootLatency, _ := cmd.Flags().GetBool("guest-boot-latency"); guestBootLatency {
+		w.vmiBootWatcher = startVMIBootWatcher(wh.UUID)
+	}
+	if pvcLifecycleLatency, _ := cmd.Flags().GetBool("pvc-lifecycle-latency"); pvcLifecycleLatency {
+		w.pvcLifecycleWatcher = startPVCLifecycleWatcher(wh.UUID)
+	}
+	if verifyEgressIPs, _ := cmd.Flags().GetBool("verify-egress-ips"); verifyEgressIPs {
+		failover, _ := cmd.Flags().GetString("failover")
+		failoverTimeout, _ := cmd.Flags().GetDuration("failover-timeout")
+		w.egressIPWatcher = startEgressIPWatcher(wh.UUID, failover, failoverTimeout)
+	}
+	if aclConvergence, _ := cmd.Flags().GetBool("acl-convergence"); aclConvergence {
+		w.aclConvergenceWatcher = startACLConvergenceWatcher(wh.UUID)
+	}
+	if netpolEnforcement, _ := cmd.Flags().GetBool("netpol-enforcement"); netpolEnforcement {
+		w.netpolEnforcement = startNetpolEnforcementWatcher(wh.UUID)
+	}
+	if distributionFile, _ := cmd.Root().PersistentFlags().GetString("tenant-distribution"); distributionFile != "" {
+		if w.tenantLabeler, err = startTenantLabeler(wh.UUID, distributionFile); err != nil {
+			return w, err
+		}
+	}
+	annotations, _ := cmd.Root().PersistentFlags().GetStringArray("annotation")
+	if annotationFile, _ := cmd.Root().PersistentFlags().GetString("annotation-file"); len(annotations) > 0 || annotationFile != "" {
+		if w.annotationWatcher, err = startAnnotationWatcher(annotations, annotationFile); err != nil {
+			return w, err
+		}
+	}
+	if retryFailedIterations, _ := cmd.Root().PersistentFlags().GetInt("retry-failed-iterations"); retryFailedIterations > 0 {
+		retryTimeout, _ := cmd.Root().PersistentFlags().GetDuration("retry-timeout")
+		w.iterationRetrier = startIterationRetrier(wh.UUID, retryFailedIterations, retryTimeout)
+	}
+	artifactsDir, _ := cmd.Root().PersistentFlags().GetString("artifacts-dir")
+	if triageSample, _ := cmd.Root().PersistentFlags().GetInt("triage-sample"); artifactsDir != "" && triageSample > 0 {
+		w.triageCollector = startTriageCollector(wh.UUID, artifactsDir, triageSample)
+	}
+	if chaosFile, _ := cmd.Root().PersistentFlags().GetString("chaos-file"); chaosFile != "" {
+		if w.chaos, err = startChaos(wh, chaosFile, cmd.Name()); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
+
+// finish runs the post-workload checks of the watchers, the triage, the egress IP failover, the isolation, ACL convergence
+// and network policy enforcement verifications, then stops them and runs the preemption phase
+func (w *runWatchers) finish(cmd *cobra.Command, rc int) {
+	if w.triageCollector != nil {
+		// Before the garbage collection removes the pods
+		if rc != 0 {
+			w.triageCollector.collect()
+		}
+		w.triageCollector.stop()
+	}
+	// Only once the workload succeeded, not when the run is aborted
+	if w.egressIPWatcher != nil && w.egressIPWatcher.failoverMode != "" && rc == 0 {
+		w.egressIPWatcher.failover(w.wh)
+	}
+	if verifyIsolation, _ := cmd.Flags().GetBool("verify-isolation"); verifyIsolation && rc == 0 {
+		verifyUDNIsolation(w.wh)
+	}
+	if w.aclConvergenceWatcher != nil && rc == 0 {
+		timeout, _ := cmd.Flags().GetDuration("acl-convergence-timeout")
+		w.aclConvergenceWatcher.wait(timeout)
+	}
+	if w.netpolEnforcement != nil && rc == 0 {
+		timeout, _ := cmd.Flags().GetDuration("netpol-enforcement-timeout")
+		w.netpolEnforcement.wait(timeout)
+	}
+	w.stop()
+	// After the watchers are stopped, so the pods recreated after being preempted aren't measured as part of the workload
+	if preemption, _ := cmd.Root().PersistentFlags().GetBool("preemption"); preemption && rc == 0 {
+		pods, _ := cmd.Root().PersistentFlags().GetInt("preemption-pods")
+		priority, _ := cmd.Root().PersistentFlags().GetInt32("preemption-priority")
+		timeout, _ := cmd.Root().PersistentFlags().GetDuration("preemption-timeout")
+		if err := runPreemption(w.wh, pods, priority, timeout); err != nil {
+			log.Errorf("Error running the preemption phase: %v", err)
+		}
+	}
+}
+
+// stop stops the watchers and indexes their measurements, also called when the run is aborted
+func (w *runWatchers) stop() {
+	w.stopOnce.Do(func() {
+		defer StartSpan("measurements")()
+		wh := w.wh
+		if w.chaos != nil {
+			w.chaos.stop(wh)
+		}
+		if w.probe != nil {
+			indexDataplaneSamples(wh, w.probe.stop())
+		}
+		if w.imagePullWatcher != nil {
+			w.imagePullWatcher.stop(wh)
+		}
+		if w.eventWatcher != nil {
+			w.eventWatcher.stop(wh)
+		}
+		if w.podStartupWatcher != nil {
+			w.podStartupWatcher.stop(wh)
+		}
+		if w.routeLatencyWatcher != nil {
+			w.routeLatencyWatcher.stop(wh)
+		}
+		if w.pvcLifecycleWatcher != nil {
+			w.pvcLifecycleWatcher.stop(wh)
+		}
+		if w.vmiBootWatcher != nil {
+			w.vmiBootWatcher.stop(wh)
+		}
+		if w.egressIPWatcher != nil {
+			w.egressIPWatcher.stop(wh)
+		}
+		if w.aclConvergenceWatcher != nil {
+			w.aclConvergenceWatcher.stop(wh)
+		}
+		if w.netpolEnforcement != nil {
+			w.netpolEnforcement.stop(wh)
+		}
+		if w.tenantLabeler != nil {
+			w.tenantLabeler.stop(wh)
+		}
+		if w.annotationWatcher != nil {
+			w.annotationWatcher.stop(wh)
+		}
+		if w.iterationRetrier != nil {
+			w.iterationRetrier.stop(wh)
+		}
+		if w.sampler != nil {
+			w.sampler.stop(wh)
+		}
+		if w.triageCollector != nil {
+			w.triageCollector.stop()
+		}
+	})
+}
+
+// cleanupWorkload indexes the auto-size recommendation and the job traces, garbage collects the resources of the run when
+// kube-burner doesn't and diffs the cluster snapshots
+func cleanupWorkload(cmd *cobra.Command, wh *workloads.WorkloadHelper, rc int, snapshotBefore *clusterSnapshot) {
+	indexAutoSize(wh)
+	traceJobs(wh.UUID)
+	if gc, _ := cmd.Root().PersistentFlags().GetBool("gc"); gc && os.Getenv("GC") == "false" {
+		ctx, cancel := context.WithTimeout(context.Background(), wh.Timeout)
+		garbageCollect(ctx, "kube-burner-uuid="+wh.UUID, gcOptionsFromFlags(cmd))
+		cancel()
+	}
+	if snapshotBefore != nil {
+		// Taken after the garbage collection, so the diff holds the resources left behind
+		if snapshotAfter, err := takeSnapshot(wh.UUID, "after"); err != nil {
+			log.Errorf("Error taking the cluster snapshot: %v", err)
+		} else {
+			indexSnapshotDiff(wh, snapshotBefore, snapshotAfter)
+		}
+	}
+}
+
+// collectRunMetrics measures the API request latency, the scheduler throughput and the audit logs and scrapes the hosted
+// control plane and user workload metrics of the run, returning whether the hosted control plane alerts fired
+func collectRunMetrics(cmd *cobra.Command, wh *workloads.WorkloadHelper, runStart, runEnd time.Time) bool {
+	if apiRequestLatency, _ := cmd.Root().PersistentFlags().GetBool("api-request-latency"); apiRequestLatency {
+		if err := measureAPIRequestLatency(wh); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	if schedulerThroughput, _ := cmd.Root().PersistentFlags().GetBool("scheduler-throughput"); schedulerThroughput {
+		if err := measureSchedulerThroughput(wh); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	if auditLog, _ := cmd.Root().PersistentFlags().GetBool("audit-log"); auditLog {
+		top, _ := cmd.Root().PersistentFlags().GetInt("audit-log-top")
+		if err := analyzeAuditLogs(wh, runStart, runEnd, top); err != nil {
+			log.Errorf("Error analyzing the audit logs: %v", err)
+		}
+	}
+	alertsFired := scrapeHostedControlPlane(wh, resultJobs(runStart, runEnd, hostedControlPlaneJob))
+	scrapeUserWorkloadMetrics(wh, resultJobs(runStart, runEnd, userWorkloadMonitoringJob))
+	return alertsFired
+}
+
+// evaluateRun evaluates the alerts, the SLOs, the threshold catalog and the regressions of the run, collecting the
+// must-gather when it fails, and returns the resulting rc along with the SLO results
+func evaluateRun(cmd *cobra.Command, wh *workloads.WorkloadHelper, rc int, hcpAlertsFired bool, runStart, runEnd time.Time) (int, []sloResult) {
+	alertGracePeriod, _ := cmd.Root().PersistentFlags().GetDuration("alert-grace-period")
+	if alertGracePeriod > 0 {
+		if evaluateAlertGracePeriod(wh, runEnd, alertGracePeriod) && rc == 0 {
+			rc = rcAlert
+		}
+	}
+	if alerting, _ := cmd.Root().PersistentFlags().GetBool("alerting"); alerting {
+		alertSeverity, _ := cmd.Root().PersistentFlags().GetString("fail-on-alert-severity")
+		alertProfiles, _ := cmd.Root().PersistentFlags().GetStringSlice("alert-profile")
+		rc = gateAlerts(wh, rc, alertSeverity, alertProfiles, runStart, runEnd.Add(alertGracePeriod))
+	}
+	// After the alerts are gated, as the hosted control plane alerts don't come from the alert profiles of the workload
+	if hcpAlertsFired && rc == 0 {
+		rc = rcAlert
+	}
+	if mustGather, _ := cmd.Root().PersistentFlags().GetBool("must-gather-on-failure"); mustGather && rc != 0 {
+		namespaces, _ := cmd.Root().PersistentFlags().GetStringSlice("must-gather-namespaces")
+		if err := collectMustGather(wh, namespaces); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	var sloResults []sloResult
+	if sloFile, _ := cmd.Root().PersistentFlags().GetString("slo-file"); sloFile != "" {
+		slos, err := readSLOFile(sloFile)
+		if err == nil {
+			sloResults, err = evaluateSLOs(wh, slos)
+		}
+		if err != nil {
+			log.Error(err.Error())
+			if rc == 0 {
+				rc = rcSLO
+			}
+		}
+		for _, result := range sloResults {
+			if !result.Passed && rc == 0 {
+				rc = rcSLO
+			}
+		}
+	}
+	catalogResults, catalogFailed := evaluateThresholdCatalog(cmd, wh)
+	sloResults = append(sloResults, catalogResults...)
+	if catalogFailed && rc == 0 {
+		rc = rcSLO
+	}
+	if baselineUUID, _ := cmd.Root().PersistentFlags().GetString("baseline-uuid"); baselineUUID != "" {
+		tolerance, _ := cmd.Root().PersistentFlags().GetFloat64("baseline-tolerance")
+		kpiTolerances, _ := cmd.Root().PersistentFlags().GetStringToInt("kpi-tolerance")
+		regressions, err := compareWithBaseline(wh, baselineUUID, tolerance, kpiTolerances)
+		if err != nil {
+			log.Error(err.Error())
+		} else if regressions > 0 && rc == 0 {
+			rc = rcRegression
+		}
+	}
+	if regressionRuns, _ := cmd.Root().PersistentFlags().GetInt("regression-runs"); regressionRuns > 0 {
+		stddevs, _ := cmd.Root().PersistentFlags().GetFloat64("regression-stddev")
+		flagged, err := detectRegressions(wh, regressionRuns, stddevs)
+		if err != nil {
+			log.Error(err.Error())
+		} else if flagged > 0 && rc == 0 {
+			rc = rcRegression
+		}
+	}
+	return rc, sloResults
+}
+
+// writeRunOutputs generates the reports, the CSV files, the summary table and the artifacts of the run
+func writeRunOutputs(cmd *cobra.Command, wh *workloads.WorkloadHelper, rc int, runStart time.Time, sloResults []sloResult) {
+	if reports, _ := cmd.Root().PersistentFlags().GetStringSlice("report"); len(reports) > 0 {
+		if err := generateReports(cmd, wh, reports, sloResults); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	if exportMeasurementsCSV, _ := cmd.Root().PersistentFlags().GetBool("csv"); exportMeasurementsCSV {
+		if err := exportCSV(localMetricsDirectory()); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	if summary, _ := cmd.Root().PersistentFlags().GetBool("summary"); summary {
+		if err := printRunSummary(wh); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	if artifactsDir, _ := cmd.Root().PersistentFlags().GetString("artifacts-dir"); artifactsDir != "" {
+		if err := writeArtifacts(cmd, wh, artifactsDir, rc, runStart, sloResults); err != nil {
+			log.Errorf("Error writing artifacts: %v", err)
+		}
+	}
 }
 
 // SetKubeBurnerFlags configures the required environment variables and flags for kube-burner
 func GatherMetadata(wh *workloads.WorkloadHelper, alerting bool) error {
 	var err error
-	kubeClientProvider := config.NewKubeClientProvider("", "")
-	_, restConfig := kubeClientProvider.DefaultClientSet()
+	_, restConfig := newClientSet()
 	wh.MetadataAgent, err = ocpmetadata.NewMetadata(restConfig)
 	if err != nil {
 		return err
@@ -68,5 +651,8 @@ func GatherMetadata(wh *workloads.WorkloadHelper, alerting bool) error {
 		"ocpMajorVersion": clusterMetadata.OCPMajorVersion,
 		"ocpVersion":      clusterMetadata.OCPVersion,
 	}
+	if err := gatherNetworkMetadata(wh); err != nil {
+		log.Warnf("Error gathering network plugin metadata: %v", err)
+	}
 	return nil
 }
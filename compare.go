@@ -0,0 +1,154 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+const baselineComparisonMetricName = "baselineComparison"
+
+// kpiDelta holds the comparison of a KPI between the baseline and the current run
+type kpiDelta struct {
+	Timestamp    time.Time              `json:"timestamp"`
+	UUID         string                 `json:"uuid"`
+	BaselineUUID string                 `json:"baselineUUID"`
+	KPI          string                 `json:"kpi"`
+	JobName      string                 `json:"jobName"`
+	Baseline     float64                `json:"baseline"`
+	Current      float64                `json:"current"`
+	Delta        float64                `json:"delta"`
+	Tolerance    float64                `json:"tolerance"`
+	Regression   bool                   `json:"regression"`
+	MetricName   string                 `json:"metricName"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// kpiValues returns the KPIs of a run indexed by KPI name and job name.
+// KPIs are the latency quantiles of the measurements, the job durations and the aggregated metrics, the lower the better
+func kpiValues(results runResults) map[[2]string]float64 {
+	values := make(map[[2]string]float64)
+	for _, metric := range results.aggregatedMetrics {
+		var labels []string
+		for k, v := range metric.Labels {
+			labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(labels)
+		kpi := metric.MetricName
+		if len(labels) > 0 {
+			kpi += "{" + strings.Join(labels, ",") + "}"
+		}
+		values[[2]string{kpi, metric.JobName}] = metric.Value
+	}
+	for _, jobSummary := range results.jobSummaries {
+		values[[2]string{"elapsedTime", jobSummary.JobConfig.Name}] = jobSummary.ElapsedTime
+	}
+	for _, lq := range results.latencyQuantiles {
+		prefix := fmt.Sprintf("%s.%s.", lq.MetricName, lq.QuantileName)
+		values[[2]string{prefix + "P99", lq.JobName}] = float64(lq.P99)
+		values[[2]string{prefix + "P95", lq.JobName}] = float64(lq.P95)
+		values[[2]string{prefix + "P50", lq.JobName}] = float64(lq.P50)
+		values[[2]string{prefix + "avg", lq.JobName}] = float64(lq.Avg)
+	}
+	return values
+}
+
+// kpiTolerance returns the tolerance of the given KPI, the longest matching prefix in kpiTolerances takes precedence over the default tolerance
+func kpiTolerance(kpi string, tolerance float64, kpiTolerances map[string]int) float64 {
+	var longestPrefix string
+	for prefix, t := range kpiTolerances {
+		if strings.HasPrefix(kpi, prefix) && len(prefix) > len(longestPrefix) {
+			longestPrefix = prefix
+			tolerance = float64(t)
+		}
+	}
+	return tolerance
+}
+
+// compareResults compares the KPIs present in both runs, a KPI regresses when it's higher than the baseline by more than its tolerance percentage
+func compareResults(baseline, current runResults, tolerance float64, kpiTolerances map[string]int) []kpiDelta {
+	var deltas []kpiDelta
+	baselineValues := kpiValues(baseline)
+	for key, value := range kpiValues(current) {
+		baselineValue, ok := baselineValues[key]
+		if !ok || baselineValue == 0 {
+			continue
+		}
+		delta := kpiDelta{
+			KPI:       key[0],
+			JobName:   key[1],
+			Baseline:  baselineValue,
+			Current:   value,
+			Delta:     (value - baselineValue) * 100 / baselineValue,
+			Tolerance: kpiTolerance(key[0], tolerance, kpiTolerances),
+		}
+		delta.Regression = delta.Delta > delta.Tolerance
+		deltas = append(deltas, delta)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].JobName != deltas[j].JobName {
+			return deltas[i].JobName < deltas[j].JobName
+		}
+		return deltas[i].KPI < deltas[j].KPI
+	})
+	return deltas
+}
+
+// compareWithBaseline compares the results of the run with the baseline UUID, indexing a baselineComparison document per KPI.
+// Returns the number of regressions found
+func compareWithBaseline(wh *workloads.WorkloadHelper, baselineUUID string, tolerance float64, kpiTolerances map[string]int) (int, error) {
+	defer StartSpan("baseline-comparison")()
+	var regressions int
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return 0, fmt.Errorf("baseline comparison requires a local indexer")
+	}
+	current, err := readResults(metricsDirectory)
+	if err != nil {
+		return 0, err
+	}
+	esServer, esIndex := esEndpoint()
+	baseline, err := loadResults(baselineUUID, esServer, esIndex)
+	if err != nil {
+		return 0, fmt.Errorf("error loading baseline %s: %v", baselineUUID, err)
+	}
+	var documents []interface{}
+	for _, delta := range compareResults(baseline, current, tolerance, kpiTolerances) {
+		delta.Timestamp = time.Now().UTC()
+		delta.UUID = wh.UUID
+		delta.BaselineUUID = baselineUUID
+		delta.MetricName = baselineComparisonMetricName
+		delta.Metadata = wh.MetricsMetadata
+		if delta.Regression {
+			regressions++
+			log.Errorf("❌ %s in job %s regressed %.2f%% (tolerance %.0f%%): %.2f → %.2f", delta.KPI, delta.JobName, delta.Delta, delta.Tolerance, delta.Baseline, delta.Current)
+		} else {
+			log.Infof("✅ %s in job %s: %+.2f%%: %.2f → %.2f", delta.KPI, delta.JobName, delta.Delta, delta.Baseline, delta.Current)
+		}
+		documents = append(documents, delta)
+	}
+	if len(documents) == 0 {
+		log.Warnf("No common KPIs found with the baseline %s", baselineUUID)
+		return 0, nil
+	}
+	indexDocuments(baselineComparisonMetricName, documents)
+	return regressions, nil
+}
@@ -0,0 +1,363 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var kubeBurnerOcpRunGVR = schema.GroupVersionResource{
+	Group:    "kube-burner.io",
+	Version:  "v1alpha1",
+	Resource: "kubeburnerocpruns",
+}
+
+// Phases of a KubeBurnerOcpRun
+const (
+	runPhasePending   = "Pending"
+	runPhaseRunning   = "Running"
+	runPhaseSucceeded = "Succeeded"
+	runPhaseFailed    = "Failed"
+)
+
+// kubeBurnerOcpRunSpec describes the workload run by a KubeBurnerOcpRun
+type kubeBurnerOcpRunSpec struct {
+	Workload string `json:"workload"`
+	// Flags of the workload and global flags by name
+	Flags map[string]string `json:"flags"`
+	// Schedule in cron format to run the workload recurrently, it runs once when empty
+	Schedule string `json:"schedule"`
+}
+
+// kubeBurnerOcpRunResults are the results of the last run of a KubeBurnerOcpRun
+type kubeBurnerOcpRunResults struct {
+	ElapsedTime float64 `json:"elapsedTime"`
+	Alerts      int     `json:"alerts"`
+	// PodReadyP99 is the highest Ready P99 pod latency of the jobs, in milliseconds
+	PodReadyP99      int    `json:"podReadyP99,omitempty"`
+	MetricsDirectory string `json:"metricsDirectory,omitempty"`
+}
+
+// kubeBurnerOcpRunStatus is the status of the last run of a KubeBurnerOcpRun
+type kubeBurnerOcpRunStatus struct {
+	Phase            string                   `json:"phase,omitempty"`
+	UUID             string                   `json:"uuid,omitempty"`
+	ReturnCode       *int                     `json:"returnCode,omitempty"`
+	Message          string                   `json:"message,omitempty"`
+	StartTime        *metav1.Time             `json:"startTime,omitempty"`
+	CompletionTime   *metav1.Time             `json:"completionTime,omitempty"`
+	LastScheduleTime *metav1.Time             `json:"lastScheduleTime,omitempty"`
+	Runs             int                      `json:"runs,omitempty"`
+	Progress         *runProgress             `json:"progress,omitempty"`
+	Results          *kubeBurnerOcpRunResults `json:"results,omitempty"`
+}
+
+// runController executes the KubeBurnerOcpRuns, one at a time
+type runController struct {
+	client         dynamic.Interface
+	statusInterval time.Duration
+	cron           *cron.Cron
+	mu             sync.Mutex
+	// schedules holds the cron entries of the scheduled runs by key, along with their schedule
+	schedules map[string]scheduledRun
+	queued    map[string]bool
+	// started holds the UUIDs of the runs started by this controller, to tell them apart from the interrupted ones
+	started map[string]bool
+	queue   chan string
+}
+
+type scheduledRun struct {
+	schedule string
+	entryID  cron.EntryID
+}
+
+// NewController runs the workloads described by KubeBurnerOcpRun custom resources
+func NewController() *cobra.Command {
+	var namespace string
+	var statusInterval time.Duration
+	cmd := &cobra.Command{
+		Use:          "controller",
+		Short:        "Runs the workloads described by KubeBurnerOcpRun custom resources",
+		Long:         "Watches the KubeBurnerOcpRun custom resources and runs their workloads, once or recurrently with a cron schedule, updating their status with the progress and results of the runs. Runs are executed one at a time",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			util.ConfigureLogging(cmd)
+			_, restConfig := newClientSet()
+			rc := &runController{
+				client:         dynamic.NewForConfigOrDie(restConfig),
+				statusInterval: statusInterval,
+				cron:           cron.New(),
+				schedules:      make(map[string]scheduledRun),
+				queued:         make(map[string]bool),
+				started:        make(map[string]bool),
+				queue:          make(chan string, 100),
+			}
+			trackProgress = true
+			factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(rc.client, 0, namespace, nil)
+			informer := factory.ForResource(kubeBurnerOcpRunGVR).Informer()
+			informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    rc.reconcile,
+				UpdateFunc: func(_, obj interface{}) { rc.reconcile(obj) },
+				DeleteFunc: rc.forget,
+			})
+			stopCh := make(chan struct{})
+			factory.Start(stopCh)
+			if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+				close(stopCh)
+				return fmt.Errorf("error syncing the KubeBurnerOcpRun informer")
+			}
+			rc.cron.Start()
+			log.Infof("Watching KubeBurnerOcpRuns")
+			// On SIGINT or SIGTERM, the running workload is aborted before exiting
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			rc.worker(ctx)
+			log.Info("Stopping the controller")
+			rc.cron.Stop()
+			close(stopCh)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&namespace, "namespace", metav1.NamespaceAll, "Namespace of the KubeBurnerOcpRuns to watch, all of them by default")
+	cmd.Flags().DurationVar(&statusInterval, "status-interval", 30*time.Second, "Interval between updates of the progress of the running workload")
+	return cmd
+}
+
+func decodeRun(obj interface{}) (kubeBurnerOcpRunSpec, kubeBurnerOcpRunStatus, error) {
+	var spec kubeBurnerOcpRunSpec
+	var status kubeBurnerOcpRunStatus
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return spec, status, fmt.Errorf("unexpected object %T", obj)
+	}
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return spec, status, err
+	}
+	var run struct {
+		Spec   kubeBurnerOcpRunSpec   `json:"spec"`
+		Status kubeBurnerOcpRunStatus `json:"status"`
+	}
+	err = json.Unmarshal(data, &run)
+	return run.Spec, run.Status, err
+}
+
+// reconcile queues the new runs and keeps the cron entries of the scheduled ones in sync with their schedule
+func (rc *runController) reconcile(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	spec, status, err := decodeRun(obj)
+	if err != nil {
+		log.Errorf("Error decoding KubeBurnerOcpRun %s: %v", key, err)
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if spec.Schedule != "" {
+		if scheduled, ok := rc.schedules[key]; ok {
+			if scheduled.schedule == spec.Schedule {
+				return
+			}
+			rc.cron.Remove(scheduled.entryID)
+		}
+		entryID, err := rc.cron.AddFunc(spec.Schedule, func() {
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			rc.enqueue(key)
+		})
+		if err != nil {
+			rc.patchStatus(key, &kubeBurnerOcpRunStatus{Phase: runPhaseFailed, Message: fmt.Sprintf("invalid schedule: %v", err)})
+			return
+		}
+		rc.schedules[key] = scheduledRun{schedule: spec.Schedule, entryID: entryID}
+		log.Infof("KubeBurnerOcpRun %s scheduled with %s", key, spec.Schedule)
+	} else if scheduled, ok := rc.schedules[key]; ok {
+		rc.cron.Remove(scheduled.entryID)
+		delete(rc.schedules, key)
+	}
+	switch status.Phase {
+	case "":
+		if spec.Schedule == "" {
+			rc.enqueue(key)
+		}
+	case runPhasePending:
+		// Queued before the controller restarted
+		if !rc.queued[key] {
+			rc.enqueue(key)
+		}
+	case runPhaseRunning:
+		if !rc.started[status.UUID] {
+			status.Phase, status.Message = runPhaseFailed, "interrupted by a restart of the controller"
+			rc.patchStatus(key, &status)
+		}
+	}
+}
+
+// enqueue queues the run of the given key unless it's already queued or running, must be called with the lock held
+func (rc *runController) enqueue(key string) {
+	if rc.queued[key] {
+		log.Warnf("KubeBurnerOcpRun %s is still queued or running, skipping", key)
+		return
+	}
+	select {
+	case rc.queue <- key:
+	default:
+		log.Errorf("Run queue is full, skipping KubeBurnerOcpRun %s", key)
+		return
+	}
+	rc.queued[key] = true
+	rc.patchStatus(key, &kubeBurnerOcpRunStatus{Phase: runPhasePending})
+}
+
+func (rc *runController) forget(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if scheduled, ok := rc.schedules[key]; ok {
+		rc.cron.Remove(scheduled.entryID)
+		delete(rc.schedules, key)
+	}
+}
+
+// patchStatus merges the given status into the status of the run, a nil one clears it
+func (rc *runController) patchStatus(key string, status *kubeBurnerOcpRunStatus) {
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return
+	}
+	_, err = rc.client.Resource(kubeBurnerOcpRunGVR).Namespace(namespace).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		log.Errorf("Error updating the status of KubeBurnerOcpRun %s: %v", key, err)
+	}
+}
+
+// worker executes the queued runs until the context is done
+func (rc *runController) worker(ctx context.Context) {
+	for {
+		select {
+		case key := <-rc.queue:
+			rc.execute(ctx, key)
+			rc.mu.Lock()
+			delete(rc.queued, key)
+			rc.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rc *runController) execute(ctx context.Context, key string) {
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	obj, err := rc.client.Resource(kubeBurnerOcpRunGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Error getting KubeBurnerOcpRun %s: %v", key, err)
+		return
+	}
+	spec, status, err := decodeRun(obj)
+	if err != nil {
+		log.Errorf("Error decoding KubeBurnerOcpRun %s: %v", key, err)
+		return
+	}
+	flags := make(map[string]string)
+	for k, v := range spec.Flags {
+		flags[k] = v
+	}
+	// A new UUID for every run of a scheduled workload
+	if flags["uuid"] == "" || spec.Schedule != "" {
+		flags["uuid"] = uuid.NewString()
+	}
+	now := metav1.Now()
+	status = kubeBurnerOcpRunStatus{
+		Phase:     runPhaseRunning,
+		UUID:      flags["uuid"],
+		StartTime: &now,
+		Runs:      status.Runs + 1,
+	}
+	if spec.Schedule != "" {
+		status.LastScheduleTime = &now
+	}
+	rc.mu.Lock()
+	rc.started[status.UUID] = true
+	rc.mu.Unlock()
+	// Clears the results of the previous run
+	rc.patchStatus(key, nil)
+	rc.patchStatus(key, &status)
+	log.Infof("Running KubeBurnerOcpRun %s: %s with UUID %s", key, spec.Workload, status.UUID)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(rc.statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if snapshot, ok := progressSnapshot(); ok {
+					rc.patchStatus(key, &kubeBurnerOcpRunStatus{Progress: &snapshot})
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	result, err := Run(ctx, workloadFlags{name: spec.Workload, CommonOptions: CommonOptions{Flags: flags}})
+	close(done)
+	completion := metav1.Now()
+	status.CompletionTime, status.ReturnCode = &completion, &result.ReturnCode
+	status.Phase = runPhaseSucceeded
+	if err != nil {
+		status.Phase, status.Message = runPhaseFailed, err.Error()
+	} else {
+		if !result.Passed {
+			status.Phase, status.Message = runPhaseFailed, fmt.Sprintf("workload finished with return code %d", result.ReturnCode)
+		}
+		status.Results = &kubeBurnerOcpRunResults{
+			ElapsedTime:      result.ElapsedTime.Seconds(),
+			Alerts:           result.Alerts,
+			MetricsDirectory: result.MetricsDirectory,
+		}
+		for _, lq := range result.LatencyQuantiles {
+			if lq.MetricName == "podLatencyQuantilesMeasurement" && lq.QuantileName == "Ready" {
+				status.Results.PodReadyP99 = max(status.Results.PodReadyP99, lq.P99)
+			}
+		}
+	}
+	rc.patchStatus(key, &status)
+	log.Infof("KubeBurnerOcpRun %s finished: %s", key, status.Phase)
+}
@@ -15,6 +15,7 @@
 package ocp
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -22,28 +23,104 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Validation keywords added to the string and integer properties of the CRD schemas
+var (
+	stringValidations = []map[string]interface{}{
+		{"maxLength": 253},
+		{"minLength": 1},
+		{"pattern": "^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"},
+	}
+	integerValidations = []map[string]interface{}{
+		{"minimum": 0},
+		{"maximum": 1000000},
+		{"multipleOf": 1},
+	}
+)
+
 // NewCrdScale holds the crd-scale workload
 func NewCrdScale(wh *workloads.WorkloadHelper) *cobra.Command {
-	var iterations int
+	var iterations, properties, depth, validations, celRules int
 	var metricsProfiles []string
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "crd-scale",
 		Short:        "Runs crd-scale workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
+			if properties < 1 || depth < 1 || celRules < 0 || validations < 0 || validations > len(stringValidations) {
+				return fmt.Errorf("--schema-properties and --schema-depth must be positive, --schema-cel-rules can't be negative and --schema-validations must be between 0 and %d", len(stringValidations))
+			}
+			schema, err := json.Marshal(crdSchema(properties, depth, validations, celRules))
+			if err != nil {
+				return err
+			}
+			os.Setenv("CRD_SCHEMA", string(schema))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
 	cmd.Flags().IntVar(&iterations, "iterations", 0, "Number of CRDs to create")
+	cmd.Flags().IntVar(&properties, "schema-properties", 2, "Number of properties of each object of the CRD schemas, alternating strings and integers")
+	cmd.Flags().IntVar(&depth, "schema-depth", 1, "Nesting depth of the CRD schemas, each object but the deepest one has a nested object along with its properties")
+	cmd.Flags().IntVar(&validations, "schema-validations", 0, fmt.Sprintf("Number of validation keywords of each property of the CRD schemas, up to %d: maxLength, minLength and pattern for strings, minimum, maximum and multipleOf for integers", len(stringValidations)))
+	cmd.Flags().IntVar(&celRules, "schema-cel-rules", 0, "Number of CEL validation rules of each object of the CRD schemas")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-aggregated.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.MarkFlagRequired("iterations")
 	return cmd
 }
+
+// crdSchema returns the OpenAPI schema of the CRDs, whose spec is an object with the given properties, validations and CEL rules,
+// nested up to the given depth
+func crdSchema(properties, depth, validations, celRules int) map[string]interface{} {
+	var object func(level int) map[string]interface{}
+	object = func(level int) map[string]interface{} {
+		fields := make(map[string]interface{})
+		for i := range properties {
+			field := map[string]interface{}{"type": "string"}
+			fieldValidations := stringValidations
+			if i%2 == 1 {
+				field["type"] = "integer"
+				fieldValidations = integerValidations
+			}
+			for _, validation := range fieldValidations[:validations] {
+				for k, v := range validation {
+					field[k] = v
+				}
+			}
+			fields[fmt.Sprintf("field%d", i)] = field
+		}
+		if level < depth {
+			fields["nested"] = object(level + 1)
+		}
+		schema := map[string]interface{}{"type": "object", "properties": fields}
+		// Each rule checks a property, optional ones must be checked with has()
+		var rules []map[string]interface{}
+		for i := range celRules {
+			field := fmt.Sprintf("field%d", i%properties)
+			rule := fmt.Sprintf("!has(self.%s) || self.%s.size() <= %d", field, field, 253+i)
+			if i%properties%2 == 1 {
+				rule = fmt.Sprintf("!has(self.%s) || self.%s >= %d", field, field, -i)
+			}
+			rules = append(rules, map[string]interface{}{"rule": rule, "message": fmt.Sprintf("rule %d of %s", i, field)})
+		}
+		if len(rules) > 0 {
+			schema["x-kubernetes-validations"] = rules
+		}
+		return schema
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"spec": object(1)},
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of the indexer credentials given by --es-credentials
+const (
+	credentialsEnv   = "env"
+	credentialsFile  = "file"
+	credentialsVault = "vault"
+)
+
+// indexerCredentials authenticate the requests to Elasticsearch or OpenSearch
+type indexerCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// indexerAuth holds the credentials of the Elasticsearch or OpenSearch server given by --es-server, kept out of its URL
+var indexerAuth indexerCredentials
+
+// readIndexerCredentials returns the given Elasticsearch or OpenSearch URL without credentials, along with the credentials read
+// from the given source: env, from the ES_USERNAME and ES_PASSWORD environment variables, file:<directory>, from the username and
+// password files of the directory where a secret is mounted, or vault:<path>, from the username and password keys of the Vault
+// secret in the given path, logging in with the AppRole given by the VAULT_ROLE_ID and VAULT_SECRET_ID environment variables.
+// Without source, the credentials are the ones embedded in the URL
+func readIndexerCredentials(esServer, source string) (string, indexerCredentials, error) {
+	var credentials indexerCredentials
+	u, err := url.Parse(esServer)
+	if err != nil {
+		return "", credentials, fmt.Errorf("invalid Elasticsearch server URL: %v", err)
+	}
+	if source == "" {
+		if u.User != nil {
+			log.Warn("Elasticsearch credentials embedded in --es-server end up in the shell history and the CI logs, use --es-credentials instead")
+		}
+		server, credentials := splitIndexerCredentials(esServer)
+		return server, credentials, nil
+	}
+	kind, location, _ := strings.Cut(source, ":")
+	switch kind {
+	case credentialsEnv:
+		credentials = indexerCredentials{Username: os.Getenv("ES_USERNAME"), Password: os.Getenv("ES_PASSWORD")}
+	case credentialsFile:
+		credentials, err = readCredentialsDir(location)
+	case credentialsVault:
+		credentials, err = readVaultCredentials(location)
+	default:
+		return "", credentials, fmt.Errorf("unsupported credentials source %s, supported options are: env, file:<directory> or vault:<path>", source)
+	}
+	if err != nil {
+		return "", credentials, fmt.Errorf("error reading the Elasticsearch credentials from %s: %v", kind, err)
+	}
+	if credentials.Username == "" || credentials.Password == "" {
+		return "", credentials, fmt.Errorf("no Elasticsearch username or password found in %s", kind)
+	}
+	log.Infof("Using the Elasticsearch credentials of %s from %s", credentials.Username, kind)
+	u.User = nil
+	return u.String(), credentials, nil
+}
+
+// splitIndexerCredentials returns the given URL without the credentials it embeds, along with them, or with the ones of
+// --es-server when it doesn't embed any
+func splitIndexerCredentials(esServer string) (string, indexerCredentials) {
+	u, err := url.Parse(esServer)
+	if err != nil || u.User == nil {
+		return esServer, indexerAuth
+	}
+	password, _ := u.User.Password()
+	credentials := indexerCredentials{Username: u.User.Username(), Password: password}
+	u.User = nil
+	return u.String(), credentials
+}
+
+// indexerURL returns the given URL with the credentials of --es-server, for the indexers of go-commons, which only take
+// them embedded in the URL of the servers
+func indexerURL(esServer string) string {
+	u, err := url.Parse(esServer)
+	if err != nil || u.User != nil || indexerAuth.Username == "" {
+		return esServer
+	}
+	u.User = url.UserPassword(indexerAuth.Username, indexerAuth.Password)
+	return u.String()
+}
+
+// readCredentialsDir reads the username and password files of the given directory, the layout of a mounted Kubernetes secret
+func readCredentialsDir(directory string) (indexerCredentials, error) {
+	var credentials indexerCredentials
+	for file, value := range map[string]*string{"username": &credentials.Username, "password": &credentials.Password} {
+		data, err := os.ReadFile(path.Join(directory, file))
+		if err != nil {
+			return credentials, err
+		}
+		*value = strings.TrimSpace(string(data))
+	}
+	return credentials, nil
+}
+
+// readVaultCredentials logs in to the Vault server given by VAULT_ADDR with the AppRole given by VAULT_ROLE_ID and VAULT_SECRET_ID,
+// or VAULT_SECRET_ID_FILE, and reads the username and password keys of the KV secret in the given path, i.e. secret/data/perfscale/es
+func readVaultCredentials(secretPath string) (indexerCredentials, error) {
+	var credentials indexerCredentials
+	vaultAddr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if vaultAddr == "" || secretPath == "" {
+		return credentials, fmt.Errorf("VAULT_ADDR and the secret path are required")
+	}
+	secretID := os.Getenv("VAULT_SECRET_ID")
+	if secretIDFile := os.Getenv("VAULT_SECRET_ID_FILE"); secretIDFile != "" {
+		data, err := os.ReadFile(secretIDFile)
+		if err != nil {
+			return credentials, err
+		}
+		secretID = strings.TrimSpace(string(data))
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	login, _ := json.Marshal(map[string]string{"role_id": os.Getenv("VAULT_ROLE_ID"), "secret_id": secretID})
+	var auth struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := vaultRequest(httpClient, http.MethodPost, vaultAddr+"/v1/auth/approle/login", "", login, &auth); err != nil {
+		return credentials, fmt.Errorf("error logging in with the AppRole: %v", err)
+	}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := vaultRequest(httpClient, http.MethodGet, vaultAddr+"/v1/"+strings.TrimPrefix(secretPath, "/"), auth.Auth.ClientToken, nil, &secret); err != nil {
+		return credentials, fmt.Errorf("error reading secret %s: %v", secretPath, err)
+	}
+	// KV version 2 secrets nest the keys in another data field
+	data := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return credentials, err
+		}
+	}
+	json.Unmarshal(data["username"], &credentials.Username)
+	json.Unmarshal(data["password"], &credentials.Password)
+	return credentials, nil
+}
+
+func vaultRequest(httpClient *http.Client, method, endpoint, token string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exportCSV writes a CSV file next to every measurement JSON file of the metrics directory.
+// Nested fields, such as metadata, are not exported
+func exportCSV(metricsDirectory string) error {
+	if metricsDirectory == "" {
+		return fmt.Errorf("CSV export requires a local indexer")
+	}
+	measurementFiles, _ := filepath.Glob(filepath.Join(metricsDirectory, "*Measurement*.json"))
+	for _, measurementFile := range measurementFiles {
+		var documents []map[string]interface{}
+		if err := readDocuments(measurementFile, &documents); err != nil {
+			return err
+		}
+		csvFile := strings.TrimSuffix(measurementFile, ".json") + ".csv"
+		if err := writeCSV(csvFile, documents); err != nil {
+			return fmt.Errorf("error writing %s: %v", csvFile, err)
+		}
+		log.Infof("Measurement exported to %s", csvFile)
+	}
+	return nil
+}
+
+func writeCSV(fileName string, documents []map[string]interface{}) error {
+	var header []string
+	columns := make(map[string]bool)
+	for _, doc := range documents {
+		for k, v := range doc {
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				continue
+			}
+			if !columns[k] {
+				columns[k] = true
+				header = append(header, k)
+			}
+		}
+	}
+	sort.Strings(header)
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(header)
+	for _, doc := range documents {
+		row := make([]string, len(header))
+		for i, k := range header {
+			if v, ok := doc[k]; ok && v != nil {
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -22,29 +22,26 @@ import (
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func CustomWorkload(wh *workloads.WorkloadHelper) *cobra.Command {
-	var churn, namespacedIterations, svcLatency bool
-	var churnDelay, churnDuration, podReadyThreshold time.Duration
-	var configFile, churnDeletionStrategy string
-	var iterations, churnPercent, churnCycles, iterationsPerNamespace, podsPerNode int
+	var namespacedIterations, svcLatency bool
+	var podReadyThreshold time.Duration
+	var configFile, sha256sums string
+	var iterations, iterationsPerNamespace, podsPerNode int
+	var churn *churnFlags
 	var rc int
 	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "Runs custom workload",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			os.Setenv("CHURN", fmt.Sprint(churn))
-			os.Setenv("CHURN_CYCLES", fmt.Sprintf("%v", churnCycles))
-			os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", churnDuration))
-			os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", churnDelay))
-			os.Setenv("CHURN_PERCENT", fmt.Sprint(churnPercent))
-			os.Setenv("CHURN_DELETION_STRATEGY", churnDeletionStrategy)
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			ingressDomain, err := wh.MetadataAgent.GetDefaultIngressDomain()
 			if err != nil {
-				log.Fatal("Error obtaining default ingress domain: ", err.Error())
+				return fmt.Errorf("error obtaining default ingress domain: %v", err)
 			}
 			os.Setenv("INGRESS_DOMAIN", ingressDomain)
 			os.Setenv("ITERATIONS_PER_NAMESPACE", fmt.Sprint(iterationsPerNamespace))
@@ -55,32 +52,45 @@ func CustomWorkload(wh *workloads.WorkloadHelper) *cobra.Command {
 				totalPods := clusterMetadata.WorkerNodesCount * podsPerNode
 				podCount, err := wh.MetadataAgent.GetCurrentPodCount()
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
 				os.Setenv("JOB_ITERATIONS", fmt.Sprint((totalPods-podCount)/2))
 			}
 			os.Setenv("NAMESPACED_ITERATIONS", fmt.Sprint(namespacedIterations))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if isRemoteConfig(configFile) {
+				workDir, err := os.MkdirTemp("", "kube-burner-ocp-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(workDir)
+				workload, err := fetchRemoteConfig(configFile, sha256sums, workDir)
+				if err != nil {
+					os.RemoveAll(workDir)
+					return fmt.Errorf("error fetching custom configuration: %v", err)
+				}
+				rc, err = runWorkload(cmd, wh, workload)
+				return err
+			}
 			if _, err := os.Stat(configFile); err != nil {
-				log.Fatalf("Error reading custom configuration file: %v", err.Error())
+				return fmt.Errorf("error reading custom configuration file: %v", err)
 			}
 			configFileName := strings.Split(configFile, ".")[0]
-			rc = wh.Run(configFileName)
+			var err error
+			rc, err = runWorkload(cmd, wh, configFileName)
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
-	cmd.Flags().StringVarP(&configFile, "config", "c", "", "Config file path or url")
-	cmd.Flags().BoolVar(&churn, "churn", true, "Enable churning")
-	cmd.Flags().IntVar(&churnCycles, "churn-cycles", 0, "Churn cycles to execute")
-	cmd.Flags().DurationVar(&churnDelay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
-	cmd.Flags().StringVar(&churnDeletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
-	cmd.Flags().DurationVar(&churnDuration, "churn-duration", 5*time.Minute, "Churn duration")
-	cmd.Flags().IntVar(&churnPercent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "Config file path, HTTP(S) URL, git reference in git::<repository>//<path>?ref=<reference> format or OCI bundle in oci://<registry>/<repository>:<tag> format. The relative object templates and profiles of the remote ones are fetched too")
+	cmd.Flags().StringVar(&sha256sums, "sha256sums", "", "Path or URL of a file in sha256sum format with the checksums of the remote config file and its object templates, relative to the directory of the config file")
+	churn = addChurnFlags(cmd, true, 5*time.Minute)
 	cmd.Flags().IntVar(&iterations, "iterations", 0, "Job iterations. Mutually exclusive with '--pods-per-node'")
 	cmd.Flags().IntVar(&iterationsPerNamespace, "iterations-per-namespace", 1, "Iterations per namespace")
 	// Adding a super set of flags from other commands so users can decide if they want to use them
@@ -0,0 +1,313 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	dataplaneNamespace = "kube-burner-dataplane"
+	dataplaneProbeName = "dataplane-probe"
+	dataplaneImage     = "quay.io/cloud-bulldozer/k8s-netperf:latest"
+	// Maximum number of pod pairs probed in each round
+	dataplaneMaxPairs = 10
+	// Duration of each netperf and iperf3 test
+	dataplaneTestDuration = 5
+)
+
+// dataplaneSample holds the result of probing the dataplane between two nodes
+type dataplaneSample struct {
+	Timestamp       time.Time              `json:"timestamp"`
+	UUID            string                 `json:"uuid"`
+	SourceNode      string                 `json:"sourceNode"`
+	DestinationNode string                 `json:"destinationNode"`
+	IPFamily        string                 `json:"ipFamily"`
+	P50Latency      float64                `json:"p50Latency"`
+	P99Latency      float64                `json:"p99Latency"`
+	Throughput      float64                `json:"throughput"`
+	MetricName      string                 `json:"metricName"`
+	JobName         string                 `json:"jobName,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type dataplaneProbe struct {
+	clientSet  kubernetes.Interface
+	restConfig *rest.Config
+	interval   time.Duration
+	ipFamily   string
+	samples    []dataplaneSample
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// startDataplaneProbe deploys the probe daemonset in the worker nodes and starts probing the dataplane between them every interval,
+// through the pod IPs of the given --ip-family
+func startDataplaneProbe(interval time.Duration, ipFamily string) (*dataplaneProbe, error) {
+	clientSet, restConfig := newClientSet()
+	dp := &dataplaneProbe{
+		clientSet:  clientSet,
+		restConfig: restConfig,
+		interval:   interval,
+		ipFamily:   ipFamily,
+		stopCh:     make(chan struct{}),
+	}
+	log.Infof("Deploying dataplane probes in namespace %s", dataplaneNamespace)
+	if err := dp.deploy(); err != nil {
+		dp.cleanup()
+		return nil, err
+	}
+	dp.wg.Add(1)
+	go dp.run()
+	return dp, nil
+}
+
+func (dp *dataplaneProbe) deploy() error {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: dataplaneNamespace,
+			Labels: map[string]string{
+				"security.openshift.io/scc.podSecurityLabelSync": "false",
+				"pod-security.kubernetes.io/enforce":             "privileged",
+				"pod-security.kubernetes.io/audit":               "privileged",
+				"pod-security.kubernetes.io/warn":                "privileged",
+			},
+		},
+	}
+	if _, err := dp.clientSet.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating namespace %s: %v", dataplaneNamespace, err)
+	}
+	labels := map[string]string{"app": dataplaneProbeName}
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: dataplaneProbeName},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					NodeSelector: map[string]string{"node-role.kubernetes.io/worker": ""},
+					Containers: []corev1.Container{
+						{
+							Name:    dataplaneProbeName,
+							Image:   dataplaneImage,
+							Command: []string{"/bin/sh", "-c", "netserver && iperf3 -s"},
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: ptr.To(false),
+								Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+								RunAsNonRoot:             ptr.To(true),
+								SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := dp.clientSet.AppsV1().DaemonSets(dataplaneNamespace).Create(context.TODO(), ds, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating daemonset %s: %v", dataplaneProbeName, err)
+	}
+	return wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+		ds, err := dp.clientSet.AppsV1().DaemonSets(dataplaneNamespace).Get(ctx, dataplaneProbeName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return ds.Status.DesiredNumberScheduled > 0 && ds.Status.NumberReady == ds.Status.DesiredNumberScheduled, nil
+	})
+}
+
+func (dp *dataplaneProbe) run() {
+	defer dp.wg.Done()
+	ticker := time.NewTicker(dp.interval)
+	defer ticker.Stop()
+	for round := 0; ; round++ {
+		dp.probe(round)
+		select {
+		case <-ticker.C:
+		case <-dp.stopCh:
+			return
+		}
+	}
+}
+
+// probe measures the dataplane between pairs of probe pods running in different nodes,
+// the destination of each pod rotates every round to cover different node pairs
+func (dp *dataplaneProbe) probe(round int) {
+	pods, err := dp.clientSet.CoreV1().Pods(dataplaneNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "app=" + dataplaneProbeName,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		log.Errorf("Error listing dataplane probe pods: %v", err)
+		return
+	}
+	n := len(pods.Items)
+	if n < 2 {
+		log.Warn("At least two dataplane probe pods are required")
+		return
+	}
+	offset := round%(n-1) + 1
+	for i := 0; i < n && i < dataplaneMaxPairs; i++ {
+		src := pods.Items[(round*dataplaneMaxPairs+i)%n]
+		dst := pods.Items[((round*dataplaneMaxPairs+i)%n+offset)%n]
+		ips := podIPs(&dst, dp.ipFamily)
+		if len(ips) == 0 {
+			log.Warnf("Dataplane probe pod in %s has no %s IP", dst.Spec.NodeName, dp.ipFamily)
+			continue
+		}
+		for _, ip := range ips {
+			sample, err := dp.probePair(src, dst, ip)
+			if err != nil {
+				log.Warnf("Dataplane probe from %s to %s at %s failed: %v", src.Spec.NodeName, dst.Spec.NodeName, ip, err)
+				continue
+			}
+			dp.samples = append(dp.samples, sample)
+		}
+	}
+}
+
+func (dp *dataplaneProbe) probePair(src, dst corev1.Pod, ip string) (dataplaneSample, error) {
+	sample := dataplaneSample{
+		Timestamp:       time.Now().UTC(),
+		SourceNode:      src.Spec.NodeName,
+		DestinationNode: dst.Spec.NodeName,
+		IPFamily:        string(corev1.IPv4Protocol),
+	}
+	// netperf and iperf3 connect over IPv4 unless told otherwise
+	familyFlag := "-4"
+	if net.ParseIP(ip).To4() == nil {
+		sample.IPFamily = string(corev1.IPv6Protocol)
+		familyFlag = "-6"
+	}
+	// netperf prints the selected output fields as comma separated values
+	rr, err := execInPod(dp.clientSet, dp.restConfig, src, dataplaneProbeName,
+		"netperf", familyFlag, "-H", ip, "-t", "TCP_RR", "-l", strconv.Itoa(dataplaneTestDuration), "-P", "0", "--", "-o", "P50_LATENCY,P99_LATENCY")
+	if err != nil {
+		return sample, err
+	}
+	latencies := strings.Split(rr, ",")
+	if len(latencies) != 2 {
+		return sample, fmt.Errorf("unexpected netperf output: %s", rr)
+	}
+	if sample.P50Latency, err = strconv.ParseFloat(strings.TrimSpace(latencies[0]), 64); err != nil {
+		return sample, err
+	}
+	if sample.P99Latency, err = strconv.ParseFloat(strings.TrimSpace(latencies[1]), 64); err != nil {
+		return sample, err
+	}
+	stream, err := execInPod(dp.clientSet, dp.restConfig, src, dataplaneProbeName,
+		"iperf3", familyFlag, "-c", ip, "-t", strconv.Itoa(dataplaneTestDuration), "-J")
+	if err != nil {
+		return sample, err
+	}
+	var iperfResult struct {
+		End struct {
+			SumReceived struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+			} `json:"sum_received"`
+		} `json:"end"`
+	}
+	if err := json.Unmarshal([]byte(stream), &iperfResult); err != nil {
+		return sample, fmt.Errorf("error decoding iperf3 output: %v", err)
+	}
+	sample.Throughput = iperfResult.End.SumReceived.BitsPerSecond / 1e6
+	return sample, nil
+}
+
+// stop stops probing, removes the probe namespace and returns the collected samples
+func (dp *dataplaneProbe) stop() []dataplaneSample {
+	close(dp.stopCh)
+	dp.wg.Wait()
+	dp.cleanup()
+	return dp.samples
+}
+
+func (dp *dataplaneProbe) cleanup() {
+	log.Infof("Deleting namespace %s", dataplaneNamespace)
+	if err := dp.clientSet.CoreV1().Namespaces().Delete(context.TODO(), dataplaneNamespace, metav1.DeleteOptions{}); err != nil {
+		log.Errorf("Error deleting namespace %s: %v", dataplaneNamespace, err)
+	}
+}
+
+// indexDataplaneSamples indexes the dataplane samples and their quantiles per job, the job of each sample is the one running when it was taken
+func indexDataplaneSamples(wh *workloads.WorkloadHelper, samples []dataplaneSample) {
+	var jobSummaries []burner.JobSummary
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		readDocuments(path.Join(metricsDirectory, "jobSummary.json"), &jobSummaries)
+	}
+	var docs []interface{}
+	latencies := make(map[string]map[string][]float64)
+	throughputs := make(map[string]map[string][]float64)
+	families := make(map[string]bool)
+	var jobNames []string
+	for _, sample := range samples {
+		sample.JobName = jobAt(jobSummaries, sample.Timestamp)
+		sample.UUID = wh.UUID
+		sample.MetricName = "dataplaneMeasurement"
+		sample.Metadata = wh.MetricsMetadata
+		docs = append(docs, sample)
+		if _, exists := latencies[sample.JobName]; !exists {
+			jobNames = append(jobNames, sample.JobName)
+			latencies[sample.JobName] = make(map[string][]float64)
+			throughputs[sample.JobName] = make(map[string][]float64)
+		}
+		families[sample.IPFamily] = true
+		latencies[sample.JobName][sample.IPFamily] = append(latencies[sample.JobName][sample.IPFamily], sample.P99Latency)
+		throughputs[sample.JobName][sample.IPFamily] = append(throughputs[sample.JobName][sample.IPFamily], sample.Throughput)
+	}
+	if len(docs) == 0 {
+		log.Warn("No dataplane samples collected")
+		return
+	}
+	var quantiles []interface{}
+	for _, jobName := range jobNames {
+		for _, family := range sortedKeys(latencies[jobName]) {
+			// The quantiles of each family are kept apart in dual-stack, as their performance differs
+			var suffix string
+			if len(families) > 1 {
+				suffix = "-" + family
+			}
+			for name, values := range map[string][]float64{"p99Latency": latencies[jobName][family], "throughput": throughputs[jobName][family]} {
+				q := metrics.NewLatencySummary(values, name+suffix)
+				q.UUID = wh.UUID
+				q.MetricName = "dataplaneQuantiles"
+				q.JobName = jobName
+				q.Metadata = wh.MetricsMetadata
+				quantiles = append(quantiles, q)
+			}
+		}
+	}
+	indexDocuments("dataplaneMeasurement", docs)
+	indexDocuments("dataplaneQuantiles", quantiles)
+}
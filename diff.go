@@ -0,0 +1,85 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// NewDiff compares the KPIs of two runs
+func NewDiff(ocpConfig embed.FS, configDir string) *cobra.Command {
+	var metricsProfiles []string
+	var rc int
+	cmd := &cobra.Command{
+		Use:          "diff <uuid or metrics directory A> <uuid or metrics directory B>",
+		Short:        "Compares the KPIs of two runs",
+		Long:         "Compares the measurement quantiles, job durations and aggregated metrics of two runs, read from local metrics directories or from Elasticsearch, and prints the per-KPI deltas of B compared to A",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		PostRun: func(cmd *cobra.Command, args []string) {
+			os.Exit(rc)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var metricNames []string
+			var runs [2]runResults
+			esServer, _ := cmd.Flags().GetString("es-server")
+			esIndex, _ := cmd.Flags().GetString("es-index")
+			tolerance, _ := cmd.Flags().GetFloat64("baseline-tolerance")
+			kpiTolerances, _ := cmd.Flags().GetStringToInt("kpi-tolerance")
+			for _, profile := range metricsProfiles {
+				metricsProfile, err := readMetricsProfile(profile, ocpConfig, configDir)
+				if err != nil {
+					return err
+				}
+				for _, metric := range metricsProfile {
+					metricNames = append(metricNames, metric.MetricName)
+				}
+			}
+			for i, source := range args {
+				results, err := loadResults(source, esServer, esIndex)
+				if err != nil {
+					return fmt.Errorf("error loading %s: %v", source, err)
+				}
+				if len(metricNames) > 0 {
+					results.aggregatedMetrics, err = loadAggregatedMetrics(source, esServer, esIndex, metricNames)
+					if err != nil {
+						return fmt.Errorf("error loading aggregated metrics of %s: %v", source, err)
+					}
+				}
+				runs[i] = results
+			}
+			deltas := compareResults(runs[0], runs[1], tolerance, kpiTolerances)
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "KPI\tJOB\tA\tB\tDELTA\tRESULT")
+			for _, delta := range deltas {
+				result := "ok"
+				if delta.Regression {
+					result = fmt.Sprintf("regression (tolerance %.0f%%)", delta.Tolerance)
+					rc = rcRegression
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%+.2f%%\t%s\n", delta.KPI, delta.JobName, delta.Baseline, delta.Current, delta.Delta, result)
+			}
+			tw.Flush()
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-report.yml"}, "Comma separated list of metrics profiles whose aggregated metrics are compared")
+	return cmd
+}
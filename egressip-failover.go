@@ -0,0 +1,286 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	egressIPFailoverMetric          = "egressIPFailover"
+	egressIPFailoverQuantilesMetric = "egressIPFailoverQuantiles"
+	// Failover modes: the node hosting the egress IPs is cordoned and made non egress-assignable, or rebooted
+	egressIPFailoverCordon = "cordon"
+	egressIPFailoverReboot = "reboot"
+	// Label of the nodes OVN-Kubernetes can assign egress IPs to
+	egressAssignableLabel = "k8s.ovn.org/egress-assignable"
+)
+
+// egressIPFailover holds the failover results of an EgressIP object with egress IPs assigned to the failover node, with the latencies
+// in milliseconds since the node was disrupted
+type egressIPFailover struct {
+	Timestamp           time.Time   `json:"timestamp"`
+	UUID                string      `json:"uuid"`
+	Name                string      `json:"egressIPName"`
+	Namespace           string      `json:"namespace"`
+	Mode                string      `json:"mode"`
+	FailoverNode        string      `json:"failoverNode"`
+	Nodes               []string    `json:"nodes"`
+	NewNodes            []string    `json:"newNodes"`
+	Reassigned          bool        `json:"reassigned"`
+	ReassignmentLatency int         `json:"reassignmentLatency"`
+	Pods                int         `json:"pods"`
+	RecoveredPods       int         `json:"recoveredPods"`
+	Recovered           bool        `json:"recovered"`
+	RecoveryLatency     int         `json:"recoveryLatency"`
+	MetricName          string      `json:"metricName"`
+	JobName             string      `json:"jobName,omitempty"`
+	Metadata            interface{} `json:"metadata,omitempty"`
+}
+
+// failover verifies the EgressIP objects, disrupts the node hosting the most egress IPs of the verified ones and measures how long it
+// takes to reassign them to other nodes, and for the traffic of their client pods to recover, which the eipvalidator app reports. The
+// client pods running in the failover node are left out, since they're disrupted too. The node is restored afterwards and the results
+// are indexed
+func (eiw *egressIPWatcher) failover(wh *workloads.WorkloadHelper) {
+	eiw.verify(wh)
+	results := eiw.results
+	clientSet, restConfig := newClientSet()
+	eiw.mu.Lock()
+	hosted := make(map[string]int)
+	for name, et := range eiw.egressIPs {
+		if !results[name].Verified {
+			continue
+		}
+		nodes, _ := assignedNodes(et.egressIP)
+		for _, node := range nodes {
+			hosted[node]++
+		}
+	}
+	var failoverNode string
+	for _, node := range sortedKeys(hosted) {
+		if hosted[node] > hosted[failoverNode] {
+			failoverNode = node
+		}
+	}
+	if failoverNode == "" {
+		eiw.mu.Unlock()
+		log.Warn("No verified EgressIP objects to fail over")
+		return
+	}
+	failovers := make(map[string]*egressIPFailover)
+	for _, name := range sortedKeys(eiw.egressIPs) {
+		et := eiw.egressIPs[name]
+		nodes, _ := assignedNodes(et.egressIP)
+		if !results[name].Verified || !slices.Contains(nodes, failoverNode) {
+			continue
+		}
+		et.failover = true
+		failovers[name] = &egressIPFailover{
+			UUID:         wh.UUID,
+			Name:         name,
+			Namespace:    egressIPNamespace(et.egressIP),
+			Mode:         eiw.failoverMode,
+			FailoverNode: failoverNode,
+			Nodes:        nodes,
+			MetricName:   egressIPFailoverMetric,
+			JobName:      results[name].JobName,
+			Metadata:     wh.MetricsMetadata,
+		}
+	}
+	eiw.failoverNode = failoverNode
+	eiw.mu.Unlock()
+	log.Infof("Failing over node %s hosting egress IPs of %d EgressIP objects, mode: %s", failoverNode, len(failovers), eiw.failoverMode)
+	start := time.Now()
+	restore, err := disruptNode(clientSet, restConfig, failoverNode, eiw.failoverMode, eiw.failoverTimeout)
+	if err != nil {
+		log.Errorf("Error disrupting node %s: %v", failoverNode, err)
+		return
+	}
+	deadline := start.Add(eiw.failoverTimeout)
+	for {
+		var pending int
+		eiw.mu.Lock()
+		for name, failover := range failovers {
+			et := eiw.egressIPs[name]
+			if !failover.Reassigned && !et.reassigned.IsZero() {
+				failover.Reassigned = true
+				failover.ReassignmentLatency = max(int(et.reassigned.Sub(start).Milliseconds()), 0)
+				failover.NewNodes = et.failoverNodes
+			}
+			if !failover.Reassigned {
+				pending++
+			}
+		}
+		eiw.mu.Unlock()
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	for {
+		var pending int
+		for name, failover := range failovers {
+			if failover.Recovered || !failover.Reassigned {
+				continue
+			}
+			eiw.mu.Lock()
+			egressIP := eiw.egressIPs[name].egressIP
+			eiw.mu.Unlock()
+			recoverEgressIP(clientSet, egressIP, failover)
+			if !failover.Recovered {
+				pending++
+			}
+		}
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	restore()
+	var docs, quantiles []interface{}
+	var jobNames []string
+	var failed int
+	latencies := make(map[string]map[string][]float64)
+	for _, name := range sortedKeys(failovers) {
+		failover := failovers[name]
+		failover.Timestamp = start.UTC()
+		if !failover.Recovered {
+			failed++
+			log.Warnf("EgressIP %s didn't recover: reassigned %v, %d/%d pods got a response with its egress IPs", name, failover.Reassigned, failover.RecoveredPods, failover.Pods)
+		}
+		if _, exists := latencies[failover.JobName]; !exists {
+			jobNames = append(jobNames, failover.JobName)
+			latencies[failover.JobName] = make(map[string][]float64)
+		}
+		if failover.Reassigned {
+			latencies[failover.JobName]["Reassigned"] = append(latencies[failover.JobName]["Reassigned"], float64(failover.ReassignmentLatency))
+		}
+		if failover.Recovered {
+			latencies[failover.JobName]["Recovered"] = append(latencies[failover.JobName]["Recovered"], float64(failover.RecoveryLatency))
+		}
+		docs = append(docs, failover)
+	}
+	if failed > 0 {
+		log.Errorf("%d/%d EgressIP objects didn't recover from the failover of node %s", failed, len(docs), failoverNode)
+	} else {
+		log.Infof("Traffic of the %d EgressIP objects recovered from the failover of node %s", len(docs), failoverNode)
+	}
+	for _, jobName := range jobNames {
+		for condition, values := range latencies[jobName] {
+			q := metrics.NewLatencySummary(values, condition)
+			q.UUID = wh.UUID
+			q.MetricName = egressIPFailoverQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(egressIPFailoverMetric, docs)
+	indexDocuments(egressIPFailoverQuantilesMetric, quantiles)
+}
+
+// recoverEgressIP sets the EgressIP object as recovered when all its running client pods out of the failover node got a response with
+// one of its egress IPs after the interruption, and its recovery latency is the slowest one of the pods
+func recoverEgressIP(clientSet kubernetes.Interface, egressIP *unstructured.Unstructured, failover *egressIPFailover) {
+	podsMetrics, err := clientPodsMetrics(clientSet, egressIP)
+	if err != nil {
+		log.Errorf("Error reading the metrics of the client pods of EgressIP %s: %v", failover.Name, err)
+		return
+	}
+	failover.Pods, failover.RecoveredPods, failover.RecoveryLatency = 0, 0, 0
+	for _, m := range podsMetrics {
+		if m.pod.Spec.NodeName == failover.FailoverNode {
+			continue
+		}
+		failover.Pods++
+		if m.recoveryLatency > 0 {
+			failover.RecoveredPods++
+			failover.RecoveryLatency = max(failover.RecoveryLatency, int(m.recoveryLatency*1000))
+		}
+	}
+	failover.Recovered = failover.Pods > 0 && failover.RecoveredPods == failover.Pods
+}
+
+// disruptNode disrupts the given node so its egress IPs fail over, and returns the function restoring it. In cordon mode the node is
+// cordoned and its egress-assignable label removed, and in reboot mode it's rebooted and the restore function waits until it's ready
+func disruptNode(clientSet kubernetes.Interface, restConfig *rest.Config, nodeName, mode string, timeout time.Duration) (func(), error) {
+	node, err := clientSet.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case egressIPFailoverCordon:
+		value, labeled := node.Labels[egressAssignableLabel]
+		patch := func(label interface{}, unschedulable bool) error {
+			data, _ := json.Marshal(map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{egressAssignableLabel: label}},
+				"spec":     map[string]interface{}{"unschedulable": unschedulable},
+			})
+			_, err := clientSet.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, data, metav1.PatchOptions{})
+			return err
+		}
+		// A null label is removed by the merge patch
+		if err := patch(nil, true); err != nil {
+			return nil, err
+		}
+		return func() {
+			var label interface{}
+			if labeled {
+				label = value
+			}
+			if err := patch(label, node.Spec.Unschedulable); err != nil {
+				log.Errorf("Error restoring node %s: %v", nodeName, err)
+				return
+			}
+			log.Infof("Node %s restored", nodeName)
+		}, nil
+	case egressIPFailoverReboot:
+		bootID := node.Status.NodeInfo.BootID
+		if err := nodeExec(clientSet, restConfig, nodeName, "systemctl", "reboot"); err != nil {
+			return nil, err
+		}
+		return func() {
+			// The node is ready again once it reports a new boot ID
+			err := wait.PollUntilContextTimeout(context.TODO(), 10*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+				node, err := clientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+				if err != nil {
+					return false, nil
+				}
+				return node.Status.NodeInfo.BootID != bootID && nodeReady(*node), nil
+			})
+			if err != nil {
+				log.Errorf("Node %s not ready after the reboot: %v", nodeName, err)
+				return
+			}
+			log.Infof("Node %s ready after the reboot", nodeName)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported failover mode %s, supported modes are: %s and %s", mode, egressIPFailoverCordon, egressIPFailoverReboot)
+}
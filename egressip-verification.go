@@ -0,0 +1,400 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	egressIPMetric          = "egressIPVerification"
+	egressIPQuantilesMetric = "egressIPVerificationQuantiles"
+	// The client pods of each iteration send their requests to the port 9002 + iteration % 60 of the external server
+	echoServerBasePort = 9002
+	echoServerPorts    = 60
+	// Port and metrics of the eipvalidator app run by the client pods
+	eipValidatorPort           = "8080"
+	eipStartupLatencyMetric    = "scale_eip_startup_latency_total"
+	eipRecoveryLatencyMetric   = "scale_eip_recovery_latency"
+	eipNonEgressRequestsMetric = "scale_startup_non_eip_total"
+	// Time given to the client pods to get a response with their egress IPs once the job finished
+	egressIPVerificationTimeout = 2 * time.Minute
+)
+
+var egressIPGVR = schema.GroupVersionResource{Group: "k8s.ovn.org", Version: "v1", Resource: "egressips"}
+
+// egressIPVerification holds the assignment and dataplane verification results of an EgressIP object, with the latencies in milliseconds
+type egressIPVerification struct {
+	Timestamp           time.Time   `json:"timestamp"`
+	UUID                string      `json:"uuid"`
+	Name                string      `json:"egressIPName"`
+	Namespace           string      `json:"namespace"`
+	EgressIPs           []string    `json:"egressIPs"`
+	Nodes               []string    `json:"nodes"`
+	Assigned            bool        `json:"assigned"`
+	AssignmentLatency   int         `json:"assignmentLatency"`
+	Pods                int         `json:"pods"`
+	VerifiedPods        int         `json:"verifiedPods"`
+	Verified            bool        `json:"verified"`
+	VerificationLatency int         `json:"verificationLatency"`
+	NonEgressIPRequests int         `json:"nonEgressIPRequests"`
+	MetricName          string      `json:"metricName"`
+	JobName             string      `json:"jobName,omitempty"`
+	Metadata            interface{} `json:"metadata,omitempty"`
+}
+
+// egressIPTimestamps holds the EgressIP object and when all its egress IPs were assigned to nodes. When failed over, it also holds
+// when they were all reassigned to other nodes than the failover one
+type egressIPTimestamps struct {
+	egressIP             *unstructured.Unstructured
+	assigned, reassigned time.Time
+	nodes, failoverNodes []string
+	failover             bool
+}
+
+// eipValidatorMetrics holds the metrics of the eipvalidator app of a client pod, with the latencies in seconds
+type eipValidatorMetrics struct {
+	pod                                                  corev1.Pod
+	startupLatency, recoveryLatency, nonEgressIPRequests float64
+}
+
+type egressIPWatcher struct {
+	stopCh    chan struct{}
+	egressIPs map[string]*egressIPTimestamps
+	// Failover mode and timeout, and the node disrupted once the failover starts
+	failoverMode    string
+	failoverTimeout time.Duration
+	failoverNode    string
+	// Verification results, once verified
+	results map[string]*egressIPVerification
+	mu      sync.Mutex
+}
+
+// echoServer replies to every request with its source IP, which is the egress IP when the traffic of the client pods egresses through it
+type echoServer struct {
+	servers []*http.Server
+}
+
+// startEchoServer listens in the ports the client pods of the egressip workload send their requests to
+func startEchoServer() (*echoServer, error) {
+	es := &echoServer{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprint(w, host)
+	})
+	for port := echoServerBasePort; port < echoServerBasePort+echoServerPorts; port++ {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			es.stop()
+			return nil, fmt.Errorf("error starting the echo server: %v", err)
+		}
+		server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		es.servers = append(es.servers, server)
+		go server.Serve(listener)
+	}
+	log.Infof("Echo server listening in ports %d-%d", echoServerBasePort, echoServerBasePort+echoServerPorts-1)
+	return es, nil
+}
+
+func (es *echoServer) stop() {
+	for _, server := range es.servers {
+		server.Close()
+	}
+}
+
+// echoServerAddress returns the local address used to reach the API server, which the client pods are expected to reach too
+func echoServerAddress() (string, error) {
+	_, restConfig := newClientSet()
+	u, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	// No packets are sent, dialing UDP only selects the route
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", fmt.Errorf("error detecting the echo server address: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// startEgressIPWatcher watches the EgressIP objects created by the run with the given UUID to record when their egress IPs are assigned
+// to nodes. With a failover mode, the node hosting most of them can be disrupted once the workload finished
+func startEgressIPWatcher(uuid, failoverMode string, failoverTimeout time.Duration) *egressIPWatcher {
+	_, restConfig := newClientSet()
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	eiw := &egressIPWatcher{
+		stopCh:          make(chan struct{}),
+		egressIPs:       make(map[string]*egressIPTimestamps),
+		failoverMode:    failoverMode,
+		failoverTimeout: failoverTimeout,
+	}
+	informer := dynamicinformer.NewFilteredDynamicInformer(dynamicClient, egressIPGVR, metav1.NamespaceAll, 0, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			eiw.handleEgressIP(obj.(*unstructured.Unstructured))
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			eiw.handleEgressIP(obj.(*unstructured.Unstructured))
+		},
+	})
+	go informer.Run(eiw.stopCh)
+	log.Info("Watching EgressIP assignments")
+	return eiw
+}
+
+// handleEgressIP records when every egress IP of the object is listed in its status, along with the node it was assigned to, and
+// when failed over, when none of them is assigned to the failover node anymore
+func (eiw *egressIPWatcher) handleEgressIP(egressIP *unstructured.Unstructured) {
+	eiw.mu.Lock()
+	defer eiw.mu.Unlock()
+	et, exists := eiw.egressIPs[egressIP.GetName()]
+	if !exists {
+		et = &egressIPTimestamps{}
+		eiw.egressIPs[egressIP.GetName()] = et
+	}
+	et.egressIP = egressIP
+	nodes, assigned := assignedNodes(egressIP)
+	if !assigned {
+		return
+	}
+	if et.assigned.IsZero() {
+		et.assigned = time.Now()
+		et.nodes = nodes
+	}
+	if et.failover && et.reassigned.IsZero() && !slices.Contains(nodes, eiw.failoverNode) {
+		et.reassigned = time.Now()
+		et.failoverNodes = nodes
+	}
+}
+
+// assignedNodes returns the nodes each egress IP of the object is assigned to, and whether all of them are assigned
+func assignedNodes(egressIP *unstructured.Unstructured) ([]string, bool) {
+	egressIPs, _, _ := unstructured.NestedStringSlice(egressIP.Object, "spec", "egressIPs")
+	items, _, _ := unstructured.NestedSlice(egressIP.Object, "status", "items")
+	assigned := make(map[string]string)
+	for _, i := range items {
+		if item, ok := i.(map[string]interface{}); ok {
+			assigned[fmt.Sprint(item["egressIP"])] = fmt.Sprint(item["node"])
+		}
+	}
+	var nodes []string
+	for _, ip := range egressIPs {
+		node, ok := assigned[ip]
+		if !ok {
+			return nil, false
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, len(nodes) > 0
+}
+
+// stop verifies the dataplane of the EgressIP objects, unless already verified before their failover, and stops watching
+func (eiw *egressIPWatcher) stop(wh *workloads.WorkloadHelper) {
+	if eiw.results == nil {
+		eiw.verify(wh)
+	}
+	close(eiw.stopCh)
+}
+
+// verify verifies the dataplane of every EgressIP object, reading the eipvalidator metrics of the client pods it selects, which report
+// when they first got a response with one of their egress IPs and the responses with other source IPs. The verification results and
+// the quantiles of the assignment and verification latencies per job are indexed
+func (eiw *egressIPWatcher) verify(wh *workloads.WorkloadHelper) {
+	eiw.mu.Lock()
+	defer eiw.mu.Unlock()
+	clientSet, _ := newClientSet()
+	names := sortedKeys(eiw.egressIPs)
+	results := make(map[string]*egressIPVerification, len(names))
+	eiw.results = results
+	for _, name := range names {
+		et := eiw.egressIPs[name]
+		created := et.egressIP.GetCreationTimestamp().Time
+		result := &egressIPVerification{
+			Timestamp:  created.UTC(),
+			UUID:       wh.UUID,
+			Name:       name,
+			Nodes:      et.nodes,
+			Assigned:   !et.assigned.IsZero(),
+			MetricName: egressIPMetric,
+			JobName:    et.egressIP.GetLabels()["kube-burner-job"],
+			Metadata:   wh.MetricsMetadata,
+		}
+		result.EgressIPs, _, _ = unstructured.NestedStringSlice(et.egressIP.Object, "spec", "egressIPs")
+		result.Namespace = egressIPNamespace(et.egressIP)
+		if result.Assigned {
+			result.AssignmentLatency = max(int(et.assigned.Sub(created).Milliseconds()), 0)
+		}
+		results[name] = result
+	}
+	// The client pods may still be waiting for a response with their egress IPs
+	deadline := time.Now().Add(egressIPVerificationTimeout)
+	for {
+		var pending int
+		for _, name := range names {
+			if result := results[name]; !result.Verified && result.Assigned {
+				verifyEgressIP(clientSet, eiw.egressIPs[name].egressIP, result)
+				if !result.Verified {
+					pending++
+				}
+			}
+		}
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	var docs, quantiles []interface{}
+	var jobNames []string
+	var unverified int
+	latencies := make(map[string]map[string][]float64)
+	for _, name := range names {
+		result := results[name]
+		if !result.Verified {
+			unverified++
+			log.Warnf("EgressIP %s not verified: assigned %v, %d/%d pods got a response with its egress IPs", name, result.Assigned, result.VerifiedPods, result.Pods)
+		}
+		if _, exists := latencies[result.JobName]; !exists {
+			jobNames = append(jobNames, result.JobName)
+			latencies[result.JobName] = make(map[string][]float64)
+		}
+		if result.Assigned {
+			latencies[result.JobName]["Assigned"] = append(latencies[result.JobName]["Assigned"], float64(result.AssignmentLatency))
+		}
+		if result.Verified {
+			latencies[result.JobName]["Verified"] = append(latencies[result.JobName]["Verified"], float64(result.VerificationLatency))
+		}
+		docs = append(docs, result)
+	}
+	if len(docs) == 0 {
+		log.Warn("No EgressIP objects found")
+		return
+	}
+	if unverified > 0 {
+		log.Errorf("%d/%d EgressIP objects failed the dataplane verification", unverified, len(docs))
+	} else {
+		log.Infof("Traffic of the %d EgressIP objects egressed with their egress IPs", len(docs))
+	}
+	for _, jobName := range jobNames {
+		for condition, values := range latencies[jobName] {
+			q := metrics.NewLatencySummary(values, condition)
+			q.UUID = wh.UUID
+			q.MetricName = egressIPQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(egressIPMetric, docs)
+	indexDocuments(egressIPQuantilesMetric, quantiles)
+}
+
+// egressIPNamespace returns the namespace selected by the EgressIP object, the workload selects a single one by name
+func egressIPNamespace(egressIP *unstructured.Unstructured) string {
+	namespace, _, _ := unstructured.NestedString(egressIP.Object, "spec", "namespaceSelector", "matchLabels", "kubernetes.io/metadata.name")
+	return namespace
+}
+
+// verifyEgressIP verifies the EgressIP object when all its running client pods got a response with one of its egress IPs, and its
+// verification latency is the slowest one of the pods
+func verifyEgressIP(clientSet kubernetes.Interface, egressIP *unstructured.Unstructured, result *egressIPVerification) {
+	podsMetrics, err := clientPodsMetrics(clientSet, egressIP)
+	if err != nil {
+		log.Errorf("Error reading the metrics of the client pods of EgressIP %s: %v", result.Name, err)
+		return
+	}
+	result.Pods, result.VerifiedPods, result.NonEgressIPRequests, result.VerificationLatency = len(podsMetrics), 0, 0, 0
+	for _, m := range podsMetrics {
+		result.NonEgressIPRequests += int(m.nonEgressIPRequests)
+		if m.startupLatency > 0 {
+			result.VerifiedPods++
+			result.VerificationLatency = max(result.VerificationLatency, int(m.startupLatency*1000))
+		}
+	}
+	result.Verified = result.Pods > 0 && result.VerifiedPods == result.Pods
+}
+
+// clientPodsMetrics reads the eipvalidator metrics of the running client pods selected by the EgressIP object through the API server.
+// The pods whose metrics can't be read are returned without metrics
+func clientPodsMetrics(clientSet kubernetes.Interface, egressIP *unstructured.Unstructured) ([]eipValidatorMetrics, error) {
+	podLabels, _, _ := unstructured.NestedStringMap(egressIP.Object, "spec", "podSelector", "matchLabels")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pods, err := clientSet.CoreV1().Pods(egressIPNamespace(egressIP)).List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(podLabels).String()})
+	if err != nil {
+		return nil, err
+	}
+	var podsMetrics []eipValidatorMetrics
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		m := eipValidatorMetrics{pod: pod}
+		data, err := clientSet.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, eipValidatorPort, "metrics", nil).DoRaw(ctx)
+		if err != nil {
+			log.Debugf("Error reading the metrics of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		} else {
+			var parser expfmt.TextParser
+			families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+			if err != nil {
+				log.Debugf("Error parsing the metrics of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			}
+			// The latencies are set once the first response with one of the egress IPs is received, at startup and after an interruption
+			m.startupLatency = metricValue(families[eipStartupLatencyMetric])
+			m.recoveryLatency = metricValue(families[eipRecoveryLatencyMetric])
+			m.nonEgressIPRequests = metricValue(families[eipNonEgressRequestsMetric])
+		}
+		podsMetrics = append(podsMetrics, m)
+	}
+	return podsMetrics, nil
+}
+
+// metricValue returns the sum of the samples of the given metric family, which may not be exposed yet
+func metricValue(family *dto.MetricFamily) float64 {
+	var value float64
+	for _, m := range family.GetMetric() {
+		value += m.GetGauge().GetValue() + m.GetCounter().GetValue() + m.GetUntyped().GetValue()
+	}
+	return value
+}
@@ -23,27 +23,27 @@ import (
 	"strings"
 	"time"
 
-	"github.com/kube-burner/kube-burner/pkg/config"
 	"github.com/kube-burner/kube-burner/pkg/workloads"
 	"github.com/praserx/ipconv"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/pager"
 )
 
 // get egress IP cidr, node IPs from worker node annotations
-func getEgressIPCidrNodeIPs() ([]string, string) {
-	kubeClientProvider := config.NewKubeClientProvider("", "")
-	clientSet, _ := kubeClientProvider.ClientSet(0, 0)
-	workers, err := clientSet.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		log.Errorf("Error retrieving workers: %v", err)
-		os.Exit(1)
-	}
-
+func getEgressIPCidrNodeIPs() ([]string, string, error) {
+	clientSet, _ := newClientSet()
 	nodeIPs := []string{}
 	var egressIPCidr string
-	for _, worker := range workers.Items {
+	// Nodes are listed in pages to bound the size of the responses on large clusters. All the nodes are listed, not only
+	// the workers, since the IPs of the control plane nodes can be in the egress IP CIDR too
+	err := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return clientSet.CoreV1().Nodes().List(ctx, opts)
+	}).EachListItem(context.Background(), metav1.ListOptions{}, func(obj runtime.Object) error {
+		worker := obj.(*corev1.Node)
 		nodeIPs = append(nodeIPs, worker.Status.Addresses[0].Address)
 		// Add gateway ip to nodeIPs to get excluded while creating egress ip list
 		gwconfig, exist := worker.ObjectMeta.Annotations["k8s.ovn.org/l3-gateway-config"]
@@ -71,18 +71,21 @@ func getEgressIPCidrNodeIPs() ([]string, string) {
 				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error retrieving workers: %v", err)
 	}
-	return nodeIPs, egressIPCidr
+	return nodeIPs, egressIPCidr, nil
 }
 
 // This function returns first usable address from the cidr
 // for example, if cidr is 10.0.132.49/19, first usable address is 10.0.128.1
-func getFirstUsableAddr(cidr string) uint32 {
+func getFirstUsableAddr(cidr string) (uint32, error) {
 	// Parse the IP address and subnet mask
 	ip, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
-		fmt.Println("Error parsing CIDR notation:", err)
-		os.Exit(1)
+		return 0, fmt.Errorf("error parsing CIDR notation: %v", err)
 	}
 
 	// Get the network address by performing a bitwise AND
@@ -101,18 +104,24 @@ func getFirstUsableAddr(cidr string) uint32 {
 	// Output the network address and the first usable IP address in CIDR notation
 	baseAddrInt, err := ipconv.IPv4ToInt(firstUsableIP)
 	if err != nil {
-		log.Fatal("Error converting IP to int: ", err)
+		return 0, fmt.Errorf("error converting IP to int: %v", err)
 	}
-	return baseAddrInt
+	return baseAddrInt, nil
 }
 
 // egress IPs and node IPs will be in same cidr. So we need to exclude node IPs from CIDR to generate list of available egress IPs.
-func generateEgressIPs(numJobIterations int, addressesPerIteration int, externalServerIP string) {
+func generateEgressIPs(numJobIterations int, addressesPerIteration int, externalServerIP string) error {
 
-	nodeIPs, egressIPCidr := getEgressIPCidrNodeIPs()
+	nodeIPs, egressIPCidr, err := getEgressIPCidrNodeIPs()
+	if err != nil {
+		return err
+	}
 	// Add external server ip to nodeIPs to get excluded while creating egress ip list
 	nodeIPs = append(nodeIPs, externalServerIP)
-	baseAddrInt := getFirstUsableAddr(egressIPCidr)
+	baseAddrInt, err := getFirstUsableAddr(egressIPCidr)
+	if err != nil {
+		return err
+	}
 	// list to host available egress IPs
 	addrSlice := make([]string, 0, (numJobIterations * addressesPerIteration))
 
@@ -121,7 +130,7 @@ func generateEgressIPs(numJobIterations int, addressesPerIteration int, external
 	for _, nodeip := range nodeIPs {
 		nodeipuint32, err := ipconv.IPv4ToInt(net.ParseIP(nodeip))
 		if err != nil {
-			log.Fatal("Error: ", err)
+			return fmt.Errorf("error converting node IP %s to int: %v", nodeip, err)
 		}
 		nodeMap[nodeipuint32] = true
 	}
@@ -142,28 +151,70 @@ func generateEgressIPs(numJobIterations int, addressesPerIteration int, external
 
 	// combine all addresses to a string and export as an environment variable
 	os.Setenv("EIP_ADDRESSES", strings.Join(addrSlice, " "))
+	return nil
 }
 
 // NewClusterDensity holds cluster-density workload
 func NewEgressIP(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
 	var iterations, addressesPerIteration int
-	var externalServerIP string
+	var externalServerIP, echoServerAddr string
 	var podReadyThreshold time.Duration
 	var metricsProfiles []string
+	var verifyEgressIPs bool
+	var failover string
+	var failoverTimeout time.Duration
+	var echo *echoServer
 	var rc int
 	cmd := &cobra.Command{
 		Use:   variant,
 		Short: fmt.Sprintf("Runs %v workload", variant),
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if failover != "" && failover != egressIPFailoverCordon && failover != egressIPFailoverReboot {
+				return fmt.Errorf("unsupported failover mode %s, supported modes are: %s and %s", failover, egressIPFailoverCordon, egressIPFailoverReboot)
+			}
+			if failover != "" && !verifyEgressIPs {
+				return fmt.Errorf("--failover requires --verify-egress-ips")
+			}
+			// Without an external server, the echo server is run by kube-burner-ocp, outside of the cluster
+			if externalServerIP == "" {
+				var err error
+				if echoServerAddr == "" {
+					if echoServerAddr, err = echoServerAddress(); err != nil {
+						return err
+					}
+				}
+				if echo, err = startEchoServer(); err != nil {
+					return err
+				}
+				externalServerIP = echoServerAddr
+				log.Infof("Client pods send their requests to the echo server at %s", externalServerIP)
+			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("ADDRESSES_PER_ITERATION", fmt.Sprint(addressesPerIteration))
 			os.Setenv("EXTERNAL_SERVER_IP", externalServerIP)
-			generateEgressIPs(iterations, addressesPerIteration, externalServerIP)
+			if err := generateEgressIPs(iterations, addressesPerIteration, externalServerIP); err != nil {
+				if echo != nil {
+					echo.stop()
+				}
+				return err
+			}
+			// The EgressIP objects and client pods are verified after the workload, so they're garbage collected afterwards
+			if verifyEgressIPs {
+				os.Setenv("GC", "false")
+			}
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			if echo != nil {
+				defer echo.stop()
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -171,10 +222,13 @@ func NewEgressIP(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
 	}
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 2*time.Minute, "Pod ready timeout threshold")
 	cmd.Flags().IntVar(&iterations, "iterations", 0, fmt.Sprintf("%v iterations", variant))
-	cmd.Flags().StringVar(&externalServerIP, "external-server-ip", "", "External server IP address")
+	cmd.Flags().StringVar(&externalServerIP, "external-server-ip", "", "External server IP address, when not set kube-burner-ocp runs an echo server the client pods send their requests to")
+	cmd.Flags().StringVar(&echoServerAddr, "echo-server-address", "", "Address of the host running kube-burner-ocp the client pods reach the echo server at, by default the one used to reach the API server")
+	cmd.Flags().BoolVar(&verifyEgressIPs, "verify-egress-ips", true, "Verify the traffic of the client pods egresses with the egress IPs assigned to them, and index the assignment and verification latencies")
+	cmd.Flags().StringVar(&failover, "failover", "", fmt.Sprintf("Once verified, disrupt the node hosting the most egress IPs and measure their reassignment and traffic recovery latencies: %s or %s", egressIPFailoverCordon, egressIPFailoverReboot))
+	cmd.Flags().DurationVar(&failoverTimeout, "failover-timeout", 10*time.Minute, "Maximum time to wait for the egress IPs to be reassigned and their traffic to recover, and for the rebooted node to be ready")
 	cmd.Flags().IntVar(&addressesPerIteration, "addresses-per-iteration", 1, fmt.Sprintf("%v iterations", variant))
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-egressip.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.MarkFlagRequired("iterations")
-	cmd.MarkFlagRequired("external-server-ip")
 	return cmd
 }
@@ -0,0 +1,204 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const kubeEventMetric = "kubeEvent"
+
+// Namespaces of the components whose events are captured by default along with the ones of the workload
+var defaultEventNamespaces = []string{
+	"openshift-kube-apiserver",
+	"openshift-kube-controller-manager",
+	"openshift-kube-scheduler",
+	"openshift-etcd",
+	"openshift-ovn-kubernetes",
+	"openshift-multus",
+	"openshift-ingress",
+	"openshift-machine-config-operator",
+}
+
+// kubeEvent document indexed for each event of the run
+type kubeEvent struct {
+	Timestamp     time.Time   `json:"timestamp"`
+	LastTimestamp time.Time   `json:"lastTimestamp"`
+	UUID          string      `json:"uuid"`
+	Namespace     string      `json:"namespace"`
+	Kind          string      `json:"kind"`
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	Reason        string      `json:"reason"`
+	Message       string      `json:"message"`
+	Count         int32       `json:"count"`
+	Component     string      `json:"component,omitempty"`
+	Host          string      `json:"host,omitempty"`
+	MetricName    string      `json:"metricName"`
+	JobName       string      `json:"jobName,omitempty"`
+	Metadata      interface{} `json:"metadata,omitempty"`
+}
+
+type eventWatcher struct {
+	clientSet kubernetes.Interface
+	uuid      string
+	start     time.Time
+	stopCh    chan struct{}
+	// systemNamespaces are captured regardless of the run
+	systemNamespaces []string
+	// namespaces caches the job of each namespace, empty when not created by this run
+	namespaces map[string]string
+	// events are keyed by UID, as repeated events are updated with a higher count
+	events map[types.UID]kubeEvent
+	mu     sync.Mutex
+}
+
+// startEventWatcher captures the events of the namespaces created by the run with the given UUID and of the given system namespaces
+func startEventWatcher(uuid string, systemNamespaces []string) *eventWatcher {
+	clientSet, _ := newClientSet()
+	ew := &eventWatcher{
+		clientSet:        clientSet,
+		uuid:             uuid,
+		start:            time.Now().UTC(),
+		stopCh:           make(chan struct{}),
+		systemNamespaces: systemNamespaces,
+		namespaces:       make(map[string]string),
+		events:           make(map[types.UID]kubeEvent),
+	}
+	log.Info("Capturing cluster events")
+	lw := cache.NewListWatchFromClient(clientSet.CoreV1().RESTClient(), "events", metav1.NamespaceAll, fields.Everything())
+	_, controller := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: lw,
+		ObjectType:    &corev1.Event{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				ew.handleEvent(obj.(*corev1.Event))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				ew.handleEvent(obj.(*corev1.Event))
+			},
+		},
+	})
+	go controller.Run(ew.stopCh)
+	return ew
+}
+
+func (ew *eventWatcher) handleEvent(event *corev1.Event) {
+	lastTimestamp := eventTimestamp(event).UTC()
+	// Events listed when the watch starts may have happened before the run
+	if lastTimestamp.Before(ew.start) {
+		return
+	}
+	jobName, ok := ew.jobName(event.Namespace)
+	if !ok {
+		return
+	}
+	timestamp := event.FirstTimestamp.Time.UTC()
+	if timestamp.IsZero() {
+		timestamp = lastTimestamp
+	}
+	count := event.Count
+	if event.Series != nil {
+		count = event.Series.Count
+	}
+	component, host := event.Source.Component, event.Source.Host
+	if component == "" {
+		component, host = event.ReportingController, event.ReportingInstance
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.events[event.UID] = kubeEvent{
+		Timestamp:     timestamp,
+		LastTimestamp: lastTimestamp,
+		UUID:          ew.uuid,
+		Namespace:     event.Namespace,
+		Kind:          event.InvolvedObject.Kind,
+		Name:          event.InvolvedObject.Name,
+		Type:          event.Type,
+		Reason:        event.Reason,
+		Message:       event.Message,
+		Count:         max(count, 1),
+		Component:     component,
+		Host:          host,
+		MetricName:    kubeEventMetric,
+		JobName:       jobName,
+	}
+}
+
+// jobName returns the job that created the given namespace, and whether its events are captured
+func (ew *eventWatcher) jobName(namespace string) (string, bool) {
+	if slices.Contains(ew.systemNamespaces, namespace) {
+		return "", true
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if jobName, exists := ew.namespaces[namespace]; exists {
+		return jobName, jobName != ""
+	}
+	ns, err := ew.clientSet.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", false
+	}
+	if ns.Labels["kube-burner-uuid"] == ew.uuid {
+		ew.namespaces[namespace] = ns.Labels["kube-burner-job"]
+	} else {
+		ew.namespaces[namespace] = ""
+	}
+	return ew.namespaces[namespace], ew.namespaces[namespace] != ""
+}
+
+// stop stops capturing events and indexes them, logging the most frequent warning reasons
+func (ew *eventWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(ew.stopCh)
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if len(ew.events) == 0 {
+		log.Info("No events captured")
+		return
+	}
+	var docs []interface{}
+	warnings := make(map[string]int32)
+	for _, event := range ew.events {
+		event.Metadata = wh.MetricsMetadata
+		docs = append(docs, event)
+		if event.Type == corev1.EventTypeWarning {
+			warnings[event.Reason] += event.Count
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].(kubeEvent).Timestamp.Before(docs[j].(kubeEvent).Timestamp) })
+	reasons := make([]string, 0, len(warnings))
+	for reason := range warnings {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return warnings[reasons[i]] > warnings[reasons[j]] })
+	for _, reason := range reasons[:min(len(reasons), 5)] {
+		log.Warnf("%d %s warning events during the run", warnings[reason], reason)
+	}
+	log.Infof("Indexing %d events", len(docs))
+	indexDocuments(kubeEventMetric, docs)
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Results of the connection probes, probes failing for other reasons than the connection are errors
+const (
+	probeAllowed = "allowed"
+	probeDenied  = "denied"
+	probeError   = "error"
+)
+
+// execInPod runs the given command in a pod container and returns its trimmed stdout
+func execInPod(clientSet kubernetes.Interface, restConfig *rest.Config, pod corev1.Pod, container string, command ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	req := clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec")
+	req.VersionedParams(&corev1.PodExecOptions{
+		Container: container,
+		Command:   command,
+		Stdout:    true,
+		Stderr:    true,
+	}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	err = exec.StreamWithContext(context.TODO(), remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running %s in pod %s/%s: %v: %s", strings.Join(command, " "), pod.Namespace, pod.Name, err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// probeConnection connects from the given container of a pod to the given address. Any HTTP response means the connection was
+// allowed, while curl failing to connect, exit code 7, or timing out, exit code 28, means it was denied
+func probeConnection(clientSet kubernetes.Interface, restConfig *rest.Config, pod corev1.Pod, container, address string) (string, error) {
+	_, err := execInPod(clientSet, restConfig, pod, container, "curl", "-sS", "-o", "/dev/null", "--connect-timeout", "3", "--max-time", "5", "http://"+address)
+	switch {
+	case err == nil:
+		return probeAllowed, nil
+	case strings.Contains(err.Error(), "exit code 7") || strings.Contains(err.Error(), "exit code 28"):
+		return probeDenied, nil
+	}
+	return probeError, err
+}
+
+// getRunningPod returns the first running pod matching the given label selector
+func getRunningPod(clientSet kubernetes.Interface, namespace, labelSelector string) (corev1.Pod, error) {
+	pods, err := clientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return corev1.Pod{}, err
+	}
+	if len(pods.Items) == 0 {
+		return corev1.Pod{}, fmt.Errorf("no running pods found in namespace %s with labels %s", namespace, labelSelector)
+	}
+	return pods.Items[0], nil
+}
+
+// nodeExec runs the given command in the host of the node through its machine-config-daemon pod, which is privileged
+// and uses the host network
+func nodeExec(clientSet kubernetes.Interface, restConfig *rest.Config, node string, command ...string) error {
+	mcdPods, err := clientSet.CoreV1().Pods("openshift-machine-config-operator").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "k8s-app=machine-config-daemon",
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return err
+	}
+	if len(mcdPods.Items) == 0 {
+		return fmt.Errorf("machine-config-daemon pod not found in node %s", node)
+	}
+	_, err = execInPod(clientSet, restConfig, mcdPods.Items[0], "machine-config-daemon", append([]string{"chroot", "/rootfs"}, command...)...)
+	return err
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// extraQueriesProfile writes the queries given by --extra-query, in name=expr format, along with the ones of the metrics profile
+// given by --extra-queries-file, to a temporary metrics profile appended to the ones of the workload. It returns an empty path
+// when there are no extra queries
+func extraQueriesProfile(cmd *cobra.Command) (string, error) {
+	extraQueries, _ := cmd.Root().PersistentFlags().GetStringArray("extra-query")
+	extraQueriesFile, _ := cmd.Root().PersistentFlags().GetString("extra-queries-file")
+	var queries []metricQuery
+	if extraQueriesFile != "" {
+		var err error
+		// Not looked up in the embedded configuration, whose profiles may have the same name
+		if queries, err = readMetricsProfile(extraQueriesFile, embed.FS{}, ""); err != nil {
+			return "", err
+		}
+	}
+	for _, extraQuery := range extraQueries {
+		name, expr, _ := strings.Cut(extraQuery, "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if name == "" || expr == "" {
+			return "", fmt.Errorf("invalid extra query %q, expected format is name=expr", extraQuery)
+		}
+		queries = append(queries, metricQuery{Query: expr, MetricName: name})
+	}
+	if len(queries) == 0 {
+		return "", nil
+	}
+	data, err := yaml.Marshal(queries)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "kube-burner-ocp-extra-queries-*.yml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	for _, query := range queries {
+		log.Infof("Adding extra query %s: %s", query.MetricName, query.Query)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// extraTemplate is an object template given by --extra-template, created in each iteration of the workload
+type extraTemplate struct {
+	Template string `json:"template"`
+	Replicas int    `json:"replicas"`
+}
+
+// setExtraTemplatesEnv sets the EXTRA_TEMPLATES environment variable, consumed by the workloads supporting extra templates, from
+// the given object templates in path[:replicas] format, file paths or URLs, with 1 replica by default
+func setExtraTemplatesEnv(templates []string) error {
+	extraTemplates := []extraTemplate{}
+	for _, template := range templates {
+		et := extraTemplate{Template: template, Replicas: 1}
+		// URLs have colons too, only a numeric suffix is taken as replicas
+		if i := strings.LastIndex(template, ":"); i != -1 {
+			if replicas, err := strconv.Atoi(template[i+1:]); err == nil {
+				if replicas < 1 {
+					return fmt.Errorf("invalid extra template %s, replicas must be positive", template)
+				}
+				et.Template, et.Replicas = template[:i], replicas
+			}
+		}
+		if u, err := url.Parse(et.Template); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			// Templates are looked up in the embedded configuration first, an absolute path avoids picking an embedded one with the same name
+			if _, err := os.Stat(et.Template); err != nil {
+				return fmt.Errorf("error reading extra template: %v", err)
+			}
+			if et.Template, err = filepath.Abs(et.Template); err != nil {
+				return err
+			}
+		}
+		log.Infof("Adding extra template %s with %d replicas to each iteration", et.Template, et.Replicas)
+		extraTemplates = append(extraTemplates, et)
+	}
+	data, err := json.Marshal(extraTemplates)
+	if err != nil {
+		return err
+	}
+	os.Setenv("EXTRA_TEMPLATES", string(data))
+	return nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/google/uuid"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const fleetSummaryMetric = "fleetSummary"
+
+var (
+	managedClusterGVR = schema.GroupVersionResource{
+		Group:    "cluster.open-cluster-management.io",
+		Version:  "v1",
+		Resource: "managedclusters",
+	}
+	clusterDeploymentGVR = schema.GroupVersionResource{
+		Group:    "hive.openshift.io",
+		Version:  "v1",
+		Resource: "clusterdeployments",
+	}
+)
+
+// fleetFlags are the flags of the hub run, not passed to the runs of the managed clusters
+var fleetFlags = []string{"--fleet-selector", "--fleet-concurrency"}
+
+// fleetCluster holds the results of the workload in a managed cluster
+type fleetCluster struct {
+	Cluster     string  `json:"cluster"`
+	UUID        string  `json:"uuid"`
+	RC          int     `json:"rc"`
+	Passed      bool    `json:"passed"`
+	ElapsedTime float64 `json:"elapsedTime"`
+	Alerts      int     `json:"alerts"`
+	// PodReadyP99 is the highest Ready P99 pod latency of the jobs, in milliseconds
+	PodReadyP99 int    `json:"podReadyP99,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// fleetSummary aggregates the results of the workload in the managed clusters
+type fleetSummary struct {
+	Timestamp      time.Time      `json:"timestamp"`
+	UUID           string         `json:"uuid"`
+	Workload       string         `json:"workload"`
+	MetricName     string         `json:"metricName"`
+	ElapsedTime    float64        `json:"elapsedTime"`
+	ClusterCount   int            `json:"clusterCount"`
+	FailedClusters int            `json:"failedClusters"`
+	PodReadyP99    int            `json:"podReadyP99,omitempty"`
+	Clusters       []fleetCluster `json:"clusters"`
+	Metadata       interface{}    `json:"metadata,omitempty"`
+}
+
+// managedClusterKubeconfig returns the admin kubeconfig of the given managed cluster, stored by Hive in the secret
+// referenced by its ClusterDeployment. Imported clusters don't have one
+func managedClusterKubeconfig(dynamicClient dynamic.Interface, cluster string) ([]byte, error) {
+	clientSet, _ := newClientSet()
+	cd, err := dynamicClient.Resource(clusterDeploymentGVR).Namespace(cluster).Get(context.TODO(), cluster, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the clusterdeployment of %s: %v", cluster, err)
+	}
+	secretName, _, _ := unstructured.NestedString(cd.Object, "spec", "clusterMetadata", "adminKubeconfigSecretRef", "name")
+	if secretName == "" {
+		return nil, fmt.Errorf("clusterdeployment of %s has no admin kubeconfig", cluster)
+	}
+	secret, err := clientSet.CoreV1().Secrets(cluster).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the admin kubeconfig of %s: %v", cluster, err)
+	}
+	for _, key := range []string{"kubeconfig", "raw-kubeconfig"} {
+		if kubeconfig, ok := secret.Data[key]; ok {
+			return kubeconfig, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %s/%s has no kubeconfig", cluster, secretName)
+}
+
+// fleetArgs returns the arguments of the workload without the fleet flags
+func fleetArgs(args []string) []string {
+	var workloadArgs []string
+	for i := 0; i < len(args); i++ {
+		flagName, _, hasValue := strings.Cut(args[i], "=")
+		isFleetFlag := false
+		for _, fleetFlag := range fleetFlags {
+			if flagName == fleetFlag {
+				isFleetFlag = true
+			}
+		}
+		if !isFleetFlag {
+			workloadArgs = append(workloadArgs, args[i])
+		} else if !hasValue {
+			// The value is the next argument
+			i++
+		}
+	}
+	return workloadArgs
+}
+
+// runFleet runs the workload in the managed clusters of the ACM hub matching the given label selector, each one in its own
+// process and directory under fleet-<uuid>, and indexes a summary of the results of every cluster.
+// Returns the first non-zero return code of the managed clusters
+func runFleet(cmd *cobra.Command, wh *workloads.WorkloadHelper, selector string) (int, error) {
+	concurrency, _ := cmd.Root().PersistentFlags().GetInt("fleet-concurrency")
+	_, restConfig := newClientSet()
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	managedClusters, err := dynamicClient.Resource(managedClusterGVR).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return 0, fmt.Errorf("error listing managed clusters: %v", err)
+	}
+	if len(managedClusters.Items) == 0 {
+		return 0, fmt.Errorf("no managed clusters match %s", selector)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	fleetDirectory := "fleet-" + wh.UUID
+	workloadArgs := fleetArgs(os.Args[1:])
+	start := time.Now().UTC()
+	results := make([]fleetCluster, len(managedClusters.Items))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	log.Infof("Running %s in %d managed clusters", cmd.Name(), len(managedClusters.Items))
+	for i, mc := range managedClusters.Items {
+		results[i] = fleetCluster{Cluster: mc.GetName(), UUID: uuid.NewString()}
+		kubeconfig, err := managedClusterKubeconfig(dynamicClient, mc.GetName())
+		if err != nil {
+			log.Error(err.Error())
+			results[i].RC, results[i].Error = 1, err.Error()
+			continue
+		}
+		wg.Add(1)
+		go func(result *fleetCluster) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			runFleetCluster(executable, workloadArgs, path.Join(fleetDirectory, result.Cluster), kubeconfig, result)
+		}(&results[i])
+	}
+	wg.Wait()
+	summary := fleetSummary{
+		Timestamp:    start,
+		UUID:         wh.UUID,
+		Workload:     cmd.Name(),
+		MetricName:   fleetSummaryMetric,
+		ElapsedTime:  time.Since(start).Round(time.Second).Seconds(),
+		ClusterCount: len(results),
+		Clusters:     results,
+		Metadata:     wh.MetricsMetadata,
+	}
+	var rc int
+	for _, result := range results {
+		if result.RC != 0 {
+			summary.FailedClusters++
+			if rc == 0 {
+				rc = result.RC
+			}
+		}
+		summary.PodReadyP99 = max(summary.PodReadyP99, result.PodReadyP99)
+	}
+	log.Infof("Workload finished in %d/%d managed clusters", summary.ClusterCount-summary.FailedClusters, summary.ClusterCount)
+	if err := indexFleetSummary(fleetDirectory, summary); err != nil {
+		log.Error(err.Error())
+	}
+	return rc, nil
+}
+
+// runFleetCluster runs the workload with the given kubeconfig in the given directory, and loads its results
+func runFleetCluster(executable string, args []string, directory string, kubeconfig []byte, result *fleetCluster) {
+	fail := func(err error) {
+		log.Errorf("%s: %v", result.Cluster, err)
+		result.RC, result.Error = 1, err.Error()
+	}
+	if err := os.MkdirAll(directory, 0744); err != nil {
+		fail(err)
+		return
+	}
+	kubeconfigFile := path.Join(directory, "kubeconfig")
+	if err := os.WriteFile(kubeconfigFile, kubeconfig, 0600); err != nil {
+		fail(err)
+		return
+	}
+	defer os.Remove(kubeconfigFile)
+	logFile, err := os.Create(path.Join(directory, "kube-burner-ocp.log"))
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer logFile.Close()
+	workload := exec.Command(executable, append(args, "--uuid="+result.UUID)...)
+	workload.Dir = directory
+	workload.Env = append(os.Environ(), "KUBECONFIG=kubeconfig")
+	workload.Stdout, workload.Stderr = logFile, logFile
+	log.Infof("Running workload in managed cluster %s with UUID %s", result.Cluster, result.UUID)
+	start := time.Now()
+	err = workload.Run()
+	result.ElapsedTime = time.Since(start).Round(time.Second).Seconds()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.RC = exitErr.ExitCode()
+		log.Errorf("Workload failed in managed cluster %s with return code %d, see %s", result.Cluster, result.RC, logFile.Name())
+	} else if err != nil {
+		fail(err)
+		return
+	}
+	result.Passed = result.RC == 0
+	// The local metrics directory of the run, or its UUID to search the results in Elasticsearch
+	source := path.Join(directory, "collected-metrics-"+result.UUID)
+	if _, err := os.Stat(source); err != nil {
+		source = result.UUID
+	}
+	clusterResults, err := loadResults(source, os.Getenv("ES_SERVER"), os.Getenv("ES_INDEX"))
+	if err != nil {
+		log.Warnf("Unable to load the results of managed cluster %s: %v", result.Cluster, err)
+		return
+	}
+	result.Alerts = len(clusterResults.alerts)
+	for _, lq := range clusterResults.latencyQuantiles {
+		if lq.MetricName == "podLatencyQuantilesMeasurement" && lq.QuantileName == "Ready" {
+			result.PodReadyP99 = max(result.PodReadyP99, lq.P99)
+		}
+	}
+}
+
+// indexFleetSummary writes the fleet summary to the fleet directory, and indexes it in Elasticsearch when configured
+func indexFleetSummary(fleetDirectory string, summary fleetSummary) error {
+	indexerConfigs := []indexers.IndexerConfig{{Type: indexers.LocalIndexer, MetricsDirectory: fleetDirectory}}
+	if esServer, esIndex := os.Getenv("ES_SERVER"), os.Getenv("ES_INDEX"); esServer != "" && esIndex != "" {
+		indexerConfigs = append(indexerConfigs, indexers.IndexerConfig{Type: indexers.ElasticIndexer, Servers: []string{esServer}, Index: esIndex})
+	}
+	var errs []error
+	for _, indexerConfig := range indexerConfigs {
+		indexer, err := indexers.NewIndexer(indexerConfig)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if indexerConfig.Type != indexers.LocalIndexer {
+			*indexer = newRetryIndexer(*indexer)
+		}
+		resp, err := (*indexer).Index([]interface{}{summary}, indexers.IndexingOpts{MetricName: fleetSummaryMetric})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Info(resp)
+	}
+	return errors.Join(errs...)
+}
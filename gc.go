@@ -0,0 +1,198 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kube-burner/kube-burner/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// gcOptions narrow down the resources deleted by the garbage collection
+type gcOptions struct {
+	// labelSelector is added to the run UUID selector
+	labelSelector string
+	// exclude holds the kinds or resource names to keep
+	exclude []string
+	dryRun  bool
+	// async doesn't wait for the namespaces to be deleted
+	async bool
+	// keepNamespaces deletes the resources of the namespaces instead of the namespaces
+	keepNamespaces bool
+}
+
+// gcOptionsFromFlags returns the garbage collection options given by the root flags
+func gcOptionsFromFlags(cmd *cobra.Command) gcOptions {
+	var opts gcOptions
+	opts.labelSelector, _ = cmd.Root().PersistentFlags().GetString("gc-label-selector")
+	opts.exclude, _ = cmd.Root().PersistentFlags().GetStringSlice("gc-exclude")
+	opts.dryRun, _ = cmd.Root().PersistentFlags().GetBool("gc-dry-run")
+	opts.async, _ = cmd.Root().PersistentFlags().GetBool("gc-async")
+	opts.keepNamespaces, _ = cmd.Root().PersistentFlags().GetBool("reuse-namespaces")
+	return opts
+}
+
+// NewGC deletes the resources left by a run
+func NewGC() *cobra.Command {
+	var exitCode int
+	cmd := &cobra.Command{
+		Use:          "gc",
+		Short:        "Deletes the resources left by a run",
+		Long:         "Deletes the namespaces and every namespaced or cluster scoped resource labeled with the run UUID given by --uuid, or with any run UUID when not given",
+		SilenceUsage: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			labelSelector := "kube-burner-uuid"
+			if cmd.Flags().Changed("uuid") {
+				uuid, _ := cmd.Flags().GetString("uuid")
+				labelSelector = "kube-burner-uuid=" + uuid
+			}
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			garbageCollect(ctx, labelSelector, gcOptionsFromFlags(cmd))
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
+		},
+	}
+	// Set when garbage collecting an aborted run, which exits with rcAborted
+	cmd.Flags().IntVar(&exitCode, "exit-code", 0, "Code to exit with once the garbage collection finishes")
+	cmd.Flags().MarkHidden("exit-code")
+	return cmd
+}
+
+// excluded returns whether the given resource is excluded from the garbage collection
+func (opts gcOptions) excluded(resource metav1.APIResource) bool {
+	for _, exclude := range opts.exclude {
+		if strings.EqualFold(exclude, resource.Kind) || strings.EqualFold(exclude, resource.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// garbageCollect deletes the namespaces with the given label selector, and then the rest of the resources with it
+// since they can live in namespaces not created by kube-burner or be cluster scoped, like CRDs. When a namespaced kind
+// is excluded or they're reused, the namespaces are kept and their resources are deleted one by one instead. In async mode,
+// the namespaces are still terminating when it returns
+func garbageCollect(ctx context.Context, labelSelector string, opts gcOptions) {
+	defer StartSpan("gc")()
+	clientSet, restConfig := newClientSet()
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	if opts.labelSelector != "" {
+		labelSelector += "," + opts.labelSelector
+	}
+	// Discovery returns the resources of the available API groups along with the error of the failing ones
+	resourceLists, err := discoveryClient().ServerPreferredResources()
+	if err != nil {
+		log.Warnf("Error discovering API resources: %v", err)
+	}
+	deleteNamespaces := !opts.keepNamespaces
+	if opts.keepNamespaces {
+		log.Info("Keeping the namespaces to reuse them")
+	}
+	for _, resourceList := range resourceLists {
+		for _, resource := range resourceList.APIResources {
+			if opts.excluded(resource) && (resource.Namespaced || resource.Name == "namespaces") {
+				log.Infof("Keeping the namespaces since %s are excluded", resource.Name)
+				deleteNamespaces = false
+			}
+		}
+	}
+	// Namespaces deleted without waiting for their termination, or to be deleted in dry-run mode, as their resources are
+	// removed along with them
+	deletedNamespaces := make(map[string]bool)
+	if deleteNamespaces {
+		if opts.dryRun || opts.async {
+			namespaces, err := clientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+			if err != nil {
+				log.Errorf("Error listing namespaces: %v", err)
+			} else if opts.dryRun {
+				for _, ns := range namespaces.Items {
+					log.Infof("Would delete namespace %s", ns.Name)
+					deletedNamespaces[ns.Name] = true
+				}
+			} else if len(namespaces.Items) > 0 {
+				// The namespace controller removes their resources in the background
+				log.Infof("Deleting %d namespaces with label: %s, without waiting for their termination", len(namespaces.Items), labelSelector)
+				for _, ns := range namespaces.Items {
+					err := clientSet.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{})
+					if err != nil && !kerrors.IsNotFound(err) {
+						log.Errorf("Error deleting namespace %s: %v", ns.Name, err)
+						continue
+					}
+					deletedNamespaces[ns.Name] = true
+				}
+			}
+		} else {
+			util.CleanupNamespaces(ctx, clientSet, labelSelector)
+		}
+	}
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			// Skip subresources and the namespaces, already handled
+			if strings.Contains(resource.Name, "/") || resource.Name == "namespaces" || !slices.Contains(resource.Verbs, "list") || !slices.Contains(resource.Verbs, "delete") || opts.excluded(resource) {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			resources, err := dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+			if err != nil {
+				log.Debugf("Unable to list %s: %v", gvr.GroupResource(), err)
+				continue
+			}
+			var items []unstructured.Unstructured
+			for _, item := range resources.Items {
+				if !deletedNamespaces[item.GetNamespace()] {
+					items = append(items, item)
+				}
+			}
+			if len(items) == 0 {
+				continue
+			}
+			if !opts.dryRun {
+				log.Infof("Deleting %d %s with label: %s", len(items), gvr.GroupResource(), labelSelector)
+			}
+			for _, item := range items {
+				name := item.GetName()
+				if item.GetNamespace() != "" {
+					name = item.GetNamespace() + "/" + name
+				}
+				if opts.dryRun {
+					log.Infof("Would delete %s %s", gvr.GroupResource(), name)
+					continue
+				}
+				err := dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+				if err != nil && !kerrors.IsNotFound(err) {
+					log.Errorf("Error deleting %s %s: %v", gvr.GroupResource(), name, err)
+				}
+			}
+		}
+	}
+	log.Info("Garbage collection finished")
+}
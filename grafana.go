@@ -0,0 +1,232 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const grafanaDatasourceName = "kube-burner-ocp"
+
+// groupByRegex captures the labels of the last aggregation clause of a PromQL expression
+var groupByRegex = regexp.MustCompile(`by\s*\(([^)]+)\)[^(]*$`)
+
+// NewGrafanaDashboard generates a Grafana dashboard for the workload metrics indexed in Elasticsearch
+func NewGrafanaDashboard(ocpConfig embed.FS, configDir string) *cobra.Command {
+	var workload, outputFile, grafanaURL, grafanaToken string
+	var metricsProfiles []string
+	cmd := &cobra.Command{
+		Use:          "grafana-dashboard",
+		Short:        "Generates a Grafana dashboard for the metrics of a workload",
+		Long:         "Generates a Grafana dashboard for the metrics of a workload indexed in Elasticsearch, written to a JSON file or provisioned through the Grafana API",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var metricNames []string
+			groupBy := make(map[string]string)
+			esServer, _ := cmd.Flags().GetString("es-server")
+			esIndex, _ := cmd.Flags().GetString("es-index")
+			for _, profile := range metricsProfiles {
+				metricsProfile, err := readMetricsProfile(profile, ocpConfig, configDir)
+				if err != nil {
+					return err
+				}
+				for _, metric := range metricsProfile {
+					if _, exists := groupBy[metric.MetricName]; exists {
+						continue
+					}
+					metricNames = append(metricNames, metric.MetricName)
+					groupBy[metric.MetricName] = ""
+					if match := groupByRegex.FindStringSubmatch(metric.Query); match != nil {
+						for _, label := range strings.Split(match[1], ",") {
+							// le is consumed by histogram_quantile
+							if label = strings.TrimSpace(label); label != "le" {
+								groupBy[metric.MetricName] = label
+								break
+							}
+						}
+					}
+				}
+			}
+			dashboard := grafanaDashboard(workload, metricNames, groupBy)
+			if grafanaURL == "" {
+				data, _ := json.MarshalIndent(dashboard, "", "  ")
+				if err := os.WriteFile(outputFile, data, 0644); err != nil {
+					return err
+				}
+				log.Infof("Grafana dashboard written to %s", outputFile)
+				return nil
+			}
+			if esServer == "" || esIndex == "" {
+				return fmt.Errorf("provisioning the Grafana dashboard requires --es-server and --es-index")
+			}
+			return provisionGrafanaDashboard(grafanaURL, grafanaToken, esServer, esIndex, dashboard)
+		},
+	}
+	cmd.Flags().StringVar(&workload, "workload", "", "Workload to generate the dashboard for, used to filter the documents by job name")
+	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to generate panels for")
+	cmd.Flags().StringVar(&outputFile, "output", "dashboard.json", "File to write the dashboard to, when not provisioning it")
+	cmd.Flags().StringVar(&grafanaURL, "grafana-url", "", "Grafana URL, when set the Elasticsearch datasource and the dashboard are provisioned through the Grafana API")
+	cmd.Flags().StringVar(&grafanaToken, "grafana-token", "", "Grafana service account token")
+	cmd.MarkFlagRequired("workload")
+	return cmd
+}
+
+func grafanaDashboard(workload string, metricNames []string, groupBy map[string]string) map[string]interface{} {
+	datasource := map[string]string{"type": "elasticsearch", "uid": "${datasource}"}
+	jobFilter := fmt.Sprintf(`uuid.keyword: $uuid AND jobName.keyword: "%s"`, workload)
+	panels := []interface{}{
+		map[string]interface{}{
+			"type":       "table",
+			"title":      "Latency quantiles",
+			"datasource": datasource,
+			"gridPos":    map[string]int{"h": 8, "w": 24, "x": 0, "y": 0},
+			"targets": []interface{}{
+				map[string]interface{}{
+					"refId":      "A",
+					"query":      `uuid.keyword: $uuid AND metricName.keyword: /.*QuantilesMeasurement/`,
+					"metrics":    []interface{}{map[string]interface{}{"id": "1", "type": "raw_data", "settings": map[string]int{"size": 500}}},
+					"bucketAggs": []interface{}{},
+					"timeField":  "timestamp",
+				},
+			},
+		},
+	}
+	for i, metricName := range metricNames {
+		bucketAggs := []interface{}{}
+		if label := groupBy[metricName]; label != "" {
+			bucketAggs = append(bucketAggs, map[string]interface{}{
+				"id":       "3",
+				"type":     "terms",
+				"field":    fmt.Sprintf("labels.%s.keyword", label),
+				"settings": map[string]string{"size": "10", "order": "desc", "orderBy": "1"},
+			})
+		}
+		bucketAggs = append(bucketAggs, map[string]interface{}{
+			"id":       "2",
+			"type":     "date_histogram",
+			"field":    "timestamp",
+			"settings": map[string]string{"interval": "auto"},
+		})
+		panels = append(panels, map[string]interface{}{
+			"type":       "timeseries",
+			"title":      metricName,
+			"datasource": datasource,
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": 8 + (i/2)*8},
+			"targets": []interface{}{
+				map[string]interface{}{
+					"refId":      "A",
+					"query":      fmt.Sprintf(`%s AND metricName.keyword: "%s"`, jobFilter, metricName),
+					"metrics":    []interface{}{map[string]interface{}{"id": "1", "type": "avg", "field": "value"}},
+					"bucketAggs": bucketAggs,
+					"timeField":  "timestamp",
+				},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"title":         fmt.Sprintf("kube-burner-ocp %s", workload),
+		"uid":           fmt.Sprintf("kube-burner-ocp-%s", workload),
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"tags":          []string{"kube-burner-ocp", workload},
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":  "datasource",
+					"type":  "datasource",
+					"query": "elasticsearch",
+				},
+				map[string]interface{}{
+					"name":       "uuid",
+					"type":       "query",
+					"datasource": datasource,
+					"query":      fmt.Sprintf(`{"find": "terms", "field": "uuid.keyword", "query": "metricName.keyword: jobSummary AND jobConfig.name.keyword: \"%s\""}`, workload),
+					"refresh":    2,
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+// provisionGrafanaDashboard creates the Elasticsearch datasource, when it doesn't exist, and the dashboard through the Grafana API
+func provisionGrafanaDashboard(grafanaURL, grafanaToken, esServer, esIndex string, dashboard map[string]interface{}) error {
+	datasource := map[string]interface{}{
+		"name":   grafanaDatasourceName,
+		"type":   "elasticsearch",
+		"access": "proxy",
+		"url":    esServer,
+		"jsonData": map[string]interface{}{
+			"index":         esIndex,
+			"timeField":     "timestamp",
+			"tlsSkipVerify": true,
+		},
+	}
+	// Stored encrypted by Grafana, rather than in the URL
+	if indexerAuth.Username != "" {
+		datasource["basicAuth"] = true
+		datasource["basicAuthUser"] = indexerAuth.Username
+		datasource["secureJsonData"] = map[string]interface{}{"basicAuthPassword": indexerAuth.Password}
+	}
+	status, err := grafanaRequest(grafanaURL+"/api/datasources", grafanaToken, datasource)
+	if err != nil {
+		return fmt.Errorf("error creating Grafana datasource: %v", err)
+	}
+	if status == http.StatusConflict {
+		log.Infof("Grafana datasource %s already exists", grafanaDatasourceName)
+	}
+	status, err = grafanaRequest(grafanaURL+"/api/dashboards/db", grafanaToken, map[string]interface{}{
+		"dashboard": dashboard,
+		"overwrite": true,
+	})
+	if err != nil || status != http.StatusOK {
+		return fmt.Errorf("error provisioning Grafana dashboard: %v %d", err, status)
+	}
+	log.Infof("Grafana dashboard %s provisioned in %s", dashboard["title"], grafanaURL)
+	return nil
+}
+
+func grafanaRequest(url, token string, body interface{}) (int, error) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	ocpmetadata "github.com/cloud-bulldozer/go-commons/ocp-metadata"
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/util/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	hostedClusterSizeLabel    = "hypershift.openshift.io/hosted-cluster-size"
+	requestServingNodeLabel   = "hypershift.openshift.io/request-serving-component"
+	hostedControlPlaneLabel   = "hypershift.openshift.io/cluster"
+	instanceTypeLabel         = "node.kubernetes.io/instance-type"
+	hostedClusterInfraIDField = "infraID"
+	hostedControlPlaneJob     = "hosted-control-plane"
+	clusterMonitoringLabel    = "openshift.io/cluster-monitoring"
+)
+
+var hostedClusterGVR = schema.GroupVersionResource{
+	Group:    "hypershift.openshift.io",
+	Version:  "v1beta1",
+	Resource: "hostedclusters",
+}
+
+var infrastructureGVR = schema.GroupVersionResource{
+	Group:    "config.openshift.io",
+	Version:  "v1",
+	Resource: "infrastructures",
+}
+
+// hostedControlPlane holds the control plane namespace of the hosted cluster and the management cluster Prometheus
+// scraping it, set by GatherHostedClusterMetadata
+var hostedControlPlane struct {
+	namespace       string
+	prometheusURL   string
+	prometheusToken string
+}
+
+// hostedCluster holds the management cluster details of a hosted cluster
+type hostedCluster struct {
+	name                         string
+	namespace                    string
+	controlPlaneNamespace        string
+	controllerAvailabilityPolicy string
+	size                         string
+}
+
+// getHostedCluster looks for the HostedCluster object whose infraID matches the given infrastructure name
+func getHostedCluster(dynamicClient dynamic.Interface, infraID string) (*hostedCluster, error) {
+	hostedClusters, err := dynamicClient.Resource(hostedClusterGVR).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing hostedclusters: %v", err)
+	}
+	for _, hc := range hostedClusters.Items {
+		id, _, _ := unstructured.NestedString(hc.Object, "spec", hostedClusterInfraIDField)
+		if id != infraID {
+			continue
+		}
+		policy, _, _ := unstructured.NestedString(hc.Object, "spec", "controllerAvailabilityPolicy")
+		return &hostedCluster{
+			name:                         hc.GetName(),
+			namespace:                    hc.GetNamespace(),
+			controlPlaneNamespace:        fmt.Sprintf("%s-%s", hc.GetNamespace(), hc.GetName()),
+			controllerAvailabilityPolicy: policy,
+			size:                         hc.GetLabels()[hostedClusterSizeLabel],
+		}, nil
+	}
+	return nil, fmt.Errorf("hostedcluster with infraID %s not found in the management cluster", infraID)
+}
+
+// getRequestServingNodeType returns the instance type of the request serving nodes dedicated to the given hosted control plane
+func getRequestServingNodeType(clientSet kubernetes.Interface, controlPlaneNamespace string) (string, error) {
+	nodes, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true,%s=%s", requestServingNodeLabel, hostedControlPlaneLabel, controlPlaneNamespace),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(nodes.Items) == 0 {
+		return "", nil
+	}
+	return nodes.Items[0].Labels[instanceTypeLabel], nil
+}
+
+// hostedClusterTopology returns whether the cluster is a hosted cluster, whose control plane runs outside of it
+func hostedClusterTopology() bool {
+	_, restConfig := newClientSet()
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return false
+	}
+	infra, err := dynamicClient.Resource(infrastructureGVR).Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	topology, _, _ := unstructured.NestedString(infra.Object, "status", "controlPlaneTopology")
+	return topology == "External"
+}
+
+// hostedControlPlanePrometheus returns the URL and the token of the management cluster Prometheus scraping the given hosted
+// control plane namespace. The namespaces labeled for cluster monitoring are scraped by the platform Prometheus, the rest by
+// the user workload monitoring one, queried along with the platform one through the Thanos querier
+func hostedControlPlanePrometheus(clientSet kubernetes.Interface, restConfig *rest.Config, namespace string) (string, string, error) {
+	mcMetadata, err := ocpmetadata.NewMetadata(restConfig)
+	if err != nil {
+		return "", "", err
+	}
+	prometheusURL, prometheusToken, err := mcMetadata.GetPrometheus()
+	if err != nil {
+		return "", "", err
+	}
+	ns, err := clientSet.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("error getting namespace %s: %v", namespace, err)
+	}
+	if ns.Labels[clusterMonitoringLabel] == "true" {
+		return prometheusURL, prometheusToken, nil
+	}
+	thanosURL, err := monitoringRouteURL(restConfig, thanosQuerierRoute)
+	if err != nil {
+		log.Warnf("Error getting the management cluster Thanos querier, the hosted control plane metrics are scraped from the platform Prometheus: %v", err)
+		return prometheusURL, prometheusToken, nil
+	}
+	log.Infof("Hosted control plane namespace %s isn't labeled for cluster monitoring, scraping its metrics from the Thanos querier", namespace)
+	return thanosURL, prometheusToken, nil
+}
+
+// GatherHostedClusterMetadata adds the management cluster identity and the hosted control plane sizing to the metadata
+func GatherHostedClusterMetadata(wh *workloads.WorkloadHelper, mcKubeconfig string) error {
+	if mcKubeconfig == "" {
+		if hostedClusterTopology() {
+			log.Warn("The cluster is a hosted cluster, pass the management cluster kubeconfig with --mc-kubeconfig to collect the hosted control plane metrics")
+		}
+		return nil
+	}
+	log.Info("Gathering hosted control plane metadata from the management cluster")
+	kubeClientProvider := config.NewKubeClientProvider(mcKubeconfig, "")
+	clientSet, restConfig := kubeClientProvider.DefaultClientSet()
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	hc, err := getHostedCluster(dynamicClient, clusterMetadata.ClusterName)
+	if err != nil {
+		return err
+	}
+	// Consumed by the hosted control plane metrics and alert profiles
+	os.Setenv("HCP_NAMESPACE", hc.controlPlaneNamespace)
+	hostedControlPlane.namespace = hc.controlPlaneNamespace
+	hostedControlPlane.prometheusURL, hostedControlPlane.prometheusToken, err = hostedControlPlanePrometheus(clientSet, restConfig, hc.controlPlaneNamespace)
+	if err != nil {
+		log.Warnf("Error getting the management cluster Prometheus, hosted control plane metrics won't be collected: %v", err)
+		hostedControlPlane.prometheusURL = ""
+	}
+	mcInfra, err := dynamicClient.Resource(infrastructureGVR).Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting management cluster infrastructure: %v", err)
+	}
+	mcName, _, _ := unstructured.NestedString(mcInfra.Object, "status", "infrastructureName")
+	hcpMetadata := map[string]interface{}{
+		"mgmtClusterName":              mcName,
+		"hostedClusterName":            hc.name,
+		"hostedControlPlaneNamespace":  hc.controlPlaneNamespace,
+		"controllerAvailabilityPolicy": hc.controllerAvailabilityPolicy,
+		"hostedClusterSize":            hc.size,
+	}
+	for deployment, field := range map[string]string{
+		"kube-apiserver":        "kubeAPIServerReplicas",
+		"openshift-apiserver":   "openshiftAPIServerReplicas",
+		"ovnkube-control-plane": "ovnkubeControlPlaneReplicas",
+	} {
+		d, err := clientSet.AppsV1().Deployments(hc.controlPlaneNamespace).Get(context.TODO(), deployment, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Error getting deployment %s in namespace %s: %v", deployment, hc.controlPlaneNamespace, err)
+			continue
+		}
+		if d.Spec.Replicas != nil {
+			hcpMetadata[field] = *d.Spec.Replicas
+		}
+	}
+	if etcd, err := clientSet.AppsV1().StatefulSets(hc.controlPlaneNamespace).Get(context.TODO(), "etcd", metav1.GetOptions{}); err == nil && etcd.Spec.Replicas != nil {
+		hcpMetadata["etcdReplicas"] = *etcd.Spec.Replicas
+	}
+	hcpMetadata["requestServingNodeType"], err = getRequestServingNodeType(clientSet, hc.controlPlaneNamespace)
+	if err != nil {
+		return fmt.Errorf("error getting request serving nodes: %v", err)
+	}
+	for k, v := range hcpMetadata {
+		wh.SummaryMetadata[k] = v
+	}
+	wh.MetricsMetadata["mgmtClusterName"] = mcName
+	return nil
+}
+
+// scrapeHostedControlPlane scrapes the hosted control plane metrics from the management cluster Prometheus over the given jobs,
+// indexing them with the indexers of the workload, and evaluates the hosted control plane alerts when alerting is enabled.
+// Returns true when any alert with error severity fired
+func scrapeHostedControlPlane(wh *workloads.WorkloadHelper, jobs []prometheus.Job) bool {
+	if hostedControlPlane.prometheusURL == "" {
+		return false
+	}
+	defer StartSpan("hosted-control-plane-metrics")()
+	log.Infof("Collecting hosted control plane metrics of namespace %s from %s", hostedControlPlane.namespace, hostedControlPlane.prometheusURL)
+	return scrapeProfiles(wh, hostedControlPlane.prometheusURL, hostedControlPlane.prometheusToken, []string{"metrics-hcp.yml"}, []string{"alerts-hcp.yml"}, jobs)
+}
+
+// scrapeProfiles scrapes the given metrics profiles from another Prometheus than the one of the workload over the given jobs,
+// indexing them with the indexers of the workload, and evaluates the given alert profiles when alerting is enabled.
+// Returns true when any alert with error severity fired
+func scrapeProfiles(wh *workloads.WorkloadHelper, prometheusURL, prometheusToken string, metricsProfiles, alertProfiles []string, jobs []prometheus.Job) bool {
+	var alertFired bool
+	configSpec := workloads.ConfigSpec
+	metricsEndpoints := workloads.ConfigSpec.MetricsEndpoints
+	if wh.MetricsEndpoint != "" {
+		metricsEndpoints = metrics.DecodeMetricsEndpoint(wh.MetricsEndpoint)
+	}
+	configSpec.MetricsEndpoints = make([]config.MetricsEndpoint, len(metricsEndpoints))
+	copy(configSpec.MetricsEndpoints, metricsEndpoints)
+	// The local indexer would overwrite the alerts of the run, so the documents are written to a temporary directory and appended afterwards
+	localDirectories := make(map[string]string)
+	for i, endpoint := range configSpec.MetricsEndpoints {
+		configSpec.MetricsEndpoints[i].Endpoint = prometheusURL
+		configSpec.MetricsEndpoints[i].Token = prometheusToken
+		configSpec.MetricsEndpoints[i].Username, configSpec.MetricsEndpoints[i].Password = "", ""
+		configSpec.MetricsEndpoints[i].Metrics = metricsProfiles
+		configSpec.MetricsEndpoints[i].Alerts = nil
+		if len(endpoint.Alerts) > 0 {
+			configSpec.MetricsEndpoints[i].Alerts = alertProfiles
+		}
+		if endpoint.Type == indexers.LocalIndexer {
+			tmpDir, err := os.MkdirTemp("", "scrape-profiles-")
+			if err != nil {
+				log.Error(err.Error())
+				return false
+			}
+			defer os.RemoveAll(tmpDir)
+			localDirectories[tmpDir] = endpoint.MetricsDirectory
+			configSpec.MetricsEndpoints[i].MetricsDirectory = tmpDir
+		}
+	}
+	metricsScraper := metrics.ProcessMetricsScraperConfig(metrics.ScraperConfig{
+		ConfigSpec:      &configSpec,
+		MetricsMetadata: wh.MetricsMetadata,
+	})
+	for _, prometheusClient := range metricsScraper.PrometheusClients {
+		if err := prometheusClient.ScrapeJobsMetrics(jobs...); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	for _, alertM := range metricsScraper.AlertMs {
+		for _, job := range jobs {
+			if err := alertM.Evaluate(job); err != nil {
+				log.Error(err.Error())
+				alertFired = true
+			}
+		}
+	}
+	for tmpDir, metricsDirectory := range localDirectories {
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		for _, file := range files {
+			if err := appendDocuments(path.Join(tmpDir, file.Name()), path.Join(metricsDirectory, file.Name())); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	return alertFired
+}
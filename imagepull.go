@@ -0,0 +1,190 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	imagePullLatencyMetric          = "imagePullLatencyMeasurement"
+	imagePullLatencyQuantilesMetric = "imagePullLatencyQuantilesMeasurement"
+)
+
+// Successfully pulled image "quay.io/cloud-bulldozer/sampleapp:latest" in 1.432s (1.432s including waiting). Image size: 9212339 bytes.
+var pulledImageRegex = regexp.MustCompile(`Successfully pulled image "([^"]+)" in ([^ ]+) \(([^ ]+) including waiting\)`)
+
+// imagePullLatency holds the image pull duration of a pod container
+type imagePullLatency struct {
+	Timestamp   time.Time   `json:"timestamp"`
+	UUID        string      `json:"uuid"`
+	Namespace   string      `json:"namespace"`
+	PodName     string      `json:"podName"`
+	NodeName    string      `json:"nodeName"`
+	Image       string      `json:"image"`
+	PullLatency int         `json:"pullLatency"`
+	WaitLatency int         `json:"waitLatency"`
+	MetricName  string      `json:"metricName"`
+	JobName     string      `json:"jobName,omitempty"`
+	Metadata    interface{} `json:"metadata,omitempty"`
+}
+
+type imagePullWatcher struct {
+	clientSet kubernetes.Interface
+	uuid      string
+	stopCh    chan struct{}
+	// namespaces caches the job of each namespace, empty when not created by this run
+	namespaces map[string]string
+	latencies  []imagePullLatency
+	mu         sync.Mutex
+}
+
+// startImagePullWatcher watches the image pulled events of the pods created by the run with the given UUID
+func startImagePullWatcher(uuid string) *imagePullWatcher {
+	clientSet, _ := newClientSet()
+	ipw := &imagePullWatcher{
+		clientSet:  clientSet,
+		uuid:       uuid,
+		stopCh:     make(chan struct{}),
+		namespaces: make(map[string]string),
+	}
+	log.Info("Watching image pull events")
+	watchEvents(clientSet, "Pulled", ipw.stopCh, ipw.handleEvent)
+	return ipw
+}
+
+func (ipw *imagePullWatcher) handleEvent(event *corev1.Event) {
+	// Cached images produce Pulled events too, without a pull duration
+	match := pulledImageRegex.FindStringSubmatch(event.Message)
+	if match == nil {
+		return
+	}
+	jobName, ok := ipw.jobName(event.Namespace)
+	if !ok {
+		return
+	}
+	pullLatency, err := time.ParseDuration(match[2])
+	if err != nil {
+		log.Debugf("Error parsing image pull duration from event %s: %v", event.Name, err)
+		return
+	}
+	waitLatency, err := time.ParseDuration(match[3])
+	if err != nil {
+		log.Debugf("Error parsing image pull duration from event %s: %v", event.Name, err)
+		return
+	}
+	ipw.mu.Lock()
+	defer ipw.mu.Unlock()
+	ipw.latencies = append(ipw.latencies, imagePullLatency{
+		Timestamp:   eventTimestamp(event).UTC(),
+		UUID:        ipw.uuid,
+		Namespace:   event.Namespace,
+		PodName:     event.InvolvedObject.Name,
+		NodeName:    event.Source.Host,
+		Image:       match[1],
+		PullLatency: int(pullLatency.Milliseconds()),
+		WaitLatency: int(waitLatency.Milliseconds()),
+		MetricName:  imagePullLatencyMetric,
+		JobName:     jobName,
+	})
+}
+
+// jobName returns the job that created the given namespace, and whether it was created by this run
+func (ipw *imagePullWatcher) jobName(namespace string) (string, bool) {
+	ipw.mu.Lock()
+	defer ipw.mu.Unlock()
+	if jobName, exists := ipw.namespaces[namespace]; exists {
+		return jobName, jobName != ""
+	}
+	ns, err := ipw.clientSet.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", false
+	}
+	if ns.Labels["kube-burner-uuid"] == ipw.uuid {
+		ipw.namespaces[namespace] = ns.Labels["kube-burner-job"]
+	} else {
+		ipw.namespaces[namespace] = ""
+	}
+	return ipw.namespaces[namespace], ipw.namespaces[namespace] != ""
+}
+
+// stop stops watching events and indexes the image pull latencies and their quantiles per job
+func (ipw *imagePullWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(ipw.stopCh)
+	ipw.mu.Lock()
+	defer ipw.mu.Unlock()
+	if len(ipw.latencies) == 0 {
+		log.Info("No image pulls found, images were already present in the nodes")
+		return
+	}
+	var docs, quantiles []interface{}
+	pullLatencies := make(map[string][]float64)
+	var jobNames []string
+	for _, latency := range ipw.latencies {
+		latency.Metadata = wh.MetricsMetadata
+		docs = append(docs, latency)
+		if _, exists := pullLatencies[latency.JobName]; !exists {
+			jobNames = append(jobNames, latency.JobName)
+		}
+		pullLatencies[latency.JobName] = append(pullLatencies[latency.JobName], float64(latency.PullLatency))
+	}
+	for _, jobName := range jobNames {
+		q := metrics.NewLatencySummary(pullLatencies[jobName], "ImagePulled")
+		q.UUID = wh.UUID
+		q.MetricName = imagePullLatencyQuantilesMetric
+		q.JobName = jobName
+		q.Metadata = wh.MetricsMetadata
+		quantiles = append(quantiles, q)
+	}
+	indexDocuments(imagePullLatencyMetric, docs)
+	indexDocuments(imagePullLatencyQuantilesMetric, quantiles)
+}
+
+// watchEvents calls the handler with the pod events with the given reason
+func watchEvents(clientSet kubernetes.Interface, reason string, stopCh chan struct{}, handler func(*corev1.Event)) {
+	lw := cache.NewListWatchFromClient(clientSet.CoreV1().RESTClient(), "events", metav1.NamespaceAll, fields.OneTermEqualSelector("reason", reason))
+	_, controller := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: lw,
+		ObjectType:    &corev1.Event{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if event := obj.(*corev1.Event); event.InvolvedObject.Kind == "Pod" {
+					handler(event)
+				}
+			},
+		},
+	})
+	go controller.Run(stopCh)
+}
+
+// eventTimestamp returns the most precise timestamp of the event
+func eventTimestamp(event *corev1.Event) time.Time {
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.LastTimestamp.Time
+}
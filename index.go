@@ -15,10 +15,16 @@
 package ocp
 
 import (
+	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/cloud-bulldozer/go-commons/indexers"
@@ -30,6 +36,7 @@ import (
 	"github.com/kube-burner/kube-burner/pkg/workloads"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 // NewIndex orchestrates indexing for ocp wrapper
@@ -45,6 +52,10 @@ func NewIndex(wh *workloads.WorkloadHelper, ocpConfig embed.FS) *cobra.Command {
 	var tarballName string
 	var indexer config.MetricsEndpoint
 	var clusterMetadataMap map[string]interface{}
+	var replayDirectory string
+	var downsample, downsampleMargin time.Duration
+	var duration, offset time.Duration
+	var fromRun string
 	cmd := &cobra.Command{
 		Use:          "index",
 		Short:        "Runs index sub-command",
@@ -54,21 +65,58 @@ func NewIndex(wh *workloads.WorkloadHelper, ocpConfig embed.FS) *cobra.Command {
 			log.Info("👋 Exiting kube-burner ", uuid)
 			os.Exit(rc)
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			jobEnd := end
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer StopMetricsEndpointProxies()
+			chunkDuration, _ := cmd.Flags().GetDuration("chunk-duration")
+			concurrency, _ := cmd.Flags().GetInt("scrape-concurrency")
+			if downsample != 0 && downsample <= prometheusStep {
+				return fmt.Errorf("--downsample must be longer than the Prometheus step %v", prometheusStep)
+			}
+			if chunkDuration != 0 && chunkDuration <= prometheusStep {
+				return fmt.Errorf("--chunk-duration must be longer than the Prometheus step %v", prometheusStep)
+			}
 			uuid, _ = cmd.Flags().GetString("uuid")
+			esServer, _ := cmd.Flags().GetString("es-server")
+			esIndex, _ := cmd.Flags().GetString("es-index")
+			var jobs []prometheus.Job
+			// The metrics are scraped until some minutes after the end of the time range, but not after the end of the jobs of a run
+			scrapeMargin := time.Duration(TenMinutes) * time.Second
+			if fromRun != "" {
+				for _, flag := range []string{"start", "end", "duration", "offset", "job-name"} {
+					if cmd.Flags().Changed(flag) {
+						return fmt.Errorf("--from-run can't be used along with --%s", flag)
+					}
+				}
+				runUUID, previousJobs, err := runJobs(fromRun, esServer, esIndex)
+				if err != nil {
+					return err
+				}
+				// The documents are indexed with the UUID of the run, unless another one is given
+				if !cmd.Flags().Changed("uuid") && runUUID != "" {
+					uuid = runUUID
+				}
+				jobs, scrapeMargin = previousJobs, 0
+				for _, job := range jobs {
+					log.Infof("Job %s of run %s: %s - %s", job.JobConfig.Name, uuid, job.Start.UTC().Format(time.RFC3339), job.End.UTC().Format(time.RFC3339))
+				}
+			} else {
+				rangeStart, rangeEnd, err := indexTimeRange(cmd, time.Now(), duration, offset)
+				if err != nil {
+					return err
+				}
+				log.Infof("Indexing from %s to %s", time.Unix(rangeStart, 0).UTC().Format(time.RFC3339), time.Unix(rangeEnd, 0).UTC().Format(time.RFC3339))
+				jobs = []prometheus.Job{{Start: time.Unix(rangeStart, 0), End: time.Unix(rangeEnd, 0), JobConfig: config.Job{Name: jobName}}}
+			}
 			clusterMetadata, err := wh.MetadataAgent.GetClusterMetadata()
 			if err != nil {
-				log.Fatal("Error obtaining clusterMetadata: ", err.Error())
+				return fmt.Errorf("error obtaining clusterMetadata: %v", err)
 			}
-			esServer, _ := cmd.Flags().GetString("es-server")
-			esIndex, _ := cmd.Flags().GetString("es-index")
 			workloads.ConfigSpec.GlobalConfig.UUID = uuid
 			// When metricsEndpoint is specified, don't fetch any prometheus token
 			if wh.MetricsEndpoint == "" {
 				prometheusURL, prometheusToken, err = wh.MetadataAgent.GetPrometheus()
 				if err != nil {
-					log.Fatal("Error obtaining prometheus information from cluster: ", err.Error())
+					return fmt.Errorf("error obtaining prometheus information from cluster: %v", err)
 				}
 			}
 			indexer = config.MetricsEndpoint{
@@ -81,7 +129,7 @@ func NewIndex(wh *workloads.WorkloadHelper, ocpConfig embed.FS) *cobra.Command {
 			if esServer != "" && esIndex != "" {
 				indexer.IndexerConfig = indexers.IndexerConfig{
 					Type:    indexers.ElasticIndexer,
-					Servers: []string{esServer},
+					Servers: []string{indexerURL(esServer)},
 					Index:   esIndex,
 				}
 			} else {
@@ -110,21 +158,35 @@ func NewIndex(wh *workloads.WorkloadHelper, ocpConfig embed.FS) *cobra.Command {
 				UserMetaData:    userMetadata,
 				MetricsMetadata: metadata,
 			})
-			for _, prometheusClient := range metricsScraper.PrometheusClients {
-				prometheusJob := prometheus.Job{
-					Start: time.Unix(start, 0),
-					End:   time.Unix(end+TenMinutes, 0),
-					JobConfig: config.Job{
-						Name: jobName,
-					},
-				}
-				if prometheusClient.ScrapeJobsMetrics(prometheusJob) != nil {
-					rc = 1
+			// Spilled documents are indexed as they are, without scraping
+			if replayDirectory != "" {
+				for _, indexer := range metricsScraper.IndexerList {
+					if err := replayDocuments(replayDirectory, indexer); err != nil {
+						log.Error(err.Error())
+						rc = 1
+					}
 				}
+				return nil
 			}
+			if err := scrapeJobs(jobs, metricsScraper.PrometheusClients, ocpConfig, wh.ConfigDir, scrapeOptions{
+				margin:           scrapeMargin,
+				chunkDuration:    chunkDuration,
+				step:             prometheusStep,
+				downsample:       downsample,
+				downsampleMargin: downsampleMargin,
+				concurrency:      concurrency,
+				metadata:         metadata,
+			}); err != nil {
+				log.Error(err.Error())
+				rc = 1
+			}
+			// Along with the hosted control plane metrics of the same jobs, given the management cluster kubeconfig, and the user
+			// workload metrics
+			scrapeHostedControlPlane(wh, jobs)
+			scrapeUserWorkloadMetrics(wh, jobs)
 			if workloads.ConfigSpec.MetricsEndpoints[0].Type == indexers.LocalIndexer && tarballName != "" {
 				if err := metrics.CreateTarball(workloads.ConfigSpec.MetricsEndpoints[0].IndexerConfig); err != nil {
-					log.Fatal(err)
+					return err
 				}
 			}
 			var indexerValue indexers.Indexer
@@ -132,30 +194,495 @@ func NewIndex(wh *workloads.WorkloadHelper, ocpConfig embed.FS) *cobra.Command {
 				indexerValue = value
 				break
 			}
-			jobSummary := burner.JobSummary{
-				Timestamp:    time.Unix(start, 0).UTC(),
-				EndTimestamp: time.Unix(jobEnd, 0).UTC(),
-				ElapsedTime:  time.Unix(jobEnd, 0).UTC().Sub(time.Unix(start, 0).UTC()).Round(time.Second).Seconds(),
-				UUID:         uuid,
-				JobConfig: config.Job{
-					Name: jobName,
-				},
-				Metadata:   metricsScraper.SummaryMetadata,
-				MetricName: "jobSummary",
-				Version:    fmt.Sprintf("%v@%v", version.Version, version.GitCommit),
-				Passed:     rc == 0,
-			}
-			burner.IndexJobSummary([]burner.JobSummary{jobSummary}, indexerValue)
+			var jobSummaries []burner.JobSummary
+			for _, job := range jobs {
+				jobSummaries = append(jobSummaries, burner.JobSummary{
+					Timestamp:    job.Start.UTC(),
+					EndTimestamp: job.End.UTC(),
+					ElapsedTime:  job.End.Sub(job.Start).Round(time.Second).Seconds(),
+					UUID:         uuid,
+					JobConfig: config.Job{
+						Name: job.JobConfig.Name,
+					},
+					Metadata:   metricsScraper.SummaryMetadata,
+					MetricName: "jobSummary",
+					Version:    fmt.Sprintf("%v@%v", version.Version, version.GitCommit),
+					Passed:     rc == 0,
+				})
+			}
+			burner.IndexJobSummary(jobSummaries, indexerValue)
+			return nil
 		},
 	}
 	cmd.Flags().StringSliceVarP(&metricsProfiles, "metrics-profile", "m", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.Flags().StringVar(&metricsDirectory, "metrics-directory", "collected-metrics", "Directory to dump the metrics files in, when using default local indexing")
 	cmd.Flags().DurationVar(&prometheusStep, "step", 30*time.Second, "Prometheus step size")
-	cmd.Flags().Int64Var(&start, "start", time.Now().Unix()-3600, "Epoch start time")
-	cmd.Flags().Int64Var(&end, "end", time.Now().Unix(), "Epoch end time")
+	cmd.Flags().Int64Var(&start, "start", 0, "Epoch start time, an hour before the end time by default")
+	cmd.Flags().Int64Var(&end, "end", 0, "Epoch end time, now by default")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "Duration of the time range, i.e. 2h, ending at the end time, or starting at the start time when only it's given. It can't be used along with both --start and --end")
+	cmd.Flags().DurationVar(&offset, "offset", 0, "Time the time range ends before now, i.e. 30m, instead of --end")
+	cmd.Flags().StringVar(&fromRun, "from-run", "", "UUID, local metrics directory, result.json file or artifacts directory of a previous run whose jobs are indexed, with their names and time ranges, instead of --start and --end")
 	cmd.Flags().StringVar(&jobName, "job-name", "kube-burner-ocp-indexing", "Indexing job name")
 	cmd.Flags().StringVar(&userMetadata, "user-metadata", "", "User provided metadata file, in YAML format")
 	cmd.Flags().StringVar(&tarballName, "tarball-name", "", "Dump collected metrics into a tarball with the given name, requires local indexing")
+	cmd.Flags().DurationVar(&downsample, "downsample", 0, "Step of the range queries farther than --downsample-margin from the start and the end of the time range, to reduce the number of documents of long runs. 0 disables it")
+	cmd.Flags().DurationVar(&downsampleMargin, "downsample-margin", 10*time.Minute, "Time from the start and the end of the time range scraped with the Prometheus step when downsampling")
+	cmd.Flags().StringVar(&replayDirectory, "replay-directory", "", "Index the documents spilled to this directory by the failed indexing requests of a run instead of scraping metrics")
 	cmd.Flags().SortFlags = false
 	return cmd
 }
+
+// indexTimeRange returns the epochs of the start and the end of the time range of the index subcommand, given by --start and --end,
+// or relative to them or to now with --duration and --offset
+func indexTimeRange(cmd *cobra.Command, now time.Time, duration, offset time.Duration) (int64, int64, error) {
+	start, _ := cmd.Flags().GetInt64("start")
+	end, _ := cmd.Flags().GetInt64("end")
+	startSet, endSet := cmd.Flags().Changed("start"), cmd.Flags().Changed("end")
+	if duration < 0 || offset < 0 {
+		return 0, 0, fmt.Errorf("--duration and --offset can't be negative")
+	}
+	if offset != 0 && endSet {
+		return 0, 0, fmt.Errorf("--offset can't be used along with --end")
+	}
+	if duration != 0 && startSet && endSet {
+		return 0, 0, fmt.Errorf("--duration can't be used along with both --start and --end")
+	}
+	switch {
+	case endSet:
+	case startSet && duration != 0:
+		end = time.Unix(start, 0).Add(duration).Unix()
+	default:
+		end = now.Add(-offset).Unix()
+	}
+	if !startSet {
+		if duration == 0 {
+			duration = time.Hour
+		}
+		start = time.Unix(end, 0).Add(-duration).Unix()
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("the start time %s must be before the end time %s", time.Unix(start, 0).UTC().Format(time.RFC3339), time.Unix(end, 0).UTC().Format(time.RFC3339))
+	}
+	if end > now.Unix() {
+		log.Warnf("The end time %s is in the future", time.Unix(end, 0).UTC().Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// runJobs returns the UUID and the jobs of a previous run, with their time ranges, from its result.json file, the artifacts
+// directory holding it, its local metrics directory, or its UUID, looked up in the collected-metrics-<uuid> directory or the
+// given Elasticsearch index
+func runJobs(source, esServer, esIndex string) (string, []prometheus.Job, error) {
+	resultFile := source
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		resultFile = path.Join(source, artifactsResultFile)
+	}
+	if _, err := os.Stat(resultFile); err == nil {
+		var result artifactResult
+		if err := readDocuments(resultFile, &result); err != nil {
+			return "", nil, fmt.Errorf("error reading %s: %v", resultFile, err)
+		}
+		var jobs []prometheus.Job
+		for _, job := range result.Jobs {
+			if !job.Timestamp.IsZero() && job.EndTimestamp.After(job.Timestamp) {
+				jobs = append(jobs, prometheus.Job{Start: job.Timestamp, End: job.EndTimestamp, JobConfig: config.Job{Name: job.Name}})
+			}
+		}
+		// The result files written before the job timestamps were added only have the ones of the run
+		if len(jobs) == 0 && result.EndTimestamp.After(result.Timestamp) {
+			jobs = append(jobs, prometheus.Job{Start: result.Timestamp, End: result.EndTimestamp, JobConfig: config.Job{Name: result.Workload}})
+		}
+		if len(jobs) == 0 {
+			return "", nil, fmt.Errorf("no jobs found in %s", resultFile)
+		}
+		return result.UUID, jobs, nil
+	}
+	results, err := loadResults(source, esServer, esIndex)
+	if err != nil {
+		return "", nil, err
+	}
+	var uuid string
+	var jobs []prometheus.Job
+	for _, jobSummary := range results.jobSummaries {
+		// Like the ones of the garbage collection when it isn't measured
+		if !jobSummary.EndTimestamp.After(jobSummary.Timestamp) {
+			continue
+		}
+		uuid = jobSummary.UUID
+		jobs = append(jobs, prometheus.Job{Start: jobSummary.Timestamp, End: jobSummary.EndTimestamp, JobConfig: config.Job{Name: jobSummary.JobConfig.Name}})
+	}
+	if len(jobs) == 0 {
+		return "", nil, fmt.Errorf("no jobs found in the results of %s", source)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Start.Before(jobs[j].Start)
+	})
+	return uuid, jobs, nil
+}
+
+// elapsedRegex matches the {{.elapsed}} variable of the queries of the metrics profiles
+var elapsedRegex = regexp.MustCompile(`{{-?\s*\.elapsed\s*-?}}`)
+
+// indexWindow is a time window of the index subcommand, along with the step of its range queries and the profiles with the range
+// queries of each endpoint
+type indexWindow struct {
+	prometheus.Job
+	step    time.Duration
+	metrics [][]string
+}
+
+// indexWindows splits the given time range into consecutive windows of the given duration. When downsampling, the time range
+// but the given margins from its start and its end is scraped with the downsample step. Every window but the last one ends a
+// step before the next one starts, so the range queries don't return their boundary datapoints twice
+func indexWindows(start, end time.Time, duration, step, downsample, margin time.Duration) []indexWindow {
+	segments := []indexWindow{{Job: prometheus.Job{Start: start, End: end}, step: step}}
+	if downsample > step && end.Sub(start) > 2*margin {
+		segments = []indexWindow{
+			{Job: prometheus.Job{Start: start, End: start.Add(margin)}, step: step},
+			{Job: prometheus.Job{Start: start.Add(margin), End: end.Add(-margin)}, step: downsample},
+			{Job: prometheus.Job{Start: end.Add(-margin), End: end}, step: step},
+		}
+	}
+	var windows []indexWindow
+	for i, segment := range segments {
+		windowDuration := duration
+		if windowDuration <= 0 || windowDuration >= segment.End.Sub(segment.Start) {
+			// A single window, rounded up to a whole number of steps
+			windowDuration = segment.End.Sub(segment.Start) + segment.step - 1
+		}
+		// Every window spans a whole number of steps, so the datapoints are the same ones returned for the whole time range
+		windowDuration = max(windowDuration.Truncate(segment.step), segment.step)
+		for windowStart := segment.Start; windowStart.Before(segment.End); windowStart = windowStart.Add(windowDuration) {
+			windowEnd := windowStart.Add(windowDuration - segment.step)
+			if !windowStart.Add(windowDuration).Before(segment.End) {
+				windowEnd = segment.End
+				if i < len(segments)-1 {
+					windowEnd = segment.End.Add(-segment.step)
+				}
+			}
+			if windowEnd.Before(windowStart) {
+				windowEnd = windowStart
+			}
+			windows = append(windows, indexWindow{
+				Job:  prometheus.Job{Start: windowStart, End: windowEnd},
+				step: segment.step,
+			})
+		}
+	}
+	return windows
+}
+
+// scrapeOptions are the options of the scraping of the metrics of the jobs
+type scrapeOptions struct {
+	// margin is the time scraped after the end of each job
+	margin                       time.Duration
+	chunkDuration, step          time.Duration
+	downsample, downsampleMargin time.Duration
+	concurrency                  int
+	metadata                     map[string]interface{}
+}
+
+// scrapeJobs scrapes and indexes the metrics of the given jobs with the given Prometheus clients. When the time ranges of the jobs
+// span several windows, the range queries of the metrics profiles of the workload endpoints are scraped one window after another
+// and the instant ones once per job, as the local indexer would overwrite the documents of the previous windows
+func scrapeJobs(jobs []prometheus.Job, prometheusClients []*prometheus.Prometheus, ocpConfig embed.FS, configDir string, opts scrapeOptions) error {
+	if len(jobs) == 0 || len(prometheusClients) == 0 {
+		return nil
+	}
+	jobWindows := make([][]indexWindow, len(jobs))
+	var windowCount int
+	for i, job := range jobs {
+		jobWindows[i] = indexWindows(job.Start, job.End.Add(opts.margin), opts.chunkDuration, opts.step, opts.downsample, opts.downsampleMargin)
+		for w := range jobWindows[i] {
+			jobWindows[i][w].JobConfig = job.JobConfig
+			jobWindows[i][w].ChurnStart, jobWindows[i][w].ChurnEnd = job.ChurnStart, job.ChurnEnd
+		}
+		windowCount += len(jobWindows[i])
+	}
+	if windowCount == 1 {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, opts.concurrency)
+		errs := make([]error, len(prometheusClients))
+		for i, prometheusClient := range prometheusClients {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, prometheusClient *prometheus.Prometheus) {
+				defer wg.Done()
+				errs[i] = prometheusClient.ScrapeJobsMetrics(jobWindows[0][0].Job)
+				<-sem
+			}(i, prometheusClient)
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+	var profiles []string
+	defer func() {
+		for _, profile := range profiles {
+			os.Remove(profile)
+		}
+	}()
+	var windows []indexWindow
+	instantMetrics := make([][][]string, len(jobs))
+	for i, job := range jobs {
+		// Only the range queries are split in windows, the instant ones are evaluated once for the whole time range. The {{.elapsed}}
+		// variable of both is rendered with the duration of the whole time range of the job, not the one of each window
+		rangeMetrics, jobInstantMetrics, err := splitMetricsProfiles(workloads.ConfigSpec.MetricsEndpoints, ocpConfig, configDir, job.End.Add(opts.margin).Sub(job.Start))
+		if err != nil {
+			return err
+		}
+		for _, endpointProfiles := range append(rangeMetrics, jobInstantMetrics...) {
+			profiles = append(profiles, endpointProfiles...)
+		}
+		instantMetrics[i] = jobInstantMetrics
+		for _, window := range jobWindows[i] {
+			window.metrics = rangeMetrics
+			windows = append(windows, window)
+		}
+	}
+	var errs []error
+	written := make(map[string]bool)
+	if err := scrapeWindows(windows, opts.concurrency, opts.metadata, written); err != nil {
+		errs = append(errs, err)
+	}
+	for i, job := range jobs {
+		instantJob := job
+		instantJob.End = job.End.Add(opts.margin)
+		localDirectories, err := scrapeWindow(instantJob, 0, opts.metadata, instantMetrics[i])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if err := mergeWindow(localDirectories, written); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// jobSummaryRecorder wraps an indexer recording the jobs of the job summaries indexed by kube-burner, whose metrics are scraped
+// once it finishes
+type jobSummaryRecorder struct {
+	indexers.Indexer
+	jobs []prometheus.Job
+}
+
+// Index implements indexers.Indexer
+func (r *jobSummaryRecorder) Index(documents []interface{}, opts indexers.IndexingOpts) (string, error) {
+	if opts.MetricName == "jobSummary" {
+		for _, document := range documents {
+			var jobSummary burner.JobSummary
+			data, err := json.Marshal(document)
+			if err != nil {
+				continue
+			}
+			if err := json.Unmarshal(data, &jobSummary); err != nil {
+				log.Warnf("Error decoding job summary: %v", err)
+				continue
+			}
+			r.jobs = append(r.jobs, prometheus.Job{
+				Start:      jobSummary.Timestamp,
+				End:        jobSummary.EndTimestamp,
+				ChurnStart: jobSummary.ChurnStartTimestamp,
+				ChurnEnd:   jobSummary.ChurnEndTimestamp,
+				JobConfig:  jobSummary.JobConfig,
+			})
+		}
+	}
+	return r.Indexer.Index(documents, opts)
+}
+
+// splitMetricsProfiles splits the metrics profiles of each endpoint in a profile with the range queries and another with the
+// instant ones, written to temporary files, with the {{.elapsed}} variable rendered with the given duration. Returns the range
+// and instant profiles of each endpoint
+func splitMetricsProfiles(endpoints []config.MetricsEndpoint, ocpConfig embed.FS, configDir string, elapsed time.Duration) ([][]string, [][]string, error) {
+	rangeMetrics := make([][]string, len(endpoints))
+	instantMetrics := make([][]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		var rangeQueries, instantQueries []metricQuery
+		for _, profile := range endpoint.Metrics {
+			metricsProfile, err := readMetricsProfile(profile, ocpConfig, configDir)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, query := range metricsProfile {
+				// Rendered the way kube-burner does, which still renders the rest of the template
+				query.Query = elapsedRegex.ReplaceAllString(query.Query, fmt.Sprintf("%ds", int(elapsed.Seconds())))
+				if query.Instant {
+					instantQueries = append(instantQueries, query)
+				} else {
+					rangeQueries = append(rangeQueries, query)
+				}
+			}
+		}
+		for _, split := range []struct {
+			queries []metricQuery
+			metrics *[]string
+		}{{rangeQueries, &rangeMetrics[i]}, {instantQueries, &instantMetrics[i]}} {
+			if len(split.queries) == 0 {
+				continue
+			}
+			f, err := os.CreateTemp("", "metrics-*.yml")
+			if err != nil {
+				return nil, nil, err
+			}
+			err = yaml.NewEncoder(f).Encode(split.queries)
+			f.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			*split.metrics = []string{f.Name()}
+		}
+	}
+	return rangeMetrics, instantMetrics, nil
+}
+
+// scrapeWindows scrapes and indexes the metrics of the given windows with their profiles of each endpoint, up to the given
+// number of windows at once. The local indexers write them to temporary directories, and once every window of a batch is
+// scraped, their documents are appended in order to the ones of the previous windows
+func scrapeWindows(windows []indexWindow, concurrency int, metadata map[string]interface{}, written map[string]bool) error {
+	var failed bool
+	for batchStart := 0; batchStart < len(windows); batchStart += concurrency {
+		batch := windows[batchStart:min(batchStart+concurrency, len(windows))]
+		localDirectories := make([]map[string]string, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, window := range batch {
+			log.Infof("Indexing window %d/%d: %v - %v, step %v", batchStart+i+1, len(windows), window.Start.UTC().Format(time.RFC3339), window.End.UTC().Format(time.RFC3339), window.step)
+			wg.Add(1)
+			go func(i int, window indexWindow) {
+				defer wg.Done()
+				localDirectories[i], errs[i] = scrapeWindow(window.Job, window.step, metadata, window.metrics)
+			}(i, window)
+		}
+		wg.Wait()
+		for i := range batch {
+			if errs[i] != nil {
+				log.Error(errs[i].Error())
+				failed = true
+			}
+			if err := mergeWindow(localDirectories[i], written); err != nil {
+				log.Error(err.Error())
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return fmt.Errorf("error indexing the metrics of some windows")
+	}
+	return nil
+}
+
+// scrapeWindow scrapes and indexes the metrics of the given window with the given profiles of each endpoint and step, when not 0.
+// The local indexers write them to temporary directories. Returns the metrics directory of each temporary directory
+func scrapeWindow(job prometheus.Job, step time.Duration, metadata map[string]interface{}, endpointMetrics [][]string) (map[string]string, error) {
+	// The endpoints of --metrics-endpoint are already decoded into the workload configuration
+	configSpec := workloads.ConfigSpec
+	configSpec.MetricsEndpoints = make([]config.MetricsEndpoint, len(workloads.ConfigSpec.MetricsEndpoints))
+	copy(configSpec.MetricsEndpoints, workloads.ConfigSpec.MetricsEndpoints)
+	localDirectories := make(map[string]string)
+	for i, endpoint := range configSpec.MetricsEndpoints {
+		configSpec.MetricsEndpoints[i].Metrics = endpointMetrics[i]
+		// The alert profiles aren't evaluated per window
+		configSpec.MetricsEndpoints[i].Alerts = nil
+		if step != 0 {
+			configSpec.MetricsEndpoints[i].Step = step
+		}
+		if endpoint.Type == indexers.LocalIndexer {
+			tmpDir, err := os.MkdirTemp("", "index-window-")
+			if err != nil {
+				return localDirectories, err
+			}
+			localDirectories[tmpDir] = endpoint.MetricsDirectory
+			configSpec.MetricsEndpoints[i].MetricsDirectory = tmpDir
+		}
+	}
+	metricsScraper := metrics.ProcessMetricsScraperConfig(metrics.ScraperConfig{
+		ConfigSpec:      &configSpec,
+		MetricsMetadata: metadata,
+	})
+	for _, prometheusClient := range metricsScraper.PrometheusClients {
+		if err := prometheusClient.ScrapeJobsMetrics(job); err != nil {
+			return localDirectories, err
+		}
+	}
+	return localDirectories, nil
+}
+
+// mergeWindow appends the documents of the temporary directories of a window to their metrics directories and removes them.
+// The metric files not written yet by a previous window are replaced
+func mergeWindow(localDirectories map[string]string, written map[string]bool) error {
+	for tmpDir, metricsDirectory := range localDirectories {
+		defer os.RemoveAll(tmpDir)
+		if err := os.MkdirAll(metricsDirectory, 0744); err != nil {
+			return err
+		}
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			dst := path.Join(metricsDirectory, file.Name())
+			if !written[dst] {
+				os.Remove(dst)
+				written[dst] = true
+			}
+			if err := appendDocuments(path.Join(tmpDir, file.Name()), dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendDocuments appends the JSON array of documents of the source file to the one of the destination file, without
+// loading the latter in memory
+func appendDocuments(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '[' || data[len(data)-1] != ']' {
+		return fmt.Errorf("%s doesn't hold a JSON array", src)
+	}
+	// Nothing to append
+	if len(bytes.TrimSpace(data[1:len(data)-1])) == 0 {
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			return os.WriteFile(dst, []byte("[]\n"), 0644)
+		}
+		return nil
+	}
+	f, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		_, err = f.Write(append(data, '\n'))
+		return err
+	}
+	// Look for the closing bracket of the destination array in its trailing bytes
+	tail := make([]byte, min(info.Size(), 64))
+	offset := info.Size() - int64(len(tail))
+	if _, err := f.ReadAt(tail, offset); err != nil {
+		return err
+	}
+	closing := bytes.LastIndexByte(tail, ']')
+	if closing == -1 {
+		return fmt.Errorf("%s doesn't hold a JSON array", dst)
+	}
+	if err := f.Truncate(offset + int64(closing)); err != nil {
+		return err
+	}
+	// Unless the destination array is empty
+	if !bytes.HasSuffix(bytes.TrimSpace(tail[:closing]), []byte("[")) {
+		data[0] = ','
+	} else {
+		data = data[1:]
+	}
+	_, err = f.WriteAt(append(data, '\n'), offset+int64(closing))
+	return err
+}
@@ -0,0 +1,444 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/prometheus"
+	"github.com/spf13/cobra"
+	"k8s.io/utils/ptr"
+)
+
+func TestIndexWindows(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	type window struct {
+		start, end time.Time
+		step       time.Duration
+	}
+	tests := []struct {
+		name       string
+		end        time.Time
+		duration   time.Duration
+		step       time.Duration
+		downsample time.Duration
+		margin     time.Duration
+		want       []window
+	}{
+		{
+			name: "single window",
+			end:  at(time.Hour),
+			step: 30 * time.Second,
+			want: []window{{start, at(time.Hour), 30 * time.Second}},
+		},
+		{
+			name:     "window longer than the time range",
+			end:      at(time.Hour),
+			duration: 2 * time.Hour,
+			step:     30 * time.Second,
+			want:     []window{{start, at(time.Hour), 30 * time.Second}},
+		},
+		{
+			name:     "even windows",
+			end:      at(time.Hour),
+			duration: 20 * time.Minute,
+			step:     30 * time.Second,
+			want: []window{
+				{start, at(19*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(20 * time.Minute), at(39*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(40 * time.Minute), at(time.Hour), 30 * time.Second},
+			},
+		},
+		{
+			name:     "shorter last window",
+			end:      at(time.Hour),
+			duration: 25 * time.Minute,
+			step:     30 * time.Second,
+			want: []window{
+				{start, at(24*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(25 * time.Minute), at(49*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(50 * time.Minute), at(time.Hour), 30 * time.Second},
+			},
+		},
+		{
+			name:     "window rounded to whole steps",
+			end:      at(time.Hour),
+			duration: 20*time.Minute + 10*time.Second,
+			step:     30 * time.Second,
+			want: []window{
+				{start, at(19*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(20 * time.Minute), at(39*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(40 * time.Minute), at(time.Hour), 30 * time.Second},
+			},
+		},
+		{
+			name:     "window shorter than the step",
+			end:      at(time.Minute),
+			duration: 10 * time.Second,
+			step:     30 * time.Second,
+			want: []window{
+				{start, start, 30 * time.Second},
+				{at(30 * time.Second), at(time.Minute), 30 * time.Second},
+			},
+		},
+		{
+			name:       "downsampled",
+			end:        at(time.Hour),
+			step:       30 * time.Second,
+			downsample: 5 * time.Minute,
+			margin:     10 * time.Minute,
+			want: []window{
+				{start, at(9*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(10 * time.Minute), at(45 * time.Minute), 5 * time.Minute},
+				{at(50 * time.Minute), at(time.Hour), 30 * time.Second},
+			},
+		},
+		{
+			name:       "downsampled in windows",
+			end:        at(time.Hour),
+			duration:   20 * time.Minute,
+			step:       30 * time.Second,
+			downsample: 5 * time.Minute,
+			margin:     10 * time.Minute,
+			want: []window{
+				{start, at(9*time.Minute + 30*time.Second), 30 * time.Second},
+				{at(10 * time.Minute), at(25 * time.Minute), 5 * time.Minute},
+				{at(30 * time.Minute), at(45 * time.Minute), 5 * time.Minute},
+				{at(50 * time.Minute), at(time.Hour), 30 * time.Second},
+			},
+		},
+		{
+			name:       "time range too short to downsample",
+			end:        at(15 * time.Minute),
+			step:       30 * time.Second,
+			downsample: 5 * time.Minute,
+			margin:     10 * time.Minute,
+			want:       []window{{start, at(15 * time.Minute), 30 * time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []window
+			for _, w := range indexWindows(start, tt.end, tt.duration, tt.step, tt.downsample, tt.margin) {
+				got = append(got, window{w.Start, w.End, w.step})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got windows %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexTimeRange(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	unix := func(d time.Duration) int64 { return now.Add(d).Unix() }
+	tests := []struct {
+		name      string
+		flags     map[string]int64
+		duration  time.Duration
+		offset    time.Duration
+		wantStart int64
+		wantEnd   int64
+		wantErr   bool
+	}{
+		{
+			name:      "last hour by default",
+			wantStart: unix(-time.Hour),
+			wantEnd:   unix(0),
+		},
+		{
+			name:      "duration",
+			duration:  30 * time.Minute,
+			wantStart: unix(-30 * time.Minute),
+			wantEnd:   unix(0),
+		},
+		{
+			name:      "offset",
+			offset:    10 * time.Minute,
+			wantStart: unix(-70 * time.Minute),
+			wantEnd:   unix(-10 * time.Minute),
+		},
+		{
+			name:      "duration and offset",
+			duration:  20 * time.Minute,
+			offset:    10 * time.Minute,
+			wantStart: unix(-30 * time.Minute),
+			wantEnd:   unix(-10 * time.Minute),
+		},
+		{
+			name:      "start and duration",
+			flags:     map[string]int64{"start": unix(-2 * time.Hour)},
+			duration:  30 * time.Minute,
+			wantStart: unix(-2 * time.Hour),
+			wantEnd:   unix(-90 * time.Minute),
+		},
+		{
+			name:      "start only",
+			flags:     map[string]int64{"start": unix(-2 * time.Hour)},
+			wantStart: unix(-2 * time.Hour),
+			wantEnd:   unix(0),
+		},
+		{
+			name:      "end and duration",
+			flags:     map[string]int64{"end": unix(-time.Hour)},
+			duration:  30 * time.Minute,
+			wantStart: unix(-90 * time.Minute),
+			wantEnd:   unix(-time.Hour),
+		},
+		{
+			name:      "start and end",
+			flags:     map[string]int64{"start": unix(-3 * time.Hour), "end": unix(-time.Hour)},
+			wantStart: unix(-3 * time.Hour),
+			wantEnd:   unix(-time.Hour),
+		},
+		{
+			name:      "end in the future",
+			flags:     map[string]int64{"end": unix(time.Hour)},
+			wantStart: unix(0),
+			wantEnd:   unix(time.Hour),
+		},
+		{
+			name:     "negative duration",
+			duration: -time.Minute,
+			wantErr:  true,
+		},
+		{
+			name:    "negative offset",
+			offset:  -time.Minute,
+			wantErr: true,
+		},
+		{
+			name:    "offset and end",
+			flags:   map[string]int64{"end": unix(-time.Hour)},
+			offset:  10 * time.Minute,
+			wantErr: true,
+		},
+		{
+			name:     "duration, start and end",
+			flags:    map[string]int64{"start": unix(-3 * time.Hour), "end": unix(-time.Hour)},
+			duration: time.Hour,
+			wantErr:  true,
+		},
+		{
+			name:    "start after end",
+			flags:   map[string]int64{"start": unix(-time.Hour), "end": unix(-2 * time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:    "start after the offset end",
+			flags:   map[string]int64{"start": unix(-5 * time.Minute)},
+			offset:  10 * time.Minute,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().Int64("start", 0, "")
+			cmd.Flags().Int64("end", 0, "")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, strconv.FormatInt(value, 10)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start, end, err := indexTimeRange(cmd, now, tt.duration, tt.offset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (start != tt.wantStart || end != tt.wantEnd) {
+				t.Errorf("got time range %d-%d, want %d-%d", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestAppendDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		dst     *string
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "missing destination",
+			src:  `[{"value": 1}, {"value": 2}]`,
+			want: []int{1, 2},
+		},
+		{
+			name: "empty destination",
+			src:  `[{"value": 1}]`,
+			dst:  ptr.To(""),
+			want: []int{1},
+		},
+		{
+			name: "empty destination array",
+			src:  `[{"value": 1}]`,
+			dst:  ptr.To("[]\n"),
+			want: []int{1},
+		},
+		{
+			name: "destination with documents",
+			src:  "[{\"value\": 3}]\n",
+			dst:  ptr.To("[{\"value\": 1},\n{\"value\": 2}]\n"),
+			want: []int{1, 2, 3},
+		},
+		{
+			name: "indented destination",
+			src:  `[{"value": 2}]`,
+			dst:  ptr.To("[\n  {\n    \"value\": 1\n  }\n]\n"),
+			want: []int{1, 2},
+		},
+		{
+			name: "empty source array",
+			src:  "[ ]",
+			dst:  ptr.To(`[{"value": 1}]`),
+			want: []int{1},
+		},
+		{
+			name:    "source not an array",
+			src:     `{"value": 1}`,
+			dst:     ptr.To(`[{"value": 1}]`),
+			wantErr: true,
+		},
+		{
+			name:    "destination not an array",
+			src:     `[{"value": 1}]`,
+			dst:     ptr.To(`{"value": 1}`),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := path.Join(dir, "src.json"), path.Join(dir, "dst.json")
+			if err := os.WriteFile(src, []byte(tt.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dst != nil {
+				if err := os.WriteFile(dst, []byte(*tt.dst), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := appendDocuments(src, dst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var documents []struct {
+				Value int `json:"value"`
+			}
+			if err := json.Unmarshal(data, &documents); err != nil {
+				t.Fatalf("invalid destination %q: %v", data, err)
+			}
+			var got []int
+			for _, document := range documents {
+				got = append(got, document.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got documents %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// discardIndexer is an indexer dropping the documents
+type discardIndexer struct{}
+
+func (discardIndexer) Index(documents []interface{}, opts indexers.IndexingOpts) (string, error) {
+	return "", nil
+}
+
+func TestJobSummaryRecorder(t *testing.T) {
+	start := time.Date(2024, 6, 10, 6, 0, 0, 0, time.UTC)
+	churnStart, churnEnd := start.Add(time.Hour), start.Add(2*time.Hour)
+	recorder := &jobSummaryRecorder{Indexer: discardIndexer{}}
+	burner.IndexJobSummary([]burner.JobSummary{
+		{Timestamp: start, EndTimestamp: churnEnd, ChurnStartTimestamp: &churnStart, ChurnEndTimestamp: &churnEnd, JobConfig: config.Job{Name: "cluster-density-v2"}},
+		{Timestamp: churnEnd, EndTimestamp: churnEnd.Add(time.Minute), JobConfig: config.Job{Name: "garbage-collection"}},
+	}, recorder)
+	if _, err := recorder.Index([]interface{}{map[string]interface{}{"value": 1}}, indexers.IndexingOpts{MetricName: "podLatencyMeasurement"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []prometheus.Job{
+		{Start: start, End: churnEnd, ChurnStart: &churnStart, ChurnEnd: &churnEnd, JobConfig: config.Job{Name: "cluster-density-v2"}},
+		{Start: churnEnd, End: churnEnd.Add(time.Minute), JobConfig: config.Job{Name: "garbage-collection"}},
+	}
+	if !reflect.DeepEqual(recorder.jobs, want) {
+		t.Errorf("got jobs %v, want %v", recorder.jobs, want)
+	}
+}
+
+func TestSplitMetricsProfiles(t *testing.T) {
+	profile := path.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(profile, []byte(`- query: sum(rate(apiserver_request_total[2m]))
+  metricName: apiRequestRate
+- query: max_over_time(process_resident_memory_bytes[{{ .elapsed }}:])
+  metricName: maxMemory
+  instant: true
+- query: avg_over_time(etcd_disk_wal_fsync_duration_seconds[{{.elapsed}}:])
+  metricName: avgFsync
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rangeMetrics, instantMetrics, err := splitMetricsProfiles([]config.MetricsEndpoint{{Metrics: []string{profile}}}, embed.FS{}, "", 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, profiles := range append(rangeMetrics, instantMetrics...) {
+		for _, profile := range profiles {
+			defer os.Remove(profile)
+		}
+	}
+	for _, tt := range []struct {
+		metrics [][]string
+		want    []string
+	}{
+		{rangeMetrics, []string{"sum(rate(apiserver_request_total[2m]))", "avg_over_time(etcd_disk_wal_fsync_duration_seconds[5400s:])"}},
+		{instantMetrics, []string{"max_over_time(process_resident_memory_bytes[5400s:])"}},
+	} {
+		if len(tt.metrics) != 1 || len(tt.metrics[0]) != 1 {
+			t.Fatalf("got profiles %v, want one profile", tt.metrics)
+		}
+		metricsProfile, err := readMetricsProfile(tt.metrics[0][0], embed.FS{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var queries []string
+		for _, query := range metricsProfile {
+			queries = append(queries, query.Query)
+		}
+		if !reflect.DeepEqual(queries, tt.want) {
+			t.Errorf("got queries %q, want %q", queries, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryIndexer retries the failed indexing requests with an exponential backoff, and writes the documents to the spill
+// directory once the retries are exhausted, so they can be indexed afterwards with index --replay-directory
+type retryIndexer struct {
+	indexer        indexers.Indexer
+	retries        int
+	backoff        time.Duration
+	spillDirectory string
+}
+
+// newRetryIndexer wraps the given indexer with the retries configured by the INDEX_RETRIES and INDEX_RETRY_BACKOFF
+// environment variables. The documents are spilled to the index-spill-<uuid> directory
+func newRetryIndexer(indexer indexers.Indexer) *retryIndexer {
+	retries, _ := strconv.Atoi(os.Getenv("INDEX_RETRIES"))
+	backoff, _ := time.ParseDuration(os.Getenv("INDEX_RETRY_BACKOFF"))
+	return &retryIndexer{
+		indexer:        indexer,
+		retries:        retries,
+		backoff:        backoff,
+		spillDirectory: "index-spill-" + os.Getenv("UUID"),
+	}
+}
+
+// Index implements indexers.Indexer
+func (r *retryIndexer) Index(documents []interface{}, opts indexers.IndexingOpts) (string, error) {
+	backoff := r.backoff
+	resp, err := r.indexer.Index(documents, opts)
+	for attempt := 1; err != nil && attempt <= r.retries; attempt++ {
+		log.Warnf("Error indexing %s, retrying in %v (%d/%d): %v", opts.MetricName, backoff, attempt, r.retries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		resp, err = r.indexer.Index(documents, opts)
+	}
+	if err == nil {
+		return resp, nil
+	}
+	if spillErr := r.spill(documents, opts.MetricName); spillErr != nil {
+		return "", fmt.Errorf("%v, and the documents couldn't be written to %s: %v", err, r.spillDirectory, spillErr)
+	}
+	return "", fmt.Errorf("%v, %d documents written to %s", err, len(documents), path.Join(r.spillDirectory, opts.MetricName+".json"))
+}
+
+// spill appends the documents to the file of the metric in the spill directory
+func (r *retryIndexer) spill(documents []interface{}, metricName string) error {
+	if err := os.MkdirAll(r.spillDirectory, 0744); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp("", "index-spill-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	err = json.NewEncoder(tmpFile).Encode(documents)
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return appendDocuments(tmpFile.Name(), path.Join(r.spillDirectory, metricName+".json"))
+}
+
+// replayDocuments indexes the documents of every metric file of the given directory with the given indexer
+func replayDocuments(directory string, indexer indexers.Indexer) error {
+	files, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	var failed bool
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		var documents []interface{}
+		if err := readDocuments(path.Join(directory, file.Name()), &documents); err != nil {
+			log.Error(err.Error())
+			failed = true
+			continue
+		}
+		metricName := strings.TrimSuffix(file.Name(), ".json")
+		resp, err := indexer.Index(documents, indexers.IndexingOpts{MetricName: metricName})
+		if err != nil {
+			log.Error(err.Error())
+			failed = true
+			continue
+		}
+		log.Info(resp)
+	}
+	if failed {
+		return fmt.Errorf("error replaying the documents of %s", directory)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ipFamilies are the IP families of the services of the network workloads for each --ip-family
+var ipFamilies = map[string][]corev1.IPFamily{
+	"v4":   {corev1.IPv4Protocol},
+	"v6":   {corev1.IPv6Protocol},
+	"dual": {corev1.IPv4Protocol, corev1.IPv6Protocol},
+}
+
+// ipFamilyEnv returns the IP family policy and families of the services for the given --ip-family, as the YAML the templates
+// expect. They're empty when it isn't set, so the services get the default family of the cluster
+func ipFamilyEnv(family string) (string, string) {
+	families, ok := ipFamilies[family]
+	if !ok {
+		return "", ""
+	}
+	policy := corev1.IPFamilyPolicySingleStack
+	if len(families) > 1 {
+		policy = corev1.IPFamilyPolicyRequireDualStack
+	}
+	var names []string
+	for _, f := range families {
+		names = append(names, string(f))
+	}
+	return string(policy), "[" + strings.Join(names, ", ") + "]"
+}
+
+// validateIPFamily checks the cluster and service networks of the cluster have CIDRs of every IP family of the given --ip-family
+func validateIPFamily(family string) error {
+	families, ok := ipFamilies[family]
+	if !ok {
+		return fmt.Errorf("invalid --ip-family %s, supported options are: v4, v6 or dual", family)
+	}
+	_, restConfig := newClientSet()
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	network, err := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    "config.openshift.io",
+		Version:  "v1",
+		Resource: "networks",
+	}).Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting the cluster network configuration: %v", err)
+	}
+	serviceNetwork, _, _ := unstructured.NestedStringSlice(network.Object, "status", "serviceNetwork")
+	clusterNetworks, _, _ := unstructured.NestedSlice(network.Object, "status", "clusterNetwork")
+	var clusterNetwork []string
+	for _, cn := range clusterNetworks {
+		if cidr, ok := cn.(map[string]interface{})["cidr"].(string); ok {
+			clusterNetwork = append(clusterNetwork, cidr)
+		}
+	}
+	for name, cidrs := range map[string][]string{"service": serviceNetwork, "cluster": clusterNetwork} {
+		available := cidrFamilies(cidrs)
+		for _, f := range families {
+			if !available[f] {
+				return fmt.Errorf("--ip-family %s requires an %s %s network, the cluster has %s", family, f, name, strings.Join(cidrs, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// cidrFamilies returns the IP families of the given CIDRs
+func cidrFamilies(cidrs []string) map[corev1.IPFamily]bool {
+	families := make(map[corev1.IPFamily]bool)
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			families[corev1.IPv4Protocol] = true
+		} else {
+			families[corev1.IPv6Protocol] = true
+		}
+	}
+	return families
+}
+
+// podIPs returns the IPs of the given pod to probe with the given --ip-family, one of each family in dual-stack, and its
+// primary IP when it isn't set
+func podIPs(pod *corev1.Pod, family string) []string {
+	families, ok := ipFamilies[family]
+	if !ok {
+		return []string{pod.Status.PodIP}
+	}
+	var ips []string
+	for _, f := range families {
+		for _, podIP := range pod.Status.PodIPs {
+			ip := net.ParseIP(podIP.IP)
+			if ip == nil {
+				continue
+			}
+			if (ip.To4() != nil) == (f == corev1.IPv4Protocol) {
+				ips = append(ips, podIP.IP)
+				break
+			}
+		}
+	}
+	return ips
+}
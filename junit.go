@@ -0,0 +1,124 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+)
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       float64         `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+func (ts *junitTestSuite) addTestCase(tc junitTestCase) {
+	ts.Tests++
+	if tc.Failure != nil {
+		ts.Failures++
+	}
+	ts.TestCases = append(ts.TestCases, tc)
+}
+
+// writeJUnitReport writes a JUnit XML file where every job, SLO and alert evaluation is a test case
+func writeJUnitReport(workload string, wh *workloads.WorkloadHelper, results runResults, sloResults []sloResult, fileName string) error {
+	testSuite := junitTestSuite{
+		Name:      workload,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "uuid", Value: wh.UUID},
+		},
+	}
+	for _, k := range []string{"ocpVersion", "platform", "sdnType", "workerNodesCount"} {
+		if v, ok := wh.SummaryMetadata[k]; ok {
+			testSuite.Properties = append(testSuite.Properties, junitProperty{Name: k, Value: fmt.Sprint(v)})
+		}
+	}
+	for _, jobSummary := range results.jobSummaries {
+		tc := junitTestCase{
+			Name:      jobSummary.JobConfig.Name,
+			ClassName: workload + ".jobs",
+			Time:      jobSummary.ElapsedTime,
+		}
+		if !jobSummary.Passed {
+			tc.Failure = &junitFailure{Message: jobSummary.ExecutionErrors}
+		}
+		testSuite.Time += jobSummary.ElapsedTime
+		testSuite.addTestCase(tc)
+	}
+	for _, result := range sloResults {
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("%s %s", result.Name, result.JobName),
+			ClassName: workload + ".slos",
+			SystemOut: fmt.Sprintf("value %.2f, threshold %.2f", result.Value, result.Threshold),
+		}
+		if !result.Passed {
+			tc.Failure = &junitFailure{Message: fmt.Sprintf("SLO %s not met: %.2f > %.2f", result.Name, result.Value, result.Threshold)}
+		}
+		testSuite.addTestCase(tc)
+	}
+	for _, a := range results.alerts {
+		tc := junitTestCase{
+			Name:      a.Description,
+			ClassName: workload + ".alerts",
+			SystemOut: fmt.Sprintf("%s alert at %s", a.Severity, a.Timestamp.Format(time.RFC3339)),
+		}
+		if a.Severity != "warning" {
+			tc.Failure = &junitFailure{Message: fmt.Sprintf("%s alert: %s", a.Severity, a.Description)}
+		}
+		testSuite.addTestCase(tc)
+	}
+	// Always report the alert evaluation, even when no alert fired
+	if len(results.alerts) == 0 {
+		testSuite.addTestCase(junitTestCase{Name: "no alerts fired", ClassName: workload + ".alerts"})
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{Name: "kube-burner-ocp", TestSuites: []junitTestSuite{testSuite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append([]byte(xml.Header), data...), 0644)
+}
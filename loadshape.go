@@ -0,0 +1,183 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Supported churn patterns
+const (
+	churnPatternSteady     = "steady"
+	churnPatternSpike      = "spike"
+	churnPatternSinusoidal = "sinusoidal"
+)
+
+// jobStep is one of the jobs the workloads supporting load shaping are split into, the numbers are encoded as strings
+// so the templates render them verbatim
+type jobStep struct {
+	// Suffix appended to the job name and namespace, empty for the main job
+	Suffix         string  `json:"suffix"`
+	Iterations     int     `json:"iterations,string"`
+	QPS            float64 `json:"qps,string"`
+	Burst          int     `json:"burst,string"`
+	IterationDelay string  `json:"iterationDelay"`
+	Churn          bool    `json:"churn"`
+	ChurnDuration  string  `json:"churnDuration"`
+	ChurnPercent   int     `json:"churnPercent,string"`
+	// Cleanup deletes the namespaces of the step once it finishes
+	Cleanup bool `json:"cleanup"`
+}
+
+// rampSchedule splits the job iterations into the given number of steps with a QPS and burst increasing linearly from startPercent
+// to 100% of the configured ones. Each step gets a share of the iterations proportional to its rate, and when a duration is given,
+// its iterations are paced so every step lasts the same share of the duration
+func rampSchedule(iterations, qps, burst, steps, startPercent int, duration time.Duration) []jobStep {
+	if steps < 2 {
+		return []jobStep{{Iterations: iterations, QPS: float64(qps), Burst: burst, IterationDelay: "0s"}}
+	}
+	percents := make([]float64, steps)
+	var total float64
+	for i := range percents {
+		percents[i] = float64(startPercent) + float64(100-startPercent)*float64(i)/float64(steps-1)
+		total += percents[i]
+	}
+	var schedule []jobStep
+	remaining := iterations
+	for i, percent := range percents {
+		step := jobStep{
+			Suffix:     fmt.Sprintf("-ramp-%d", i),
+			Iterations: int(float64(iterations) * percent / total),
+			QPS:        float64(scalePercent(qps, percent)),
+			Burst:      scalePercent(burst, percent),
+			// kube-burner sleeps this delay after each iteration
+			IterationDelay: "0s",
+		}
+		// The last step is the main job, and gets the iterations left by the rounding
+		last := i == steps-1
+		if last {
+			step.Suffix = ""
+			step.Iterations = remaining
+		}
+		remaining -= step.Iterations
+		if step.Iterations == 0 && !last {
+			continue
+		}
+		if duration > 0 && step.Iterations > 0 {
+			step.IterationDelay = (duration / time.Duration(steps) / time.Duration(step.Iterations)).String()
+		}
+		schedule = append(schedule, step)
+	}
+	return schedule
+}
+
+// churnPatternPercents returns the percentage of the QPS and burst of each churn phase, the spike pattern churns at the
+// minimum rate but in the middle phase, and the sinusoidal one goes from the minimum rate to the full one and back
+func churnPatternPercents(pattern string, phases, minPercent int) []float64 {
+	percents := make([]float64, phases)
+	for i := range percents {
+		switch pattern {
+		case churnPatternSpike:
+			percents[i] = float64(minPercent)
+			if i == phases/2 {
+				percents[i] = 100
+			}
+		case churnPatternSinusoidal:
+			// Sampled in the middle of each phase
+			percents[i] = float64(minPercent) + float64(100-minPercent)*(1-math.Cos(2*math.Pi*(float64(i)+0.5)/float64(phases)))/2
+		default:
+			percents[i] = 100
+		}
+	}
+	return percents
+}
+
+// churnPhases returns a job per phase of the churn pattern, each one creating the churned percentage of the job iterations
+// and churning all of them during its share of the churn duration with its own QPS and burst, and removing them once done
+func churnPhases(iterations, qps, burst, churnPercent int, churnDuration time.Duration, percents []float64) []jobStep {
+	var phases []jobStep
+	for i, percent := range percents {
+		phases = append(phases, jobStep{
+			Suffix:         fmt.Sprintf("-churn-%d", i),
+			Iterations:     max(iterations*churnPercent/100, 1),
+			QPS:            float64(scalePercent(qps, percent)),
+			Burst:          scalePercent(burst, percent),
+			IterationDelay: "0s",
+			Churn:          true,
+			ChurnDuration:  (churnDuration / time.Duration(len(percents))).String(),
+			ChurnPercent:   100,
+			Cleanup:        true,
+		})
+	}
+	return phases
+}
+
+func scalePercent(value int, percent float64) int {
+	return max(int(math.Round(float64(value)*percent/100)), 1)
+}
+
+// setJobStepsEnv sets the JOB_STEPS environment variable consumed by the workloads supporting load shaping with their ramp-up
+// steps followed by their churn phases, from the JOB_ITERATIONS, QPS, BURST and churn environment variables. Without a ramp-up
+// schedule nor a churn pattern, there's a single step churning as configured by the churn flags. With a pacing window, the
+// main job of the given workload is paced instead of ramped up
+func setJobStepsEnv(cmd *cobra.Command, workload string) error {
+	rampSteps, _ := cmd.Root().PersistentFlags().GetInt("ramp-steps")
+	rampStartPercent, _ := cmd.Root().PersistentFlags().GetInt("ramp-start-percent")
+	rampDuration, _ := cmd.Root().PersistentFlags().GetDuration("ramp-duration")
+	pacingWindow, _ := cmd.Root().PersistentFlags().GetDuration("pacing-window")
+	if pacingWindow > 0 && rampSteps > 1 {
+		return fmt.Errorf("--pacing-window and --ramp-steps can't be used together")
+	}
+	iterations, _ := strconv.Atoi(os.Getenv("JOB_ITERATIONS"))
+	qps, _ := strconv.Atoi(os.Getenv("QPS"))
+	burst, _ := strconv.Atoi(os.Getenv("BURST"))
+	churn, _ := strconv.ParseBool(os.Getenv("CHURN"))
+	churnPercent, _ := strconv.Atoi(os.Getenv("CHURN_PERCENT"))
+	churnDuration, _ := time.ParseDuration(os.Getenv("CHURN_DURATION"))
+	churnPattern := os.Getenv("CHURN_PATTERN")
+	steps := rampSchedule(iterations, qps, burst, rampSteps, rampStartPercent, rampDuration)
+	if churn && churnPattern != "" && churnPattern != churnPatternSteady {
+		phases, _ := strconv.Atoi(os.Getenv("CHURN_PATTERN_PHASES"))
+		minPercent, _ := strconv.Atoi(os.Getenv("CHURN_PATTERN_MIN_PERCENT"))
+		steps = append(steps, churnPhases(iterations, qps, burst, churnPercent, churnDuration, churnPatternPercents(churnPattern, phases, minPercent))...)
+	} else {
+		// The main job churns
+		main := &steps[len(steps)-1]
+		main.Churn = churn
+		main.ChurnDuration = churnDuration.String()
+		main.ChurnPercent = churnPercent
+	}
+	if pacingWindow > 0 {
+		if err := paceSteps(workload, steps, pacingWindow); err != nil {
+			return fmt.Errorf("error pacing %s: %v", workload, err)
+		}
+	}
+	if len(steps) > 1 {
+		for _, step := range steps {
+			log.Infof("Job step%s: %d iterations, QPS %v, burst %d, iteration delay %s, churn %v", step.Suffix, step.Iterations, step.QPS, step.Burst, step.IterationDelay, step.Churn)
+		}
+	}
+	jobSteps, _ := json.Marshal(steps)
+	os.Setenv("JOB_STEPS", string(jobSteps))
+	return nil
+}
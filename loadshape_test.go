@@ -0,0 +1,225 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRampSchedule(t *testing.T) {
+	tests := []struct {
+		name         string
+		iterations   int
+		qps          int
+		burst        int
+		steps        int
+		startPercent int
+		duration     time.Duration
+		want         []jobStep
+	}{
+		{
+			name:         "no ramp-up",
+			iterations:   100,
+			qps:          20,
+			burst:        40,
+			steps:        1,
+			startPercent: 25,
+			want:         []jobStep{{Iterations: 100, QPS: 20, Burst: 40, IterationDelay: "0s"}},
+		},
+		{
+			name:         "linear ramp-up",
+			iterations:   100,
+			qps:          20,
+			burst:        40,
+			steps:        4,
+			startPercent: 25,
+			want: []jobStep{
+				{Suffix: "-ramp-0", Iterations: 10, QPS: 5, Burst: 10, IterationDelay: "0s"},
+				{Suffix: "-ramp-1", Iterations: 20, QPS: 10, Burst: 20, IterationDelay: "0s"},
+				{Suffix: "-ramp-2", Iterations: 30, QPS: 15, Burst: 30, IterationDelay: "0s"},
+				{Iterations: 40, QPS: 20, Burst: 40, IterationDelay: "0s"},
+			},
+		},
+		{
+			name:         "paced ramp-up",
+			iterations:   100,
+			qps:          20,
+			burst:        40,
+			steps:        4,
+			startPercent: 25,
+			duration:     40 * time.Minute,
+			want: []jobStep{
+				{Suffix: "-ramp-0", Iterations: 10, QPS: 5, Burst: 10, IterationDelay: "1m0s"},
+				{Suffix: "-ramp-1", Iterations: 20, QPS: 10, Burst: 20, IterationDelay: "30s"},
+				{Suffix: "-ramp-2", Iterations: 30, QPS: 15, Burst: 30, IterationDelay: "20s"},
+				{Iterations: 40, QPS: 20, Burst: 40, IterationDelay: "15s"},
+			},
+		},
+		{
+			name:         "rounding left to the main job",
+			iterations:   10,
+			qps:          10,
+			burst:        10,
+			steps:        3,
+			startPercent: 50,
+			want: []jobStep{
+				{Suffix: "-ramp-0", Iterations: 2, QPS: 5, Burst: 5, IterationDelay: "0s"},
+				{Suffix: "-ramp-1", Iterations: 3, QPS: 8, Burst: 8, IterationDelay: "0s"},
+				{Iterations: 5, QPS: 10, Burst: 10, IterationDelay: "0s"},
+			},
+		},
+		{
+			name:         "steps without iterations",
+			iterations:   2,
+			qps:          10,
+			burst:        10,
+			steps:        4,
+			startPercent: 10,
+			want:         []jobStep{{Iterations: 2, QPS: 10, Burst: 10, IterationDelay: "0s"}},
+		},
+		{
+			name:         "minimum rate",
+			iterations:   100,
+			qps:          2,
+			burst:        2,
+			steps:        2,
+			startPercent: 10,
+			want: []jobStep{
+				{Suffix: "-ramp-0", Iterations: 9, QPS: 1, Burst: 1, IterationDelay: "0s"},
+				{Iterations: 91, QPS: 2, Burst: 2, IterationDelay: "0s"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rampSchedule(tt.iterations, tt.qps, tt.burst, tt.steps, tt.startPercent, tt.duration)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got schedule %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChurnPatternPercents(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		phases     int
+		minPercent int
+		want       []float64
+	}{
+		{
+			pattern:    churnPatternSteady,
+			phases:     3,
+			minPercent: 20,
+			want:       []float64{100, 100, 100},
+		},
+		{
+			pattern:    churnPatternSpike,
+			phases:     5,
+			minPercent: 20,
+			want:       []float64{20, 20, 100, 20, 20},
+		},
+		{
+			pattern:    churnPatternSpike,
+			phases:     1,
+			minPercent: 20,
+			want:       []float64{100},
+		},
+		{
+			pattern:    churnPatternSinusoidal,
+			phases:     4,
+			minPercent: 0,
+			want:       []float64{14.645, 85.355, 85.355, 14.645},
+		},
+		{
+			pattern:    churnPatternSinusoidal,
+			phases:     4,
+			minPercent: 20,
+			want:       []float64{31.716, 88.284, 88.284, 31.716},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := churnPatternPercents(tt.pattern, tt.phases, tt.minPercent)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got percents %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 0.001 {
+					t.Fatalf("got percents %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestChurnPhases(t *testing.T) {
+	tests := []struct {
+		name          string
+		iterations    int
+		qps           int
+		burst         int
+		churnPercent  int
+		churnDuration time.Duration
+		percents      []float64
+		want          []jobStep
+	}{
+		{
+			name:          "phases",
+			iterations:    100,
+			qps:           20,
+			burst:         40,
+			churnPercent:  10,
+			churnDuration: 30 * time.Minute,
+			percents:      []float64{100, 50, 20},
+			want: []jobStep{
+				{Suffix: "-churn-0", Iterations: 10, QPS: 20, Burst: 40, IterationDelay: "0s", Churn: true, ChurnDuration: "10m0s", ChurnPercent: 100, Cleanup: true},
+				{Suffix: "-churn-1", Iterations: 10, QPS: 10, Burst: 20, IterationDelay: "0s", Churn: true, ChurnDuration: "10m0s", ChurnPercent: 100, Cleanup: true},
+				{Suffix: "-churn-2", Iterations: 10, QPS: 4, Burst: 8, IterationDelay: "0s", Churn: true, ChurnDuration: "10m0s", ChurnPercent: 100, Cleanup: true},
+			},
+		},
+		{
+			name:          "minimum iterations and rate",
+			iterations:    5,
+			qps:           2,
+			burst:         2,
+			churnPercent:  10,
+			churnDuration: time.Minute,
+			percents:      []float64{20},
+			want: []jobStep{
+				{Suffix: "-churn-0", Iterations: 1, QPS: 1, Burst: 1, IterationDelay: "0s", Churn: true, ChurnDuration: "1m0s", ChurnPercent: 100, Cleanup: true},
+			},
+		},
+		{
+			name:          "no phases",
+			iterations:    100,
+			qps:           20,
+			burst:         40,
+			churnPercent:  10,
+			churnDuration: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := churnPhases(tt.iterations, tt.qps, tt.burst, tt.churnPercent, tt.churnDuration, tt.percents)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got phases %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
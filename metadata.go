@@ -0,0 +1,167 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/openshift/client-go/config/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GatherCustomMetadata extracts user metadata from cluster objects:
+// labels and annotations from the Infrastructure cluster object starting with the given prefix
+// and the data from the given ConfigMap, expressed as namespace/name
+func GatherCustomMetadata(wh *workloads.WorkloadHelper, prefix, configMap string) error {
+	if prefix == "" && configMap == "" {
+		return nil
+	}
+	customMetadata := make(map[string]interface{})
+	clientSet, restConfig := newClientSet()
+	if prefix != "" {
+		openshiftClientset, err := versioned.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("error creating OpenShift clientset: %v", err)
+		}
+		infra, err := openshiftClientset.ConfigV1().Infrastructures().Get(context.TODO(), "cluster", metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting infrastructure/cluster: %v", err)
+		}
+		for _, kv := range []map[string]string{infra.Labels, infra.Annotations} {
+			for k, v := range kv {
+				if strings.HasPrefix(k, prefix) {
+					customMetadata[strings.TrimPrefix(k, prefix)] = v
+				}
+			}
+		}
+	}
+	if configMap != "" {
+		namespace, name, found := strings.Cut(configMap, "/")
+		if !found {
+			return fmt.Errorf("invalid metadata ConfigMap %s, expected format is namespace/name", configMap)
+		}
+		cm, err := clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting configmap/%s in namespace %s: %v", name, namespace, err)
+		}
+		for k, v := range cm.Data {
+			customMetadata[k] = v
+		}
+	}
+	log.Infof("Gathered %d metadata fields from cluster objects", len(customMetadata))
+	for k, v := range customMetadata {
+		// The metadata gathered by kube-burner-ocp, like platform or ocpVersion, isn't overridden
+		_, inSummary := wh.SummaryMetadata[k]
+		_, inMetrics := wh.MetricsMetadata[k]
+		if inSummary || inMetrics {
+			log.Warnf("Skipping metadata field %s from cluster objects, it's already set", k)
+			continue
+		}
+		wh.SummaryMetadata[k] = v
+		wh.MetricsMetadata[k] = v
+	}
+	return nil
+}
+
+// CompareMetadata compares the gathered metadata against the reference stored in the given JSON file.
+// Differences are logged and added to the jobSummary metadata as metadataDrift.
+// When the reference file doesn't exist, it's created from the gathered metadata
+func CompareMetadata(wh *workloads.WorkloadHelper, referenceFile string) error {
+	if referenceFile == "" {
+		return nil
+	}
+	reference := make(map[string]interface{})
+	data, err := os.ReadFile(referenceFile)
+	if os.IsNotExist(err) {
+		log.Infof("Metadata reference %s not found, creating it from the current cluster", referenceFile)
+		data, err = json.MarshalIndent(wh.SummaryMetadata, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(referenceFile, data, 0644)
+	} else if err != nil {
+		return fmt.Errorf("error reading metadata reference: %v", err)
+	}
+	if err := json.Unmarshal(data, &reference); err != nil {
+		return fmt.Errorf("error decoding metadata reference %s: %v", referenceFile, err)
+	}
+	drift := make(map[string]interface{})
+	for k, refValue := range reference {
+		value, exists := wh.SummaryMetadata[k]
+		if !exists || fmt.Sprint(value) != fmt.Sprint(refValue) {
+			log.Warnf("Metadata %s differs from the reference: %v, found: %v", k, refValue, value)
+			drift[k] = map[string]interface{}{
+				"reference": refValue,
+				"current":   value,
+			}
+		}
+	}
+	if len(drift) > 0 {
+		wh.SummaryMetadata["metadataDrift"] = drift
+	} else {
+		log.Infof("Metadata matches the reference %s", referenceFile)
+	}
+	return nil
+}
+
+// gatherNetworkMetadata records OVN-Kubernetes, OVN and OVS versions, and whether OVS hardware offload is enabled
+func gatherNetworkMetadata(wh *workloads.WorkloadHelper) error {
+	if clusterMetadata.SDNType != "OVNKubernetes" {
+		return nil
+	}
+	clientSet, restConfig := newClientSet()
+	pod, err := getRunningPod(clientSet, "openshift-ovn-kubernetes", "app=ovnkube-node")
+	if err != nil {
+		return err
+	}
+	ovnkVersion, err := execInPod(clientSet, restConfig, pod, "ovnkube-controller", "ovnkube", "--version")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(ovnkVersion, "\n") {
+		if version, found := strings.CutPrefix(line, "Version:"); found {
+			wh.SummaryMetadata["ovnkVersion"] = strings.TrimSpace(version)
+		}
+		if commit, found := strings.CutPrefix(line, "Git commit:"); found {
+			wh.SummaryMetadata["ovnkCommit"] = strings.TrimSpace(commit)
+		}
+	}
+	ovnVersion, err := execInPod(clientSet, restConfig, pod, "ovn-controller", "ovn-controller", "--version")
+	if err != nil {
+		return err
+	}
+	// ovn-controller 24.03.2
+	if fields := strings.Fields(strings.Split(ovnVersion, "\n")[0]); len(fields) > 1 {
+		wh.SummaryMetadata["ovnVersion"] = fields[len(fields)-1]
+	}
+	ovsVersion, err := execInPod(clientSet, restConfig, pod, "ovn-controller", "ovs-vsctl", "get", "Open_vSwitch", ".", "ovs_version")
+	if err != nil {
+		return err
+	}
+	wh.SummaryMetadata["ovsVersion"] = strings.Trim(ovsVersion, `"`)
+	// The command fails when hw-offload is not set in other_config
+	hwOffload, _ := execInPod(clientSet, restConfig, pod, "ovn-controller", "ovs-vsctl", "get", "Open_vSwitch", ".", "other_config:hw-offload")
+	wh.SummaryMetadata["ovsHardwareOffload"] = strings.Trim(hwOffload, `"`) == "true"
+	if ovnkVersion, ok := wh.SummaryMetadata["ovnkVersion"]; ok {
+		wh.MetricsMetadata["ovnkVersion"] = ovnkVersion
+	}
+	return nil
+}
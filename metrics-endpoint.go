@@ -0,0 +1,290 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// metricsEndpointSettings are the settings of an endpoint of the --metrics-endpoint file kube-burner doesn't support
+type metricsEndpointSettings struct {
+	// File with the bearer token, like the one of a mounted service account, instead of token
+	TokenFile string `yaml:"tokenFile"`
+	// CA bundle verifying the certificate of the endpoint, enabling its verification
+	CAFile string `yaml:"caFile"`
+	// Client certificate and key authenticating to the endpoint
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// Name verified in the certificate of the endpoint, its host by default
+	ServerName string `yaml:"serverName"`
+	// Labels added to every series scraped from the endpoint, unless the series already has them
+	Labels map[string]string `yaml:"labels"`
+}
+
+// metricsEndpointSettingsFields are the fields of the settings, removed from the endpoints decoded by kube-burner
+var metricsEndpointSettingsFields = []string{"tokenFile", "caFile", "certFile", "keyFile", "serverName", "labels"}
+
+// metricsEndpointTargets are the endpoints of the --metrics-endpoint file behind the local proxies, by proxy URL
+var metricsEndpointTargets = make(map[string]string)
+
+// metricsEndpointCleanup stops the local proxies of the --metrics-endpoint file and removes the file given to kube-burner
+var metricsEndpointCleanup []func()
+
+// StopMetricsEndpointProxies stops the local proxies started for the --metrics-endpoint file, at the end of the run
+func StopMetricsEndpointProxies() {
+	for _, cleanup := range metricsEndpointCleanup {
+		cleanup()
+	}
+	metricsEndpointCleanup = nil
+	clear(metricsEndpointTargets)
+}
+
+// resolveMetricsEndpoints reads the given --metrics-endpoint file and returns the one given to kube-burner. The token files are
+// read into the tokens, and the endpoints with TLS settings or labels are proxied through a local listener applying them, as the
+// kube-burner Prometheus client only supports skipping the TLS verification. The file is returned as it is when it doesn't use them
+func resolveMetricsEndpoints(metricsEndpointFile string) (string, error) {
+	f, err := util.GetReader(metricsEndpointFile, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("error reading metrics endpoint file %s: %v", metricsEndpointFile, err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("error reading metrics endpoint file %s: %v", metricsEndpointFile, err)
+	}
+	// Rendered with the environment like kube-burner does
+	rendered, err := util.RenderTemplate(data, util.EnvToMap(), util.MissingKeyError)
+	if err != nil {
+		return "", fmt.Errorf("template error in %s: %v", metricsEndpointFile, err)
+	}
+	// The settings are removed from the endpoints before decoding them, as kube-burner rejects the unknown fields
+	var doc yaml.Node
+	if err := yaml.Unmarshal(rendered, &doc); err != nil {
+		return "", fmt.Errorf("error decoding metrics endpoint file %s: %v", metricsEndpointFile, err)
+	}
+	var settings []metricsEndpointSettings
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.SequenceNode {
+		for _, item := range doc.Content[0].Content {
+			var endpointSettings metricsEndpointSettings
+			if err := item.Decode(&endpointSettings); err != nil {
+				return "", fmt.Errorf("error decoding metrics endpoint file %s: %v", metricsEndpointFile, err)
+			}
+			settings = append(settings, endpointSettings)
+			var content []*yaml.Node
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				if !slices.Contains(metricsEndpointSettingsFields, item.Content[i].Value) {
+					content = append(content, item.Content[i], item.Content[i+1])
+				}
+			}
+			item.Content = content
+		}
+	}
+	stripped, err := yaml.Marshal(&doc)
+	if err != nil {
+		return "", err
+	}
+	var metricsEndpoints []config.MetricsEndpoint
+	yamlDec := yaml.NewDecoder(bytes.NewReader(stripped))
+	yamlDec.KnownFields(true)
+	if err := yamlDec.Decode(&metricsEndpoints); err != nil {
+		return "", fmt.Errorf("error decoding metrics endpoint file %s: %v", metricsEndpointFile, err)
+	}
+	var extended bool
+	for i, endpoint := range metricsEndpoints {
+		endpointSettings := settings[i]
+		if endpointSettings.TokenFile != "" {
+			if endpoint.Token != "" {
+				return "", fmt.Errorf("metrics endpoint %s can't have both token and tokenFile", endpoint.Endpoint)
+			}
+			token, err := os.ReadFile(endpointSettings.TokenFile)
+			if err != nil {
+				return "", fmt.Errorf("error reading the token of metrics endpoint %s: %v", endpoint.Endpoint, err)
+			}
+			metricsEndpoints[i].Token = strings.TrimSpace(string(token))
+			extended = true
+		}
+		if endpoint.Endpoint != "" && (endpointSettings.CAFile != "" || endpointSettings.CertFile != "" || endpointSettings.KeyFile != "" || endpointSettings.ServerName != "" || len(endpointSettings.Labels) > 0) {
+			proxyURL, proxyToken, stop, err := startEndpointProxy(metricsEndpoints[i], endpointSettings)
+			if err != nil {
+				return "", fmt.Errorf("error proxying metrics endpoint %s: %v", endpoint.Endpoint, err)
+			}
+			metricsEndpointCleanup = append(metricsEndpointCleanup, stop)
+			log.Infof("Scraping metrics endpoint %s through %s", endpoint.Endpoint, proxyURL)
+			metricsEndpointTargets[proxyURL] = endpoint.Endpoint
+			// The proxy authenticates to the endpoint with its credentials
+			metricsEndpoints[i].Endpoint, metricsEndpoints[i].Token = proxyURL, proxyToken
+			metricsEndpoints[i].Username, metricsEndpoints[i].Password = "", ""
+			extended = true
+		}
+	}
+	if !extended {
+		return metricsEndpointFile, nil
+	}
+	// The tokens are written to the file, so it's only readable by the user
+	out, err := os.CreateTemp("", "metrics-endpoint-*.yml")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	metricsEndpointCleanup = append(metricsEndpointCleanup, func() { os.Remove(out.Name()) })
+	if err := yaml.NewEncoder(out).Encode(metricsEndpoints); err != nil {
+		return "", fmt.Errorf("error writing metrics endpoint file: %v", err)
+	}
+	return out.Name(), nil
+}
+
+// startEndpointProxy starts a local reverse proxy to the given endpoint, connecting to it with its credentials and TLS settings
+// and adding its labels to the series returned by the queries. The certificate of the endpoint is verified when a CA bundle is
+// given, as kube-burner skips the verification by default. Returns the URL of the proxy, the token it requires, as other
+// users of the host can reach it too, and the function stopping it
+func startEndpointProxy(endpoint config.MetricsEndpoint, settings metricsEndpointSettings) (string, string, func(), error) {
+	target, err := url.Parse(endpoint.Endpoint)
+	if err != nil || target.Host == "" {
+		// Like the endpoints given without scheme
+		if target, err = url.Parse("https://" + endpoint.Endpoint); err != nil {
+			return "", "", nil, err
+		}
+	}
+	for name := range settings.Labels {
+		if !model.LabelName(name).IsValid() {
+			return "", "", nil, fmt.Errorf("invalid label name %s", name)
+		}
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: endpoint.SkipTLSVerify && settings.CAFile == "", ServerName: settings.ServerName}
+	if settings.CAFile != "" {
+		ca, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return "", "", nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return "", "", nil, fmt.Errorf("no certificates found in %s", settings.CAFile)
+		}
+	}
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("error loading the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", nil, err
+	}
+	proxyToken := hex.EncodeToString(secret)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.Header.Del("Authorization")
+			if endpoint.Username != "" {
+				r.Out.SetBasicAuth(endpoint.Username, endpoint.Password)
+			}
+			if endpoint.Token != "" {
+				r.Out.Header.Set("Authorization", "Bearer "+endpoint.Token)
+			}
+			// The responses are decoded to add the labels, the transport decompresses them when it requests the compression
+			r.Out.Header.Del("Accept-Encoding")
+		},
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		ModifyResponse: func(resp *http.Response) error {
+			return addSeriesLabels(resp, settings.Labels)
+		},
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+proxyToken)) != 1 {
+				http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+				return
+			}
+			proxy.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", nil, err
+	}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Errorf("Metrics endpoint %s proxy stopped: %v", endpoint.Endpoint, err)
+		}
+	}()
+	return "http://" + listener.Addr().String(), proxyToken, func() { server.Close() }, nil
+}
+
+// addSeriesLabels adds the given labels to the series of the vector and matrix results of the Prometheus queries in the given
+// response, keeping the values of the labels the series already have
+func addSeriesLabels(resp *http.Response, labels map[string]string) error {
+	if len(labels) == 0 || resp.StatusCode != http.StatusOK || !(strings.HasSuffix(resp.Request.URL.Path, "/api/v1/query") || strings.HasSuffix(resp.Request.URL.Path, "/api/v1/query_range")) {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var response map[string]json.RawMessage
+	var data map[string]json.RawMessage
+	var resultType string
+	var result []map[string]json.RawMessage
+	if json.Unmarshal(body, &response) != nil || json.Unmarshal(response["data"], &data) != nil || json.Unmarshal(data["resultType"], &resultType) != nil ||
+		(resultType != model.ValVector.String() && resultType != model.ValMatrix.String()) || json.Unmarshal(data["result"], &result) != nil {
+		// Errors and scalar results are returned as they are
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+	for _, series := range result {
+		metric := make(map[string]string)
+		json.Unmarshal(series["metric"], &metric)
+		for name, value := range labels {
+			if _, ok := metric[name]; !ok {
+				metric[name] = value
+			}
+		}
+		series["metric"], _ = json.Marshal(metric)
+	}
+	data["result"], _ = json.Marshal(result)
+	response["data"], _ = json.Marshal(data)
+	if body, err = json.Marshal(response); err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
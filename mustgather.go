@@ -0,0 +1,68 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+const mustGatherTimeout = 30 * time.Minute
+
+// collectMustGather runs oc adm must-gather, or oc adm inspect of the given namespaces, storing it in the must-gather
+// directory of the local metrics directory, or in must-gather-<uuid> when local indexing is disabled
+func collectMustGather(wh *workloads.WorkloadHelper, namespaces []string) error {
+	defer StartSpan("must-gather")()
+	oc, err := exec.LookPath("oc")
+	if err != nil {
+		return fmt.Errorf("oc is required to collect a must-gather: %v", err)
+	}
+	destDir := "must-gather-" + wh.UUID
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		destDir = path.Join(metricsDirectory, "must-gather")
+	}
+	if err := os.MkdirAll(destDir, 0744); err != nil {
+		return err
+	}
+	args := []string{"adm", "must-gather", "--dest-dir=" + destDir}
+	if len(namespaces) > 0 {
+		args = []string{"adm", "inspect", "--dest-dir=" + destDir}
+		for _, namespace := range namespaces {
+			args = append(args, "ns/"+namespace)
+		}
+	}
+	logFile, err := os.Create(path.Join(destDir, "must-gather.log"))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), mustGatherTimeout)
+	defer cancel()
+	mustGather := exec.CommandContext(ctx, oc, args...)
+	mustGather.Stdout, mustGather.Stderr = logFile, logFile
+	log.Infof("Run failed, collecting must-gather in %s", destDir)
+	if err := mustGather.Run(); err != nil {
+		return fmt.Errorf("error collecting must-gather, see %s: %v", logFile.Name(), err)
+	}
+	log.Infof("must-gather collected in %s", destDir)
+	return nil
+}
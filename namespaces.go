@@ -0,0 +1,71 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// namespacePrefixWorkloads are the workloads whose namespaces get the --namespace-prefix and can be reused
+var namespacePrefixWorkloads = []string{"cluster-density-v2", "cluster-density-ms", "node-density", "node-density-cni", "node-density-heavy", "udn-density-pods"}
+
+// namespacePrefixEnv returns the prefix prepended to the namespaces of the workloads, empty when --namespace-prefix isn't set
+func namespacePrefixEnv(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if errs := validation.IsDNS1123Label(prefix); len(errs) > 0 {
+		return "", fmt.Errorf("invalid --namespace-prefix %s: %s", prefix, strings.Join(errs, ", "))
+	}
+	return prefix + "-", nil
+}
+
+// reuseNamespaces labels the namespaces of the given workload left by previous runs with --reuse-namespaces with the UUID of this
+// run, so they're treated as created by it. The objects are created in them instead of in new namespaces, since kube-burner
+// only creates the namespaces that don't exist
+func reuseNamespaces(workload, uuid string) error {
+	clientSet, _ := newClientSet()
+	namespaces, err := clientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{LabelSelector: "kube-burner-uuid"})
+	if err != nil {
+		return fmt.Errorf("error listing namespaces: %v", err)
+	}
+	// The namespaces are named after the workload, with the suffixes of the ramp-up or churn step and the iteration
+	nameRegex := regexp.MustCompile("^" + regexp.QuoteMeta(os.Getenv("NAMESPACE_PREFIX")+workload) + `(-(ramp|churn)-\d+)?(-\d+)?$`)
+	var reused int
+	for _, ns := range namespaces.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating || !nameRegex.MatchString(ns.Name) || ns.Labels["kube-burner-uuid"] == uuid {
+			continue
+		}
+		patch := fmt.Sprintf(`{"metadata":{"labels":{"kube-burner-uuid":%q}}}`, uuid)
+		if _, err := clientSet.CoreV1().Namespaces().Patch(context.TODO(), ns.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+			log.Errorf("Error labeling namespace %s: %v", ns.Name, err)
+			continue
+		}
+		log.Debugf("Reusing namespace %s", ns.Name)
+		reused++
+	}
+	log.Infof("Reusing %d namespaces of previous %s runs", reused, workload)
+	return nil
+}
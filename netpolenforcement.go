@@ -0,0 +1,740 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	netpolEnforcementMetric          = "netpolEnforcementMeasurement"
+	netpolEnforcementQuantilesMetric = "netpolEnforcementQuantilesMeasurement"
+	// Resolution of the enforcement latencies, along with the duration of the probes
+	netpolProbeInterval = 2 * time.Second
+	// Container of the network-policy workload pods the probes are run from
+	netpolProbeContainer = "curlapp"
+	// Network policies probed at once
+	netpolProbeWorkers = 20
+)
+
+// netpolEnforcement holds the enforcement of a network policy, with the latency in milliseconds since it was created until
+// a connection it allows succeeded and a connection with the same pod it doesn't allow was dropped in the same probe
+type netpolEnforcement struct {
+	Timestamp          time.Time   `json:"timestamp"`
+	UUID               string      `json:"uuid"`
+	Namespace          string      `json:"namespace"`
+	Name               string      `json:"networkPolicy"`
+	AllowedConnection  string      `json:"allowedConnection,omitempty"`
+	DeniedConnection   string      `json:"deniedConnection,omitempty"`
+	AllowedResult      string      `json:"allowedResult,omitempty"`
+	DeniedResult       string      `json:"deniedResult,omitempty"`
+	Enforced           bool        `json:"enforced"`
+	EnforcementLatency int         `json:"enforcementLatency"`
+	Probes             int         `json:"probes"`
+	Error              string      `json:"error,omitempty"`
+	MetricName         string      `json:"metricName"`
+	JobName            string      `json:"jobName,omitempty"`
+	Metadata           interface{} `json:"metadata,omitempty"`
+}
+
+// netpolConnection holds a TCP connection from a pod to a port of another one
+type netpolConnection struct {
+	src, dst *corev1.Pod
+	port     int32
+}
+
+func (c *netpolConnection) String() string {
+	return fmt.Sprintf("%s/%s -> %s/%s:%d", c.src.Namespace, c.src.Name, c.dst.Namespace, c.dst.Name, c.port)
+}
+
+func (c *netpolConnection) address() string {
+	return net.JoinHostPort(c.dst.Status.PodIP, fmt.Sprint(c.port))
+}
+
+// netpolProbes holds the connections of a network policy, picked once and picked again only when they no longer hold, its
+// last probes, and when it was enforced
+type netpolProbes struct {
+	np                          *parsedNetpol
+	allowed, denied             *netpolConnection
+	allowedResult, deniedResult string
+	err                         string
+	// generation of the pods and network policies when no connections were found, so they're only searched again once they change
+	searched int
+	probes   int
+	enforced time.Time
+}
+
+type netpolEnforcementWatcher struct {
+	clientSet      kubernetes.Interface
+	restConfig     *rest.Config
+	stopCh         chan struct{}
+	wg             sync.WaitGroup
+	podStore       cache.Store
+	namespaceStore cache.Store
+	// parsed holds every network policy of the run by namespace, with its selectors parsed
+	parsed map[string]map[string]*parsedNetpol
+	// policies holds the network policies with rules, the ones without rules, like deny-all, allow nothing to probe
+	policies map[string]*netpolProbes
+	// generation changes along with the running pods and the network policies
+	generation int
+	mu         sync.Mutex
+}
+
+// startNetpolEnforcementWatcher watches the pods, namespaces and network policies created by the run with the given UUID, and
+// probes a connection allowed and a connection denied by each network policy to record when it was enforced in the dataplane
+func startNetpolEnforcementWatcher(uuid string) *netpolEnforcementWatcher {
+	clientSet, restConfig := newClientSet()
+	nw := &netpolEnforcementWatcher{
+		clientSet:  clientSet,
+		restConfig: restConfig,
+		stopCh:     make(chan struct{}),
+		parsed:     make(map[string]map[string]*parsedNetpol),
+		policies:   make(map[string]*netpolProbes),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	informer := func(client cache.Getter, resource string, objectType runtime.Object, handler cache.ResourceEventHandler) cache.Store {
+		store, controller := cache.NewInformerWithOptions(cache.InformerOptions{
+			ListerWatcher: cache.NewFilteredListWatchFromClient(client, resource, metav1.NamespaceAll, labelSelector),
+			ObjectType:    objectType,
+			Handler:       handler,
+		})
+		go controller.Run(nw.stopCh)
+		return store
+	}
+	nw.podStore = informer(clientSet.CoreV1().RESTClient(), "pods", &corev1.Pod{}, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nw.handlePod(nil, obj)
+		},
+		UpdateFunc: nw.handlePod,
+		DeleteFunc: func(obj interface{}) {
+			nw.handlePod(obj, nil)
+		},
+	})
+	nw.namespaceStore = informer(clientSet.CoreV1().RESTClient(), "namespaces", &corev1.Namespace{}, cache.ResourceEventHandlerFuncs{})
+	informer(clientSet.NetworkingV1().RESTClient(), "networkpolicies", &networkingv1.NetworkPolicy{}, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nw.handleNetworkPolicy(obj.(*networkingv1.NetworkPolicy), false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nw.handleNetworkPolicy(newObj.(*networkingv1.NetworkPolicy), false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if np, ok := obj.(*networkingv1.NetworkPolicy); ok {
+				nw.handleNetworkPolicy(np, true)
+			}
+		},
+	})
+	log.Infof("Probing the enforcement of the network policies every %v", netpolProbeInterval)
+	nw.wg.Add(1)
+	go nw.run()
+	return nw
+}
+
+// handlePod changes the generation when a pod starts or stops running, as the connections not found may be found with it
+func (nw *netpolEnforcementWatcher) handlePod(oldObj, newObj interface{}) {
+	running := func(obj interface{}) string {
+		if pod, ok := obj.(*corev1.Pod); ok && probeablePod(pod) {
+			return pod.Status.PodIP
+		}
+		return ""
+	}
+	if running(oldObj) == running(newObj) {
+		return
+	}
+	nw.mu.Lock()
+	nw.generation++
+	nw.mu.Unlock()
+}
+
+func (nw *netpolEnforcementWatcher) handleNetworkPolicy(np *networkingv1.NetworkPolicy, deleted bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.generation++
+	if deleted {
+		delete(nw.parsed[np.Namespace], np.Name)
+		return
+	}
+	parsed := parseNetpol(np)
+	if nw.parsed[np.Namespace] == nil {
+		nw.parsed[np.Namespace] = make(map[string]*parsedNetpol)
+	}
+	nw.parsed[np.Namespace][np.Name] = parsed
+	if len(np.Spec.Ingress) == 0 && len(np.Spec.Egress) == 0 {
+		return
+	}
+	key := np.Namespace + ":" + np.Name
+	// Churned network policies are created again with the same name
+	if probes, exists := nw.policies[key]; exists && !np.CreationTimestamp.After(probes.np.CreationTimestamp.Time) {
+		return
+	}
+	nw.policies[key] = &netpolProbes{np: parsed, searched: -1}
+}
+
+func (nw *netpolEnforcementWatcher) run() {
+	defer nw.wg.Done()
+	ticker := time.NewTicker(netpolProbeInterval)
+	defer ticker.Stop()
+	for {
+		nw.poll()
+		select {
+		case <-ticker.C:
+		case <-nw.stopCh:
+			return
+		}
+	}
+}
+
+// poll probes the network policies not enforced yet, checking first that their connections still hold with the current
+// pods and network policies, and searching them otherwise
+func (nw *netpolEnforcementWatcher) poll() {
+	nw.mu.Lock()
+	generation := nw.generation
+	snapshot := &netpolSnapshot{policies: make(map[string][]*parsedNetpol, len(nw.parsed))}
+	for namespace, policies := range nw.parsed {
+		for _, np := range policies {
+			snapshot.policies[namespace] = append(snapshot.policies[namespace], np)
+		}
+	}
+	snapshot.setNamespaces(nw.namespaceStore.List())
+	var pending []*netpolProbes
+	for _, key := range sortedKeys(nw.policies) {
+		probes := nw.policies[key]
+		if !probes.enforced.IsZero() {
+			continue
+		}
+		if probes.allowed != nil && !nw.connectionsHold(snapshot, probes) {
+			probes.allowed, probes.denied = nil, nil
+		}
+		if probes.allowed != nil || probes.searched != generation {
+			pending = append(pending, probes)
+		}
+	}
+	nw.mu.Unlock()
+	var searched bool
+	for _, probes := range pending {
+		if probes.allowed != nil {
+			continue
+		}
+		// The pods are only needed to search connections
+		if !searched {
+			snapshot.setPods(nw.podStore.List())
+			searched = true
+		}
+		allowed, denied, err := snapshot.connections(probes.np)
+		nw.mu.Lock()
+		probes.allowed, probes.denied = allowed, denied
+		if err != nil {
+			probes.err = err.Error()
+			probes.searched = generation
+		}
+		nw.mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	probeCh := make(chan *netpolProbes)
+	for range netpolProbeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for probes := range probeCh {
+				nw.probe(probes)
+			}
+		}()
+	}
+	for _, probes := range pending {
+		if probes.allowed != nil {
+			probeCh <- probes
+		}
+	}
+	close(probeCh)
+	wg.Wait()
+}
+
+// connectionsHold returns whether the pods of the connections of the network policy are still running with the same IPs,
+// and the connections are still allowed and denied by the network policies
+func (nw *netpolEnforcementWatcher) connectionsHold(snapshot *netpolSnapshot, probes *netpolProbes) bool {
+	for _, c := range []*netpolConnection{probes.allowed, probes.denied} {
+		for _, pod := range []*corev1.Pod{c.src, c.dst} {
+			obj, exists, err := nw.podStore.Get(pod)
+			if err != nil || !exists || !probeablePod(obj.(*corev1.Pod)) || obj.(*corev1.Pod).Status.PodIP != pod.Status.PodIP {
+				return false
+			}
+		}
+	}
+	return snapshot.allowed(probes.allowed, nil) && !snapshot.allowed(probes.denied, nil)
+}
+
+// probe probes the connections of a network policy, which is enforced once the allowed one succeeds and the denied one
+// is dropped. The denied connection is only probed after the allowed one succeeds, as it lasts until the connection timeout
+func (nw *netpolEnforcementWatcher) probe(probes *netpolProbes) {
+	nw.mu.Lock()
+	allowed, denied := probes.allowed, probes.denied
+	nw.mu.Unlock()
+	start := time.Now().UTC()
+	var deniedResult string
+	allowedResult, err := probeConnection(nw.clientSet, nw.restConfig, *allowed.src, netpolProbeContainer, allowed.address())
+	if allowedResult == probeAllowed {
+		deniedResult, err = probeConnection(nw.clientSet, nw.restConfig, *denied.src, netpolProbeContainer, denied.address())
+	}
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	probes.probes++
+	probes.allowedResult, probes.deniedResult = allowedResult, deniedResult
+	probes.err = ""
+	if err != nil {
+		probes.err = err.Error()
+	}
+	if allowedResult == probeAllowed && deniedResult == probeDenied {
+		probes.enforced = start
+	}
+}
+
+// wait waits until all the network policies are enforced, or the timeout expires
+func (nw *netpolEnforcementWatcher) wait(timeout time.Duration) {
+	log.Infof("Waiting up to %v for the network policies to be enforced", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		var pending int
+		nw.mu.Lock()
+		for _, probes := range nw.policies {
+			if probes.enforced.IsZero() {
+				pending++
+			}
+		}
+		total := len(nw.policies)
+		nw.mu.Unlock()
+		if pending == 0 {
+			log.Infof("The %d network policies are enforced", total)
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Errorf("%d/%d network policies not enforced after %v", pending, total, timeout)
+			return
+		}
+		time.Sleep(netpolProbeInterval)
+	}
+}
+
+// stop stops probing and indexes the enforcement of each network policy, along with their quantiles per job
+func (nw *netpolEnforcementWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(nw.stopCh)
+	nw.wg.Wait()
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if len(nw.policies) == 0 {
+		log.Warn("No network policies with rules found")
+		return
+	}
+	var docs, quantiles []interface{}
+	var jobNames []string
+	var failed int
+	latencies := make(map[string][]float64)
+	for _, key := range sortedKeys(nw.policies) {
+		probes := nw.policies[key]
+		created := probes.np.CreationTimestamp.Time
+		doc := netpolEnforcement{
+			Timestamp:     created.UTC(),
+			UUID:          wh.UUID,
+			Namespace:     probes.np.Namespace,
+			Name:          probes.np.Name,
+			AllowedResult: probes.allowedResult,
+			DeniedResult:  probes.deniedResult,
+			Enforced:      !probes.enforced.IsZero(),
+			Probes:        probes.probes,
+			Error:         probes.err,
+			MetricName:    netpolEnforcementMetric,
+			JobName:       probes.np.Labels["kube-burner-job"],
+			Metadata:      wh.MetricsMetadata,
+		}
+		if probes.allowed != nil {
+			doc.AllowedConnection, doc.DeniedConnection = probes.allowed.String(), probes.denied.String()
+		}
+		if doc.Enforced {
+			// Creation timestamps have a resolution of seconds
+			doc.EnforcementLatency = max(int(probes.enforced.Sub(created).Milliseconds()), 0)
+			if _, exists := latencies[doc.JobName]; !exists {
+				jobNames = append(jobNames, doc.JobName)
+			}
+			latencies[doc.JobName] = append(latencies[doc.JobName], float64(doc.EnforcementLatency))
+		} else {
+			failed++
+		}
+		docs = append(docs, doc)
+	}
+	if failed > 0 {
+		log.Warnf("%d/%d network policies weren't enforced", failed, len(docs))
+	}
+	for _, jobName := range jobNames {
+		q := metrics.NewLatencySummary(latencies[jobName], "Enforced")
+		q.UUID = wh.UUID
+		q.MetricName = netpolEnforcementQuantilesMetric
+		q.JobName = jobName
+		q.Metadata = wh.MetricsMetadata
+		quantiles = append(quantiles, q)
+	}
+	indexDocuments(netpolEnforcementMetric, docs)
+	indexDocuments(netpolEnforcementQuantilesMetric, quantiles)
+}
+
+// netpolPeer holds a peer of a network policy rule with its selectors parsed. A nil namespace selector matches the namespace
+// of the network policy, and a nil pod selector matches every pod
+type netpolPeer struct {
+	ipBlock           *networkingv1.IPBlock
+	namespaceSelector labels.Selector
+	podSelector       labels.Selector
+}
+
+// netpolRule holds an ingress or egress rule of a network policy with the selectors of its peers parsed
+type netpolRule struct {
+	ports []networkingv1.NetworkPolicyPort
+	peers []netpolPeer
+}
+
+// parsedNetpol holds a network policy with its selectors parsed, so they're parsed once instead of for every connection evaluated
+type parsedNetpol struct {
+	*networkingv1.NetworkPolicy
+	podSelector     labels.Selector
+	ingress, egress []netpolRule
+	// Whether the network policy applies to the ingress and the egress of the pods it selects
+	ingressType, egressType bool
+}
+
+func parseNetpol(np *networkingv1.NetworkPolicy) *parsedNetpol {
+	parsed := &parsedNetpol{
+		NetworkPolicy: np,
+		podSelector:   parseSelector(&np.Spec.PodSelector),
+	}
+	// Without policy types, a network policy always applies to ingress, and to egress when it has egress rules
+	if len(np.Spec.PolicyTypes) == 0 {
+		parsed.ingressType, parsed.egressType = true, len(np.Spec.Egress) > 0
+	} else {
+		parsed.ingressType = slices.Contains(np.Spec.PolicyTypes, networkingv1.PolicyTypeIngress)
+		parsed.egressType = slices.Contains(np.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	}
+	parseRule := func(ports []networkingv1.NetworkPolicyPort, peers []networkingv1.NetworkPolicyPeer) netpolRule {
+		rule := netpolRule{ports: ports}
+		for _, peer := range peers {
+			p := netpolPeer{ipBlock: peer.IPBlock}
+			if peer.NamespaceSelector != nil {
+				p.namespaceSelector = parseSelector(peer.NamespaceSelector)
+			}
+			if peer.PodSelector != nil {
+				p.podSelector = parseSelector(peer.PodSelector)
+			}
+			rule.peers = append(rule.peers, p)
+		}
+		return rule
+	}
+	for _, rule := range np.Spec.Ingress {
+		parsed.ingress = append(parsed.ingress, parseRule(rule.Ports, rule.From))
+	}
+	for _, rule := range np.Spec.Egress {
+		parsed.egress = append(parsed.egress, parseRule(rule.Ports, rule.To))
+	}
+	return parsed
+}
+
+// parseSelector parses a label selector, an invalid one matches nothing
+func parseSelector(labelSelector *metav1.LabelSelector) labels.Selector {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return labels.Nothing()
+	}
+	return selector
+}
+
+// netpolSnapshot holds the network policies of each namespace, and the running pods and the labels of the namespaces
+// the connections are searched among
+type netpolSnapshot struct {
+	policies        map[string][]*parsedNetpol
+	namespaces      map[string]labels.Set
+	pods            []*corev1.Pod
+	podsByNamespace map[string][]*corev1.Pod
+}
+
+func (s *netpolSnapshot) setNamespaces(namespaces []interface{}) {
+	s.namespaces = make(map[string]labels.Set, len(namespaces))
+	for _, obj := range namespaces {
+		namespace := obj.(*corev1.Namespace)
+		s.namespaces[namespace.Name] = namespace.Labels
+	}
+}
+
+func (s *netpolSnapshot) setPods(pods []interface{}) {
+	s.pods = nil
+	s.podsByNamespace = make(map[string][]*corev1.Pod)
+	for _, obj := range pods {
+		if pod := obj.(*corev1.Pod); probeablePod(pod) {
+			s.pods = append(s.pods, pod)
+		}
+	}
+	// Sorted, so the same connections are found for the same pods
+	slices.SortFunc(s.pods, func(a, b *corev1.Pod) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+	for _, pod := range s.pods {
+		s.podsByNamespace[pod.Namespace] = append(s.podsByNamespace[pod.Namespace], pod)
+	}
+}
+
+// connections returns a connection allowed by a rule of the given network policy and a connection with the same pod selected
+// by the policy, to the same port, denied by all the network policies. At least one of them only gets its result once the
+// policy is enforced: the allowed one isn't allowed by the other policies, or the denied one is
+func (s *netpolSnapshot) connections(np *parsedNetpol) (*netpolConnection, *netpolConnection, error) {
+	dependOnPolicy := func(allowed, denied *netpolConnection) bool {
+		return !s.allowed(allowed, np) || s.allowed(denied, np)
+	}
+	for _, pod := range s.podsByNamespace[np.Namespace] {
+		if !np.podSelector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if np.ingressType {
+			for _, rule := range np.ingress {
+				for _, src := range s.peerPods(rule, np.Namespace) {
+					if src == pod {
+						continue
+					}
+					for _, port := range containerPorts(pod) {
+						allowed := &netpolConnection{src: src, dst: pod, port: port}
+						if !portsMatch(rule.ports, port) || !s.allowed(allowed, nil) {
+							continue
+						}
+						for _, other := range s.pods {
+							denied := &netpolConnection{src: other, dst: pod, port: port}
+							if other != pod && !s.allowed(denied, nil) && dependOnPolicy(allowed, denied) {
+								return allowed, denied, nil
+							}
+						}
+					}
+				}
+			}
+		}
+		if np.egressType {
+			for _, rule := range np.egress {
+				for _, dst := range s.peerPods(rule, np.Namespace) {
+					if dst == pod {
+						continue
+					}
+					for _, port := range containerPorts(dst) {
+						allowed := &netpolConnection{src: pod, dst: dst, port: port}
+						if !portsMatch(rule.ports, port) || !s.allowed(allowed, nil) {
+							continue
+						}
+						// Listening in the port, so the connection only fails when it's dropped
+						for _, other := range s.pods {
+							denied := &netpolConnection{src: pod, dst: other, port: port}
+							if other != pod && slices.Contains(containerPorts(other), port) && !s.allowed(denied, nil) && dependOnPolicy(allowed, denied) {
+								return allowed, denied, nil
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no running pods with connections allowed and denied by the network policy")
+}
+
+// peerPods returns the pods matching the peers of a rule of a network policy of the given namespace, a rule without peers
+// matches all of them
+func (s *netpolSnapshot) peerPods(rule netpolRule, policyNamespace string) []*corev1.Pod {
+	if len(rule.peers) == 0 {
+		return s.pods
+	}
+	var pods []*corev1.Pod
+	for _, peer := range rule.peers {
+		if peer.ipBlock != nil {
+			for _, pod := range s.pods {
+				if ipBlockMatches(peer.ipBlock, pod.Status.PodIP) {
+					pods = append(pods, pod)
+				}
+			}
+			continue
+		}
+		namespaces := []string{policyNamespace}
+		if peer.namespaceSelector != nil {
+			namespaces = nil
+			for _, namespace := range sortedKeys(s.podsByNamespace) {
+				if peer.namespaceSelector.Matches(s.namespaces[namespace]) {
+					namespaces = append(namespaces, namespace)
+				}
+			}
+		}
+		for _, namespace := range namespaces {
+			for _, pod := range s.podsByNamespace[namespace] {
+				if peer.podSelector == nil || peer.podSelector.Matches(labels.Set(pod.Labels)) {
+					pods = append(pods, pod)
+				}
+			}
+		}
+	}
+	return pods
+}
+
+// allowed returns whether the network policies, but the excluded one, allow the connection, which requires both the
+// egress of its source pod and the ingress of its destination pod to be allowed
+func (s *netpolSnapshot) allowed(c *netpolConnection, exclude *parsedNetpol) bool {
+	return s.directionAllowed(networkingv1.PolicyTypeEgress, c.src, c.dst, c.port, exclude) &&
+		s.directionAllowed(networkingv1.PolicyTypeIngress, c.dst, c.src, c.port, exclude)
+}
+
+// directionAllowed returns whether the network policies selecting the given pod for the policy type allow its traffic with
+// the peer pod, to the given port of the destination. Pods not selected by any of them aren't isolated
+func (s *netpolSnapshot) directionAllowed(policyType networkingv1.PolicyType, pod, peer *corev1.Pod, port int32, exclude *parsedNetpol) bool {
+	var isolated bool
+	for _, np := range s.policies[pod.Namespace] {
+		var rules []netpolRule
+		switch {
+		case exclude != nil && np.Name == exclude.Name:
+			continue
+		case policyType == networkingv1.PolicyTypeIngress && np.ingressType:
+			rules = np.ingress
+		case policyType == networkingv1.PolicyTypeEgress && np.egressType:
+			rules = np.egress
+		default:
+			continue
+		}
+		if !np.podSelector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		isolated = true
+		for _, rule := range rules {
+			if portsMatch(rule.ports, port) && s.peerMatches(rule, np.Namespace, peer) {
+				return true
+			}
+		}
+	}
+	return !isolated
+}
+
+// peerMatches returns whether the pod matches any of the peers of a rule of a network policy of the given namespace, a rule
+// without peers matches all of them
+func (s *netpolSnapshot) peerMatches(rule netpolRule, policyNamespace string, pod *corev1.Pod) bool {
+	if len(rule.peers) == 0 {
+		return true
+	}
+	for _, peer := range rule.peers {
+		if peer.ipBlock != nil {
+			if ipBlockMatches(peer.ipBlock, pod.Status.PodIP) {
+				return true
+			}
+			continue
+		}
+		if peer.namespaceSelector == nil && pod.Namespace != policyNamespace {
+			continue
+		}
+		if peer.namespaceSelector != nil && !peer.namespaceSelector.Matches(s.namespaces[pod.Namespace]) {
+			continue
+		}
+		if peer.podSelector == nil || peer.podSelector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipBlockMatches(ipBlock *networkingv1.IPBlock, podIP string) bool {
+	ip := net.ParseIP(podIP)
+	_, cidr, err := net.ParseCIDR(ipBlock.CIDR)
+	if ip == nil || err != nil || !cidr.Contains(ip) {
+		return false
+	}
+	for _, except := range ipBlock.Except {
+		if _, cidr, err := net.ParseCIDR(except); err == nil && cidr.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// portsMatch returns whether the TCP port matches any of the ports of a rule, a rule without ports matches all of them.
+// Named ports aren't resolved, so they never match
+func portsMatch(ports []networkingv1.NetworkPolicyPort, port int32) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		if p.Protocol != nil && *p.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		if p.Port == nil {
+			return true
+		}
+		if p.Port.Type != intstr.Int {
+			continue
+		}
+		if port == p.Port.IntVal || (p.EndPort != nil && port > p.Port.IntVal && port <= *p.EndPort) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerPorts returns the TCP ports the containers of the pod listen in
+func containerPorts(pod *corev1.Pod) []int32 {
+	var ports []int32
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Protocol == "" || port.Protocol == corev1.ProtocolTCP {
+				ports = append(ports, port.ContainerPort)
+			}
+		}
+	}
+	return ports
+}
+
+// probeablePod returns whether the pod is running with an IP, so connections can be probed from and to it
+func probeablePod(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" && pod.DeletionTimestamp == nil
+}
+
+// sortedKeys returns the sorted union of the keys of the given maps
+func sortedKeys[V any](maps ...map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func TestNetpolSnapshotConnections(t *testing.T) {
+	var namespaces []interface{}
+	for _, name := range []string{"ns-0", "ns-1", "ns-2"} {
+		namespaces = append(namespaces, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"kubernetes.io/metadata.name": name}}})
+	}
+	pod := func(namespace, name, num, ip string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"num": num}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+		}
+	}
+	pods := []interface{}{
+		pod("ns-0", "pod-1", "1", "10.128.0.1"),
+		pod("ns-0", "pod-2", "2", "10.128.0.2"),
+		pod("ns-1", "pod-1", "1", "10.129.0.1"),
+		pod("ns-1", "pod-2", "2", "10.129.0.2"),
+		pod("ns-2", "pod-1", "1", "10.130.0.1"),
+	}
+	denyAll := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-0", Name: "deny-all"}}
+	peer := func(namespace, num string) networkingv1.NetworkPolicyPeer {
+		return networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": namespace}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"num": num}},
+		}
+	}
+	ingress := func(name string, port int32, endPort *int32, peers ...networkingv1.NetworkPolicyPeer) *networkingv1.NetworkPolicy {
+		return &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-0", Name: name},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"num": "1"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From:  peers,
+					Ports: []networkingv1.NetworkPolicyPort{{Port: ptr.To(intstr.FromInt32(port)), EndPort: endPort}},
+				}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		}
+	}
+	egress := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "egress"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"num": "2"}},
+			Egress:      []networkingv1.NetworkPolicyEgressRule{{To: []networkingv1.NetworkPolicyPeer{peer("ns-2", "1")}}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+	tests := []struct {
+		name        string
+		np          *networkingv1.NetworkPolicy
+		others      []*networkingv1.NetworkPolicy
+		wantAllowed string
+		wantDenied  string
+	}{
+		{
+			name:        "ingress",
+			np:          ingress("ingress", 8080, nil, peer("ns-1", "2")),
+			others:      []*networkingv1.NetworkPolicy{denyAll},
+			wantAllowed: "ns-1/pod-2 -> ns-0/pod-1:8080",
+			wantDenied:  "ns-0/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:        "ingress port range",
+			np:          ingress("ingress", 8000, ptr.To[int32](8100), peer("ns-1", "2")),
+			others:      []*networkingv1.NetworkPolicy{denyAll},
+			wantAllowed: "ns-1/pod-2 -> ns-0/pod-1:8080",
+			wantDenied:  "ns-0/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:        "ingress with connections allowed by other policies",
+			np:          ingress("ingress", 8080, nil, peer("ns-1", "1"), peer("ns-1", "2")),
+			others:      []*networkingv1.NetworkPolicy{denyAll, ingress("other", 8080, nil, peer("ns-1", "1"))},
+			wantAllowed: "ns-1/pod-2 -> ns-0/pod-1:8080",
+			wantDenied:  "ns-0/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:        "ingress blocked by the egress of the source",
+			np:          ingress("ingress", 8080, nil, peer("ns-1", "1"), peer("ns-1", "2")),
+			others:      []*networkingv1.NetworkPolicy{denyAll, egress},
+			wantAllowed: "ns-1/pod-1 -> ns-0/pod-1:8080",
+			wantDenied:  "ns-0/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:        "egress",
+			np:          egress,
+			wantAllowed: "ns-1/pod-2 -> ns-2/pod-1:8080",
+			wantDenied:  "ns-1/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:   "port not listened in",
+			np:     ingress("ingress", 8081, nil, peer("ns-1", "2")),
+			others: []*networkingv1.NetworkPolicy{denyAll},
+		},
+		{
+			name:        "isolating ingress",
+			np:          ingress("ingress", 8080, nil, peer("ns-1", "2")),
+			wantAllowed: "ns-1/pod-2 -> ns-0/pod-1:8080",
+			wantDenied:  "ns-0/pod-2 -> ns-0/pod-1:8080",
+		},
+		{
+			name:   "connections allowed by other policies",
+			np:     ingress("ingress", 8080, nil, peer("ns-1", "2")),
+			others: []*networkingv1.NetworkPolicy{denyAll, ingress("other", 8080, nil, peer("ns-1", "2"))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			np := parseNetpol(tt.np)
+			snapshot := &netpolSnapshot{policies: map[string][]*parsedNetpol{}}
+			for _, policy := range append(tt.others, tt.np) {
+				parsed := parseNetpol(policy)
+				if policy == tt.np {
+					parsed = np
+				}
+				snapshot.policies[policy.Namespace] = append(snapshot.policies[policy.Namespace], parsed)
+			}
+			snapshot.setNamespaces(namespaces)
+			snapshot.setPods(pods)
+			allowed, denied, err := snapshot.connections(np)
+			if (err != nil) != (tt.wantAllowed == "") {
+				t.Fatalf("got error %v, want connections %q and %q", err, tt.wantAllowed, tt.wantDenied)
+			}
+			if err == nil && (allowed.String() != tt.wantAllowed || denied.String() != tt.wantDenied) {
+				t.Errorf("got connections %q and %q, want %q and %q", allowed, denied, tt.wantAllowed, tt.wantDenied)
+			}
+		})
+	}
+}
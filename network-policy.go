@@ -27,14 +27,14 @@ import (
 // NewNetworkPolicy holds network-policy workload
 func NewNetworkPolicy(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
 	var iterations, podsPerNamespace, netpolPerNamespace, localPods, podSelectors, singlePorts, portRanges, remoteNamespaces, remotePods, cidrs int
-	var netpolLatency bool
+	var netpolLatency, aclConvergence, netpolEnforcement bool
 	var metricsProfiles []string
-	var netpolReadyThreshold time.Duration
+	var netpolReadyThreshold, aclConvergenceTimeout, netpolEnforcementTimeout time.Duration
 	var rc int
 	cmd := &cobra.Command{
 		Use:   variant,
 		Short: fmt.Sprintf("Runs %v workload", variant),
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
 			os.Setenv("PODS_PER_NAMESPACE", fmt.Sprint(podsPerNamespace))
 			os.Setenv("NETPOLS_PER_NAMESPACE", fmt.Sprint(netpolPerNamespace))
@@ -47,10 +47,19 @@ func NewNetworkPolicy(wh *workloads.WorkloadHelper, variant string) *cobra.Comma
 			os.Setenv("CIDRS", fmt.Sprint(cidrs))
 			os.Setenv("NETPOL_LATENCY", strconv.FormatBool(netpolLatency))
 			os.Setenv("NETPOL_READY_THRESHOLD", fmt.Sprintf("%v", netpolReadyThreshold))
+			// The ACLs and the enforcement are waited for after the workload, so the network policies are garbage collected afterwards
+			if aclConvergence || netpolEnforcement {
+				os.Setenv("GC", "false")
+			}
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -68,6 +77,10 @@ func NewNetworkPolicy(wh *workloads.WorkloadHelper, variant string) *cobra.Comma
 	cmd.Flags().IntVar(&remotePods, "remotes-pods", 2, "Number of pods in remote namespaces to accept traffic from or send traffic to in ingress and egress rules")
 	cmd.Flags().IntVar(&cidrs, "cidrs", 2, "Number of cidrs to accept traffic from or send traffic to in ingress and egress rules")
 	cmd.Flags().BoolVar(&netpolLatency, "networkpolicy-latency", true, "Enable network policy latency measurement")
+	cmd.Flags().BoolVar(&aclConvergence, "acl-convergence", false, "Wait until the ACLs of the network policies are programmed in all the nodes, and index the convergence latency of each namespace")
+	cmd.Flags().DurationVar(&aclConvergenceTimeout, "acl-convergence-timeout", 10*time.Minute, "Maximum time to wait for the ACLs of the network policies to be programmed after the workload with --acl-convergence")
+	cmd.Flags().BoolVar(&netpolEnforcement, "netpol-enforcement", false, "Probe a connection allowed and a connection denied by each network policy until it's enforced, and index its enforcement latency")
+	cmd.Flags().DurationVar(&netpolEnforcementTimeout, "netpol-enforcement-timeout", 10*time.Minute, "Maximum time to wait for the network policies to be enforced after the workload with --netpol-enforcement")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-aggregated.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.MarkFlagRequired("iterations")
 	return cmd
@@ -25,39 +25,42 @@ import (
 
 // NewNetworkPolicyLegacy holds network-policy legacy workload
 func NewNetworkPolicyLegacy(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
-	var iterations, churnPercent, churnCycles int
-	var churn bool
-	var churnDelay, churnDuration time.Duration
-	var churnDeletionStrategy string
+	var iterations int
+	var aclConvergence bool
+	var aclConvergenceTimeout time.Duration
+	var churn *churnFlags
 	var metricsProfiles []string
 	var rc int
 	cmd := &cobra.Command{
 		Use:   variant,
 		Short: fmt.Sprintf("Runs %v workload", variant),
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
-			os.Setenv("CHURN", fmt.Sprint(churn))
-			os.Setenv("CHURN_CYCLES", fmt.Sprintf("%v", churnCycles))
-			os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", churnDuration))
-			os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", churnDelay))
-			os.Setenv("CHURN_PERCENT", fmt.Sprint(churnPercent))
-			os.Setenv("CHURN_DELETION_STRATEGY", churnDeletionStrategy)
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
+			// The ACLs are waited for after the workload, so the network policies are garbage collected afterwards
+			if aclConvergence {
+				os.Setenv("GC", "false")
+			}
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
 	cmd.Flags().IntVar(&iterations, "iterations", 0, fmt.Sprintf("%v iterations", variant))
-	cmd.Flags().BoolVar(&churn, "churn", false, "Enable churning")
-	cmd.Flags().IntVar(&churnCycles, "churn-cycles", 0, "Churn cycles to execute")
-	cmd.Flags().DurationVar(&churnDuration, "churn-duration", 1*time.Hour, "Churn duration")
-	cmd.Flags().DurationVar(&churnDelay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
-	cmd.Flags().IntVar(&churnPercent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
-	cmd.Flags().StringVar(&churnDeletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
+	churn = addChurnFlags(cmd, false, time.Hour)
+	cmd.Flags().BoolVar(&aclConvergence, "acl-convergence", false, "Wait until the ACLs of the network policies are programmed in all the nodes, and index the convergence latency of each namespace")
+	cmd.Flags().DurationVar(&aclConvergenceTimeout, "acl-convergence-timeout", 10*time.Minute, "Maximum time to wait for the ACLs of the network policies to be programmed after the workload with --acl-convergence")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	cmd.MarkFlagRequired("iterations")
 	return cmd
@@ -21,7 +21,6 @@ import (
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 )
@@ -33,27 +32,35 @@ func NewNodeDensityCNI(wh *workloads.WorkloadHelper) *cobra.Command {
 	var podReadyThreshold time.Duration
 	var iterationsPerNamespace int
 	var metricsProfiles []string
+	var churn *churnFlags
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "node-density-cni",
 		Short:        "Runs node-density-cni workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			totalPods := clusterMetadata.WorkerNodesCount * podsPerNode
 			podCount, err := wh.MetadataAgent.GetCurrentPodCount()
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint((totalPods-podCount)/2))
-			os.Setenv("PPROF", fmt.Sprint(pprof))
 			os.Setenv("NAMESPACED_ITERATIONS", fmt.Sprint(namespacedIterations))
 			os.Setenv("ITERATIONS_PER_NAMESPACE", fmt.Sprint(iterationsPerNamespace))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -61,10 +68,11 @@ func NewNodeDensityCNI(wh *workloads.WorkloadHelper) *cobra.Command {
 	}
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 1*time.Minute, "Pod ready timeout threshold")
 	cmd.Flags().IntVar(&podsPerNode, "pods-per-node", 245, "Pods per node")
-	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection")
+	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection of the OVN components, same as --pprof-targets=ovn")
 	cmd.Flags().BoolVar(&namespacedIterations, "namespaced-iterations", true, "Namespaced iterations")
 	cmd.Flags().IntVar(&iterationsPerNamespace, "iterations-per-namespace", 1000, "Iterations per namespace")
 	cmd.Flags().BoolVar(&svcLatency, "service-latency", false, "Enable service latency measurement")
+	churn = addChurnFlags(cmd, false, time.Hour)
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
@@ -17,54 +17,93 @@ package ocp
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // NewNodeDensity holds node-density-heavy workload
 func NewNodeDensityHeavy(wh *workloads.WorkloadHelper) *cobra.Command {
 	var podsPerNode int
-	var podReadyThreshold, probesPeriod time.Duration
-	var namespacedIterations, pprof bool
+	var podReadyThreshold, probesPeriod, readinessProbePeriod time.Duration
+	var appImage, appCPU, appMemory, dbImage, dbCPU, dbMemory string
+	var namespacedIterations, svcLatency, pprof bool
 	var iterationsPerNamespace int
 	var metricsProfiles []string
+	var churn *churnFlags
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "node-density-heavy",
 		Short:        "Runs node-density-heavy workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			totalPods := clusterMetadata.WorkerNodesCount * podsPerNode
 			podCount, err := wh.MetadataAgent.GetCurrentPodCount()
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			// We divide by two the number of pods to deploy to obtain the workload iterations
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint((totalPods-podCount)/2))
-			os.Setenv("PPROF", fmt.Sprint(pprof))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("PROBES_PERIOD", fmt.Sprint(probesPeriod.Seconds()))
+			if readinessProbePeriod == 0 {
+				readinessProbePeriod = probesPeriod
+			}
+			if probesPeriod < time.Second || readinessProbePeriod < time.Second {
+				return fmt.Errorf("--probes-period and --readiness-probe-period must be at least 1s")
+			}
+			// Probe periods are whole seconds
+			os.Setenv("READINESS_PROBE_PERIOD", fmt.Sprint(int(readinessProbePeriod.Seconds())))
+			for flag, quantity := range map[string]string{"app-cpu": appCPU, "app-memory": appMemory, "db-cpu": dbCPU, "db-memory": dbMemory} {
+				if _, err := resource.ParseQuantity(quantity); err != nil {
+					return fmt.Errorf("invalid --%s %s: %v", flag, quantity, err)
+				}
+			}
+			os.Setenv("APP_IMAGE", appImage)
+			os.Setenv("APP_CPU", appCPU)
+			os.Setenv("APP_MEMORY", appMemory)
+			os.Setenv("DB_IMAGE", dbImage)
+			os.Setenv("DB_CPU", dbCPU)
+			os.Setenv("DB_MEMORY", dbMemory)
 			os.Setenv("NAMESPACED_ITERATIONS", fmt.Sprint(namespacedIterations))
 			os.Setenv("ITERATIONS_PER_NAMESPACE", fmt.Sprint(iterationsPerNamespace))
+			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 2*time.Minute, "Pod ready timeout threshold")
-	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection")
-	cmd.Flags().DurationVar(&probesPeriod, "probes-period", 10*time.Second, "Perf app readiness/livenes probes period")
+	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection of the OVN components, same as --pprof-targets=ovn")
+	cmd.Flags().DurationVar(&probesPeriod, "probes-period", 10*time.Second, "Perf app liveness probe period, also used as readiness probe period unless --readiness-probe-period is given")
+	cmd.Flags().DurationVar(&readinessProbePeriod, "readiness-probe-period", 0, "Perf app readiness probe period, each readiness probe runs an insert query in the database, so it sets the client query rate. Defaults to --probes-period")
+	cmd.Flags().StringVar(&appImage, "app-image", "quay.io/cloud-bulldozer/perfapp:latest", "Perf app image, it must serve the /ready and /health endpoints on port 8080 and read the POSTGRESQL_* environment variables")
+	cmd.Flags().StringVar(&appCPU, "app-cpu", "10m", "Perf app CPU request")
+	cmd.Flags().StringVar(&appMemory, "app-memory", "10Mi", "Perf app memory request")
+	cmd.Flags().StringVar(&dbImage, "db-image", "registry.redhat.io/rhel8/postgresql-10@sha256:4b912c80085b88a03309aeb7907efcc29dd3342fa3952b6ea067afb1914bfe53", "Database image, it must listen on port 5432 and read the POSTGRESQL_* environment variables")
+	cmd.Flags().StringVar(&dbCPU, "db-cpu", "20m", "Database CPU request")
+	cmd.Flags().StringVar(&dbMemory, "db-memory", "20Mi", "Database memory request")
 	cmd.Flags().IntVar(&podsPerNode, "pods-per-node", 245, "Pods per node")
 	cmd.Flags().BoolVar(&namespacedIterations, "namespaced-iterations", true, "Namespaced iterations")
 	cmd.Flags().IntVar(&iterationsPerNamespace, "iterations-per-namespace", 1000, "Iterations per namespace")
+	cmd.Flags().BoolVar(&svcLatency, "service-latency", false, "Enable service latency measurement")
+	churn = addChurnFlags(cmd, false, time.Hour)
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
@@ -20,7 +20,6 @@ import (
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 )
@@ -30,36 +29,53 @@ func NewNodeDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 	var podsPerNode int
 	var pprof bool
 	var podReadyThreshold time.Duration
-	var containerImage string
+	var containerImage, podSpecOverlayFile string
 	var metricsProfiles []string
+	var churn *churnFlags
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "node-density",
 		Short:        "Runs node-density workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			totalPods := clusterMetadata.WorkerNodesCount * podsPerNode
 			podCount, err := wh.MetadataAgent.GetCurrentPodCount()
 			if err != nil {
-				log.Fatal(err.Error())
+				return err
 			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(totalPods-podCount))
-			os.Setenv("PPROF", fmt.Sprint(pprof))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
 			os.Setenv("CONTAINER_IMAGE", containerImage)
+			podTemplate := "pod.yml"
+			if podSpecOverlayFile != "" {
+				if podTemplate, err = podSpecOverlay(cmd.Name(), podTemplate, podSpecOverlayFile); err != nil {
+					return err
+				}
+			}
+			os.Setenv("POD_TEMPLATE", podTemplate)
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
 	cmd.Flags().IntVar(&podsPerNode, "pods-per-node", 245, "Pods per node")
-	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection")
+	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection of the OVN components, same as --pprof-targets=ovn")
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 15*time.Second, "Pod ready timeout threshold")
 	cmd.Flags().StringVar(&containerImage, "container-image", "gcr.io/google_containers/pause:3.1", "Container image")
+	cmd.Flags().StringVar(&podSpecOverlayFile, "pod-spec-overlay", "", "YAML file with a pod snippet strategic-merged onto the pause pod, i.e. its resources, image, env or securityContext. The container to modify is named node-density")
+	churn = addChurnFlags(cmd, false, time.Hour)
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
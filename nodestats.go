@@ -0,0 +1,213 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// nodeStat is a per node value obtained by running a shell command printing a number in the ovnkube-node pod of each node
+type nodeStat struct {
+	metricName string
+	container  string
+	command    string
+}
+
+// ovnStats are the OVN stats not exposed as Prometheus metrics
+var ovnStats = []nodeStat{
+	{
+		// With OVN interconnect, the southbound database of each node holds the logical flows of its zone
+		metricName: "ovnLogicalFlows",
+		container:  "sbdb",
+		command:    "ovn-sbctl --no-leader-only --format=csv --no-headings --columns=_uuid list Logical_Flow | wc -l",
+	},
+}
+
+// networkTableStats are the size of the node network tables, the ovnkube-controller container runs in the host network namespace
+var networkTableStats = []nodeStat{
+	{
+		metricName: "conntrackEntries",
+		container:  "ovnkube-controller",
+		command:    "cat /proc/sys/net/netfilter/nf_conntrack_count",
+	},
+	{
+		metricName: "conntrackMax",
+		container:  "ovnkube-controller",
+		command:    "cat /proc/sys/net/netfilter/nf_conntrack_max",
+	},
+	{
+		// grep -c exits with an error when there are no matches
+		metricName: "iptablesRules",
+		container:  "ovnkube-controller",
+		command:    "(iptables-save; ip6tables-save) | grep -c '^-A' || true",
+	},
+	{
+		// Tables and chains have a handle too, but they're followed by an opening brace
+		metricName: "nftablesRules",
+		container:  "ovnkube-controller",
+		command:    "nft -a list ruleset | grep '# handle' | grep -vc '{' || true",
+	},
+	{
+		metricName: "ovsOpenFlowFlows",
+		container:  "ovnkube-controller",
+		command:    "ovs-ofctl dump-aggregate br-int | sed -n 's/.*flow_count=\\([0-9]*\\).*/\\1/p'",
+	},
+	{
+		metricName: "ovsDatapathFlows",
+		container:  "ovnkube-controller",
+		command:    "ovs-appctl dpctl/show | sed -n 's/^ *flows: *\\([0-9]*\\).*/\\1/p'",
+	},
+}
+
+// nodeSample has the same format as the metrics indexed by kube-burner
+type nodeSample struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	Labels     map[string]string `json:"labels"`
+	Value      float64           `json:"value"`
+	UUID       string            `json:"uuid"`
+	MetricName string            `json:"metricName"`
+	JobName    string            `json:"jobName,omitempty"`
+	Metadata   interface{}       `json:"metadata,omitempty"`
+}
+
+type nodeSampler struct {
+	clientSet  kubernetes.Interface
+	restConfig *rest.Config
+	stats      []nodeStat
+	interval   time.Duration
+	samples    []nodeSample
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+// startNodeSampler samples the given stats in every node each interval
+func startNodeSampler(stats []nodeStat, interval time.Duration) *nodeSampler {
+	clientSet, restConfig := newClientSet()
+	ns := &nodeSampler{
+		clientSet:  clientSet,
+		restConfig: restConfig,
+		stats:      stats,
+		interval:   interval,
+		stopCh:     make(chan struct{}),
+	}
+	log.Infof("Sampling node stats every %v", interval)
+	ns.wg.Add(1)
+	go ns.run()
+	return ns
+}
+
+func (ns *nodeSampler) run() {
+	defer ns.wg.Done()
+	ticker := time.NewTicker(ns.interval)
+	defer ticker.Stop()
+	for {
+		ns.sample()
+		select {
+		case <-ticker.C:
+		case <-ns.stopCh:
+			return
+		}
+	}
+}
+
+func (ns *nodeSampler) sample() {
+	pods, err := ns.clientSet.CoreV1().Pods("openshift-ovn-kubernetes").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "app=ovnkube-node",
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		log.Errorf("Error listing ovnkube-node pods: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, pod := range pods.Items {
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			for _, stat := range ns.stats {
+				timestamp := time.Now().UTC()
+				out, err := execInPod(ns.clientSet, ns.restConfig, pod, stat.container, "/bin/sh", "-c", stat.command)
+				if err != nil {
+					log.Debugf("Error sampling %s in node %s: %v", stat.metricName, pod.Spec.NodeName, err)
+					continue
+				}
+				value, err := strconv.ParseFloat(out, 64)
+				if err != nil {
+					log.Debugf("Unexpected %s output in node %s: %s", stat.metricName, pod.Spec.NodeName, out)
+					continue
+				}
+				ns.mu.Lock()
+				ns.samples = append(ns.samples, nodeSample{
+					Timestamp:  timestamp,
+					Labels:     map[string]string{"node": pod.Spec.NodeName},
+					Value:      value,
+					MetricName: stat.metricName,
+				})
+				ns.mu.Unlock()
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// stop stops sampling and indexes the samples of each stat, the job of each sample is the one running when it was taken
+func (ns *nodeSampler) stop(wh *workloads.WorkloadHelper) {
+	close(ns.stopCh)
+	ns.wg.Wait()
+	if len(ns.samples) == 0 {
+		log.Warn("No node stats sampled")
+		return
+	}
+	var jobSummaries []burner.JobSummary
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		readDocuments(path.Join(metricsDirectory, "jobSummary.json"), &jobSummaries)
+	}
+	docs := make(map[string][]interface{})
+	for _, sample := range ns.samples {
+		sample.UUID = wh.UUID
+		sample.JobName = jobAt(jobSummaries, sample.Timestamp)
+		sample.Metadata = wh.MetricsMetadata
+		docs[sample.MetricName] = append(docs[sample.MetricName], sample)
+	}
+	for _, stat := range ns.stats {
+		if len(docs[stat.metricName]) > 0 {
+			indexDocuments(stat.metricName, docs[stat.metricName])
+		}
+	}
+}
+
+// jobAt returns the name of the job running at the given time
+func jobAt(jobSummaries []burner.JobSummary, t time.Time) string {
+	var jobName string
+	for _, jobSummary := range jobSummaries {
+		if !t.Before(jobSummary.Timestamp) && !t.After(jobSummary.EndTimestamp) {
+			jobName = jobSummary.JobConfig.Name
+		}
+	}
+	return jobName
+}
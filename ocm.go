@@ -0,0 +1,213 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/openshift/client-go/config/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ocmTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// ocmCluster holds the fields of an OCM cluster added to the metadata
+type ocmCluster struct {
+	ID      string `json:"id"`
+	MultiAZ bool   `json:"multi_az"`
+	Product struct {
+		ID string `json:"id"`
+	} `json:"product"`
+	Hypershift struct {
+		Enabled bool `json:"enabled"`
+	} `json:"hypershift"`
+}
+
+// ocmMachinePool holds the fields of the OCM machine pools, and node pools of hosted clusters
+type ocmMachinePool struct {
+	ID                string   `json:"id"`
+	InstanceType      string   `json:"instance_type"`
+	Replicas          int      `json:"replicas"`
+	AvailabilityZones []string `json:"availability_zones"`
+	AvailabilityZone  string   `json:"availability_zone"`
+	Autoscaling       *struct {
+		MinReplicas int `json:"min_replicas"`
+		MaxReplicas int `json:"max_replicas"`
+	} `json:"autoscaling"`
+	AWSNodePool struct {
+		InstanceType string `json:"instance_type"`
+	} `json:"aws_node_pool"`
+}
+
+// machinePool is the machine pool definition added to the metadata
+type machinePool struct {
+	Name              string   `json:"name"`
+	InstanceType      string   `json:"instanceType"`
+	Replicas          int      `json:"replicas,omitempty"`
+	MinReplicas       int      `json:"minReplicas,omitempty"`
+	MaxReplicas       int      `json:"maxReplicas,omitempty"`
+	AvailabilityZones []string `json:"availabilityZones,omitempty"`
+}
+
+// ocmClient queries the OCM API with an access token obtained from an OCM offline token
+type ocmClient struct {
+	url         string
+	accessToken string
+	httpClient  *http.Client
+}
+
+// newOCMClient exchanges the given OCM offline token for an access token
+func newOCMClient(ocmURL, offlineToken string) (*ocmClient, error) {
+	c := &ocmClient{
+		url:        strings.TrimSuffix(ocmURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	resp, err := c.httpClient.PostForm(ocmTokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {"cloud-services"},
+		"refresh_token": {offlineToken},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error requesting OCM access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error requesting OCM access token: %s: %s", resp.Status, msg)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("error decoding OCM access token: %v", err)
+	}
+	c.accessToken = token.AccessToken
+	return c, nil
+}
+
+func (c *ocmClient) get(path string, query url.Values, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// getCluster returns the OCM cluster with the given external ID, which is the ID of the ClusterVersion of the cluster
+func (c *ocmClient) getCluster(externalID string) (*ocmCluster, error) {
+	var clusters struct {
+		Items []ocmCluster `json:"items"`
+	}
+	if err := c.get("/api/clusters_mgmt/v1/clusters", url.Values{"search": {fmt.Sprintf("external_id = '%s'", externalID)}}, &clusters); err != nil {
+		return nil, fmt.Errorf("error searching the cluster in OCM: %v", err)
+	}
+	if len(clusters.Items) == 0 {
+		return nil, fmt.Errorf("cluster with external ID %s not found in OCM", externalID)
+	}
+	return &clusters.Items[0], nil
+}
+
+// getMachinePools returns the machine pools of the given cluster, or its node pools when it's a hosted cluster
+func (c *ocmClient) getMachinePools(cluster *ocmCluster) ([]machinePool, error) {
+	resource := "machine_pools"
+	if cluster.Hypershift.Enabled {
+		resource = "node_pools"
+	}
+	var pools struct {
+		Items []ocmMachinePool `json:"items"`
+	}
+	if err := c.get(fmt.Sprintf("/api/clusters_mgmt/v1/clusters/%s/%s", cluster.ID, resource), url.Values{}, &pools); err != nil {
+		return nil, fmt.Errorf("error getting the %s of the cluster from OCM: %v", resource, err)
+	}
+	var machinePools []machinePool
+	for _, pool := range pools.Items {
+		mp := machinePool{
+			Name:              pool.ID,
+			InstanceType:      pool.InstanceType,
+			Replicas:          pool.Replicas,
+			AvailabilityZones: pool.AvailabilityZones,
+		}
+		if mp.InstanceType == "" {
+			mp.InstanceType = pool.AWSNodePool.InstanceType
+		}
+		if pool.AvailabilityZone != "" {
+			mp.AvailabilityZones = []string{pool.AvailabilityZone}
+		}
+		if pool.Autoscaling != nil {
+			mp.MinReplicas, mp.MaxReplicas = pool.Autoscaling.MinReplicas, pool.Autoscaling.MaxReplicas
+		}
+		machinePools = append(machinePools, mp)
+	}
+	return machinePools, nil
+}
+
+// GatherOCMMetadata adds the OCM cluster ID, product, multi-AZ and machine pool definitions of a ROSA or OSD cluster to the metadata,
+// using the given OCM offline token
+func GatherOCMMetadata(wh *workloads.WorkloadHelper, ocmURL, ocmToken string) error {
+	if ocmToken == "" {
+		return nil
+	}
+	log.Infof("Gathering managed cluster metadata from %s", ocmURL)
+	_, restConfig := newClientSet()
+	openshiftClientset, err := versioned.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error creating OpenShift clientset: %v", err)
+	}
+	clusterVersion, err := openshiftClientset.ConfigV1().ClusterVersions().Get(context.TODO(), "version", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting clusterversion/version: %v", err)
+	}
+	client, err := newOCMClient(ocmURL, ocmToken)
+	if err != nil {
+		return err
+	}
+	cluster, err := client.getCluster(string(clusterVersion.Spec.ClusterID))
+	if err != nil {
+		return err
+	}
+	machinePools, err := client.getMachinePools(cluster)
+	if err != nil {
+		return err
+	}
+	for k, v := range map[string]interface{}{
+		"ocmClusterID": cluster.ID,
+		"product":      cluster.Product.ID,
+		"multiAZ":      cluster.MultiAZ,
+	} {
+		wh.SummaryMetadata[k] = v
+		wh.MetricsMetadata[k] = v
+	}
+	wh.SummaryMetadata["machinePools"] = machinePools
+	return nil
+}
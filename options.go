@@ -0,0 +1,398 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// WorkloadOptions are the options of a workload run through Run, one of the *Options types of this package.
+// The fields map to the flags of the workload: zero values keep the defaults of the flags, and the flags whose
+// default is true are pointers so they can be disabled
+type WorkloadOptions interface {
+	workload() string
+	common() CommonOptions
+}
+
+// CommonOptions are the options shared by all the workloads
+type CommonOptions struct {
+	// Kubeconfig of the cluster, by default the one from the KUBECONFIG environment variable or ~/.kube/config
+	Kubeconfig      string
+	UUID            string        `flag:"uuid"`
+	Timeout         time.Duration `flag:"timeout"`
+	QPS             int           `flag:"qps"`
+	Burst           int           `flag:"burst"`
+	ESServer        string        `flag:"es-server"`
+	ESIndex         string        `flag:"es-index"`
+	LocalIndexing   bool          `flag:"local-indexing"`
+	MetricsEndpoint string        `flag:"metrics-endpoint"`
+	ProfileType     string        `flag:"profile-type"`
+	UserMetadata    string        `flag:"user-metadata"`
+	Alerting        *bool         `flag:"alerting"`
+	CheckHealth     *bool         `flag:"check-health"`
+	GC              *bool         `flag:"gc"`
+	// Flags sets any other flag by name, without dashes, i.e. {"gc-metrics": "true"}, taking precedence over the fields
+	Flags map[string]string
+}
+
+func (o CommonOptions) common() CommonOptions {
+	return o
+}
+
+// ChurnOptions are the churn options of the workloads supporting it
+type ChurnOptions struct {
+	Churn             *bool         `flag:"churn"`
+	Cycles            int           `flag:"churn-cycles"`
+	Duration          time.Duration `flag:"churn-duration"`
+	Delay             time.Duration `flag:"churn-delay"`
+	Percent           int           `flag:"churn-percent"`
+	DeletionStrategy  string        `flag:"churn-deletion-strategy"`
+	Pattern           string        `flag:"churn-pattern"`
+	PatternPhases     int           `flag:"churn-pattern-phases"`
+	PatternMinPercent int           `flag:"churn-pattern-min-percent"`
+}
+
+// ClusterDensityOptions are the options of cluster-density-v2 and cluster-density-ms
+type ClusterDensityOptions struct {
+	CommonOptions
+	// Variant of the workload, cluster-density-v2 by default
+	Variant           string
+	Iterations        int           `flag:"iterations"`
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	Pprof             bool          `flag:"pprof"`
+	ServiceLatency    bool          `flag:"service-latency"`
+	ExtraTemplates    []string      `flag:"extra-template"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+	Churn             ChurnOptions
+}
+
+func (o ClusterDensityOptions) workload() string {
+	return defaultVariant(o.Variant, "cluster-density-v2")
+}
+
+// CrdScaleOptions are the options of crd-scale
+type CrdScaleOptions struct {
+	CommonOptions
+	Iterations        int      `flag:"iterations"`
+	SchemaProperties  int      `flag:"schema-properties"`
+	SchemaDepth       int      `flag:"schema-depth"`
+	SchemaValidations int      `flag:"schema-validations"`
+	SchemaCELRules    int      `flag:"schema-cel-rules"`
+	MetricsProfiles   []string `flag:"metrics-profile"`
+}
+
+func (CrdScaleOptions) workload() string {
+	return "crd-scale"
+}
+
+// NetworkPolicyOptions are the options of network-policy
+type NetworkPolicyOptions struct {
+	CommonOptions
+	Iterations               int           `flag:"iterations"`
+	NetpolReadyThreshold     time.Duration `flag:"netpol-ready-threshold"`
+	PodsPerNamespace         int           `flag:"pods-per-namespace"`
+	NetpolPerNamespace       int           `flag:"netpol-per-namespace"`
+	LocalPods                int           `flag:"local-pods"`
+	PodSelectors             int           `flag:"pod-selectors"`
+	SinglePorts              int           `flag:"single-ports"`
+	PortRanges               int           `flag:"port-ranges"`
+	RemoteNamespaces         int           `flag:"remotes-namespaces"`
+	RemotePods               int           `flag:"remotes-pods"`
+	CIDRs                    int           `flag:"cidrs"`
+	NetworkPolicyLatency     *bool         `flag:"networkpolicy-latency"`
+	ACLConvergence           bool          `flag:"acl-convergence"`
+	ACLConvergenceTimeout    time.Duration `flag:"acl-convergence-timeout"`
+	NetpolEnforcement        bool          `flag:"netpol-enforcement"`
+	NetpolEnforcementTimeout time.Duration `flag:"netpol-enforcement-timeout"`
+	MetricsProfiles          []string      `flag:"metrics-profile"`
+}
+
+func (NetworkPolicyOptions) workload() string {
+	return "network-policy"
+}
+
+// NetworkPolicyLegacyOptions are the options of networkpolicy-multitenant, networkpolicy-matchlabels and networkpolicy-matchexpressions
+type NetworkPolicyLegacyOptions struct {
+	CommonOptions
+	// Variant of the workload, networkpolicy-multitenant by default
+	Variant               string
+	Iterations            int           `flag:"iterations"`
+	ACLConvergence        bool          `flag:"acl-convergence"`
+	ACLConvergenceTimeout time.Duration `flag:"acl-convergence-timeout"`
+	MetricsProfiles       []string      `flag:"metrics-profile"`
+	Churn                 ChurnOptions
+}
+
+func (o NetworkPolicyLegacyOptions) workload() string {
+	return defaultVariant(o.Variant, "networkpolicy-multitenant")
+}
+
+// NodeDensityOptions are the options of node-density
+type NodeDensityOptions struct {
+	CommonOptions
+	PodsPerNode       int           `flag:"pods-per-node"`
+	Pprof             bool          `flag:"pprof"`
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	ContainerImage    string        `flag:"container-image"`
+	PodSpecOverlay    string        `flag:"pod-spec-overlay"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+	Churn             ChurnOptions
+}
+
+func (NodeDensityOptions) workload() string {
+	return "node-density"
+}
+
+// NodeDensityHeavyOptions are the options of node-density-heavy
+type NodeDensityHeavyOptions struct {
+	CommonOptions
+	PodReadyThreshold      time.Duration `flag:"pod-ready-threshold"`
+	Pprof                  bool          `flag:"pprof"`
+	ProbesPeriod           time.Duration `flag:"probes-period"`
+	ReadinessProbePeriod   time.Duration `flag:"readiness-probe-period"`
+	AppImage               string        `flag:"app-image"`
+	AppCPU                 string        `flag:"app-cpu"`
+	AppMemory              string        `flag:"app-memory"`
+	DBImage                string        `flag:"db-image"`
+	DBCPU                  string        `flag:"db-cpu"`
+	DBMemory               string        `flag:"db-memory"`
+	PodsPerNode            int           `flag:"pods-per-node"`
+	NamespacedIterations   *bool         `flag:"namespaced-iterations"`
+	IterationsPerNamespace int           `flag:"iterations-per-namespace"`
+	ServiceLatency         bool          `flag:"service-latency"`
+	MetricsProfiles        []string      `flag:"metrics-profile"`
+	Churn                  ChurnOptions
+}
+
+func (NodeDensityHeavyOptions) workload() string {
+	return "node-density-heavy"
+}
+
+// NodeDensityCNIOptions are the options of node-density-cni
+type NodeDensityCNIOptions struct {
+	CommonOptions
+	PodReadyThreshold      time.Duration `flag:"pod-ready-threshold"`
+	PodsPerNode            int           `flag:"pods-per-node"`
+	Pprof                  bool          `flag:"pprof"`
+	NamespacedIterations   *bool         `flag:"namespaced-iterations"`
+	IterationsPerNamespace int           `flag:"iterations-per-namespace"`
+	ServiceLatency         bool          `flag:"service-latency"`
+	MetricsProfiles        []string      `flag:"metrics-profile"`
+	Churn                  ChurnOptions
+}
+
+func (NodeDensityCNIOptions) workload() string {
+	return "node-density-cni"
+}
+
+// UDNDensityPodsOptions are the options of udn-density-pods
+type UDNDensityPodsOptions struct {
+	CommonOptions
+	Layer3            *bool         `flag:"layer3"`
+	JobPause          string        `flag:"job-pause"`
+	Pprof             bool          `flag:"pprof"`
+	Simple            bool          `flag:"simple"`
+	Networks          int           `flag:"networks"`
+	PodsPerNetwork    int           `flag:"pods-per-network"`
+	VerifyIsolation   bool          `flag:"verify-isolation"`
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	ServiceLatency    bool          `flag:"service-latency"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+	Churn             ChurnOptions
+}
+
+func (UDNDensityPodsOptions) workload() string {
+	return "udn-density-pods"
+}
+
+// PVCDensityOptions are the options of pvc-density
+type PVCDensityOptions struct {
+	CommonOptions
+	Iterations             int      `flag:"iterations"`
+	Provisioner            string   `flag:"provisioner"`
+	StorageClasses         []string `flag:"storage-classes"`
+	ParallelStorageClasses bool     `flag:"parallel-storage-classes"`
+	ClaimSize              string   `flag:"claim-size"`
+	ContainerImage         string   `flag:"container-image"`
+	PVCLifecycleLatency    *bool    `flag:"pvc-lifecycle-latency"`
+	MetricsProfiles        []string `flag:"metrics-profile"`
+	Churn                  ChurnOptions
+}
+
+func (PVCDensityOptions) workload() string {
+	return "pvc-density"
+}
+
+// RDSCoreOptions are the options of rds-core
+type RDSCoreOptions struct {
+	CommonOptions
+	DPDKCores         int           `flag:"dpdk-cores"`
+	Iterations        int           `flag:"iterations"`
+	PerfProfile       string        `flag:"perf-profile"`
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	ServiceLatency    bool          `flag:"service-latency"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+	Churn             ChurnOptions
+}
+
+func (RDSCoreOptions) workload() string {
+	return "rds-core"
+}
+
+// WebBurnerOptions are the options of web-burner-init, web-burner-node-density and web-burner-cluster-density
+type WebBurnerOptions struct {
+	CommonOptions
+	// Variant of the workload, web-burner-init by default
+	Variant           string
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	LimitCount        int           `flag:"limitcount"`
+	Scale             int           `flag:"scale"`
+	BFD               *bool         `flag:"bfd"`
+	CRD               *bool         `flag:"crd"`
+	ICNI              *bool         `flag:"icni"`
+	Probe             bool          `flag:"probe"`
+	SRIOV             *bool         `flag:"sriov"`
+	Bridge            string        `flag:"bridge"`
+	LBPods            int           `flag:"lb-pods"`
+	AppPods           int           `flag:"app-pods"`
+	NormalPods        int           `flag:"normal-pods"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+}
+
+func (o WebBurnerOptions) workload() string {
+	return defaultVariant(o.Variant, "web-burner-init")
+}
+
+// EgressIPOptions are the options of egressip
+type EgressIPOptions struct {
+	CommonOptions
+	PodReadyThreshold     time.Duration `flag:"pod-ready-threshold"`
+	Iterations            int           `flag:"iterations"`
+	ExternalServerIP      string        `flag:"external-server-ip"`
+	EchoServerAddress     string        `flag:"echo-server-address"`
+	VerifyEgressIPs       *bool         `flag:"verify-egress-ips"`
+	Failover              string        `flag:"failover"`
+	FailoverTimeout       time.Duration `flag:"failover-timeout"`
+	AddressesPerIteration int           `flag:"addresses-per-iteration"`
+	MetricsProfiles       []string      `flag:"metrics-profile"`
+}
+
+func (EgressIPOptions) workload() string {
+	return "egressip"
+}
+
+// WhereaboutsOptions are the options of whereabouts
+type WhereaboutsOptions struct {
+	CommonOptions
+	Iterations        int           `flag:"iterations"`
+	Fast              bool          `flag:"fast"`
+	PodReadyThreshold time.Duration `flag:"pod-ready-threshold"`
+	ContainerImage    string        `flag:"container-image"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+}
+
+func (WhereaboutsOptions) workload() string {
+	return "whereabouts"
+}
+
+// VirtDensityOptions are the options of virt-density
+type VirtDensityOptions struct {
+	CommonOptions
+	VMsPerNode        int           `flag:"vms-per-node"`
+	VMIReadyThreshold time.Duration `flag:"vmi-ready-threshold"`
+	VMImage           string        `flag:"vm-image"`
+	VMMemory          string        `flag:"vm-memory"`
+	GuestBootLatency  bool          `flag:"guest-boot-latency"`
+	MetricsProfiles   []string      `flag:"metrics-profile"`
+	Churn             ChurnOptions
+}
+
+func (VirtDensityOptions) workload() string {
+	return "virt-density"
+}
+
+// CustomWorkloadOptions are the options of init, which runs a custom workload from its config file
+type CustomWorkloadOptions struct {
+	CommonOptions
+	Config                 string `flag:"config"`
+	SHA256Sums             string `flag:"sha256sums"`
+	Iterations             int    `flag:"iterations"`
+	IterationsPerNamespace int    `flag:"iterations-per-namespace"`
+	NamespacedIterations   *bool  `flag:"namespaced-iterations"`
+	PodsPerNode            int    `flag:"pods-per-node"`
+	ServiceLatency         bool   `flag:"service-latency"`
+	Churn                  ChurnOptions
+}
+
+func (CustomWorkloadOptions) workload() string {
+	return "init"
+}
+
+// workloadFlags are the options of a workload given by name, with all its flags in CommonOptions.Flags,
+// as the serve and controller subcommands take them
+type workloadFlags struct {
+	CommonOptions
+	name string
+}
+
+func (o workloadFlags) workload() string {
+	return o.name
+}
+
+func defaultVariant(variant, defaultValue string) string {
+	if variant == "" {
+		return defaultValue
+	}
+	return variant
+}
+
+// optionFlags returns the values of the flags set by the given options by name, a slice flag taking one value per element
+func optionFlags(opts WorkloadOptions) map[string][]string {
+	flags := make(map[string][]string)
+	addOptionFlags(reflect.Indirect(reflect.ValueOf(opts)), flags)
+	for name, value := range opts.common().Flags {
+		flags[name] = []string{value}
+	}
+	return flags
+}
+
+func addOptionFlags(v reflect.Value, flags map[string][]string) {
+	for i := range v.NumField() {
+		field, value := v.Type().Field(i), v.Field(i)
+		name := field.Tag.Get("flag")
+		if name == "" {
+			// Embedded CommonOptions and ChurnOptions
+			if value.Kind() == reflect.Struct {
+				addOptionFlags(value, flags)
+			}
+			continue
+		}
+		if value.IsZero() {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Pointer:
+			flags[name] = []string{fmt.Sprint(value.Elem().Interface())}
+		case reflect.Slice:
+			for j := range value.Len() {
+				flags[name] = append(flags[name], fmt.Sprint(value.Index(j).Interface()))
+			}
+		default:
+			flags[name] = []string{fmt.Sprint(value.Interface())}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/utils/ptr"
+)
+
+func TestOptionFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         WorkloadOptions
+		wantWorkload string
+		want         map[string][]string
+	}{
+		{
+			name:         "zero values",
+			opts:         ClusterDensityOptions{},
+			wantWorkload: "cluster-density-v2",
+			want:         map[string][]string{},
+		},
+		{
+			name: "workload, common and churn options",
+			opts: ClusterDensityOptions{
+				CommonOptions:  CommonOptions{Kubeconfig: "kubeconfig", Timeout: time.Hour, GC: ptr.To(false)},
+				Variant:        "cluster-density-ms",
+				Iterations:     10,
+				ExtraTemplates: []string{"a.yml", "b.yml"},
+				Churn:          ChurnOptions{Churn: ptr.To(true), Duration: 5 * time.Minute},
+			},
+			wantWorkload: "cluster-density-ms",
+			want: map[string][]string{
+				"timeout":        {"1h0m0s"},
+				"gc":             {"false"},
+				"iterations":     {"10"},
+				"extra-template": {"a.yml", "b.yml"},
+				"churn":          {"true"},
+				"churn-duration": {"5m0s"},
+			},
+		},
+		{
+			name: "flags taking precedence over the fields",
+			opts: &NodeDensityOptions{
+				CommonOptions: CommonOptions{QPS: 50, Flags: map[string]string{"qps": "100", "gc-metrics": "true"}},
+				PodsPerNode:   100,
+			},
+			wantWorkload: "node-density",
+			want:         map[string][]string{"qps": {"100"}, "gc-metrics": {"true"}, "pods-per-node": {"100"}},
+		},
+		{
+			name:         "workload given by name",
+			opts:         workloadFlags{name: "egressip", CommonOptions: CommonOptions{Flags: map[string]string{"iterations": "5"}}},
+			wantWorkload: "egressip",
+			want:         map[string][]string{"iterations": {"5"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.workload(); got != tt.wantWorkload {
+				t.Errorf("got workload %s, want %s", got, tt.wantWorkload)
+			}
+			if got := optionFlags(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got flags %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errPacingUnsupported is returned for the workloads whose configuration isn't split into job steps
+var errPacingUnsupported = errors.New("pacing not supported")
+
+// paceSteps paces the main job of the given steps so the objects of each iteration are created evenly over the pacing window,
+// instead of as fast as the QPS allows. kube-burner creates the objects of an iteration before starting the next one, so the
+// main job gets a QPS of the objects per iteration over the window, with a burst of 1. The QPS isn't set under 1, the iterations
+// with less objects than seconds in the window wait for the rest of the window once their objects are created
+func paceSteps(workload string, steps []jobStep, window time.Duration) error {
+	objects, err := objectsPerIteration(workload, steps)
+	if err == errPacingUnsupported {
+		log.Warnf("%s doesn't support pacing, creating its objects as fast as the QPS allows", workload)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if objects == 0 {
+		return fmt.Errorf("%s creates no objects per iteration to pace", workload)
+	}
+	main := &steps[len(steps)-1]
+	qps := float64(objects) / window.Seconds()
+	if qps > main.QPS {
+		log.Warnf("%d objects per iteration can't be created in %v at %v QPS, pacing them at %v QPS", objects, window, main.QPS, main.QPS)
+		qps = main.QPS
+	}
+	main.QPS = max(qps, 1)
+	main.Burst = 1
+	if creation := time.Duration(float64(objects) / main.QPS * float64(time.Second)); creation < window {
+		main.IterationDelay = (window - creation).String()
+	}
+	log.Infof("Pacing %d objects per iteration over %v: QPS %.3g, iteration delay %s", objects, window, main.QPS, main.IterationDelay)
+	return nil
+}
+
+// objectsPerIteration renders the configuration of the given workload with the given steps and returns the number of objects
+// its main job, named after the workload, creates in each iteration
+func objectsPerIteration(workload string, steps []jobStep) (int, error) {
+	cfg, err := readWorkloadFile(workload, workload+".yml")
+	if err != nil {
+		return 0, fmt.Errorf("error reading configuration of %s: %v", workload, err)
+	}
+	if !bytes.Contains(cfg, []byte("JOB_STEPS")) {
+		return 0, errPacingUnsupported
+	}
+	jobSteps, _ := json.Marshal(steps)
+	spec, err := renderWorkloadSpec(workload, cfg, map[string]interface{}{"JOB_STEPS": string(jobSteps)})
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range spec.Jobs {
+		if job.Name != workload {
+			continue
+		}
+		var objects int
+		for _, obj := range job.Objects {
+			if !obj.RunOnce {
+				objects += obj.Replicas
+			}
+		}
+		return objects, nil
+	}
+	return 0, fmt.Errorf("job %s not found in its configuration", workload)
+}
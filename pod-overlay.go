@@ -0,0 +1,105 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// templateAction matches the template actions of the object templates, which aren't valid YAML
+var templateAction = regexp.MustCompile(`{{.*?}}`)
+
+// podSpecOverlay strategic-merges the pod given in the overlay file onto the embedded pod template of the given workload,
+// and writes the result to a temporary object template, returning its path. Containers are merged by name, so the overlay
+// must use the names of the template containers to modify them. Template actions like {{.Iteration}} are kept in both files
+func podSpecOverlay(workload, template, overlayFile string) (string, error) {
+	original, err := ocpConfig.ReadFile(path.Join(configDir, workload, template))
+	if err != nil {
+		return "", err
+	}
+	overlay, err := os.ReadFile(overlayFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading pod spec overlay: %v", err)
+	}
+	// The template actions are replaced by placeholders while merging, and restored afterwards
+	var actions []string
+	protect := func(data []byte) []byte {
+		return templateAction.ReplaceAllFunc(data, func(action []byte) []byte {
+			actions = append(actions, string(action))
+			return []byte(fmt.Sprintf("kube-burner-action-%d", len(actions)-1))
+		})
+	}
+	originalJSON, err := yamlToJSON(protect(original))
+	if err != nil {
+		return "", fmt.Errorf("error decoding %s: %v", template, err)
+	}
+	overlayJSON, err := yamlToJSON(protect(overlay))
+	if err != nil {
+		return "", fmt.Errorf("error decoding pod spec overlay %s: %v", overlayFile, err)
+	}
+	mergedJSON, err := strategicpatch.StrategicMergePatch(originalJSON, overlayJSON, corev1.Pod{})
+	if err != nil {
+		return "", fmt.Errorf("error merging pod spec overlay %s: %v", overlayFile, err)
+	}
+	// Catches misspelled fields, which the merge keeps
+	decoder := json.NewDecoder(bytes.NewReader(mergedJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&corev1.Pod{}); err != nil {
+		return "", fmt.Errorf("invalid pod spec overlay %s: %v", overlayFile, err)
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(mergedJSON, &merged); err != nil {
+		return "", err
+	}
+	mergedYAML, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	podTemplate := string(mergedYAML)
+	// Restored from the last one, so kube-burner-action-1 isn't replaced within kube-burner-action-10
+	for i := len(actions) - 1; i >= 0; i-- {
+		podTemplate = strings.ReplaceAll(podTemplate, fmt.Sprintf("kube-burner-action-%d", i), actions[i])
+	}
+	f, err := os.CreateTemp("", "kube-burner-ocp-"+workload+"-*.yml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(podTemplate); err != nil {
+		return "", err
+	}
+	log.Infof("Applied pod spec overlay %s to %s", overlayFile, template)
+	log.Debugf("Pod template:\n%s", podTemplate)
+	return f.Name(), nil
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	return json.Marshal(object)
+}
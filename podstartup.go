@@ -0,0 +1,233 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	podStartupMetric          = "podStartupMeasurement"
+	podStartupQuantilesMetric = "podStartupQuantilesMeasurement"
+)
+
+// podStartup holds the duration of each startup phase of a pod, in milliseconds
+type podStartup struct {
+	Timestamp                time.Time   `json:"timestamp"`
+	UUID                     string      `json:"uuid"`
+	Namespace                string      `json:"namespace"`
+	PodName                  string      `json:"podName"`
+	NodeName                 string      `json:"nodeName"`
+	SchedulingLatency        int         `json:"schedulingLatency"`
+	NetworkingLatency        int         `json:"networkingLatency"`
+	ImagePullLatency         int         `json:"imagePullLatency"`
+	ContainersStartedLatency int         `json:"containersStartedLatency"`
+	ReadyLatency             int         `json:"readyLatency"`
+	MetricName               string      `json:"metricName"`
+	JobName                  string      `json:"jobName,omitempty"`
+	Metadata                 interface{} `json:"metadata,omitempty"`
+}
+
+// podTimestamps holds the timestamps of the startup milestones of a pod
+type podTimestamps struct {
+	namespace, name, nodeName, jobName string
+	created, scheduled, networked      time.Time
+	pulled, started, ready             time.Time
+}
+
+type podStartupWatcher struct {
+	uuid   string
+	stopCh chan struct{}
+	pods   map[string]*podTimestamps
+	mu     sync.Mutex
+}
+
+// startPodStartupWatcher watches the pods created by the run with the given UUID and their events
+// to record when they're scheduled, get their network interface, pull their images, start their containers and become ready
+func startPodStartupWatcher(uuid string) *podStartupWatcher {
+	clientSet, _ := newClientSet()
+	psw := &podStartupWatcher{
+		uuid:   uuid,
+		stopCh: make(chan struct{}),
+		pods:   make(map[string]*podTimestamps),
+	}
+	podLW := cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	})
+	_, podController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: podLW,
+		ObjectType:    &corev1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				psw.handlePod(obj.(*corev1.Pod))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				psw.handlePod(obj.(*corev1.Pod))
+			},
+		},
+	})
+	log.Info("Watching pod startup phases")
+	go podController.Run(psw.stopCh)
+	// AddedInterface events are emitted by Multus once the pod network is configured
+	watchEvents(clientSet, "AddedInterface", psw.stopCh, func(event *corev1.Event) {
+		psw.handleEvent(event, func(pt *podTimestamps, t time.Time) {
+			if t.After(pt.networked) {
+				pt.networked = t
+			}
+		})
+	})
+	watchEvents(clientSet, "Pulled", psw.stopCh, func(event *corev1.Event) {
+		psw.handleEvent(event, func(pt *podTimestamps, t time.Time) {
+			if t.After(pt.pulled) {
+				pt.pulled = t
+			}
+		})
+	})
+	return psw
+}
+
+func (psw *podStartupWatcher) timestamps(namespace, name string) *podTimestamps {
+	key := namespace + "/" + name
+	pt, exists := psw.pods[key]
+	if !exists {
+		pt = &podTimestamps{namespace: namespace, name: name}
+		psw.pods[key] = pt
+	}
+	return pt
+}
+
+// handleEvent records the event timestamp of the pods created by the run, events of other pods are discarded at the end
+func (psw *podStartupWatcher) handleEvent(event *corev1.Event, record func(*podTimestamps, time.Time)) {
+	psw.mu.Lock()
+	defer psw.mu.Unlock()
+	record(psw.timestamps(event.InvolvedObject.Namespace, event.InvolvedObject.Name), eventTimestamp(event))
+}
+
+func (psw *podStartupWatcher) handlePod(pod *corev1.Pod) {
+	psw.mu.Lock()
+	defer psw.mu.Unlock()
+	pt := psw.timestamps(pod.Namespace, pod.Name)
+	pt.jobName = pod.Labels["kube-burner-job"]
+	pt.nodeName = pod.Spec.NodeName
+	pt.created = pod.CreationTimestamp.Time
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case corev1.PodScheduled:
+			pt.scheduled = condition.LastTransitionTime.Time
+		case corev1.PodReady:
+			if pt.ready.IsZero() {
+				pt.ready = condition.LastTransitionTime.Time
+			}
+		}
+	}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Running != nil && containerStatus.State.Running.StartedAt.After(pt.started) {
+			pt.started = containerStatus.State.Running.StartedAt.Time
+		}
+	}
+}
+
+// phases returns the startup phases of the pod, each phase lasts from the previous recorded milestone,
+// so a missing milestone, like the pod network one in clusters without Multus, is accounted in the next phase
+func (pt *podTimestamps) phases() podStartup {
+	ps := podStartup{
+		Timestamp: pt.created.UTC(),
+		Namespace: pt.namespace,
+		PodName:   pt.name,
+		NodeName:  pt.nodeName,
+		JobName:   pt.jobName,
+	}
+	previous := pt.created
+	for _, milestone := range []struct {
+		t       time.Time
+		latency *int
+	}{
+		{pt.scheduled, &ps.SchedulingLatency},
+		{pt.networked, &ps.NetworkingLatency},
+		{pt.pulled, &ps.ImagePullLatency},
+		{pt.started, &ps.ContainersStartedLatency},
+		{pt.ready, &ps.ReadyLatency},
+	} {
+		if milestone.t.IsZero() {
+			continue
+		}
+		*milestone.latency = max(int(milestone.t.Sub(previous).Milliseconds()), 0)
+		if milestone.t.After(previous) {
+			previous = milestone.t
+		}
+	}
+	return ps
+}
+
+// stop stops watching and indexes the startup phases of the pods that became ready, and their quantiles per job
+func (psw *podStartupWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(psw.stopCh)
+	psw.mu.Lock()
+	defer psw.mu.Unlock()
+	var docs, quantiles []interface{}
+	var jobNames []string
+	latencies := make(map[string]map[string][]float64)
+	for _, pt := range psw.pods {
+		// Pods not created by the run have no creation timestamp
+		if pt.created.IsZero() || pt.ready.IsZero() {
+			continue
+		}
+		ps := pt.phases()
+		ps.UUID = wh.UUID
+		ps.MetricName = podStartupMetric
+		ps.Metadata = wh.MetricsMetadata
+		docs = append(docs, ps)
+		if _, exists := latencies[ps.JobName]; !exists {
+			jobNames = append(jobNames, ps.JobName)
+			latencies[ps.JobName] = make(map[string][]float64)
+		}
+		for phase, latency := range map[string]int{
+			"Scheduling":        ps.SchedulingLatency,
+			"Networking":        ps.NetworkingLatency,
+			"ImagePull":         ps.ImagePullLatency,
+			"ContainersStarted": ps.ContainersStartedLatency,
+			"Ready":             ps.ReadyLatency,
+		} {
+			latencies[ps.JobName][phase] = append(latencies[ps.JobName][phase], float64(latency))
+		}
+	}
+	if len(docs) == 0 {
+		log.Warn("No pod startup phases recorded")
+		return
+	}
+	for _, jobName := range jobNames {
+		for phase, values := range latencies[jobName] {
+			q := metrics.NewLatencySummary(values, phase)
+			q.UUID = wh.UUID
+			q.MetricName = podStartupQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(podStartupMetric, docs)
+	indexDocuments(podStartupQuantilesMetric, quantiles)
+}
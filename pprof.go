@@ -0,0 +1,132 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// pprofProfiles maps the supported profiles to their pprof endpoint paths
+var pprofProfiles = map[string]string{
+	"cpu":  "profile?seconds=30",
+	"heap": "heap",
+}
+
+type pprofEndpoint struct {
+	name          string
+	namespace     string
+	labelSelector map[string]string
+	url           string
+}
+
+// pprofComponents holds the pprof endpoints of each component, profiles are collected from the first container of the selected pods
+var pprofComponents = map[string][]pprofEndpoint{
+	"kube-apiserver": {
+		{"kube-apiserver", "openshift-kube-apiserver", map[string]string{"app": "openshift-kube-apiserver"}, "https://localhost:6443/debug/pprof/"},
+	},
+	"etcd": {
+		{"etcd", "openshift-etcd", map[string]string{"app": "etcd"}, "https://localhost:2379/debug/pprof/"},
+	},
+	"ovn": {
+		{"ovnkube-controller", "openshift-ovn-kubernetes", map[string]string{"app": "ovnkube-node"}, "http://localhost:29103/debug/pprof/"},
+		{"ovn-controller", "openshift-ovn-kubernetes", map[string]string{"app": "ovnkube-node"}, "http://localhost:29105/debug/pprof/"},
+		{"ovnk-control-plane", "openshift-ovn-kubernetes", map[string]string{"app": "ovnkube-control-plane"}, "http://localhost:29108/debug/pprof/"},
+	},
+	// ovnkube-node pods run in the host network of every node, so they can reach the kubelet
+	"kubelet": {
+		{"kubelet", "openshift-ovn-kubernetes", map[string]string{"app": "ovnkube-node"}, "https://localhost:10250/debug/pprof/"},
+	},
+}
+
+// PProfTargets returns the pprof targets of the given components and profiles, as a JSON list rendered in the workload templates
+func PProfTargets(components, profiles []string) (string, error) {
+	var targets []map[string]interface{}
+	if len(components) == 0 {
+		return "", nil
+	}
+	clientSet, restConfig := newClientSet()
+	for _, component := range components {
+		endpoints, exists := pprofComponents[component]
+		if !exists {
+			return "", fmt.Errorf("unsupported pprof target %s, supported options are: kube-apiserver, etcd, ovn or kubelet", component)
+		}
+		var auth map[string]interface{}
+		var err error
+		switch component {
+		case "kube-apiserver", "kubelet":
+			auth, err = kubeconfigAuth(restConfig)
+		case "etcd":
+			auth, err = etcdClientAuth(clientSet)
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, profile := range profiles {
+			profilePath, exists := pprofProfiles[profile]
+			if !exists {
+				return "", fmt.Errorf("unsupported pprof profile %s, supported options are: cpu or heap", profile)
+			}
+			for _, endpoint := range endpoints {
+				target := map[string]interface{}{
+					"name":          fmt.Sprintf("%s-%s", endpoint.name, profile),
+					"namespace":     endpoint.namespace,
+					"labelSelector": endpoint.labelSelector,
+					"url":           endpoint.url + profilePath,
+				}
+				for k, v := range auth {
+					target[k] = v
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+	data, err := json.Marshal(targets)
+	return string(data), err
+}
+
+// kubeconfigAuth returns the pprof target credentials from the kubeconfig, either a bearer token or a client certificate
+func kubeconfigAuth(restConfig *rest.Config) (map[string]interface{}, error) {
+	switch {
+	case restConfig.BearerToken != "":
+		return map[string]interface{}{"bearerToken": restConfig.BearerToken}, nil
+	case len(restConfig.CertData) > 0 && len(restConfig.KeyData) > 0:
+		return map[string]interface{}{
+			"cert": base64.StdEncoding.EncodeToString(restConfig.CertData),
+			"key":  base64.StdEncoding.EncodeToString(restConfig.KeyData),
+		}, nil
+	case restConfig.CertFile != "" && restConfig.KeyFile != "":
+		return map[string]interface{}{"certFile": restConfig.CertFile, "keyFile": restConfig.KeyFile}, nil
+	}
+	return nil, fmt.Errorf("kubeconfig credentials not supported by pprof collection, a bearer token or a client certificate is required")
+}
+
+// etcdClientAuth returns the etcd client certificate used by kube-apiserver
+func etcdClientAuth(clientSet kubernetes.Interface) (map[string]interface{}, error) {
+	secret, err := clientSet.CoreV1().Secrets("openshift-etcd").Get(context.TODO(), "etcd-client", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting etcd client certificate: %v", err)
+	}
+	return map[string]interface{}{
+		"cert": base64.StdEncoding.EncodeToString(secret.Data["tls.crt"]),
+		"key":  base64.StdEncoding.EncodeToString(secret.Data["tls.key"]),
+	}, nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	prePullNamespace = "kube-burner-pre-pull"
+	prePullName      = "pre-pull"
+)
+
+// prePullImages pulls the images of the objects of the given workload in every worker node before the run, with a DaemonSet
+// running a container per image, and waits until every image is pulled or the timeout expires. The containers may not even
+// start, like when their image has no shell, since only their image matters
+func prePullImages(workload string, timeout time.Duration) error {
+	defer StartSpan("pre-pull")()
+	images, err := workloadImages(workload)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		log.Warnf("No images found to pre-pull in %s", workload)
+		return nil
+	}
+	clientSet, _ := newClientSet()
+	log.Infof("Pre-pulling %d images in namespace %s: %v", len(images), prePullNamespace, images)
+	defer deletePrePullNamespace(clientSet)
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: prePullNamespace,
+			Labels: map[string]string{
+				"security.openshift.io/scc.podSecurityLabelSync": "false",
+				"pod-security.kubernetes.io/enforce":             "privileged",
+				"pod-security.kubernetes.io/audit":               "privileged",
+				"pod-security.kubernetes.io/warn":                "privileged",
+			},
+		},
+	}
+	if _, err := clientSet.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating namespace %s: %v", prePullNamespace, err)
+	}
+	labels := map[string]string{"app": prePullName}
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: prePullName},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					NodeSelector:                  map[string]string{"node-role.kubernetes.io/worker": ""},
+					Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					TerminationGracePeriodSeconds: ptr.To[int64](0),
+				},
+			},
+		},
+	}
+	// Regular containers instead of init containers, so a container failing to start doesn't block pulling the next images
+	for i, image := range images {
+		ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"sleep", "infinity"},
+		})
+	}
+	if _, err := clientSet.AppsV1().DaemonSets(prePullNamespace).Create(context.TODO(), ds, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating daemonset %s: %v", prePullName, err)
+	}
+	start := time.Now()
+	var pending map[string][]string
+	err = wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		ds, err := clientSet.AppsV1().DaemonSets(prePullNamespace).Get(ctx, prePullName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		pods, err := clientSet.CoreV1().Pods(prePullNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + prePullName})
+		if err != nil {
+			return false, nil
+		}
+		pending = make(map[string][]string)
+		for _, pod := range pods.Items {
+			for _, cs := range pod.Status.ContainerStatuses {
+				if !imagePulled(cs) {
+					pending[pod.Spec.NodeName] = append(pending[pod.Spec.NodeName], cs.Image)
+				}
+			}
+			if len(pod.Status.ContainerStatuses) < len(images) {
+				pending[pod.Spec.NodeName] = append(pending[pod.Spec.NodeName], images...)
+			}
+		}
+		return ds.Status.DesiredNumberScheduled > 0 && len(pods.Items) >= int(ds.Status.DesiredNumberScheduled) && len(pending) == 0, nil
+	})
+	if err != nil {
+		for _, node := range sortedKeys(pending) {
+			log.Warnf("Images not pulled in node %s: %v", node, pending[node])
+		}
+		return fmt.Errorf("images not pulled in %d nodes after %v", len(pending), timeout)
+	}
+	log.Infof("Images pulled in every worker node in %v", time.Since(start).Round(time.Second))
+	return nil
+}
+
+// imagePulled tells whether the image of the container was pulled, a container failing to start after pulling its image
+// counts as pulled too
+func imagePulled(cs corev1.ContainerStatus) bool {
+	if cs.ImageID != "" || cs.State.Running != nil || cs.State.Terminated != nil || cs.LastTerminationState.Terminated != nil {
+		return true
+	}
+	return cs.State.Waiting != nil && slices.Contains([]string{"CreateContainerError", "RunContainerError", "CrashLoopBackOff"}, cs.State.Waiting.Reason)
+}
+
+func deletePrePullNamespace(clientSet kubernetes.Interface) {
+	log.Infof("Deleting namespace %s", prePullNamespace)
+	if err := clientSet.CoreV1().Namespaces().Delete(context.TODO(), prePullNamespace, metav1.DeleteOptions{}); err != nil {
+		log.Errorf("Error deleting namespace %s: %v", prePullNamespace, err)
+		return
+	}
+	// The pods are removed before the run starts, so they don't add to its load
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+		_, err := clientSet.CoreV1().Namespaces().Get(ctx, prePullNamespace, metav1.GetOptions{})
+		return kerrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		log.Warnf("Namespace %s not deleted yet", prePullNamespace)
+	}
+}
+
+// workloadImages returns the images of the containers and container disks of the objects created by the given workload,
+// rendering its configuration and object templates as kube-burner does for the first iteration
+func workloadImages(workload string) ([]string, error) {
+	cfg, err := readWorkloadFile(workload, workload+".yml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration of %s: %v", workload, err)
+	}
+	overrides := make(map[string]interface{})
+	// JOB_STEPS is set later by the run, a single step is enough to know the objects
+	if _, ok := os.LookupEnv("JOB_STEPS"); !ok {
+		iterations, _ := strconv.Atoi(os.Getenv("JOB_ITERATIONS"))
+		qps, _ := strconv.Atoi(os.Getenv("QPS"))
+		burst, _ := strconv.Atoi(os.Getenv("BURST"))
+		jobSteps, _ := json.Marshal(rampSchedule(iterations, qps, burst, 0, 0, 0))
+		overrides["JOB_STEPS"] = string(jobSteps)
+	}
+	spec, err := renderWorkloadSpec(workload, cfg, overrides)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, job := range spec.Jobs {
+		for _, obj := range job.Objects {
+			if obj.ObjectTemplate == "" {
+				continue
+			}
+			template, err := readWorkloadFile(workload, obj.ObjectTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("error reading object template %s: %v", obj.ObjectTemplate, err)
+			}
+			templateData := map[string]interface{}{
+				"JobName":   job.Name,
+				"Iteration": 0,
+				"Replica":   1,
+				"UUID":      os.Getenv("UUID"),
+			}
+			for k, v := range obj.InputVars {
+				templateData[k] = v
+			}
+			rendered, err := util.RenderTemplate(template, templateData, util.MissingKeyZero)
+			if err != nil {
+				log.Warnf("Images of %s not pre-pulled: %v", obj.ObjectTemplate, err)
+				continue
+			}
+			var object yaml.Node
+			if err := yaml.Unmarshal(rendered, &object); err != nil {
+				log.Warnf("Images of %s not pre-pulled: %v", obj.ObjectTemplate, err)
+				continue
+			}
+			for _, image := range objectImages(&object, "") {
+				if !slices.Contains(images, image) {
+					images = append(images, image)
+				}
+			}
+		}
+	}
+	return images, nil
+}
+
+// objectImages walks the given object returning the images of its containers, init containers and container disks at any depth,
+// so the pod templates of any kind of object are covered. The object is walked as a YAML node, since like kube-burner, the
+// templates with duplicated keys are accepted
+func objectImages(node *yaml.Node, parent string) []string {
+	var images []string
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			images = append(images, objectImages(n, parent)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "image" && value.Kind == yaml.ScalarNode && value.Value != "" && (parent == "containers" || parent == "initContainers" || parent == "containerDisk") {
+				images = append(images, value.Value)
+			}
+			images = append(images, objectImages(value, key)...)
+		}
+	case yaml.SequenceNode:
+		// The items of a list keep the key of the list as parent
+		for _, n := range node.Content {
+			images = append(images, objectImages(n, parent)...)
+		}
+	}
+	return images
+}
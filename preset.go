@@ -0,0 +1,124 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// applyPreset sets the flags of the given command not given in the command line from the preset file. The top level keys of
+// the preset are flag names, applied to every command having them, and the keys named after a command hold the flags only
+// applied to that command, overriding the top level ones, i.e.
+//
+//	gc-metrics: true
+//	node-density:
+//	  pods-per-node: 245
+func applyPreset(cmd *cobra.Command, presetFile string) error {
+	data, err := os.ReadFile(presetFile)
+	if err != nil {
+		return fmt.Errorf("error reading preset: %v", err)
+	}
+	var preset map[string]interface{}
+	if err := yaml.Unmarshal(data, &preset); err != nil {
+		return fmt.Errorf("error decoding preset %s: %v", presetFile, err)
+	}
+	flags := make(map[string]interface{})
+	// Flags of the given command, which must exist
+	var commandFlags map[string]interface{}
+	for name, value := range preset {
+		if section, ok := value.(map[string]interface{}); ok && isSubcommand(cmd.Root(), name) {
+			if name == cmd.Name() {
+				commandFlags = section
+			}
+			continue
+		}
+		flags[name] = value
+	}
+	for name, value := range commandFlags {
+		if cmd.Flags().Lookup(name) == nil {
+			return fmt.Errorf("preset %s: unknown flag %s of %s", presetFile, name, cmd.Name())
+		}
+		flags[name] = value
+	}
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var applied int
+	for _, name := range names {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			log.Debugf("Preset flag %s doesn't apply to %s", name, cmd.Name())
+			continue
+		}
+		// Command line flags override the preset
+		if flag.Changed {
+			continue
+		}
+		values := []string{presetValue(flags[name])}
+		// The values of array flags may hold commas, so they're set one by one
+		if items, ok := flags[name].([]interface{}); ok && flag.Value.Type() == "stringArray" {
+			values = values[:0]
+			for _, item := range items {
+				values = append(values, fmt.Sprint(item))
+			}
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return fmt.Errorf("preset %s: invalid value of flag %s: %v", presetFile, name, err)
+			}
+		}
+		applied++
+	}
+	log.Infof("Applied %d flags from preset %s", applied, presetFile)
+	return nil
+}
+
+func isSubcommand(root *cobra.Command, name string) bool {
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// presetValue returns the flag value of the given preset value, lists are comma separated and maps are key=value pairs
+func presetValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,388 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	preemptionNamespace       = "kube-burner-preemption"
+	preemptionPriorityClass   = "kube-burner-preemption"
+	preemptionLatencyMetric   = "preemptionLatency"
+	preemptionQuantilesMetric = "preemptionLatencyQuantiles"
+	preemptionVictimMetric    = "preemptionVictim"
+	preemptionImage           = "registry.k8s.io/pause:3.1"
+)
+
+// ensurePriorityClass creates the given PriorityClass with the given value when it doesn't exist, returning the function
+// deleting it once the run finishes. The existing ones are kept
+func ensurePriorityClass(name string, value int32) (func(), error) {
+	clientSet, _ := newClientSet()
+	if pc, err := clientSet.SchedulingV1().PriorityClasses().Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+		log.Infof("Using the existing PriorityClass %s with value %d", name, pc.Value)
+		return func() {}, nil
+	} else if !kerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting PriorityClass %s: %v", name, err)
+	}
+	if err := createPriorityClass(clientSet, name, value); err != nil {
+		return nil, err
+	}
+	log.Infof("Created PriorityClass %s with value %d", name, value)
+	return func() { deletePriorityClass(clientSet, name) }, nil
+}
+
+func createPriorityClass(clientSet kubernetes.Interface, name string, value int32) error {
+	pc := &schedulingv1.PriorityClass{
+		ObjectMeta:       metav1.ObjectMeta{Name: name},
+		Value:            value,
+		PreemptionPolicy: ptr.To(corev1.PreemptLowerPriority),
+		Description:      "Created by kube-burner-ocp",
+	}
+	if _, err := clientSet.SchedulingV1().PriorityClasses().Create(context.TODO(), pc, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating PriorityClass %s: %v", name, err)
+	}
+	return nil
+}
+
+func deletePriorityClass(clientSet kubernetes.Interface, name string) {
+	log.Infof("Deleting PriorityClass %s", name)
+	if err := clientSet.SchedulingV1().PriorityClasses().Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		log.Errorf("Error deleting PriorityClass %s: %v", name, err)
+	}
+}
+
+// preemptionLatency holds the latencies of a high priority pod created by the preemption phase, in milliseconds
+type preemptionLatency struct {
+	Timestamp         time.Time   `json:"timestamp"`
+	UUID              string      `json:"uuid"`
+	PodName           string      `json:"podName"`
+	NodeName          string      `json:"nodeName"`
+	Preempted         bool        `json:"preempted"`
+	NominationLatency int         `json:"nominationLatency,omitempty"`
+	SchedulingLatency int         `json:"schedulingLatency"`
+	ReadyLatency      int         `json:"readyLatency"`
+	MetricName        string      `json:"metricName"`
+	Metadata          interface{} `json:"metadata,omitempty"`
+}
+
+// preemptionVictim holds a pod of the workload preempted by the preemption phase, its eviction latency is the time since
+// it's marked as preempted until it's deleted, in milliseconds
+type preemptionVictim struct {
+	Timestamp       time.Time   `json:"timestamp"`
+	UUID            string      `json:"uuid"`
+	Namespace       string      `json:"namespace"`
+	PodName         string      `json:"podName"`
+	NodeName        string      `json:"nodeName"`
+	Evicted         bool        `json:"evicted"`
+	EvictionLatency int         `json:"evictionLatency,omitempty"`
+	MetricName      string      `json:"metricName"`
+	JobName         string      `json:"jobName,omitempty"`
+	Metadata        interface{} `json:"metadata,omitempty"`
+}
+
+type preemptorPod struct {
+	name, nodeName                       string
+	created, nominated, scheduled, ready time.Time
+}
+
+type victimPod struct {
+	namespace, name, nodeName, jobName string
+	preempted, deleted                 time.Time
+}
+
+type preemptionWatcher struct {
+	preemptors map[string]*preemptorPod
+	victims    map[string]*victimPod
+	mu         sync.Mutex
+}
+
+// runPreemption floods the worker nodes with the given number of pods with a PriorityClass of the given value, once the workload
+// filled them, so the scheduler preempts the pods of the workload to make room for them. It measures the time the high priority
+// pods take to be nominated to a node, scheduled and ready, and the time the pods of the run with the given UUID take to be
+// evicted once preempted
+func runPreemption(wh *workloads.WorkloadHelper, pods int, priority int32, timeout time.Duration) error {
+	defer StartSpan("preemption")()
+	clientSet, _ := newClientSet()
+	if err := createPriorityClass(clientSet, preemptionPriorityClass, priority); err != nil {
+		return err
+	}
+	defer deletePriorityClass(clientSet, preemptionPriorityClass)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: preemptionNamespace}}
+	if _, err := clientSet.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating namespace %s: %v", preemptionNamespace, err)
+	}
+	defer deletePreemptionNamespace(clientSet)
+	pw := &preemptionWatcher{
+		preemptors: make(map[string]*preemptorPod),
+		victims:    make(map[string]*victimPod),
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	pw.watch(clientSet, wh.UUID, stopCh)
+	log.Infof("Creating %d pods with priority %d in namespace %s", pods, priority, preemptionNamespace)
+	start := time.Now()
+	for i := range pods {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("preemptor-%d", i),
+				Labels: map[string]string{"app": "preemptor"},
+			},
+			Spec: corev1.PodSpec{
+				PriorityClassName:             preemptionPriorityClass,
+				NodeSelector:                  map[string]string{"node-role.kubernetes.io/worker": ""},
+				TerminationGracePeriodSeconds: ptr.To[int64](0),
+				Containers: []corev1.Container{{
+					Name:            "preemptor",
+					Image:           preemptionImage,
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("10m"),
+							corev1.ResourceMemory: resource.MustParse("10Mi"),
+						},
+					},
+				}},
+			},
+		}
+		if _, err := clientSet.CoreV1().Pods(preemptionNamespace).Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+			log.Errorf("Error creating pod %s: %v", pod.Name, err)
+		}
+	}
+	err := wait.PollUntilContextTimeout(context.TODO(), time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		var ready int
+		for _, p := range pw.preemptors {
+			if !p.ready.IsZero() {
+				ready++
+			}
+		}
+		return ready >= pods, nil
+	})
+	if err != nil {
+		log.Warnf("Not every high priority pod was ready after %v", timeout)
+	} else {
+		log.Infof("High priority pods ready in %v", time.Since(start).Round(time.Second))
+	}
+	pw.index(wh)
+	return nil
+}
+
+// watch records the milestones of the high priority pods and the preemption and deletion of the pods of the run
+func (pw *preemptionWatcher) watch(clientSet kubernetes.Interface, uuid string, stopCh chan struct{}) {
+	_, preemptorController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", preemptionNamespace, fields.Everything()),
+		ObjectType:    &corev1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				pw.handlePreemptor(obj.(*corev1.Pod))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				pw.handlePreemptor(obj.(*corev1.Pod))
+			},
+		},
+	})
+	victimLW := cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	})
+	_, victimController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: victimLW,
+		ObjectType:    &corev1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(_, obj interface{}) {
+				pw.handleVictim(obj.(*corev1.Pod))
+			},
+			DeleteFunc: func(obj interface{}) {
+				pod, ok := obj.(*corev1.Pod)
+				if !ok {
+					tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+					if !ok {
+						return
+					}
+					if pod, ok = tombstone.Obj.(*corev1.Pod); !ok {
+						return
+					}
+				}
+				pw.mu.Lock()
+				defer pw.mu.Unlock()
+				if victim, ok := pw.victims[pod.Namespace+"/"+pod.Name]; ok && victim.deleted.IsZero() {
+					victim.deleted = time.Now().UTC()
+				}
+			},
+		},
+	})
+	go preemptorController.Run(stopCh)
+	go victimController.Run(stopCh)
+	cache.WaitForCacheSync(stopCh, preemptorController.HasSynced, victimController.HasSynced)
+}
+
+func (pw *preemptionWatcher) handlePreemptor(pod *corev1.Pod) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	p, ok := pw.preemptors[pod.Name]
+	if !ok {
+		p = &preemptorPod{name: pod.Name, created: pod.CreationTimestamp.UTC()}
+		pw.preemptors[pod.Name] = p
+	}
+	// The nominated node is set when the scheduler preempts pods to make room for the pod
+	if pod.Status.NominatedNodeName != "" && p.nominated.IsZero() {
+		p.nominated = time.Now().UTC()
+	}
+	p.nodeName = pod.Spec.NodeName
+	for _, c := range pod.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case corev1.PodScheduled:
+			if p.scheduled.IsZero() {
+				p.scheduled = c.LastTransitionTime.UTC()
+			}
+		case corev1.PodReady:
+			if p.ready.IsZero() {
+				p.ready = c.LastTransitionTime.UTC()
+			}
+		}
+	}
+}
+
+// handleVictim records the pods of the run marked by the scheduler as preempted
+func (pw *preemptionWatcher) handleVictim(pod *corev1.Pod) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type != corev1.DisruptionTarget || c.Status != corev1.ConditionTrue || c.Reason != "PreemptionByScheduler" {
+			continue
+		}
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		key := pod.Namespace + "/" + pod.Name
+		if _, ok := pw.victims[key]; !ok {
+			pw.victims[key] = &victimPod{
+				namespace: pod.Namespace,
+				name:      pod.Name,
+				nodeName:  pod.Spec.NodeName,
+				jobName:   pod.Labels["kube-burner-job"],
+				preempted: c.LastTransitionTime.UTC(),
+			}
+		}
+		return
+	}
+}
+
+// index indexes the latencies of the high priority pods, their quantiles and the victims of the preemption
+func (pw *preemptionWatcher) index(wh *workloads.WorkloadHelper) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	var docs, victimDocs, quantiles []interface{}
+	latencies := make(map[string][]float64)
+	var preempted int
+	for _, name := range sortedKeys(pw.preemptors) {
+		p := pw.preemptors[name]
+		doc := preemptionLatency{
+			Timestamp:  p.created,
+			UUID:       wh.UUID,
+			PodName:    p.name,
+			NodeName:   p.nodeName,
+			Preempted:  !p.nominated.IsZero(),
+			MetricName: preemptionLatencyMetric,
+			Metadata:   wh.MetricsMetadata,
+		}
+		if doc.Preempted {
+			preempted++
+			doc.NominationLatency = int(p.nominated.Sub(p.created).Milliseconds())
+			latencies["Nominated"] = append(latencies["Nominated"], float64(doc.NominationLatency))
+		}
+		if !p.scheduled.IsZero() {
+			doc.SchedulingLatency = int(p.scheduled.Sub(p.created).Milliseconds())
+			latencies["PodScheduled"] = append(latencies["PodScheduled"], float64(doc.SchedulingLatency))
+		}
+		if !p.ready.IsZero() {
+			doc.ReadyLatency = int(p.ready.Sub(p.created).Milliseconds())
+			latencies["Ready"] = append(latencies["Ready"], float64(doc.ReadyLatency))
+		}
+		docs = append(docs, doc)
+	}
+	var evicted int
+	for _, key := range sortedKeys(pw.victims) {
+		v := pw.victims[key]
+		doc := preemptionVictim{
+			Timestamp:  v.preempted,
+			UUID:       wh.UUID,
+			Namespace:  v.namespace,
+			PodName:    v.name,
+			NodeName:   v.nodeName,
+			Evicted:    !v.deleted.IsZero(),
+			MetricName: preemptionVictimMetric,
+			JobName:    v.jobName,
+			Metadata:   wh.MetricsMetadata,
+		}
+		if doc.Evicted {
+			evicted++
+			doc.EvictionLatency = int(v.deleted.Sub(v.preempted).Milliseconds())
+			latencies["Evicted"] = append(latencies["Evicted"], float64(doc.EvictionLatency))
+		}
+		victimDocs = append(victimDocs, doc)
+	}
+	if len(docs) == 0 {
+		log.Warn("No high priority pods recorded")
+		return
+	}
+	log.Infof("%d high priority pods, %d of them preempted other pods, %d pods of the workload preempted and %d evicted", len(docs), preempted, len(victimDocs), evicted)
+	for _, quantile := range sortedKeys(latencies) {
+		q := metrics.NewLatencySummary(latencies[quantile], quantile)
+		q.UUID = wh.UUID
+		q.MetricName = preemptionQuantilesMetric
+		q.Metadata = wh.MetricsMetadata
+		log.Infof("Preemption %s latency: P99 %dms, max %dms", quantile, q.P99, q.Max)
+		quantiles = append(quantiles, q)
+	}
+	indexDocuments(preemptionLatencyMetric, docs)
+	indexDocuments(preemptionQuantilesMetric, quantiles)
+	if len(victimDocs) > 0 {
+		indexDocuments(preemptionVictimMetric, victimDocs)
+	}
+}
+
+func deletePreemptionNamespace(clientSet kubernetes.Interface) {
+	log.Infof("Deleting namespace %s", preemptionNamespace)
+	if err := clientSet.CoreV1().Namespaces().Delete(context.TODO(), preemptionNamespace, metav1.DeleteOptions{}); err != nil {
+		log.Errorf("Error deleting namespace %s: %v", preemptionNamespace, err)
+		return
+	}
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
+		_, err := clientSet.CoreV1().Namespaces().Get(ctx, preemptionNamespace, metav1.GetOptions{})
+		return kerrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		log.Warnf("Namespace %s not deleted yet", preemptionNamespace)
+	}
+}
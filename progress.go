@@ -0,0 +1,255 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// progress holds the gauges of the live progress of the run, exposed in /metrics or pushed to a Pushgateway
+var progress struct {
+	enabled       bool
+	mu            sync.Mutex
+	phases        []string
+	phase         *prometheus.GaugeVec
+	namespaces    prometheus.Gauge
+	pods          prometheus.Gauge
+	podsReady     prometheus.Gauge
+	errors        prometheus.Counter
+	pusher        *push.Pusher
+	stopCh        chan struct{}
+	podsReadySet  map[string]bool
+	podsCreated   map[string]bool
+	namespacesSet map[string]bool
+}
+
+// trackProgress tracks the progress of the runs even when it's not exposed nor pushed, so the controller can report it
+var trackProgress bool
+
+// runProgress is a snapshot of the progress of the run
+type runProgress struct {
+	Phase      string `json:"phase"`
+	Namespaces int    `json:"namespaces"`
+	Pods       int    `json:"pods"`
+	PodsReady  int    `json:"podsReady"`
+}
+
+// errorCounter is a logrus hook counting the errors logged by kube-burner and kube-burner-ocp
+type errorCounter struct{}
+
+func (errorCounter) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+func (errorCounter) Fire(*log.Entry) error {
+	progress.errors.Inc()
+	return nil
+}
+
+// StartProgressExporter exposes the live progress of the run in the /metrics endpoint of the given listen address,
+// and pushes it to the given Pushgateway every interval, when set. The objects created are counted by watching
+// the namespaces and pods labeled with the UUID of the run
+func StartProgressExporter(listenAddress, pushgateway string, interval time.Duration, workload, uuid string) {
+	if listenAddress == "" && pushgateway == "" && !trackProgress {
+		return
+	}
+	registry := prometheus.NewRegistry()
+	constLabels := prometheus.Labels{"uuid": uuid, "workload": workload}
+	progress.phase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "kube_burner_ocp_phase",
+		Help:        "Current phase of the run, set to 1",
+		ConstLabels: constLabels,
+	}, []string{"phase"})
+	progress.namespaces = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "kube_burner_ocp_namespaces_created",
+		Help:        "Namespaces created by the run",
+		ConstLabels: constLabels,
+	})
+	progress.pods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "kube_burner_ocp_pods_created",
+		Help:        "Pods created by the run",
+		ConstLabels: constLabels,
+	})
+	progress.podsReady = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "kube_burner_ocp_pods_ready",
+		Help:        "Pods created by the run that became ready",
+		ConstLabels: constLabels,
+	})
+	progress.errors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "kube_burner_ocp_errors_total",
+		Help:        "Errors logged during the run",
+		ConstLabels: constLabels,
+	})
+	startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "kube_burner_ocp_start_time_seconds",
+		Help:        "Start time of the run since the epoch in seconds",
+		ConstLabels: constLabels,
+	})
+	startTime.SetToCurrentTime()
+	registry.MustRegister(progress.phase, progress.namespaces, progress.pods, progress.podsReady, progress.errors, startTime)
+	progress.stopCh = make(chan struct{})
+	progress.podsCreated = make(map[string]bool)
+	progress.podsReadySet = make(map[string]bool)
+	progress.namespacesSet = make(map[string]bool)
+	progress.enabled = true
+	log.AddHook(errorCounter{})
+	watchProgress(uuid)
+	if listenAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		go func() {
+			if err := http.ListenAndServe(listenAddress, mux); err != nil {
+				log.Errorf("Error serving the progress metrics in %s: %v", listenAddress, err)
+			}
+		}()
+		log.Infof("Exposing the run progress in http://%s/metrics", listenAddress)
+	}
+	if pushgateway != "" {
+		progress.pusher = push.New(pushgateway, "kube-burner-ocp").Grouping("uuid", uuid).Gatherer(registry)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					pushProgress()
+				case <-progress.stopCh:
+					return
+				}
+			}
+		}()
+		log.Infof("Pushing the run progress to %s every %v", pushgateway, interval)
+	}
+}
+
+// watchProgress counts the namespaces and pods created by the run, and the pods that became ready
+func watchProgress(uuid string) {
+	clientSet, _ := newClientSet()
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	_, nsController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "namespaces", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.Namespace{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				progress.mu.Lock()
+				defer progress.mu.Unlock()
+				progress.namespacesSet[obj.(*corev1.Namespace).Name] = true
+				progress.namespaces.Set(float64(len(progress.namespacesSet)))
+			},
+		},
+	})
+	podHandler := func(obj interface{}) {
+		pod := obj.(*corev1.Pod)
+		key := pod.Namespace + "/" + pod.Name
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		progress.podsCreated[key] = true
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				progress.podsReadySet[key] = true
+			}
+		}
+		progress.pods.Set(float64(len(progress.podsCreated)))
+		progress.podsReady.Set(float64(len(progress.podsReadySet)))
+	}
+	_, podController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    podHandler,
+			UpdateFunc: func(_, obj interface{}) { podHandler(obj) },
+		},
+	})
+	go nsController.Run(progress.stopCh)
+	go podController.Run(progress.stopCh)
+}
+
+func pushProgress() {
+	if err := progress.pusher.Push(); err != nil {
+		log.Warnf("Error pushing the run progress: %v", err)
+	}
+}
+
+// setPhase sets the current phase of the run, returning the function restoring the previous one
+func setPhase(phase string) func() {
+	if !progress.enabled {
+		return func() {}
+	}
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.phases = append(progress.phases, phase)
+	progress.phase.Reset()
+	progress.phase.WithLabelValues(phase).Set(1)
+	return func() {
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		// Phases may end out of order when they run concurrently
+		for i := len(progress.phases) - 1; i >= 0; i-- {
+			if progress.phases[i] == phase {
+				progress.phases = append(progress.phases[:i], progress.phases[i+1:]...)
+				break
+			}
+		}
+		progress.phase.Reset()
+		if len(progress.phases) > 0 {
+			progress.phase.WithLabelValues(progress.phases[len(progress.phases)-1]).Set(1)
+		}
+	}
+}
+
+// progressSnapshot returns the current progress of the run, and whether it's tracked
+func progressSnapshot() (runProgress, bool) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if !progress.enabled {
+		return runProgress{}, false
+	}
+	snapshot := runProgress{
+		Namespaces: len(progress.namespacesSet),
+		Pods:       len(progress.podsCreated),
+		PodsReady:  len(progress.podsReadySet),
+	}
+	if len(progress.phases) > 0 {
+		snapshot.Phase = progress.phases[len(progress.phases)-1]
+	}
+	return snapshot, true
+}
+
+// StopProgressExporter stops watching the objects of the run and pushes the final progress
+func StopProgressExporter() {
+	if !progress.enabled {
+		return
+	}
+	setPhase("finished")
+	progress.mu.Lock()
+	progress.enabled = false
+	progress.mu.Unlock()
+	close(progress.stopCh)
+	if progress.pusher != nil {
+		pushProgress()
+	}
+}
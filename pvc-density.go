@@ -15,14 +15,16 @@
 package ocp
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var dynamicStorageProvisioners = map[string]string{
@@ -39,9 +41,12 @@ var dynamicStorageProvisioners = map[string]string{
 func NewPVCDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 
 	var iterations int
-	var storageProvisioners, metricsProfiles []string
+	var storageProvisioners, storageClasses, metricsProfiles []string
+	var parallelStorageClasses bool
 	var claimSize string
 	var containerImage string
+	var pvcLifecycleLatency bool
+	var churn *churnFlags
 	var rc int
 	provisioner := "aws"
 
@@ -49,7 +54,10 @@ func NewPVCDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 		Use:          "pvc-density",
 		Short:        "Runs pvc-density workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
 			os.Setenv("CONTAINER_IMAGE", containerImage)
 			os.Setenv("CLAIM_SIZE", fmt.Sprint(claimSize))
@@ -59,14 +67,29 @@ func NewPVCDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 			}
 			re := regexp.MustCompile(`(?sm)^(cinder|azure\-disk|azure\-file|gce|ibm|vsphere|aws)$`)
 			if !re.MatchString(provisioner) {
-				log.Fatal(fmt.Errorf("%s does not match one of %s", provisioner, storageProvisioners))
+				return fmt.Errorf("%s does not match one of %s", provisioner, storageProvisioners)
 			}
 
 			os.Setenv("STORAGE_PROVISIONER", fmt.Sprint(dynamicStorageProvisioners[provisioner]))
+			if parallelStorageClasses && len(storageClasses) == 0 {
+				return fmt.Errorf("--parallel-storage-classes requires --storage-classes")
+			}
+			if len(storageClasses) > 0 {
+				if err := checkStorageClasses(storageClasses); err != nil {
+					return err
+				}
+			}
+			os.Setenv("STORAGE_CLASSES", strings.Join(storageClasses, ","))
+			os.Setenv("PARALLEL_STORAGE_CLASSES", fmt.Sprint(parallelStorageClasses))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -75,8 +98,28 @@ func NewPVCDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 
 	cmd.Flags().IntVar(&iterations, "iterations", 0, fmt.Sprintf("%v iterations", iterations))
 	cmd.Flags().StringVar(&provisioner, "provisioner", provisioner, fmt.Sprintf("[%s]", strings.Join(storageProvisioners, " ")))
+	cmd.Flags().StringSliceVar(&storageClasses, "storage-classes", nil, "Comma separated list of existing storage classes to run the workload against, instead of creating a default one with --provisioner")
+	cmd.Flags().BoolVar(&parallelStorageClasses, "parallel-storage-classes", false, "Run the workload against all the storage classes at once, instead of one job per storage class")
 	cmd.Flags().StringVar(&claimSize, "claim-size", "256Mi", "claim-size=256Mi")
 	cmd.Flags().StringVar(&containerImage, "container-image", "gcr.io/google_containers/pause:3.1", "Container image")
+	cmd.Flags().BoolVar(&pvcLifecycleLatency, "pvc-lifecycle-latency", true, "Measure the binding, attach and mount latencies of the PVCs per storage class")
+	churn = addChurnFlags(cmd, false, time.Hour)
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
+
+// checkStorageClasses returns an error when any of the given storage classes doesn't exist, or is listed twice
+func checkStorageClasses(storageClasses []string) error {
+	clientSet, _ := newClientSet()
+	seen := make(map[string]bool)
+	for _, storageClass := range storageClasses {
+		if seen[storageClass] {
+			return fmt.Errorf("storage class %s given more than once", storageClass)
+		}
+		seen[storageClass] = true
+		if _, err := clientSet.StorageV1().StorageClasses().Get(context.TODO(), storageClass, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("error getting storage class %s: %v", storageClass, err)
+		}
+	}
+	return nil
+}
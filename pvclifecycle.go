@@ -0,0 +1,262 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	pvcLifecycleMetric          = "pvcLifecycleMeasurement"
+	pvcLifecycleQuantilesMetric = "pvcLifecycleQuantilesMeasurement"
+)
+
+// AttachVolume.Attach succeeded for volume "pvc-6f0c1a8e-93d1-4b4e-8c39-1d2a6c1c0f2b"
+var attachedVolumeRegex = regexp.MustCompile(`AttachVolume.Attach succeeded for volume "([^"]+)"`)
+
+// pvcLifecycle holds the duration of each lifecycle phase of a PVC, in milliseconds
+type pvcLifecycle struct {
+	Timestamp      time.Time   `json:"timestamp"`
+	UUID           string      `json:"uuid"`
+	Namespace      string      `json:"namespace"`
+	Name           string      `json:"pvcName"`
+	StorageClass   string      `json:"storageClass"`
+	BindingLatency int         `json:"bindingLatency"`
+	AttachLatency  int         `json:"attachLatency"`
+	MountLatency   int         `json:"mountLatency"`
+	MetricName     string      `json:"metricName"`
+	JobName        string      `json:"jobName,omitempty"`
+	Metadata       interface{} `json:"metadata,omitempty"`
+}
+
+// pvcLifecycleQuantiles holds the quantiles of a lifecycle phase of the PVCs of a storage class
+type pvcLifecycleQuantiles struct {
+	metrics.LatencyQuantiles
+	StorageClass string `json:"storageClass"`
+}
+
+// pvcTimestamps holds the timestamps of the lifecycle milestones of a PVC
+type pvcTimestamps struct {
+	namespace, name, storageClass, volumeName, jobName string
+	created, bound, attached, mounted                  time.Time
+}
+
+type pvcLifecycleWatcher struct {
+	stopCh chan struct{}
+	pvcs   map[string]*pvcTimestamps
+	// attachments holds the attach time of each volume, PVCs are bound to their volume name after the attachment is reported
+	attachments map[string]time.Time
+	// mounts holds the time when the first pod using each PVC started its containers, which requires the volume to be mounted
+	mounts map[string]time.Time
+	mu     sync.Mutex
+}
+
+// startPVCLifecycleWatcher watches the PVCs and pods created by the run with the given UUID
+// to record when each PVC is bound, its volume attached to a node and mounted by a pod
+func startPVCLifecycleWatcher(uuid string) *pvcLifecycleWatcher {
+	clientSet, _ := newClientSet()
+	plw := &pvcLifecycleWatcher{
+		stopCh:      make(chan struct{}),
+		pvcs:        make(map[string]*pvcTimestamps),
+		attachments: make(map[string]time.Time),
+		mounts:      make(map[string]time.Time),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	_, pvcController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "persistentvolumeclaims", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.PersistentVolumeClaim{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				plw.handlePVC(obj.(*corev1.PersistentVolumeClaim))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				plw.handlePVC(obj.(*corev1.PersistentVolumeClaim))
+			},
+		},
+	})
+	_, podController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				plw.handlePod(obj.(*corev1.Pod))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				plw.handlePod(obj.(*corev1.Pod))
+			},
+		},
+	})
+	log.Info("Watching PVC lifecycle")
+	go pvcController.Run(plw.stopCh)
+	go podController.Run(plw.stopCh)
+	watchEvents(clientSet, "SuccessfulAttachVolume", plw.stopCh, plw.handleAttachEvent)
+	return plw
+}
+
+func (plw *pvcLifecycleWatcher) handlePVC(pvc *corev1.PersistentVolumeClaim) {
+	plw.mu.Lock()
+	defer plw.mu.Unlock()
+	key := pvc.Namespace + "/" + pvc.Name
+	pt, exists := plw.pvcs[key]
+	if !exists {
+		pt = &pvcTimestamps{
+			namespace: pvc.Namespace,
+			name:      pvc.Name,
+			jobName:   pvc.Labels["kube-burner-job"],
+			created:   pvc.CreationTimestamp.Time,
+		}
+		if pvc.Spec.StorageClassName != nil {
+			pt.storageClass = *pvc.Spec.StorageClassName
+		}
+		plw.pvcs[key] = pt
+	}
+	// PVCs don't record when they were bound, so it's the time the update is received
+	if pvc.Status.Phase == corev1.ClaimBound && pt.bound.IsZero() {
+		pt.bound = time.Now()
+		pt.volumeName = pvc.Spec.VolumeName
+	}
+}
+
+func (plw *pvcLifecycleWatcher) handleAttachEvent(event *corev1.Event) {
+	match := attachedVolumeRegex.FindStringSubmatch(event.Message)
+	if match == nil {
+		return
+	}
+	plw.mu.Lock()
+	defer plw.mu.Unlock()
+	if _, exists := plw.attachments[match[1]]; !exists {
+		plw.attachments[match[1]] = eventTimestamp(event)
+	}
+}
+
+func (plw *pvcLifecycleWatcher) handlePod(pod *corev1.Pod) {
+	var started time.Time
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Running != nil && (started.IsZero() || containerStatus.State.Running.StartedAt.Before(&metav1.Time{Time: started})) {
+			started = containerStatus.State.Running.StartedAt.Time
+		}
+	}
+	if started.IsZero() {
+		return
+	}
+	plw.mu.Lock()
+	defer plw.mu.Unlock()
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+		if mounted, exists := plw.mounts[key]; !exists || started.Before(mounted) {
+			plw.mounts[key] = started
+		}
+	}
+}
+
+// phases returns the lifecycle phases of the PVC, each phase lasts from the previous recorded milestone,
+// so the attach phase of volumes not requiring an attachment, like NFS based ones, is accounted in the mount phase
+func (pt *pvcTimestamps) phases() pvcLifecycle {
+	pl := pvcLifecycle{
+		Timestamp:    pt.created.UTC(),
+		Namespace:    pt.namespace,
+		Name:         pt.name,
+		StorageClass: pt.storageClass,
+		JobName:      pt.jobName,
+	}
+	previous := pt.created
+	for _, milestone := range []struct {
+		t       time.Time
+		latency *int
+	}{
+		{pt.bound, &pl.BindingLatency},
+		{pt.attached, &pl.AttachLatency},
+		{pt.mounted, &pl.MountLatency},
+	} {
+		if milestone.t.IsZero() {
+			continue
+		}
+		*milestone.latency = max(int(milestone.t.Sub(previous).Milliseconds()), 0)
+		if milestone.t.After(previous) {
+			previous = milestone.t
+		}
+	}
+	return pl
+}
+
+// stop stops watching and indexes the lifecycle phases of the bound PVCs, and their quantiles per job and storage class
+func (plw *pvcLifecycleWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(plw.stopCh)
+	plw.mu.Lock()
+	defer plw.mu.Unlock()
+	var docs, quantiles []interface{}
+	type group struct{ jobName, storageClass string }
+	var groups []group
+	latencies := make(map[group]map[string][]float64)
+	for key, pt := range plw.pvcs {
+		if pt.bound.IsZero() {
+			continue
+		}
+		pt.attached = plw.attachments[pt.volumeName]
+		pt.mounted = plw.mounts[key]
+		pl := pt.phases()
+		pl.UUID = wh.UUID
+		pl.MetricName = pvcLifecycleMetric
+		pl.Metadata = wh.MetricsMetadata
+		docs = append(docs, pl)
+		g := group{pl.JobName, pl.StorageClass}
+		if _, exists := latencies[g]; !exists {
+			groups = append(groups, g)
+			latencies[g] = make(map[string][]float64)
+		}
+		latencies[g]["Bound"] = append(latencies[g]["Bound"], float64(pl.BindingLatency))
+		if !pt.attached.IsZero() {
+			latencies[g]["Attached"] = append(latencies[g]["Attached"], float64(pl.AttachLatency))
+		}
+		if !pt.mounted.IsZero() {
+			latencies[g]["Mounted"] = append(latencies[g]["Mounted"], float64(pl.MountLatency))
+		}
+	}
+	if len(docs) == 0 {
+		log.Warn("No bound PVCs found")
+		return
+	}
+	for _, g := range groups {
+		for phase, values := range latencies[g] {
+			// The storage class is part of the quantile name to keep the KPIs of each storage class apart
+			q := pvcLifecycleQuantiles{
+				LatencyQuantiles: metrics.NewLatencySummary(values, fmt.Sprintf("%s %s", g.storageClass, phase)),
+				StorageClass:     g.storageClass,
+			}
+			q.UUID = wh.UUID
+			q.MetricName = pvcLifecycleQuantilesMetric
+			q.JobName = g.jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(pvcLifecycleMetric, docs)
+	indexDocuments(pvcLifecycleQuantilesMetric, quantiles)
+}
@@ -21,30 +21,27 @@ import (
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 )
 
 // NewNodeDensity holds node-density-cni workload
 func NewRDSCore(wh *workloads.WorkloadHelper) *cobra.Command {
-	var iterations, churnPercent, churnCycles, dpdkCores int
-	var churn, svcLatency bool
-	var churnDelay, churnDuration, podReadyThreshold time.Duration
-	var churnDeletionStrategy, perfProfile string
+	var iterations, dpdkCores int
+	var svcLatency bool
+	var podReadyThreshold time.Duration
+	var perfProfile string
+	var churn *churnFlags
 	var metricsProfiles []string
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "rds-core",
 		Short:        "Runs rds-core workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
-			os.Setenv("CHURN", fmt.Sprint(churn))
-			os.Setenv("CHURN_CYCLES", fmt.Sprintf("%v", churnCycles))
-			os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", churnDuration))
-			os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", churnDelay))
-			os.Setenv("CHURN_PERCENT", fmt.Sprint(churnPercent))
-			os.Setenv("CHURN_DELETION_STRATEGY", churnDeletionStrategy)
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			os.Setenv("DPDK_CORES", fmt.Sprint(dpdkCores))
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
 			os.Setenv("PERF_PROFILE", perfProfile)
@@ -52,24 +49,24 @@ func NewRDSCore(wh *workloads.WorkloadHelper) *cobra.Command {
 			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
 			ingressDomain, err := wh.MetadataAgent.GetDefaultIngressDomain()
 			if err != nil {
-				log.Fatal("Error obtaining default ingress domain: ", err.Error())
+				return fmt.Errorf("error obtaining default ingress domain: %v", err)
 			}
 			os.Setenv("INGRESS_DOMAIN", ingressDomain)
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
-	cmd.Flags().BoolVar(&churn, "churn", true, "Enable churning")
-	cmd.Flags().IntVar(&churnCycles, "churn-cycles", 0, "Churn cycles to execute")
-	cmd.Flags().DurationVar(&churnDuration, "churn-duration", 1*time.Hour, "Churn duration")
-	cmd.Flags().DurationVar(&churnDelay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
-	cmd.Flags().IntVar(&churnPercent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
-	cmd.Flags().StringVar(&churnDeletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
+	churn = addChurnFlags(cmd, true, time.Hour)
 	cmd.Flags().IntVar(&dpdkCores, "dpdk-cores", 2, "Number of cores per DPDK pod")
 	cmd.Flags().IntVar(&iterations, "iterations", 0, "Number of iterations/namespaces")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
@@ -0,0 +1,180 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+const regressionDetectionMetricName = "regressionDetection"
+
+// kpiDeviation holds the deviation of a KPI from the recent runs
+type kpiDeviation struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	UUID       string                 `json:"uuid"`
+	KPI        string                 `json:"kpi"`
+	JobName    string                 `json:"jobName"`
+	Current    float64                `json:"current"`
+	Mean       float64                `json:"mean"`
+	Stddev     float64                `json:"stddev"`
+	Samples    int                    `json:"samples"`
+	ZScore     float64                `json:"zScore"`
+	Regression bool                   `json:"regression"`
+	MetricName string                 `json:"metricName"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// recentRuns returns the UUIDs of the last runs of the given jobs in clusters with the same shape:
+// platform and number of worker nodes
+func recentRuns(esServer, esIndex, uuid string, jobNames []string, runs int) ([]string, error) {
+	var uuids []string
+	var jobNameFilters []interface{}
+	for _, jobName := range jobNames {
+		jobNameFilters = append(jobNameFilters, map[string]interface{}{
+			"match_phrase": map[string]interface{}{"jobConfig.name": jobName},
+		})
+	}
+	query := map[string]interface{}{
+		"size": runs * len(jobNames),
+		"sort": []interface{}{map[string]interface{}{"timestamp": "desc"}},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"match_phrase": map[string]interface{}{"metricName": "jobSummary"}},
+					map[string]interface{}{"match_phrase": map[string]interface{}{"platform": clusterMetadata.Platform}},
+					map[string]interface{}{"term": map[string]interface{}{"workerNodesCount": clusterMetadata.WorkerNodesCount}},
+					map[string]interface{}{"bool": map[string]interface{}{"should": jobNameFilters}},
+				},
+				"must_not": []interface{}{
+					map[string]interface{}{"match_phrase": map[string]interface{}{"uuid": uuid}},
+				},
+			},
+		},
+	}
+	hits, err := esSearch(esServer, esIndex, query)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, hit := range hits {
+		var doc struct {
+			UUID string `json:"uuid"`
+		}
+		json.Unmarshal(hit, &doc)
+		if !found[doc.UUID] && len(uuids) < runs {
+			found[doc.UUID] = true
+			uuids = append(uuids, doc.UUID)
+		}
+	}
+	return uuids, nil
+}
+
+// detectRegressions compares the KPIs of the run with the last runs of the same workload in clusters with the same shape,
+// flagging the KPIs higher than the mean by more than the given number of standard deviations.
+// A regressionDetection document is indexed per KPI, returns the number of KPIs flagged
+func detectRegressions(wh *workloads.WorkloadHelper, runs int, stddevs float64) (int, error) {
+	defer StartSpan("regression-detection")()
+	var flagged int
+	var jobNames []string
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return 0, fmt.Errorf("regression detection requires a local indexer")
+	}
+	esServer, esIndex := esEndpoint()
+	if esServer == "" {
+		return 0, fmt.Errorf("regression detection requires an Elasticsearch indexer")
+	}
+	current, err := readResults(metricsDirectory)
+	if err != nil {
+		return 0, err
+	}
+	for _, jobSummary := range current.jobSummaries {
+		jobNames = append(jobNames, jobSummary.JobConfig.Name)
+	}
+	uuids, err := recentRuns(esServer, esIndex, wh.UUID, jobNames, runs)
+	if err != nil {
+		return 0, err
+	}
+	if len(uuids) < 2 {
+		log.Warnf("Regression detection requires at least 2 previous runs, found %d", len(uuids))
+		return 0, nil
+	}
+	history := make(map[[2]string][]float64)
+	for _, uuid := range uuids {
+		results, err := searchResults(esServer, esIndex, uuid)
+		if err != nil {
+			return 0, err
+		}
+		for key, value := range kpiValues(results) {
+			history[key] = append(history[key], value)
+		}
+	}
+	var documents []interface{}
+	for key, value := range kpiValues(current) {
+		samples := history[key]
+		if len(samples) < 2 {
+			continue
+		}
+		mean, stddev := meanStddev(samples)
+		deviation := kpiDeviation{
+			Timestamp:  time.Now().UTC(),
+			UUID:       wh.UUID,
+			KPI:        key[0],
+			JobName:    key[1],
+			Current:    value,
+			Mean:       mean,
+			Stddev:     stddev,
+			Samples:    len(samples),
+			MetricName: regressionDetectionMetricName,
+			Metadata:   wh.MetricsMetadata,
+		}
+		if stddev > 0 {
+			deviation.ZScore = (value - mean) / stddev
+			deviation.Regression = deviation.ZScore > stddevs
+		}
+		if deviation.Regression {
+			flagged++
+			log.Warnf("%s in job %s deviates %.2f standard deviations: %.2f, mean %.2f", deviation.KPI, deviation.JobName, deviation.ZScore, value, mean)
+		}
+		documents = append(documents, deviation)
+	}
+	if len(documents) > 0 {
+		indexDocuments(regressionDetectionMetricName, documents)
+	}
+	if flagged > 0 {
+		log.Errorf("❌ Regression suspected: %d KPIs deviate more than %.1f standard deviations from the last %d runs", flagged, stddevs, len(uuids))
+	} else {
+		log.Infof("✅ No regression suspected compared to the last %d runs", len(uuids))
+	}
+	return flagged, nil
+}
+
+func meanStddev(samples []float64) (float64, float64) {
+	var sum, squares float64
+	for _, s := range samples {
+		sum += s
+	}
+	mean := sum / float64(len(samples))
+	for _, s := range samples {
+		squares += (s - mean) * (s - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(samples)))
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Prefix of the git sources, i.e. git::https://github.com/org/repo.git//workloads/my-workload.yml?ref=v1.0.0
+	gitSourcePrefix = "git::"
+	gitCloneTimeout = 5 * time.Minute
+)
+
+// Object templates and metrics and alert profiles of the configuration, fetched along with it when relative
+var (
+	objectTemplateRegex = regexp.MustCompile(`(?m)^([ \t]*-?[ \t]*objectTemplate:[ \t]*)["']?([^"'\s#]+)["']?`)
+	profileRegex        = regexp.MustCompile(`(?m)^([ \t]*-?[ \t]*(?:metrics|alerts|metricsProfile|alertProfile):[ \t]*)(\[[^\]\n]*\]|[^\s#\[]+)`)
+)
+
+// configSource reads the files of a remote custom workload, relative to the directory of its configuration file
+type configSource interface {
+	read(file string) ([]byte, error)
+}
+
+// httpSource reads the files relative to the base URL
+type httpSource struct {
+	base       *url.URL
+	httpClient *http.Client
+}
+
+func (s *httpSource) read(file string) ([]byte, error) {
+	ref, err := url.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	return httpGet(s.httpClient, s.base.ResolveReference(ref).String())
+}
+
+// dirSource reads the files of a local directory, like a git clone
+type dirSource string
+
+func (s dirSource) read(file string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(s), filepath.FromSlash(file)))
+}
+
+// isRemoteConfig returns whether the given custom workload configuration is an HTTP(S) URL, a git reference or an OCI bundle
+func isRemoteConfig(config string) bool {
+	return strings.HasPrefix(config, "http://") || strings.HasPrefix(config, "https://") || strings.HasPrefix(config, gitSourcePrefix) || strings.HasPrefix(config, ociSourcePrefix)
+}
+
+// isRelativeFile returns whether the given reference of the configuration is a relative path, to be fetched along with it
+func isRelativeFile(file string) bool {
+	return file != "" && !path.IsAbs(file) && !isRemoteConfig(file) && !strings.Contains(file, "{{")
+}
+
+// fetchRemoteConfig fetches the given custom workload configuration and its relative object templates and profiles into the given directory,
+// verifying them against the checksums of the given sha256sum file when set. The workload to run is returned, which is the
+// path of the configuration without the .yml extension
+func fetchRemoteConfig(config, sha256sums, workDir string) (string, error) {
+	var source configSource
+	var configFile string
+	switch {
+	case strings.HasPrefix(config, gitSourcePrefix):
+		cloneDir := filepath.Join(workDir, "clone")
+		var err error
+		if configFile, err = cloneGitSource(strings.TrimPrefix(config, gitSourcePrefix), cloneDir); err != nil {
+			return "", err
+		}
+		source = dirSource(path.Join(cloneDir, path.Dir(configFile)))
+	case strings.HasPrefix(config, ociSourcePrefix):
+		bundleDir := filepath.Join(workDir, "bundle")
+		var err error
+		if configFile, err = pullBundle(strings.TrimPrefix(config, ociSourcePrefix), bundleDir); err != nil {
+			return "", err
+		}
+		source = dirSource(bundleDir)
+	default:
+		u, err := url.Parse(config)
+		if err != nil {
+			return "", fmt.Errorf("invalid configuration URL: %v", err)
+		}
+		source = &httpSource{base: u, httpClient: &http.Client{Timeout: time.Minute}}
+		configFile = u.Path
+	}
+	var checksums map[string]string
+	if sha256sums != "" {
+		var err error
+		if checksums, err = readChecksums(sha256sums); err != nil {
+			return "", err
+		}
+	}
+	fetch := func(file string) ([]byte, error) {
+		data, err := source.read(file)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s: %v", file, err)
+		}
+		if checksums == nil {
+			return data, nil
+		}
+		expected, ok := checksums[path.Clean(file)]
+		if !ok {
+			return nil, fmt.Errorf("no checksum of %s found in %s", file, sha256sums)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != expected {
+			return nil, fmt.Errorf("checksum mismatch of %s: expected %s, got %x", file, expected, sum)
+		}
+		return data, nil
+	}
+	configName := path.Base(configFile)
+	configData, err := fetch(configName)
+	if err != nil {
+		return "", err
+	}
+	workloadDir := filepath.Join(workDir, "workload")
+	// Relative paths are read from the working directory, so the relative object templates and profiles are rewritten with their fetched path
+	store := func(file string) (string, error) {
+		data, err := fetch(file)
+		if err != nil {
+			return "", err
+		}
+		dst := filepath.Join(workloadDir, filepath.FromSlash(path.Clean(file)))
+		if !strings.HasPrefix(dst, workloadDir+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside of the directory of the configuration", file)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		return dst, os.WriteFile(dst, data, 0644)
+	}
+	var fetchErr error
+	configData = objectTemplateRegex.ReplaceAllFunc(configData, func(match []byte) []byte {
+		groups := objectTemplateRegex.FindSubmatch(match)
+		template := string(groups[2])
+		if fetchErr != nil || !isRelativeFile(template) {
+			return match
+		}
+		dst, err := store(template)
+		if err != nil {
+			fetchErr = err
+			return match
+		}
+		return append(append([]byte{}, groups[1]...), dst...)
+	})
+	if fetchErr != nil {
+		return "", fetchErr
+	}
+	// Profiles may refer to the ones of the working directory too, so they're left as they are when not found
+	configData = profileRegex.ReplaceAllFunc(configData, func(match []byte) []byte {
+		groups := profileRegex.FindSubmatch(match)
+		value := string(groups[2])
+		profiles := strings.Split(strings.Trim(value, "[]"), ",")
+		for i, profile := range profiles {
+			profile = strings.Trim(strings.TrimSpace(profile), `"'`)
+			if !isRelativeFile(profile) {
+				continue
+			}
+			dst, err := store(profile)
+			if err != nil {
+				log.Warnf("Profile %s not fetched, reading it from the working directory: %v", profile, err)
+				continue
+			}
+			profiles[i] = dst
+		}
+		if strings.HasPrefix(value, "[") {
+			value = "[" + strings.Join(profiles, ",") + "]"
+		} else {
+			value = profiles[0]
+		}
+		return append(append([]byte{}, groups[1]...), value...)
+	})
+	workload := filepath.Join(workloadDir, strings.TrimSuffix(strings.TrimSuffix(configName, ".yml"), ".yaml"))
+	if err := os.MkdirAll(workloadDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(workload+".yml", configData, 0644); err != nil {
+		return "", err
+	}
+	log.Infof("Custom workload fetched from %s", config)
+	return workload, nil
+}
+
+// cloneGitSource clones the repository of the given git source, in repository//path?ref=reference format, and returns
+// the path of the configuration file in the repository. The reference can be a branch, a tag or a commit
+func cloneGitSource(source, cloneDir string) (string, error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("git is required to fetch the configuration from a git repository: %v", err)
+	}
+	source, ref, _ := strings.Cut(source, "?ref=")
+	// The double slash separating the repository and the path isn't the one after the URL scheme
+	var schemeEnd int
+	if i := strings.Index(source, "://"); i >= 0 {
+		schemeEnd = i + len("://")
+	}
+	repository, configFile, found := strings.Cut(source[schemeEnd:], "//")
+	if !found || configFile == "" {
+		return "", fmt.Errorf("invalid git source %s, expected format is git::<repository>//<path>?ref=<reference>", source)
+	}
+	repository = source[:schemeEnd] + repository
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// Otherwise git would take them as options, like --upload-pack running a command
+	if strings.HasPrefix(repository, "-") || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid git source %s, the repository and the reference can't start with -", source)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitCloneTimeout)
+	defer cancel()
+	// Fetching the reference alone works for branches, tags and commits
+	for _, args := range [][]string{
+		{"init", "--quiet", cloneDir},
+		{"-C", cloneDir, "fetch", "--quiet", "--depth=1", "--", repository, ref},
+		{"-C", cloneDir, "checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, git, args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("error fetching %s of %s: %v: %s", ref, repository, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return configFile, nil
+}
+
+// readChecksums reads a local or remote file in the format of sha256sum, with the paths relative to the configuration directory
+func readChecksums(sha256sums string) (map[string]string, error) {
+	var data []byte
+	var err error
+	if isRemoteConfig(sha256sums) {
+		data, err = httpGet(&http.Client{Timeout: time.Minute}, sha256sums)
+	} else {
+		data, err = os.ReadFile(sha256sums)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksums: %v", err)
+	}
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum prefixes the files read in binary mode with an asterisk
+		checksums[path.Clean(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+	return checksums, nil
+}
+
+func httpGet(httpClient *http.Client, location string) ([]byte, error) {
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	chartWidth  = 600
+	chartHeight = 200
+)
+
+// reportCharts are the Prometheus expressions charted in the HTML report
+var reportCharts = []struct {
+	title string
+	expr  string
+}{
+	{"Running pods", `sum(kube_pod_status_phase{phase="Running"})`},
+	{"API server requests/s by verb", `sum(rate(apiserver_request_total[2m])) by (verb)`},
+	{"Control plane nodes CPU usage %", `100 - avg(irate(node_cpu_seconds_total{mode="idle"}[2m]) and on (instance) label_replace(kube_node_role{role="master"}, "instance", "$1", "node", "(.+)")) by (instance) * 100`},
+	{"etcd DB size bytes", `max(etcd_mvcc_db_total_size_in_bytes) by (pod)`},
+}
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+type reportSeries struct {
+	Label  string
+	Points string
+	Color  string
+}
+
+type reportChart struct {
+	Title  string
+	Max    float64
+	Series []reportSeries
+}
+
+type htmlReport struct {
+	UUID             string
+	Workload         string
+	Timestamp        time.Time
+	Parameters       [][2]string
+	Metadata         [][2]string
+	JobSummaries     []burner.JobSummary
+	LatencyQuantiles []metrics.LatencyQuantiles
+	MaxLatency       int
+	Alerts           []alert
+	Charts           []reportChart
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"width": func(value, maxValue int) int {
+		if maxValue == 0 {
+			return 0
+		}
+		return value * chartWidth / maxValue
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Workload}} {{.UUID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+.passed { color: #2ca02c; }
+.failed { color: #d62728; }
+.legend span { margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Workload}}</h1>
+<p>UUID {{.UUID}}, generated at {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}</p>
+<h2>Jobs</h2>
+<table>
+<tr><th>Job</th><th>Iterations</th><th>QPS</th><th>Burst</th><th>Start</th><th>Duration</th><th>Result</th></tr>
+{{- range .JobSummaries}}
+<tr><td>{{.JobConfig.Name}}</td><td>{{.JobConfig.JobIterations}}</td><td>{{.JobConfig.QPS}}</td><td>{{.JobConfig.Burst}}</td><td>{{.Timestamp.Format "15:04:05"}}</td><td>{{.ElapsedTime}}s</td><td>{{if .Passed}}<span class="passed">passed</span>{{else}}<span class="failed">failed {{.ExecutionErrors}}</span>{{end}}</td></tr>
+{{- end}}
+</table>
+<h2>Workload parameters</h2>
+<table>
+{{- range .Parameters}}
+<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{- end}}
+</table>
+<h2>Latencies</h2>
+{{- if .LatencyQuantiles}}
+<table>
+<tr><th>Measurement</th><th>Job</th><th>Quantile</th><th>P50</th><th>P95</th><th>P99</th><th>Max</th><th>Distribution</th></tr>
+{{- $max := .MaxLatency}}
+{{- range .LatencyQuantiles}}
+<tr><td>{{.MetricName}}</td><td>{{.JobName}}</td><td>{{.QuantileName}}</td><td>{{.P50}}ms</td><td>{{.P95}}ms</td><td>{{.P99}}ms</td><td>{{.Max}}ms</td>
+<td><svg width="600" height="14"><rect width="{{width .Max $max}}" height="14" fill="#ddd"/><rect width="{{width .P99 $max}}" height="14" fill="#d62728"/><rect width="{{width .P95 $max}}" height="14" fill="#ff7f0e"/><rect width="{{width .P50 $max}}" height="14" fill="#1f77b4"/></svg></td></tr>
+{{- end}}
+</table>
+<p class="legend"><span style="color:#1f77b4">■ P50</span><span style="color:#ff7f0e">■ P95</span><span style="color:#d62728">■ P99</span><span style="color:#aaa">■ Max</span></p>
+{{- else}}
+<p>No latency measurements</p>
+{{- end}}
+<h2>Alerts</h2>
+{{- if .Alerts}}
+<table>
+<tr><th>Timestamp</th><th>Severity</th><th>Description</th></tr>
+{{- range .Alerts}}
+<tr><td>{{.Timestamp.Format "15:04:05"}}</td><td>{{.Severity}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No alerts fired</p>
+{{- end}}
+{{- if .Charts}}
+<h2>Charts</h2>
+{{- range .Charts}}
+<h3>{{.Title}}</h3>
+<p>max {{printf "%.2f" .Max}}</p>
+<svg width="600" height="200" style="border: 1px solid #ccc">
+{{- range .Series}}
+<polyline fill="none" stroke="{{.Color}}" points="{{.Points}}"/>
+{{- end}}
+</svg>
+<p class="legend">{{range .Series}}<span style="color:{{.Color}}">■ {{.Label}}</span>{{end}}</p>
+{{- end}}
+{{- end}}
+<h2>Cluster metadata</h2>
+<table>
+{{- range .Metadata}}
+<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// generateReports writes the requested reports of the run in the current directory, or in the artifacts directory when set
+func generateReports(cmd *cobra.Command, wh *workloads.WorkloadHelper, reports []string, sloResults []sloResult) error {
+	defer StartSpan("reports")()
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return fmt.Errorf("reports require a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return err
+	}
+	htmlFile, junitFile := fmt.Sprintf("report-%s.html", wh.UUID), fmt.Sprintf("junit-%s.xml", wh.UUID)
+	if artifactsDir, _ := cmd.Root().PersistentFlags().GetString("artifacts-dir"); artifactsDir != "" {
+		htmlFile, junitFile = path.Join(artifactsDir, artifactsHTMLFile), path.Join(artifactsDir, artifactsJUnitFile)
+	}
+	for _, report := range reports {
+		switch report {
+		case "html":
+			fileName := htmlFile
+			if err := writeHTMLReport(cmd, wh, results, fileName); err != nil {
+				return err
+			}
+			log.Infof("HTML report written to %s", fileName)
+		case "junit":
+			fileName := junitFile
+			if err := writeJUnitReport(cmd.Name(), wh, results, sloResults, fileName); err != nil {
+				return err
+			}
+			log.Infof("JUnit report written to %s", fileName)
+		default:
+			return fmt.Errorf("unsupported report %s, supported options are: html or junit", report)
+		}
+	}
+	return nil
+}
+
+func writeHTMLReport(cmd *cobra.Command, wh *workloads.WorkloadHelper, results runResults, fileName string) error {
+	report := htmlReport{
+		UUID:             wh.UUID,
+		Workload:         cmd.Name(),
+		Timestamp:        time.Now().UTC(),
+		JobSummaries:     results.jobSummaries,
+		LatencyQuantiles: results.latencyQuantiles,
+		Alerts:           results.alerts,
+	}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		value := f.Value.String()
+		// Like the credentials of an URL
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		report.Parameters = append(report.Parameters, [2]string{f.Name, value})
+	})
+	for k, v := range wh.SummaryMetadata {
+		report.Metadata = append(report.Metadata, [2]string{k, fmt.Sprint(v)})
+	}
+	sort.Slice(report.Metadata, func(i, j int) bool { return report.Metadata[i][0] < report.Metadata[j][0] })
+	for _, lq := range results.latencyQuantiles {
+		report.MaxLatency = max(report.MaxLatency, lq.Max)
+	}
+	if len(results.jobSummaries) > 0 {
+		charts, err := prometheusCharts(wh, results.jobSummaries)
+		if err != nil {
+			log.Warnf("Error rendering Prometheus charts: %v", err)
+		}
+		report.Charts = charts
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlReportTemplate.Execute(f, report)
+}
+
+// prometheusCharts renders the report charts as SVG polylines, from the start of the first job to the end of the last one
+func prometheusCharts(wh *workloads.WorkloadHelper, jobSummaries []burner.JobSummary) ([]reportChart, error) {
+	var charts []reportChart
+	start, end := jobSummaries[0].Timestamp, jobSummaries[0].EndTimestamp
+	for _, jobSummary := range jobSummaries {
+		if jobSummary.Timestamp.Before(start) {
+			start = jobSummary.Timestamp
+		}
+		if jobSummary.EndTimestamp.After(end) {
+			end = jobSummary.EndTimestamp
+		}
+	}
+	p, err := newPrometheusClient(wh)
+	if err != nil {
+		return nil, err
+	}
+	step := max(end.Sub(start)/chartWidth, 15*time.Second)
+	for _, rc := range reportCharts {
+		v, err := p.QueryRange(rc.expr, start, end, step)
+		if err != nil {
+			return charts, err
+		}
+		chart := reportChart{Title: rc.title}
+		matrix := v.(model.Matrix)
+		for _, sample := range matrix {
+			for _, value := range sample.Values {
+				chart.Max = max(chart.Max, float64(value.Value))
+			}
+		}
+		duration := max(end.Sub(start).Seconds(), 1)
+		for i, sample := range matrix {
+			var points []string
+			for _, value := range sample.Values {
+				x := value.Timestamp.Time().Sub(start).Seconds() * chartWidth / duration
+				y := float64(chartHeight)
+				if chart.Max > 0 {
+					y -= float64(value.Value) * chartHeight / chart.Max
+				}
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+			}
+			label := sample.Metric.String()
+			if len(sample.Metric) == 0 {
+				label = rc.title
+			}
+			chart.Series = append(chart.Series, reportSeries{
+				Label:  label,
+				Points: strings.Join(points, " "),
+				Color:  chartColors[i%len(chartColors)],
+			})
+		}
+		charts = append(charts, chart)
+	}
+	return charts, nil
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+// alert document indexed by kube-burner
+type alert struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Severity    string    `json:"severity"`
+	Description string    `json:"description"`
+}
+
+// aggregatedMetric is a metric document of an instant query, such as the ones from the reporting metrics profile
+type aggregatedMetric struct {
+	Labels     map[string]string `json:"labels"`
+	Value      float64           `json:"value"`
+	MetricName string            `json:"metricName"`
+	JobName    string            `json:"jobName"`
+}
+
+// runResults holds the documents generated by a workload run
+type runResults struct {
+	jobSummaries     []burner.JobSummary
+	latencyQuantiles []metrics.LatencyQuantiles
+	alerts           []alert
+	// aggregatedMetrics are only loaded on demand
+	aggregatedMetrics []aggregatedMetric
+	// metadata of the first job summary
+	metadata map[string]interface{}
+}
+
+// localMetricsDirectory returns the metrics directory of the first local indexer configured in the workload
+func localMetricsDirectory() string {
+	for _, endpoint := range workloads.ConfigSpec.MetricsEndpoints {
+		if endpoint.Type == indexers.LocalIndexer {
+			return endpoint.MetricsDirectory
+		}
+	}
+	return ""
+}
+
+// resultJobs returns the jobs of the run from the results of the local indexer, so the metrics scraped from other Prometheus
+// instances line up with the ones of the workload, or a job with the given name spanning the given time range when there are none
+func resultJobs(start, end time.Time, name string) []prometheus.Job {
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		if results, err := readResults(metricsDirectory); err == nil {
+			var jobs []prometheus.Job
+			for _, jobSummary := range results.jobSummaries {
+				if jobSummary.EndTimestamp.After(jobSummary.Timestamp) {
+					jobs = append(jobs, prometheus.Job{Start: jobSummary.Timestamp, End: jobSummary.EndTimestamp, JobConfig: config.Job{Name: jobSummary.JobConfig.Name}})
+				}
+			}
+			if len(jobs) > 0 {
+				return jobs
+			}
+		}
+	}
+	return []prometheus.Job{{Start: start, End: end, JobConfig: config.Job{Name: name}}}
+}
+
+// resultMetricNames are the metric names of the documents making up the results of a run
+var resultMetricNames = []string{
+	"jobSummary",
+	"alert",
+	"podLatencyQuantilesMeasurement",
+	"svcLatencyQuantilesMeasurement",
+	"nodeLatencyQuantilesMeasurement",
+	"pvcLatencyQuantilesMeasurement",
+	"netpolLatencyQuantilesMeasurement",
+	"dvLatencyQuantilesMeasurement",
+	"vmiLatencyQuantilesMeasurement",
+	"apiRequestLatencyQuantilesMeasurement",
+	"schedulingAttemptLatencyQuantilesMeasurement",
+	"imagePullLatencyQuantilesMeasurement",
+	"podStartupQuantilesMeasurement",
+	"pvcLifecycleQuantilesMeasurement",
+	"routeLatencyQuantilesMeasurement",
+	"vmiBootQuantilesMeasurement",
+}
+
+// esEndpoint returns the server and index of the first Elasticsearch or OpenSearch indexer configured in the workload
+func esEndpoint() (string, string) {
+	for _, endpoint := range workloads.ConfigSpec.MetricsEndpoints {
+		if (endpoint.Type == indexers.ElasticIndexer || endpoint.Type == indexers.OpenSearchIndexer) && len(endpoint.Servers) > 0 {
+			return endpoint.Servers[0], endpoint.Index
+		}
+	}
+	return "", ""
+}
+
+// loadResults loads the results of a run from the given local metrics directory, or from the
+// collected-metrics-<uuid> directory or the given Elasticsearch index when a UUID is passed
+func loadResults(source, esServer, esIndex string) (runResults, error) {
+	for _, metricsDirectory := range []string{source, "collected-metrics-" + source} {
+		if info, err := os.Stat(metricsDirectory); err == nil && info.IsDir() {
+			return readResults(metricsDirectory)
+		}
+	}
+	if esServer == "" || esIndex == "" {
+		return runResults{}, fmt.Errorf("%s is not a metrics directory and no Elasticsearch index is configured", source)
+	}
+	return searchResults(esServer, esIndex, source)
+}
+
+// searchResults fetches the results of the given UUID from Elasticsearch
+func searchResults(esServer, esIndex, uuid string) (runResults, error) {
+	var results runResults
+	var metricNameFilters []interface{}
+	for _, metricName := range resultMetricNames {
+		metricNameFilters = append(metricNameFilters, map[string]interface{}{
+			"match_phrase": map[string]interface{}{"metricName": metricName},
+		})
+	}
+	query := map[string]interface{}{
+		"size": 10000,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"match_phrase": map[string]interface{}{"uuid": uuid}},
+					map[string]interface{}{"bool": map[string]interface{}{"should": metricNameFilters}},
+				},
+			},
+		},
+	}
+	hits, err := esSearch(esServer, esIndex, query)
+	if err != nil {
+		return results, err
+	}
+	for _, hit := range hits {
+		var doc struct {
+			MetricName string `json:"metricName"`
+		}
+		json.Unmarshal(hit, &doc)
+		switch {
+		case doc.MetricName == "jobSummary":
+			var jobSummary burner.JobSummary
+			json.Unmarshal(hit, &jobSummary)
+			results.jobSummaries = append(results.jobSummaries, jobSummary)
+			if results.metadata == nil {
+				json.Unmarshal(hit, &results.metadata)
+			}
+		case doc.MetricName == "alert":
+			var a alert
+			json.Unmarshal(hit, &a)
+			results.alerts = append(results.alerts, a)
+		case strings.HasSuffix(doc.MetricName, "QuantilesMeasurement"):
+			var lq metrics.LatencyQuantiles
+			json.Unmarshal(hit, &lq)
+			results.latencyQuantiles = append(results.latencyQuantiles, lq)
+		}
+	}
+	if len(results.jobSummaries) == 0 {
+		return results, fmt.Errorf("no results found for UUID %s in %s", uuid, esIndex)
+	}
+	return results, nil
+}
+
+// esSearch runs the given query against the Elasticsearch index and returns the source of the hits
+func esSearch(esServer, esIndex string, query map[string]interface{}) ([]json.RawMessage, error) {
+	var hits []json.RawMessage
+	body, _ := json.Marshal(query)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   time.Minute,
+	}
+	// The servers of the indexers embed the credentials
+	esServer, credentials := splitIndexerCredentials(esServer)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s/_search", strings.TrimSuffix(esServer, "/"), esIndex), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if credentials.Username != "" {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Elasticsearch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error querying Elasticsearch: %s", resp.Status)
+	}
+	var searchResponse struct {
+		Hits struct {
+			Hits []struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&searchResponse); err != nil {
+		return nil, fmt.Errorf("error decoding Elasticsearch response: %v", err)
+	}
+	for _, hit := range searchResponse.Hits.Hits {
+		hits = append(hits, hit.Source)
+	}
+	return hits, nil
+}
+
+// loadAggregatedMetrics loads the documents of the given metrics from the same source as loadResults
+func loadAggregatedMetrics(source, esServer, esIndex string, metricNames []string) ([]aggregatedMetric, error) {
+	var aggregatedMetrics []aggregatedMetric
+	for _, metricsDirectory := range []string{source, "collected-metrics-" + source} {
+		if info, err := os.Stat(metricsDirectory); err == nil && info.IsDir() {
+			for _, metricName := range metricNames {
+				var documents []aggregatedMetric
+				err := readDocuments(path.Join(metricsDirectory, metricName+".json"), &documents)
+				if err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+				aggregatedMetrics = append(aggregatedMetrics, documents...)
+			}
+			return aggregatedMetrics, nil
+		}
+	}
+	var metricNameFilters []interface{}
+	for _, metricName := range metricNames {
+		metricNameFilters = append(metricNameFilters, map[string]interface{}{
+			"match_phrase": map[string]interface{}{"metricName": metricName},
+		})
+	}
+	query := map[string]interface{}{
+		"size": 10000,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"match_phrase": map[string]interface{}{"uuid": source}},
+					map[string]interface{}{"bool": map[string]interface{}{"should": metricNameFilters}},
+				},
+			},
+		},
+	}
+	hits, err := esSearch(esServer, esIndex, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range hits {
+		var metric aggregatedMetric
+		json.Unmarshal(hit, &metric)
+		aggregatedMetrics = append(aggregatedMetrics, metric)
+	}
+	return aggregatedMetrics, nil
+}
+
+// readResults loads the job summaries, latency quantiles and alerts written by the local indexer
+func readResults(metricsDirectory string) (runResults, error) {
+	var results runResults
+	var rawJobSummaries []map[string]interface{}
+	if err := readDocuments(path.Join(metricsDirectory, "jobSummary.json"), &results.jobSummaries); err != nil {
+		return results, err
+	}
+	// Job summaries include the metadata as top level fields
+	readDocuments(path.Join(metricsDirectory, "jobSummary.json"), &rawJobSummaries)
+	if len(rawJobSummaries) > 0 {
+		results.metadata = rawJobSummaries[0]
+	}
+	// Alerts file is only created when any alert fired
+	if err := readDocuments(path.Join(metricsDirectory, "alert.json"), &results.alerts); err != nil && !os.IsNotExist(err) {
+		return results, err
+	}
+	quantileFiles, _ := filepath.Glob(path.Join(metricsDirectory, "*QuantilesMeasurement*.json"))
+	for _, quantileFile := range quantileFiles {
+		var latencyQuantiles []metrics.LatencyQuantiles
+		if err := readDocuments(quantileFile, &latencyQuantiles); err != nil {
+			return results, err
+		}
+		results.latencyQuantiles = append(results.latencyQuantiles, latencyQuantiles...)
+	}
+	return results, nil
+}
+
+func readDocuments(fileName string, documents interface{}) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, documents); err != nil {
+		return fmt.Errorf("error decoding %s: %v", fileName, err)
+	}
+	return nil
+}
+
+// indexDocuments indexes the given documents in all the indexers configured in the workload
+func indexDocuments(metricName string, documents []interface{}) {
+	defer StartSpan("index " + metricName)()
+	for _, endpoint := range workloads.ConfigSpec.MetricsEndpoints {
+		if endpoint.Type == "" {
+			continue
+		}
+		indexer, err := indexers.NewIndexer(endpoint.IndexerConfig)
+		if err != nil {
+			log.Errorf("Error creating indexer: %v", err)
+			continue
+		}
+		if endpoint.Type != indexers.LocalIndexer {
+			*indexer = newRetryIndexer(*indexer)
+		}
+		resp, err := (*indexer).Index(documents, indexers.IndexingOpts{MetricName: metricName})
+		if err != nil {
+			log.Error(err.Error())
+		} else {
+			log.Info(resp)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	iterationRetryMetric = "iterationRetry"
+	retryCheckPeriod     = 10 * time.Second
+	// Maximum time to wait for the objects of an iteration to be deleted before recreating them
+	retryDeleteTimeout = 5 * time.Minute
+)
+
+// iterationRetry holds the retries of an iteration whose pods didn't become ready
+type iterationRetry struct {
+	Timestamp   time.Time   `json:"timestamp"`
+	UUID        string      `json:"uuid"`
+	Namespace   string      `json:"namespace"`
+	Iteration   int         `json:"iteration"`
+	Retries     int         `json:"retries"`
+	Recovered   bool        `json:"recovered"`
+	UnreadyPods int         `json:"unreadyPods"`
+	Reason      string      `json:"reason"`
+	MetricName  string      `json:"metricName"`
+	JobName     string      `json:"jobName,omitempty"`
+	Metadata    interface{} `json:"metadata,omitempty"`
+	exhausted   bool
+}
+
+type iterationRetrier struct {
+	uuid        string
+	maxRetries  int
+	timeout     time.Duration
+	dynamic     dynamic.Interface
+	podStore    cache.Store
+	deployStore cache.Store
+	iterations  map[string]*iterationRetry
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+}
+
+// startIterationRetrier watches the pods and deployments created by the run with the given UUID, and re-creates the objects of
+// the iterations with pods not ready after the given timeout, up to the given number of times per iteration. The timeout must
+// be shorter than the one of the run, otherwise kube-burner fails waiting for them first
+func startIterationRetrier(uuid string, maxRetries int, timeout time.Duration) *iterationRetrier {
+	clientSet, restConfig := newClientSet()
+	ir := &iterationRetrier{
+		uuid:       uuid,
+		maxRetries: maxRetries,
+		timeout:    timeout,
+		dynamic:    dynamic.NewForConfigOrDie(restConfig),
+		iterations: make(map[string]*iterationRetry),
+		stopCh:     make(chan struct{}),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	var podController, deployController cache.Controller
+	ir.podStore, podController = cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.Pod{},
+		Handler:       cache.ResourceEventHandlerFuncs{},
+	})
+	// Deployments catch the pods rejected at admission, which are never created
+	ir.deployStore, deployController = cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.AppsV1().RESTClient(), "deployments", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &appsv1.Deployment{},
+		Handler:       cache.ResourceEventHandlerFuncs{},
+	})
+	go podController.Run(ir.stopCh)
+	go deployController.Run(ir.stopCh)
+	log.Infof("Retrying up to %d times the iterations with pods not ready after %v", maxRetries, timeout)
+	ir.wg.Add(1)
+	go func() {
+		defer ir.wg.Done()
+		ticker := time.NewTicker(retryCheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ir.stopCh:
+				return
+			case <-ticker.C:
+				ir.check()
+			}
+		}
+	}()
+	return ir
+}
+
+// unreadyIteration is an iteration with pods not ready, identified by its job, namespace and iteration labels
+type unreadyIteration struct {
+	jobName, namespace string
+	iteration          int
+	pods               int
+	reason             string
+}
+
+func (u unreadyIteration) key() string {
+	return fmt.Sprintf("%s/%s/%d", u.jobName, u.namespace, u.iteration)
+}
+
+// unreadyIterations returns the iterations with pods or deployments not ready for longer than the given age
+func (ir *iterationRetrier) unreadyIterations(age time.Duration) map[string]*unreadyIteration {
+	unready := make(map[string]*unreadyIteration)
+	add := func(meta metav1.ObjectMeta, pods int, reason string) {
+		iteration, err := strconv.Atoi(meta.Labels[config.KubeBurnerLabelJobIteration])
+		if err != nil || meta.DeletionTimestamp != nil || time.Since(meta.CreationTimestamp.Time) < age {
+			return
+		}
+		u := &unreadyIteration{jobName: meta.Labels["kube-burner-job"], namespace: meta.Namespace, iteration: iteration}
+		if existing, ok := unready[u.key()]; ok {
+			u = existing
+		} else {
+			unready[u.key()] = u
+		}
+		u.pods += pods
+		if u.reason == "" {
+			u.reason = reason
+		}
+	}
+	for _, obj := range ir.podStore.List() {
+		pod := obj.(*corev1.Pod)
+		if pod.Status.Phase != corev1.PodSucceeded && !podReady(pod) {
+			add(pod.ObjectMeta, 1, podUnreadyReason(pod))
+		}
+	}
+	for _, obj := range ir.deployStore.List() {
+		deploy := obj.(*appsv1.Deployment)
+		if deploy.Spec.Replicas == nil || deploy.Status.Replicas >= *deploy.Spec.Replicas {
+			continue
+		}
+		for _, c := range deploy.Status.Conditions {
+			if c.Type == appsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue {
+				add(deploy.ObjectMeta, 0, c.Message)
+			}
+		}
+	}
+	return unready
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podUnreadyReason returns why the pod isn't ready, from the state of its containers or its conditions
+func podUnreadyReason(pod *corev1.Pod) string {
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			return cs.State.Waiting.Reason
+		}
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Status != corev1.ConditionTrue && c.Reason != "" {
+			return c.Reason
+		}
+	}
+	return string(pod.Status.Phase)
+}
+
+// check retries the iterations not ready for longer than the timeout since their objects were last created
+func (ir *iterationRetrier) check() {
+	for key, u := range ir.unreadyIterations(ir.timeout) {
+		ir.mu.Lock()
+		retry, ok := ir.iterations[key]
+		if !ok {
+			retry = &iterationRetry{Namespace: u.namespace, Iteration: u.iteration, JobName: u.jobName}
+			ir.iterations[key] = retry
+		}
+		retry.UnreadyPods, retry.Reason = u.pods, u.reason
+		exhausted := retry.Retries >= ir.maxRetries
+		if exhausted && !retry.exhausted {
+			log.Errorf("Iteration %d of job %s in namespace %s not ready after %d retries: %s", u.iteration, u.jobName, u.namespace, retry.Retries, u.reason)
+		}
+		retry.exhausted = exhausted
+		if !exhausted {
+			retry.Retries++
+		}
+		retries := retry.Retries
+		ir.mu.Unlock()
+		if exhausted {
+			continue
+		}
+		log.Warnf("Retrying iteration %d of job %s in namespace %s (%d/%d), %d pods not ready after %v: %s", u.iteration, u.jobName, u.namespace, retries, ir.maxRetries, u.pods, ir.timeout, u.reason)
+		if err := ir.retry(u); err != nil {
+			log.Errorf("Error retrying iteration %d of job %s: %v", u.iteration, u.jobName, err)
+		}
+	}
+}
+
+// retry cleans the objects of the given iteration from its namespace and creates them again from their current definition,
+// so they keep the names, labels and rendered templates given by kube-burner. The objects created by controllers, like the pods
+// of a deployment, are left to them
+func (ir *iterationRetrier) retry(u *unreadyIteration) error {
+	labelSelector := fmt.Sprintf("kube-burner-uuid=%s,kube-burner-job=%s,%s=%d", ir.uuid, u.jobName, config.KubeBurnerLabelJobIteration, u.iteration)
+	resourceLists, err := discoveryClient().ServerPreferredNamespacedResources()
+	if err != nil {
+		log.Warnf("Error discovering API resources: %v", err)
+	}
+	type iterationObject struct {
+		gvr schema.GroupVersionResource
+		obj *unstructured.Unstructured
+	}
+	var objects []iterationObject
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") || !slices.Contains(resource.Verbs, "list") || !slices.Contains(resource.Verbs, "delete") || !slices.Contains(resource.Verbs, "create") {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			list, err := ir.dynamic.Resource(gvr).Namespace(u.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+			if err != nil {
+				continue
+			}
+			for i := range list.Items {
+				if metav1.GetControllerOf(&list.Items[i]) == nil {
+					objects = append(objects, iterationObject{gvr: gvr, obj: &list.Items[i]})
+				}
+			}
+		}
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no objects found in namespace %s", u.namespace)
+	}
+	for _, o := range objects {
+		err := ir.dynamic.Resource(o.gvr).Namespace(u.namespace).Delete(context.TODO(), o.obj.GetName(), metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting %s %s: %v", o.gvr.GroupResource(), o.obj.GetName(), err)
+		}
+	}
+	err = wait.PollUntilContextTimeout(context.TODO(), time.Second, retryDeleteTimeout, true, func(ctx context.Context) (bool, error) {
+		for _, o := range objects {
+			if _, err := ir.dynamic.Resource(o.gvr).Namespace(u.namespace).Get(ctx, o.obj.GetName(), metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("objects of namespace %s not deleted after %v", u.namespace, retryDeleteTimeout)
+	}
+	for _, o := range objects {
+		if _, err := ir.dynamic.Resource(o.gvr).Namespace(u.namespace).Create(context.TODO(), recreatableObject(o.obj), metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating %s %s: %v", o.gvr.GroupResource(), o.obj.GetName(), err)
+		}
+	}
+	log.Infof("Recreated %d objects of iteration %d of job %s in namespace %s", len(objects), u.iteration, u.jobName, u.namespace)
+	return nil
+}
+
+// recreatableObject returns a copy of the given object without the fields set by the API server and the controllers
+func recreatableObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	newObj := obj.DeepCopy()
+	for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "generation", "managedFields", "deletionTimestamp", "deletionGracePeriodSeconds", "selfLink"} {
+		unstructured.RemoveNestedField(newObj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(newObj.Object, "status")
+	switch newObj.GetKind() {
+	case "Service":
+		// The cluster IPs of the deleted service may not be released yet
+		unstructured.RemoveNestedField(newObj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(newObj.Object, "spec", "clusterIPs")
+	case "PersistentVolumeClaim":
+		unstructured.RemoveNestedField(newObj.Object, "spec", "volumeName")
+	}
+	return newObj
+}
+
+// stop stops retrying the iterations, and indexes the retries of each retried iteration along with whether it recovered
+func (ir *iterationRetrier) stop(wh *workloads.WorkloadHelper) {
+	close(ir.stopCh)
+	ir.wg.Wait()
+	unready := ir.unreadyIterations(0)
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	var docs []interface{}
+	var recovered int
+	for _, key := range sortedKeys(ir.iterations) {
+		retry := ir.iterations[key]
+		if retry.Retries == 0 {
+			continue
+		}
+		retry.Recovered = unready[key] == nil
+		if retry.Recovered {
+			retry.UnreadyPods = 0
+			recovered++
+		}
+		retry.Timestamp = time.Now().UTC()
+		retry.UUID = wh.UUID
+		retry.MetricName = iterationRetryMetric
+		retry.Metadata = wh.MetricsMetadata
+		docs = append(docs, retry)
+	}
+	if len(docs) == 0 {
+		return
+	}
+	log.Infof("%d iterations retried, %d recovered", len(docs), recovered)
+	indexDocuments(iterationRetryMetric, docs)
+}
@@ -0,0 +1,413 @@
+// Copyright 2022 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	uid "github.com/google/uuid"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// ocpConfig holds the embedded workload templates and profiles
+//
+//go:embed config/*
+var ocpConfig embed.FS
+
+const configDir = "config"
+
+// NewOpenShiftCmd returns the kube-burner-ocp root command with the workloads and the rest of subcommands
+func NewOpenShiftCmd() *cobra.Command {
+	var workloadConfig workloads.Config
+	var wh workloads.WorkloadHelper
+	var metricsProfileType string
+	var metadataLabelPrefix, metadataConfigMap string
+	var mcKubeconfig, metadataReference string
+	var userWorkloadMetrics []string
+	var fleetSelector string
+	var ocmURL, ocmToken string
+	var mustGatherOnFailure, failOnThresholdCatalog bool
+	var otlpEndpoint, chaosFile, tenantDistributionFile, annotationFile, artifactsDir, presetFile string
+	var progressListenAddress, progressPushgateway string
+	var progressPushInterval time.Duration
+	var mustGatherNamespaces []string
+	var fleetConcurrency int
+	var sloFile, thresholdCatalog, baselineUUID string
+	var baselineTolerance, regressionStddev float64
+	var regressionRuns int
+	var kpiTolerances map[string]int
+	var alertProfiles, reports, pprofTargets, pprofProfiles, extraQueries, annotations []string
+	var extraQueriesFile string
+	var pprofInterval, dataplaneProbeInterval, nodeSampleInterval time.Duration
+	var alertSeverity string
+	var alertGracePeriod, indexRetryBackoff, chunkDuration time.Duration
+	var esServer, esIndex, esCredentials, discoveryCacheDir string
+	var discoveryCacheTTL time.Duration
+	var QPS, burst, rampSteps, rampStartPercent, warmupIterations, retryFailedIterations, triageSample, indexRetries, scrapeConcurrency, clientQPS, clientBurst int
+	var rampDuration, pacingWindow, prePullTimeout, retryTimeout, preemptionTimeout time.Duration
+	var priorityClass, ipFamily, namespacePrefix string
+	var priorityClassValue, preemptionPriority int32
+	var preemptionPods int
+	var dataplaneProbes, ovnMetrics, networkTables, apiRequestLatency, schedulerThroughput, imagePullLatency, podStartupPhases, routeLatency bool
+	var gcLabelSelector string
+	var gcExclude []string
+	var gcDryRun, gcAsync, prePull, autoSizeEnabled, preemption, reuseNamespaces bool
+	var watchList, auditLog, events, snapshot bool
+	var eventNamespaces []string
+	var auditLogTop int
+	var gc, gcMetrics, alerting, checkHealth, localIndexing, extract, summary, exportCSV bool
+	ocpCmd := &cobra.Command{
+		Use:  "kube-burner-ocp",
+		Long: `kube-burner plugin designed to be used with OpenShift clusters as a quick way to run well-known workloads`,
+	}
+	ocpCmd.PersistentFlags().StringVar(&presetFile, "preset", "", "YAML file with the values of the flags, top level or under the name of the workload. Command line flags override them")
+	ocpCmd.PersistentFlags().StringVar(&esServer, "es-server", "", "Elastic Search endpoint")
+	ocpCmd.PersistentFlags().StringVar(&esIndex, "es-index", "", "Elastic Search index")
+	ocpCmd.PersistentFlags().StringVar(&esCredentials, "es-credentials", "", "Source of the Elastic Search credentials, instead of embedding them in --es-server, supported options are: env, file:<directory> or vault:<path>")
+	ocpCmd.PersistentFlags().BoolVar(&localIndexing, "local-indexing", false, "Enable local indexing")
+	ocpCmd.PersistentFlags().IntVar(&indexRetries, "index-retries", 3, "Number of retries of the failed indexing requests of the documents indexed by kube-burner-ocp, the documents are written to the index-spill-<uuid> directory once exhausted")
+	ocpCmd.PersistentFlags().DurationVar(&indexRetryBackoff, "index-retry-backoff", 5*time.Second, "Delay before the first indexing retry, doubled on every retry")
+	ocpCmd.PersistentFlags().DurationVar(&chunkDuration, "chunk-duration", time.Hour, "Split the range queries of the metrics indexed at the end of the workloads and by the index subcommand in time windows of this duration, scraped and indexed one after another to avoid Prometheus query timeouts and sample limits and to bound memory usage. 0 scrapes the whole time range of each job at once")
+	ocpCmd.PersistentFlags().IntVar(&scrapeConcurrency, "scrape-concurrency", 1, "Maximum number of Prometheus endpoints, or time windows when the time range is split, scraped at once when indexing the metrics at the end of the workloads and by the index subcommand")
+	ocpCmd.PersistentFlags().StringVar(&workloadConfig.MetricsEndpoint, "metrics-endpoint", "", "YAML file with a list of metric endpoints, overrides the es-server and es-index flags")
+	ocpCmd.PersistentFlags().BoolVar(&alerting, "alerting", true, "Enable alerting")
+	ocpCmd.PersistentFlags().StringArrayVar(&extraQueries, "extra-query", nil, "Additional PromQL query to collect along with the metrics profile of the workload, in name=expr format. Can be repeated")
+	ocpCmd.PersistentFlags().StringVar(&extraQueriesFile, "extra-queries-file", "", "Metrics profile with additional queries to collect along with the metrics profile of the workload, file path or URL")
+	ocpCmd.PersistentFlags().StringSliceVar(&alertProfiles, "alert-profile", []string{"alerts.yml"}, "Comma separated list of alert profiles, file paths or URLs. Include alerts.yml to extend the embedded alert profile")
+	ocpCmd.PersistentFlags().StringVar(&alertSeverity, "fail-on-alert-severity", "error", "Minimum alert severity making the run fail, supported options are: warning, error or critical")
+	ocpCmd.PersistentFlags().StringVar(&sloFile, "slo-file", "", "YAML file with the SLOs to evaluate at the end of the run, the run fails when any of them is not met")
+	ocpCmd.PersistentFlags().StringVar(&thresholdCatalog, "threshold-catalog", "thresholds.yml", "Catalog with the expected results of each workload by cluster size, evaluated as SLOs at the end of the run. Set it to an empty string to disable it")
+	ocpCmd.PersistentFlags().BoolVar(&failOnThresholdCatalog, "fail-on-threshold-catalog", false, "Fail the run when the SLOs of the threshold catalog aren't met")
+	ocpCmd.PersistentFlags().StringVar(&baselineUUID, "baseline-uuid", "", "UUID or local metrics directory of a previous run to compare the results with, the run fails when any KPI regresses")
+	ocpCmd.PersistentFlags().Float64Var(&baselineTolerance, "baseline-tolerance", 10, "Percentage a KPI can be higher than the baseline before being considered a regression")
+	ocpCmd.PersistentFlags().StringToIntVar(&kpiTolerances, "kpi-tolerance", nil, "Per KPI tolerance percentages, overriding --baseline-tolerance for the KPIs starting with the given names, i.e. podLatencyQuantilesMeasurement.Ready.P99=20,elapsedTime=30")
+	ocpCmd.PersistentFlags().IntVar(&regressionRuns, "regression-runs", 0, "Number of recent runs of the same workload and cluster shape fetched from Elasticsearch to detect regressions, 0 disables it")
+	ocpCmd.PersistentFlags().Float64Var(&regressionStddev, "regression-stddev", 2, "Standard deviations a KPI can be above the mean of the recent runs before a regression is suspected")
+	ocpCmd.PersistentFlags().StringSliceVar(&reports, "report", nil, "Comma separated list of reports to generate at the end of the run, supported options are: html or junit")
+	ocpCmd.PersistentFlags().BoolVar(&exportCSV, "csv", false, "Also write the measurements as CSV files in the local metrics directory")
+	ocpCmd.PersistentFlags().StringVar(&artifactsDir, "artifacts-dir", "", "Directory to write the log, result.json, junit.xml, reports, rendered configuration and metrics tarball of the run to, for CI systems to archive")
+	ocpCmd.PersistentFlags().IntVar(&triageSample, "triage-sample", 10, "Number of pods not ready whose describe output, events and container logs are collected in the triage directory of --artifacts-dir when the run fails. 0 disables it")
+	ocpCmd.PersistentFlags().BoolVar(&summary, "summary", true, "Print a summary table with the KPIs of the run at the end of the workload")
+	ocpCmd.PersistentFlags().DurationVar(&alertGracePeriod, "alert-grace-period", 0, "Keep evaluating the alert profiles during this period after the workload finishes, to catch delayed effects like etcd compaction or OVN cleanup spikes triggered by garbage collection")
+	ocpCmd.PersistentFlags().StringSliceVar(&pprofTargets, "pprof-targets", nil, "Comma separated list of components to periodically collect pprof profiles from during the run, supported options are: kube-apiserver, etcd, ovn or kubelet")
+	ocpCmd.PersistentFlags().StringSliceVar(&pprofProfiles, "pprof-profiles", []string{"cpu"}, "Comma separated list of pprof profiles to collect, supported options are: cpu or heap")
+	ocpCmd.PersistentFlags().DurationVar(&pprofInterval, "pprof-interval", 2*time.Minute, "pprof collection interval")
+	ocpCmd.PersistentFlags().BoolVar(&apiRequestLatency, "api-request-latency", false, "Measure the P50, P95, P99 and average API request latency of each job by verb and resource from the kube-apiserver metrics")
+	ocpCmd.PersistentFlags().BoolVar(&auditLog, "audit-log", false, "Read the kube-apiserver audit logs of the run from the control plane nodes, and index the request rate and latency of each user by verb and resource, along with the slowest requests")
+	ocpCmd.PersistentFlags().IntVar(&auditLogTop, "audit-log-top", 20, "Number of slowest requests indexed with --audit-log")
+	ocpCmd.PersistentFlags().BoolVar(&schedulerThroughput, "scheduler-throughput", false, "Measure the scheduling throughput and the scheduling attempt latency of each job from the kube-scheduler metrics")
+	ocpCmd.PersistentFlags().BoolVar(&events, "events", false, "Capture the events of the workload namespaces and of --event-namespaces during the run and index them, so failures like FailedScheduling or FailedMount can be queried along with the rest of results")
+	ocpCmd.PersistentFlags().StringSliceVar(&eventNamespaces, "event-namespaces", defaultEventNamespaces, "Comma separated list of system namespaces whose events are captured with --events")
+	ocpCmd.PersistentFlags().BoolVar(&imagePullLatency, "image-pull-latency", false, "Measure the image pull duration of every pod created by the workload from the kubelet events")
+	ocpCmd.PersistentFlags().BoolVar(&podStartupPhases, "pod-startup-phases", false, "Measure the duration of the scheduling, networking, image pull, container start and readiness phases of every pod created by the workload")
+	ocpCmd.PersistentFlags().BoolVar(&routeLatency, "route-latency", false, "Measure the time since the creation of every route created by the workload until it's admitted by the router and until it serves traffic")
+	ocpCmd.PersistentFlags().BoolVar(&dataplaneProbes, "dataplane-probes", false, "Deploy netperf/iperf3 probes in the worker nodes to measure the pod to pod latency and throughput during the workload")
+	ocpCmd.PersistentFlags().DurationVar(&dataplaneProbeInterval, "dataplane-probe-interval", time.Minute, "Interval between dataplane probe rounds")
+	ocpCmd.PersistentFlags().BoolVar(&ovnMetrics, "ovn-metrics", false, "Collect the OVN southbound database size, logical and OpenFlow flow counts, ovs-vswitchd resource usage and pod network setup latencies of each node")
+	ocpCmd.PersistentFlags().BoolVar(&networkTables, "network-tables", false, "Sample the conntrack entries, iptables and nftables rule counts and OVS flow counts of each node, to catch dataplane table exhaustion")
+	ocpCmd.PersistentFlags().DurationVar(&nodeSampleInterval, "node-sample-interval", time.Minute, "Interval between samples of the node stats not exposed as Prometheus metrics, like the OVN logical flow count or the network tables")
+	ocpCmd.PersistentFlags().BoolVar(&snapshot, "snapshot", false, "Count the objects of every resource by namespace and record key cluster settings before and after the run, indexing the differences to detect the resources left behind")
+	ocpCmd.PersistentFlags().BoolVar(&mustGatherOnFailure, "must-gather-on-failure", false, "Collect a must-gather with oc when the workload fails or any alert with error severity fires, stored in the local metrics directory")
+	ocpCmd.PersistentFlags().StringSliceVar(&mustGatherNamespaces, "must-gather-namespaces", nil, "Comma separated list of namespaces to inspect with oc adm inspect instead of collecting a full must-gather")
+	ocpCmd.PersistentFlags().StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP HTTP endpoint to export the traces of the run phases to, i.e. http://tempo:4318/v1/traces")
+	ocpCmd.PersistentFlags().StringVar(&progressListenAddress, "progress-listen-address", "", "Address to expose the live progress of the run in /metrics, i.e. :8080")
+	ocpCmd.PersistentFlags().StringVar(&progressPushgateway, "progress-pushgateway", "", "Pushgateway URL to push the live progress of the run to")
+	ocpCmd.PersistentFlags().DurationVar(&progressPushInterval, "progress-push-interval", 15*time.Second, "Interval between pushes of the live progress of the run to the Pushgateway")
+	ocpCmd.PersistentFlags().StringVar(&tenantDistributionFile, "tenant-distribution", "", "YAML file with the tenant profiles and their weights, the namespaces of each iteration are labeled with the labels of the profile and tenant assigned to them")
+	ocpCmd.PersistentFlags().StringArrayVar(&annotations, "annotation", nil, "Annotation in key=value format indexed at the start of the run, to add context to its timeline. Can be repeated")
+	ocpCmd.PersistentFlags().StringVar(&annotationFile, "annotation-file", "", "File watched during the run for annotations to index, each line appended to it in key=value format, or free text, is indexed at the time it's read")
+	ocpCmd.PersistentFlags().StringVar(&chaosFile, "chaos-file", "", "YAML file with the chaos actions to inject during the workload: pod-kill, node-reboot, ovn-leader-delete, apiserver-rollout or netem")
+	ocpCmd.PersistentFlags().BoolVar(&checkHealth, "check-health", true, "Check cluster health before job")
+	ocpCmd.PersistentFlags().StringVar(&workloadConfig.UUID, "uuid", uid.NewString(), "Benchmark UUID")
+	ocpCmd.PersistentFlags().DurationVar(&workloadConfig.Timeout, "timeout", 4*time.Hour, "Benchmark timeout")
+	ocpCmd.PersistentFlags().IntVar(&QPS, "qps", 20, "QPS")
+	ocpCmd.PersistentFlags().IntVar(&burst, "burst", 20, "Burst")
+	ocpCmd.PersistentFlags().IntVar(&clientQPS, "client-qps", 0, "QPS of the clients of kube-burner-ocp watchers, samplers and checks, by default the number of nodes between 20 and 200")
+	ocpCmd.PersistentFlags().IntVar(&clientBurst, "client-burst", 0, "Burst of the clients of kube-burner-ocp watchers, samplers and checks, by default twice their QPS")
+	ocpCmd.PersistentFlags().StringVar(&discoveryCacheDir, "discovery-cache-dir", "", "Directory to cache the API discovery of the cluster between runs, by default it's only cached in memory during the run")
+	ocpCmd.PersistentFlags().DurationVar(&discoveryCacheTTL, "discovery-cache-ttl", 10*time.Minute, "Time the API discovery cached in --discovery-cache-dir is considered up to date")
+	ocpCmd.PersistentFlags().BoolVar(&watchList, "watch-list", false, "Stream the initial state of the informers of the measurements and watchers from a watch instead of listing it, requires the WatchList feature of the API server")
+	ocpCmd.PersistentFlags().IntVar(&rampSteps, "ramp-steps", 0, "Number of jobs the iterations are split into to ramp up the QPS and burst, supported by cluster-density-v2, cluster-density-ms and node-density. 0 disables it")
+	ocpCmd.PersistentFlags().IntVar(&rampStartPercent, "ramp-start-percent", 10, "Percentage of the QPS and burst of the first ramp-up step")
+	ocpCmd.PersistentFlags().DurationVar(&rampDuration, "ramp-duration", 0, "Pace the iterations of the ramp-up steps so the ramp-up lasts this duration, by default they run as fast as their QPS allows")
+	ocpCmd.PersistentFlags().DurationVar(&pacingWindow, "pacing-window", 0, "Spread the creation of the objects of each iteration evenly over this window instead of creating them as fast as the QPS allows, supported by cluster-density-v2, cluster-density-ms and node-density")
+	ocpCmd.PersistentFlags().BoolVar(&prePull, "pre-pull", false, "Pull the images of the workload in every worker node with a DaemonSet before the run, so the latencies aren't dominated by the image pulls")
+	ocpCmd.PersistentFlags().DurationVar(&prePullTimeout, "pre-pull-timeout", 10*time.Minute, "Maximum time to wait for the images to be pulled with --pre-pull")
+	ocpCmd.PersistentFlags().BoolVar(&autoSizeEnabled, "auto-size", false, "Compute the iterations or pods per node of the workload from the number and allocatable resources of the worker nodes, the flags given in the command line or the preset are kept. Supported by node-density, node-density-cni, node-density-heavy, cluster-density-v2 and cluster-density-ms")
+	ocpCmd.PersistentFlags().IntVar(&warmupIterations, "warmup-iterations", 0, "Iterations of the workload to run and garbage collect before the measured run, discarding their measurements, so image pulls and cold caches don't pollute the results. Supported by the workloads with iterations")
+	ocpCmd.PersistentFlags().IntVar(&retryFailedIterations, "retry-failed-iterations", 0, "Number of times the objects of an iteration with pods not ready after --retry-timeout are deleted and created again, instead of failing the run. 0 disables it")
+	ocpCmd.PersistentFlags().DurationVar(&retryTimeout, "retry-timeout", 10*time.Minute, "Time the pods of an iteration can stay not ready before it's retried with --retry-failed-iterations, it must be shorter than --timeout")
+	ocpCmd.PersistentFlags().StringVar(&priorityClass, "priority-class", "", "PriorityClass of the pods of node-density, node-density-cni, node-density-heavy, cluster-density-v2 and cluster-density-ms, created with --priority-class-value and deleted after the run when it doesn't exist")
+	ocpCmd.PersistentFlags().Int32Var(&priorityClassValue, "priority-class-value", 0, "Value of the PriorityClass created by --priority-class")
+	ocpCmd.PersistentFlags().BoolVar(&preemption, "preemption", false, "Once the workload succeeds, create pods with a higher priority than its pods in the worker nodes, measuring the time they take to preempt the pods of the workload and the time these take to be evicted")
+	ocpCmd.PersistentFlags().IntVar(&preemptionPods, "preemption-pods", 100, "Number of high priority pods created by --preemption")
+	ocpCmd.PersistentFlags().Int32Var(&preemptionPriority, "preemption-priority", 1000000, "Value of the PriorityClass of the high priority pods created by --preemption, it must be higher than --priority-class-value")
+	ocpCmd.PersistentFlags().DurationVar(&preemptionTimeout, "preemption-timeout", 10*time.Minute, "Maximum time to wait for the high priority pods created by --preemption to be ready")
+	ocpCmd.PersistentFlags().StringVar(&ipFamily, "ip-family", "", "IP family of the services of node-density-cni, node-density-heavy, cluster-density-v2 and cluster-density-ms and of the dataplane probes, supported options are: v4, v6 or dual. By default the services get the default family of the cluster")
+	ocpCmd.PersistentFlags().StringVar(&namespacePrefix, "namespace-prefix", "", "Prefix of the namespaces of cluster-density-v2, cluster-density-ms, node-density, node-density-cni, node-density-heavy and udn-density-pods, so runs with different prefixes don't share nor delete each other's namespaces")
+	ocpCmd.PersistentFlags().BoolVar(&reuseNamespaces, "reuse-namespaces", false, "Keep the namespaces of cluster-density-v2, cluster-density-ms, node-density, node-density-cni, node-density-heavy and udn-density-pods after the run, deleting only their objects, and reuse the ones left by previous runs instead of creating them again")
+	ocpCmd.PersistentFlags().BoolVar(&gc, "gc", true, "Garbage collect created resources")
+	ocpCmd.PersistentFlags().BoolVar(&gcMetrics, "gc-metrics", false, "Collect metrics during garbage collection")
+	ocpCmd.PersistentFlags().StringVar(&gcLabelSelector, "gc-label-selector", "", "Only garbage collect the resources of the run matching this label selector")
+	ocpCmd.PersistentFlags().StringSliceVar(&gcExclude, "gc-exclude", nil, "Comma separated list of kinds or resources to keep when garbage collecting, i.e. PersistentVolume. Namespaces are kept when a namespaced one is excluded")
+	ocpCmd.PersistentFlags().BoolVar(&gcDryRun, "gc-dry-run", false, "List the resources the garbage collection would delete instead of deleting them")
+	ocpCmd.PersistentFlags().BoolVar(&gcAsync, "gc-async", false, "Don't wait for the namespaces to be terminated when garbage collecting")
+	ocpCmd.PersistentFlags().StringVar(&workloadConfig.UserMetadata, "user-metadata", "", "User provided metadata file, in YAML format")
+	ocpCmd.PersistentFlags().StringVar(&metadataLabelPrefix, "metadata-label-prefix", "", "Add labels and annotations from the infrastructure/cluster object starting with this prefix to the metadata")
+	ocpCmd.PersistentFlags().StringVar(&metadataConfigMap, "metadata-configmap", "", "Add the data from this ConfigMap to the metadata, in namespace/name format")
+	ocpCmd.PersistentFlags().StringVar(&mcKubeconfig, "mc-kubeconfig", "", "Management cluster kubeconfig, used to gather hosted control plane metadata and metrics when benchmarking a HyperShift hosted cluster")
+	ocpCmd.PersistentFlags().StringSliceVar(&userWorkloadMetrics, "user-workload-metrics", []string{"metrics-uwm.yml"}, "Comma separated list of metrics profiles scraped from the user workload monitoring Prometheus when it's enabled in the cluster, empty to disable it")
+	ocpCmd.PersistentFlags().StringVar(&fleetSelector, "fleet-selector", "", "Run the workload in the ACM managed clusters matching this label selector instead of the current cluster, which must be the ACM hub")
+	ocpCmd.PersistentFlags().IntVar(&fleetConcurrency, "fleet-concurrency", 10, "Number of managed clusters running the workload at the same time with --fleet-selector")
+	ocpCmd.PersistentFlags().StringVar(&ocmToken, "ocm-token", "", "OCM offline token, used to add the OCM cluster ID, product, multi-AZ and machine pools of ROSA and OSD clusters to the metadata")
+	ocpCmd.PersistentFlags().StringVar(&ocmURL, "ocm-url", "https://api.openshift.com", "OCM API URL")
+	ocpCmd.PersistentFlags().StringVar(&metadataReference, "metadata-reference", "", "JSON file with the reference metadata to compare the cluster metadata against, created when it doesn't exist")
+	ocpCmd.PersistentFlags().BoolVar(&extract, "extract", false, "Extract workload in the current directory")
+	ocpCmd.PersistentFlags().StringVar(&metricsProfileType, "profile-type", "both", "Metrics profile to use, supported options are: regular, reporting (or its alias metrics-report) or both")
+	ocpCmd.MarkFlagsRequiredTogether("es-server", "es-index")
+	ocpCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// The flags are parsed, so the errors from here on aren't usage errors
+		cmd.SilenceUsage = true
+		if presetFile != "" {
+			if err := applyPreset(cmd, presetFile); err != nil {
+				return err
+			}
+		}
+		// Also read by the subcommands querying Elasticsearch. The credentials are kept out of the flag, shown in the reports
+		indexerAuth = indexerCredentials{}
+		if esServer != "" {
+			server, credentials, err := readIndexerCredentials(esServer, esCredentials)
+			if err != nil {
+				return err
+			}
+			indexerAuth = credentials
+			cmd.Root().PersistentFlags().Set("es-server", server)
+		}
+		// Subcommands not interacting with the cluster
+		if cmd.Name() == "version" || cmd.Name() == "grafana-dashboard" || cmd.Name() == "summarize" || cmd.Name() == "diff" || cmd.Name() == "serve" || cmd.Name() == "controller" || cmd.Name() == "package" {
+			return nil
+		}
+		// The logger of embedded runs is the one of the process embedding them
+		if !embeddedRun.enabled {
+			util.ConfigureLogging(cmd)
+		}
+		os.Setenv("CLIENT_QPS", fmt.Sprintf("%d", clientQPS))
+		os.Setenv("CLIENT_BURST", fmt.Sprintf("%d", clientBurst))
+		os.Setenv("DISCOVERY_CACHE_DIR", discoveryCacheDir)
+		os.Setenv("DISCOVERY_CACHE_TTL", discoveryCacheTTL.String())
+		if watchList {
+			EnableWatchList()
+		}
+		// gc only needs the cluster credentials
+		if cmd.Name() == "gc" {
+			return nil
+		}
+		if extract {
+			if err := workloads.ExtractWorkload(ocpConfig, configDir, cmd.Name(), "alerts.yml", "alerts-hcp.yml", "metrics.yml", "metrics-aggregated.yml", "metrics-report.yml", "metrics-ovn.yml", "metrics-hcp.yml", "metrics-uwm.yml", "thresholds.yml"); err != nil {
+				return err
+			}
+			os.Exit(0)
+		} else if !embeddedRun.enabled {
+			// The log of embedded runs is written by the process embedding them
+			if artifactsDir != "" {
+				if err := setupArtifactsLogging(artifactsDir); err != nil {
+					return err
+				}
+			} else {
+				util.SetupFileLogging("ocp-" + workloadConfig.UUID)
+			}
+		}
+		StartTracing(otlpEndpoint, cmd.Name(), workloadConfig.UUID)
+		StartProgressExporter(progressListenAddress, progressPushgateway, progressPushInterval, cmd.Name(), workloadConfig.UUID)
+		if checkHealth && (cmd.Name() != "cluster-health" || cmd.Name() == "index") {
+			endSpan := StartSpan("health-check")
+			err := ClusterHealthCheck()
+			endSpan()
+			if err != nil {
+				return err
+			}
+		}
+		if rampStartPercent < 1 || rampStartPercent > 100 {
+			return fmt.Errorf("--ramp-start-percent must be between 1 and 100")
+		}
+		if chunkDuration < 0 {
+			return fmt.Errorf("--chunk-duration can't be negative")
+		}
+		if scrapeConcurrency < 1 {
+			return fmt.Errorf("--scrape-concurrency must be at least 1")
+		}
+		if warmupIterations < 0 {
+			return fmt.Errorf("--warmup-iterations can't be negative")
+		}
+		if retryFailedIterations < 0 {
+			return fmt.Errorf("--retry-failed-iterations can't be negative")
+		}
+		if retryFailedIterations > 0 && retryTimeout >= workloadConfig.Timeout {
+			return fmt.Errorf("--retry-timeout must be shorter than --timeout")
+		}
+		if preemption && preemptionPods < 1 {
+			return fmt.Errorf("--preemption-pods must be greater than 0")
+		}
+		if preemption && preemptionPriority <= priorityClassValue {
+			return fmt.Errorf("--preemption-priority must be higher than --priority-class-value")
+		}
+		if ipFamily != "" {
+			if err := validateIPFamily(ipFamily); err != nil {
+				return err
+			}
+		}
+		if (namespacePrefix != "" || reuseNamespaces) && !slices.Contains(namespacePrefixWorkloads, cmd.Name()) {
+			log.Warnf("%s doesn't support --namespace-prefix nor --reuse-namespaces, ignoring them", cmd.Name())
+			namespacePrefix, reuseNamespaces = "", false
+		}
+		nsPrefix, err := namespacePrefixEnv(namespacePrefix)
+		if err != nil {
+			return err
+		}
+		workloadConfig.ConfigDir = configDir
+		kubeClientProvider := KubeClientProvider()
+		wh = workloads.NewWorkloadHelper(workloadConfig, &ocpConfig, kubeClientProvider)
+		envVars := map[string]string{
+			"UUID":  workloadConfig.UUID,
+			"QPS":   fmt.Sprintf("%d", QPS),
+			"BURST": fmt.Sprintf("%d", burst),
+			// The selective and async garbage collection run after the workload instead of the kube-burner one, as the ones of the
+			// preemption phase, which needs the pods of the workload, and of the reused namespaces, which keeps them
+			"GC":               fmt.Sprintf("%v", gc && gcLabelSelector == "" && len(gcExclude) == 0 && !gcDryRun && !gcAsync && !preemption && !reuseNamespaces),
+			"GC_METRICS":       fmt.Sprintf("%v", gcMetrics),
+			"PRIORITY_CLASS":   priorityClass,
+			"IP_FAMILY":        ipFamily,
+			"NAMESPACE_PREFIX": nsPrefix,
+			// The namespaces left by previous runs are kept when reused, or when they may belong to runs with other prefixes
+			"NAMESPACE_CLEANUP": fmt.Sprintf("%v", namespacePrefix == "" && !reuseNamespaces),
+		}
+		envVars["IP_FAMILY_POLICY"], envVars["IP_FAMILIES"] = ipFamilyEnv(ipFamily)
+		envVars["LOCAL_INDEXING"] = fmt.Sprintf("%v", localIndexing)
+		envVars["INDEX_RETRIES"] = fmt.Sprintf("%d", indexRetries)
+		envVars["INDEX_RETRY_BACKOFF"] = indexRetryBackoff.String()
+		envVars["CHUNK_DURATION"] = chunkDuration.String()
+		envVars["SCRAPE_CONCURRENCY"] = fmt.Sprintf("%d", scrapeConcurrency)
+		// Results are evaluated from the local indexer files, so ensure they're written
+		reporting := ProfileType(metricsProfileType) == Reporting || ProfileType(metricsProfileType) == MetricsReport
+		envVars["LOCAL_RESULTS"] = fmt.Sprintf("%v", sloFile != "" || apiRequestLatency || schedulerThroughput || failOnThresholdCatalog || baselineUUID != "" || regressionRuns > 0 || len(reports) > 0 || summary || exportCSV || reporting || artifactsDir != "")
+		// In reporting mode, latency timeseries are only written to the local indexer
+		envVars["TIMESERIES_INDEXER"] = ""
+		if reporting && workloadConfig.MetricsEndpoint == "" {
+			envVars["TIMESERIES_INDEXER"] = "local"
+		}
+		// The --pprof flag of some workloads enables the collection of the OVN components
+		if pprof, _ := cmd.Flags().GetBool("pprof"); pprof && !slices.Contains(pprofTargets, "ovn") {
+			pprofTargets = append(pprofTargets, "ovn")
+		}
+		pprofTargetList, err := PProfTargets(pprofTargets, pprofProfiles)
+		if err != nil {
+			return err
+		}
+		envVars["PPROF_TARGETS"] = pprofTargetList
+		envVars["PPROF_INTERVAL"] = pprofInterval.String()
+		if alerting {
+			if err := validateAlertSeverity(alertSeverity); err != nil {
+				return err
+			}
+			envVars["ALERTS"] = strings.Join(alertProfiles, ",")
+		} else {
+			envVars["ALERTS"] = ""
+		}
+		// If metricsEndpoint is not set, use values from flags
+		if workloadConfig.MetricsEndpoint == "" && esServer != "" && esIndex != "" {
+			envVars["ES_SERVER"] = indexerURL(esServer)
+			envVars["ES_INDEX"] = esIndex
+		}
+		for k, v := range envVars {
+			os.Setenv(k, v)
+		}
+		// After the environment the file is rendered with is set
+		if wh.MetricsEndpoint != "" {
+			metricsEndpoint, err := resolveMetricsEndpoints(wh.MetricsEndpoint)
+			if err != nil {
+				return err
+			}
+			wh.MetricsEndpoint = metricsEndpoint
+		}
+		endSpan := StartSpan("metadata")
+		if err := GatherMetadata(&wh, alerting); err != nil {
+			return err
+		}
+		if err := GatherCustomMetadata(&wh, metadataLabelPrefix, metadataConfigMap); err != nil {
+			return err
+		}
+		if err := GatherHostedClusterMetadata(&wh, mcKubeconfig); err != nil {
+			return err
+		}
+		if err := DiscoverUserWorkloadMonitoring(&wh, userWorkloadMetrics); err != nil {
+			log.Warnf("Error discovering the user workload monitoring, the user workload metrics won't be collected: %v", err)
+		}
+		if err := GatherOCMMetadata(&wh, ocmURL, ocmToken); err != nil {
+			return err
+		}
+		if err := CompareMetadata(&wh, metadataReference); err != nil {
+			return err
+		}
+		endSpan()
+		// Before the PreRun of the workload, which reads the sizing flags
+		if autoSizeEnabled {
+			if err := autoSize(cmd, &wh); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ocpCmd.AddCommand(
+		NewClusterDensity(&wh, "cluster-density-v2"),
+		NewClusterDensity(&wh, "cluster-density-ms"),
+		NewCrdScale(&wh),
+		NewNetworkPolicy(&wh, "network-policy"),
+		NewNetworkPolicyLegacy(&wh, "networkpolicy-multitenant"),
+		NewNetworkPolicyLegacy(&wh, "networkpolicy-matchlabels"),
+		NewNetworkPolicyLegacy(&wh, "networkpolicy-matchexpressions"),
+		NewNodeDensity(&wh),
+		NewNodeDensityHeavy(&wh),
+		NewNodeDensityCNI(&wh),
+		NewUDNDensityPods(&wh),
+		NewIndex(&wh, ocpConfig),
+		NewGrafanaDashboard(ocpConfig, configDir),
+		NewSummarize(),
+		NewDiff(ocpConfig, configDir),
+		NewPVCDensity(&wh),
+		NewRDSCore(&wh),
+		NewWebBurner(&wh, "web-burner-init"),
+		NewWebBurner(&wh, "web-burner-node-density"),
+		NewWebBurner(&wh, "web-burner-cluster-density"),
+		NewEgressIP(&wh, "egressip"),
+		NewWhereabouts(&wh),
+		NewVirtDensity(&wh),
+		ClusterHealth(),
+		NewGC(),
+		NewServe(),
+		NewController(),
+		NewPackage(),
+		CustomWorkload(&wh),
+	)
+	util.SetupCmd(ocpCmd)
+	return ocpCmd
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	routev1 "github.com/openshift/api/route/v1"
+	routeclient "github.com/openshift/client-go/route/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	routeLatencyMetric          = "routeLatencyMeasurement"
+	routeLatencyQuantilesMetric = "routeLatencyQuantilesMeasurement"
+	// Maximum number of concurrent requests to the routers
+	routeMaxProbes = 50
+	// Interval between requests to a route not serving traffic yet
+	routeProbeInterval = time.Second
+)
+
+// routeLatency holds the latencies of a route since its creation, in milliseconds
+type routeLatency struct {
+	Timestamp        time.Time   `json:"timestamp"`
+	UUID             string      `json:"uuid"`
+	Namespace        string      `json:"namespace"`
+	Name             string      `json:"routeName"`
+	Host             string      `json:"host"`
+	AdmissionLatency int         `json:"admissionLatency"`
+	ServingLatency   int         `json:"servingLatency"`
+	MetricName       string      `json:"metricName"`
+	JobName          string      `json:"jobName,omitempty"`
+	Metadata         interface{} `json:"metadata,omitempty"`
+}
+
+// routeTimestamps holds the timestamps of the milestones of a route
+type routeTimestamps struct {
+	namespace, name, host, jobName string
+	created, admitted, serving     time.Time
+}
+
+type routeLatencyWatcher struct {
+	stopCh     chan struct{}
+	routes     map[string]*routeTimestamps
+	httpClient *http.Client
+	// probes limits the concurrent requests to the routers
+	probes chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+// startRouteLatencyWatcher watches the routes created by the run with the given UUID to record when they're admitted by the router,
+// then requests each admitted route until it serves traffic
+func startRouteLatencyWatcher(uuid string) *routeLatencyWatcher {
+	_, restConfig := newClientSet()
+	routeClient := routeclient.NewForConfigOrDie(restConfig)
+	rlw := &routeLatencyWatcher{
+		stopCh: make(chan struct{}),
+		routes: make(map[string]*routeTimestamps),
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		probes: make(chan struct{}, routeMaxProbes),
+	}
+	routeLW := cache.NewFilteredListWatchFromClient(routeClient.RouteV1().RESTClient(), "routes", metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	})
+	_, routeController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: routeLW,
+		ObjectType:    &routev1.Route{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				rlw.handleRoute(obj.(*routev1.Route))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				rlw.handleRoute(obj.(*routev1.Route))
+			},
+		},
+	})
+	log.Info("Watching route admission and serving latency")
+	go routeController.Run(rlw.stopCh)
+	return rlw
+}
+
+func (rlw *routeLatencyWatcher) handleRoute(route *routev1.Route) {
+	rlw.mu.Lock()
+	defer rlw.mu.Unlock()
+	key := route.Namespace + "/" + route.Name
+	rt, exists := rlw.routes[key]
+	if !exists {
+		rt = &routeTimestamps{
+			namespace: route.Namespace,
+			name:      route.Name,
+			jobName:   route.Labels["kube-burner-job"],
+			created:   route.CreationTimestamp.Time,
+		}
+		rlw.routes[key] = rt
+	}
+	if !rt.admitted.IsZero() {
+		return
+	}
+	for _, ingress := range route.Status.Ingress {
+		for _, condition := range ingress.Conditions {
+			if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue && condition.LastTransitionTime != nil {
+				rt.admitted = condition.LastTransitionTime.Time
+				rt.host = ingress.Host
+			}
+		}
+	}
+	if !rt.admitted.IsZero() {
+		url := "http://" + rt.host
+		if route.Spec.TLS != nil {
+			url = "https://" + rt.host
+		}
+		rlw.wg.Add(1)
+		go rlw.probe(rt, url)
+	}
+}
+
+// probe requests the route until the router forwards the request to its backend, the router replies
+// with 503 while the route isn't configured yet or has no endpoints
+func (rlw *routeLatencyWatcher) probe(rt *routeTimestamps, url string) {
+	defer rlw.wg.Done()
+	ticker := time.NewTicker(routeProbeInterval)
+	defer ticker.Stop()
+	for {
+		rlw.probes <- struct{}{}
+		resp, err := rlw.httpClient.Get(url)
+		<-rlw.probes
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				rlw.mu.Lock()
+				rt.serving = time.Now()
+				rlw.mu.Unlock()
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-rlw.stopCh:
+			return
+		}
+	}
+}
+
+// stop stops watching and probing, and indexes the latencies of the admitted routes, and their quantiles per job
+func (rlw *routeLatencyWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(rlw.stopCh)
+	rlw.wg.Wait()
+	rlw.mu.Lock()
+	defer rlw.mu.Unlock()
+	var docs, quantiles []interface{}
+	var jobNames []string
+	latencies := make(map[string]map[string][]float64)
+	for _, rt := range rlw.routes {
+		if rt.admitted.IsZero() {
+			continue
+		}
+		rl := routeLatency{
+			Timestamp:        rt.created.UTC(),
+			UUID:             wh.UUID,
+			Namespace:        rt.namespace,
+			Name:             rt.name,
+			Host:             rt.host,
+			AdmissionLatency: max(int(rt.admitted.Sub(rt.created).Milliseconds()), 0),
+			MetricName:       routeLatencyMetric,
+			JobName:          rt.jobName,
+			Metadata:         wh.MetricsMetadata,
+		}
+		if _, exists := latencies[rl.JobName]; !exists {
+			jobNames = append(jobNames, rl.JobName)
+			latencies[rl.JobName] = make(map[string][]float64)
+		}
+		latencies[rl.JobName]["Admitted"] = append(latencies[rl.JobName]["Admitted"], float64(rl.AdmissionLatency))
+		if !rt.serving.IsZero() {
+			rl.ServingLatency = max(int(rt.serving.Sub(rt.created).Milliseconds()), 0)
+			latencies[rl.JobName]["Serving"] = append(latencies[rl.JobName]["Serving"], float64(rl.ServingLatency))
+		}
+		docs = append(docs, rl)
+	}
+	if len(docs) == 0 {
+		log.Warn("No admitted routes found")
+		return
+	}
+	for _, jobName := range jobNames {
+		for condition, values := range latencies[jobName] {
+			q := metrics.NewLatencySummary(values, condition)
+			q.UUID = wh.UUID
+			q.MetricName = routeLatencyQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(routeLatencyMetric, docs)
+	indexDocuments(routeLatencyQuantilesMetric, quantiles)
+}
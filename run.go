@@ -0,0 +1,203 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Result of a workload run through Run
+type Result struct {
+	UUID string
+	// ReturnCode the kube-burner-ocp binary would exit with, 0 when the run passed
+	ReturnCode  int
+	Passed      bool
+	ElapsedTime time.Duration
+	// MetricsDirectory is the local metrics directory of the run, when local indexing is enabled
+	MetricsDirectory string
+	// Results of the run, loaded from the local metrics directory or Elasticsearch when any of them is configured
+	JobSummaries     []burner.JobSummary
+	LatencyQuantiles []metrics.LatencyQuantiles
+	Alerts           int
+}
+
+// Subcommands of kube-burner-ocp which are not workloads
+var nonWorkloadCmds = []string{"index", "grafana-dashboard", "summarize", "diff", "cluster-health", "gc", "serve", "controller", "package", "version", "completion", "help"}
+
+// Flags of kube-burner-ocp not supported by Run, as they exit or re-execute the current binary
+var unsupportedRunFlags = []string{"extract", "fleet-selector"}
+
+// embeddedRun holds the state of the workload running through Run
+var embeddedRun struct {
+	mu      sync.Mutex
+	enabled bool
+	// rc is the return code of the last workload run
+	rc int
+}
+
+// fatalExit replaces the exit of the process when a fatal error is logged during Run
+type fatalExit struct {
+	code int
+}
+
+// fatalRecorder is a logrus hook recording the last fatal error logged during Run
+type fatalRecorder struct {
+	message string
+}
+
+func (*fatalRecorder) Levels() []log.Level {
+	return []log.Level{log.FatalLevel}
+}
+
+func (f *fatalRecorder) Fire(entry *log.Entry) error {
+	f.message = entry.Message
+	return nil
+}
+
+// Run runs the given workload as the kube-burner-ocp binary does, returning its result instead of exiting the process, so
+// it can be embedded in other tools. The return code of a failed run is part of the result; an error is returned when the
+// workload can't run or a fatal error is logged, unless it's logged from a goroutine, which still exits the process. The logger of the process is used as is, without writing the log file of the run.
+// Runs share the environment variables of the process and the global configuration of kube-burner, so they're serialized.
+// The deadline of the context bounds the timeout of the workload. kube-burner can't be cancelled once started, so a run whose
+// context is done is aborted once it stops, indexing the measurements taken so far along with an aborted runStatus document,
+// garbage collecting its resources and returning rcAborted
+func Run(ctx context.Context, opts WorkloadOptions) (result Result, err error) {
+	embeddedRun.mu.Lock()
+	defer embeddedRun.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	ocpCmd := NewOpenShiftCmd()
+	workloadCmd, err := findWorkload(ocpCmd, opts.workload())
+	if err != nil {
+		return result, err
+	}
+	// The workloads exit with their return code once finished
+	workloadCmd.PostRun = nil
+	flags := optionFlags(opts)
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		if slices.Contains(unsupportedRunFlags, name) {
+			return result, fmt.Errorf("flag --%s is not supported when embedding workloads", name)
+		}
+		names = append(names, name)
+	}
+	// Sorted so the arguments are logged in a stable order
+	sort.Strings(names)
+	args := []string{opts.workload()}
+	for _, name := range names {
+		for _, value := range flags[name] {
+			args = append(args, fmt.Sprintf("--%s=%s", name, value))
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout, err := time.ParseDuration(ocpCmd.PersistentFlags().Lookup("timeout").DefValue)
+		if value, ok := flags["timeout"]; ok {
+			timeout, err = time.ParseDuration(value[0])
+		}
+		if err != nil {
+			return result, fmt.Errorf("invalid timeout: %v", err)
+		}
+		args = append(args, fmt.Sprintf("--timeout=%v", min(timeout, time.Until(deadline).Round(time.Second))))
+	}
+	result.UUID = ocpCmd.PersistentFlags().Lookup("uuid").DefValue
+	if uuid, ok := flags["uuid"]; ok {
+		result.UUID = uuid[0]
+	}
+	ocpCmd.SetArgs(args)
+	ocpCmd.SilenceUsage, ocpCmd.SilenceErrors = true, true
+	if kubeconfigPath := opts.common().Kubeconfig; kubeconfigPath != "" {
+		kubeconfig, set := os.LookupEnv("KUBECONFIG")
+		os.Setenv("KUBECONFIG", kubeconfigPath)
+		defer func() {
+			if set {
+				os.Setenv("KUBECONFIG", kubeconfig)
+			} else {
+				os.Unsetenv("KUBECONFIG")
+			}
+		}()
+	}
+	resetClients()
+	logger := log.StandardLogger()
+	hooks := make(log.LevelHooks)
+	for level, levelHooks := range logger.Hooks {
+		hooks[level] = slices.Clone(levelHooks)
+	}
+	fatal := &fatalRecorder{}
+	logger.AddHook(fatal)
+	exitFunc := logger.ExitFunc
+	logger.ExitFunc = func(code int) {
+		panic(fatalExit{code: code})
+	}
+	embeddedRun.enabled, embeddedRun.rc = true, 0
+	start := time.Now()
+	defer func() {
+		embeddedRun.enabled = false
+		// Drops the hooks added by the run, like the error counter
+		logger.ExitFunc = exitFunc
+		logger.ReplaceHooks(hooks)
+		if r := recover(); r != nil {
+			exit, ok := r.(fatalExit)
+			if !ok {
+				panic(r)
+			}
+			result.ReturnCode, result.Passed = exit.code, false
+			err = errors.New(fatal.message)
+		}
+	}()
+	if err := ocpCmd.ExecuteContext(ctx); err != nil {
+		return result, err
+	}
+	result.ElapsedTime = time.Since(start).Round(time.Second)
+	result.ReturnCode = embeddedRun.rc
+	result.Passed = result.ReturnCode == 0
+	result.MetricsDirectory = localMetricsDirectory()
+	source := result.UUID
+	if result.MetricsDirectory != "" {
+		source = result.MetricsDirectory
+	}
+	esServer, esIndex := esEndpoint()
+	if result.MetricsDirectory == "" && esServer == "" {
+		return result, nil
+	}
+	results, err := loadResults(source, esServer, esIndex)
+	if err != nil {
+		log.Warnf("Unable to load the results of the run: %v", err)
+		return result, nil
+	}
+	result.JobSummaries, result.LatencyQuantiles, result.Alerts = results.jobSummaries, results.latencyQuantiles, len(results.alerts)
+	return result, nil
+}
+
+// findWorkload returns the subcommand of the given workload
+func findWorkload(ocpCmd *cobra.Command, workload string) (*cobra.Command, error) {
+	workloadCmd, _, err := ocpCmd.Find([]string{workload})
+	if err != nil || workloadCmd == ocpCmd || slices.Contains(nonWorkloadCmds, workloadCmd.Name()) {
+		return nil, fmt.Errorf("unknown workload %q", workload)
+	}
+	return workloadCmd, nil
+}
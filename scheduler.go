@@ -0,0 +1,127 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	schedulerThroughputMetric      = "schedulerThroughput"
+	schedulingAttemptLatencyMetric = "schedulingAttemptLatencyQuantilesMeasurement"
+)
+
+// schedulerSummary summarizes the scheduler activity during a job, throughputs are in pods per second
+type schedulerSummary struct {
+	Timestamp             time.Time   `json:"timestamp"`
+	UUID                  string      `json:"uuid"`
+	ScheduledPods         int         `json:"scheduledPods"`
+	UnschedulableAttempts int         `json:"unschedulableAttempts"`
+	ErrorAttempts         int         `json:"errorAttempts"`
+	AvgThroughput         float64     `json:"avgThroughput"`
+	MaxThroughput         float64     `json:"maxThroughput"`
+	MetricName            string      `json:"metricName"`
+	JobName               string      `json:"jobName"`
+	Metadata              interface{} `json:"metadata,omitempty"`
+}
+
+// measureSchedulerThroughput computes the scheduling throughput and the scheduling attempt latency quantiles of each job
+// from the kube-scheduler metrics
+func measureSchedulerThroughput(wh *workloads.WorkloadHelper) error {
+	defer StartSpan("scheduler-throughput")()
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return fmt.Errorf("scheduler throughput measurement requires a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return err
+	}
+	p, err := newPrometheusClient(wh)
+	if err != nil {
+		return err
+	}
+	var summaries, quantiles []interface{}
+	for _, jobSummary := range results.jobSummaries {
+		// Prometheus requires a range of at least one scrape interval
+		seconds := int(math.Max(jobSummary.EndTimestamp.Sub(jobSummary.Timestamp).Seconds(), 60))
+		window := fmt.Sprintf("%ds", seconds)
+		values := make(map[string]float64)
+		for name, query := range map[string]string{
+			"scheduled":     fmt.Sprintf(`sum(increase(scheduler_schedule_attempts_total{result="scheduled"}[%s]))`, window),
+			"unschedulable": fmt.Sprintf(`sum(increase(scheduler_schedule_attempts_total{result="unschedulable"}[%s]))`, window),
+			"error":         fmt.Sprintf(`sum(increase(scheduler_schedule_attempts_total{result="error"}[%s]))`, window),
+			"max":           fmt.Sprintf(`max_over_time(sum(irate(scheduler_schedule_attempts_total{result="scheduled"}[2m]))[%s:])`, window),
+			"P50":           fmt.Sprintf(`histogram_quantile(0.5, sum(increase(scheduler_scheduling_attempt_duration_seconds_bucket{result="scheduled"}[%s])) by (le))`, window),
+			"P95":           fmt.Sprintf(`histogram_quantile(0.95, sum(increase(scheduler_scheduling_attempt_duration_seconds_bucket{result="scheduled"}[%s])) by (le))`, window),
+			"P99":           fmt.Sprintf(`histogram_quantile(0.99, sum(increase(scheduler_scheduling_attempt_duration_seconds_bucket{result="scheduled"}[%s])) by (le))`, window),
+			"avg":           fmt.Sprintf(`sum(increase(scheduler_scheduling_attempt_duration_seconds_sum{result="scheduled"}[%[1]s])) / sum(increase(scheduler_scheduling_attempt_duration_seconds_count{result="scheduled"}[%[1]s]))`, window),
+		} {
+			v, err := p.Query(query, jobSummary.EndTimestamp)
+			if err != nil {
+				return fmt.Errorf("error querying scheduler metrics: %v", err)
+			}
+			// Pods not scheduled during the job produce empty results or NaN values
+			for _, sample := range v.(model.Vector) {
+				if value := float64(sample.Value); !math.IsNaN(value) && !math.IsInf(value, 0) {
+					values[name] = value
+				}
+			}
+		}
+		summaries = append(summaries, schedulerSummary{
+			Timestamp:             jobSummary.EndTimestamp,
+			UUID:                  wh.UUID,
+			ScheduledPods:         int(math.Round(values["scheduled"])),
+			UnschedulableAttempts: int(math.Round(values["unschedulable"])),
+			ErrorAttempts:         int(math.Round(values["error"])),
+			AvgThroughput:         values["scheduled"] / float64(seconds),
+			MaxThroughput:         values["max"],
+			MetricName:            schedulerThroughputMetric,
+			JobName:               jobSummary.JobConfig.Name,
+			Metadata:              wh.MetricsMetadata,
+		})
+		if values["scheduled"] == 0 {
+			continue
+		}
+		ms := func(seconds float64) int {
+			return int(seconds * float64(time.Second/time.Millisecond))
+		}
+		quantiles = append(quantiles, metrics.LatencyQuantiles{
+			QuantileName: "Scheduled",
+			UUID:         wh.UUID,
+			P50:          ms(values["P50"]),
+			P95:          ms(values["P95"]),
+			P99:          ms(values["P99"]),
+			Avg:          ms(values["avg"]),
+			Timestamp:    jobSummary.EndTimestamp,
+			MetricName:   schedulingAttemptLatencyMetric,
+			JobName:      jobSummary.JobConfig.Name,
+			Metadata:     wh.MetricsMetadata,
+		})
+	}
+	log.Infof("Indexing scheduler throughput of %d jobs", len(summaries))
+	indexDocuments(schedulerThroughputMetric, summaries)
+	if len(quantiles) > 0 {
+		indexDocuments(schedulingAttemptLatencyMetric, quantiles)
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kube-burner/kube-burner/pkg/burner"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Status of the runs submitted to the server
+const (
+	runQueued   = "queued"
+	runRunning  = "running"
+	runFinished = "finished"
+	runError    = "error"
+)
+
+// runRequest is the body of a run submission
+type runRequest struct {
+	Workload string `json:"workload"`
+	// Kubeconfig is the content of the kubeconfig of the cluster, the one of the server by default
+	Kubeconfig string            `json:"kubeconfig"`
+	Flags      map[string]string `json:"flags"`
+}
+
+// Global flags a client can set in the runs submitted to the server. Flags reading or writing files of the server, exposing
+// ports, sending data to other endpoints or executing binaries are left out, so they're only set in the server itself
+var remoteRunFlags = []string{
+	"alert-grace-period", "alerting", "api-request-latency", "audit-log", "audit-log-top", "auto-size",
+	"baseline-tolerance", "burst", "check-health", "chunk-duration", "client-burst", "client-qps", "csv",
+	"dataplane-probe-interval", "dataplane-probes", "es-index", "es-server", "event-namespaces", "events",
+	"extra-query", "fail-on-alert-severity", "fail-on-threshold-catalog", "gc", "gc-async", "gc-dry-run", "gc-exclude",
+	"gc-label-selector", "gc-metrics", "image-pull-latency", "index-retries", "index-retry-backoff", "ip-family",
+	"kpi-tolerance", "local-indexing", "metadata-configmap", "metadata-label-prefix", "namespace-prefix",
+	"network-tables", "node-sample-interval", "ovn-metrics", "pacing-window", "pod-startup-phases", "pprof-interval",
+	"pprof-profiles", "pprof-targets", "pre-pull", "pre-pull-timeout", "preemption", "preemption-pods",
+	"preemption-priority", "preemption-timeout", "priority-class", "priority-class-value", "profile-type", "qps",
+	"ramp-duration", "ramp-start-percent", "ramp-steps", "regression-runs", "regression-stddev", "report",
+	"retry-failed-iterations", "retry-timeout", "reuse-namespaces", "route-latency", "scheduler-throughput",
+	"scrape-concurrency", "snapshot", "summary", "timeout", "uuid", "warmup-iterations", "watch-list",
+}
+
+// Flags of the workloads taking files of the server, which a client can't set
+var remoteRunFileFlags = []string{"config", "extra-template", "metrics-profile", "pod-spec-overlay", "sha256sums"}
+
+// serverRun is a run submitted to the server
+type serverRun struct {
+	UUID            string     `json:"uuid"`
+	Workload        string     `json:"workload"`
+	Status          string     `json:"status"`
+	SubmitTimestamp time.Time  `json:"submitTimestamp"`
+	StartTimestamp  *time.Time `json:"startTimestamp,omitempty"`
+	EndTimestamp    *time.Time `json:"endTimestamp,omitempty"`
+	ReturnCode      int        `json:"rc"`
+	Passed          bool       `json:"passed"`
+	ElapsedTime     float64    `json:"elapsedTime,omitempty"`
+	Alerts          int        `json:"alerts"`
+	Error           string     `json:"error,omitempty"`
+	request         runRequest
+	results         *runResultsResponse
+}
+
+// runResultsResponse holds the results of a finished run
+type runResultsResponse struct {
+	UUID             string                     `json:"uuid"`
+	MetricsDirectory string                     `json:"metricsDirectory,omitempty"`
+	JobSummaries     []burner.JobSummary        `json:"jobSummaries"`
+	LatencyQuantiles []metrics.LatencyQuantiles `json:"latencyQuantiles"`
+}
+
+// runServer runs the submitted workloads one at a time, in submission order
+type runServer struct {
+	token   string
+	maxRuns int
+	mu      sync.Mutex
+	runs    map[string]*serverRun
+	queue   chan *serverRun
+}
+
+// NewServe serves an API to submit workload runs and fetch their status and results
+func NewServe() *cobra.Command {
+	var listenAddress, token, tlsCert, tlsKey string
+	var queueSize, maxRuns int
+	var insecure bool
+	cmd := &cobra.Command{
+		Use:          "serve",
+		Short:        "Serves an API to submit workload runs and fetch their status and results",
+		Long:         "Serves a REST API to submit workload runs, query their status and fetch their results and logs, so benchmarks can be driven remotely. Runs are executed one at a time in submission order, and kept in memory",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			util.ConfigureLogging(cmd)
+			if token == "" {
+				token = os.Getenv("KUBE_BURNER_OCP_TOKEN")
+			}
+			if token == "" {
+				if !insecure {
+					return fmt.Errorf("a token is required, set --token or KUBE_BURNER_OCP_TOKEN, or --insecure to serve the API without authentication")
+				}
+				log.Warn("No token configured, the API is not authenticated")
+			}
+			s := &runServer{
+				token:   token,
+				maxRuns: maxRuns,
+				runs:    make(map[string]*serverRun),
+				queue:   make(chan *serverRun, queueSize),
+			}
+			server := &http.Server{
+				Addr:              listenAddress,
+				Handler:           s.handler(),
+				ReadHeaderTimeout: 30 * time.Second,
+			}
+			// On SIGINT or SIGTERM, or when the server fails, the running workload is aborted before exiting
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			serveErr := make(chan error, 1)
+			go func() {
+				log.Infof("Serving the kube-burner-ocp API in %s", listenAddress)
+				var err error
+				if tlsCert != "" {
+					err = server.ListenAndServeTLS(tlsCert, tlsKey)
+				} else {
+					err = server.ListenAndServe()
+				}
+				if !errors.Is(err, http.ErrServerClosed) {
+					serveErr <- err
+					stop()
+				}
+			}()
+			s.worker(ctx)
+			log.Info("Shutting down the kube-burner-ocp API")
+			server.Close()
+			select {
+			case err := <-serveErr:
+				return err
+			default:
+				return nil
+			}
+		},
+	}
+	cmd.Flags().StringVar(&listenAddress, "listen-address", "127.0.0.1:8080", "Address to serve the API in")
+	cmd.Flags().StringVar(&token, "token", "", "Bearer token required by the API, by default the one from the KUBE_BURNER_OCP_TOKEN environment variable")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Serve the API without authentication when no token is configured")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "TLS key file")
+	cmd.Flags().IntVar(&queueSize, "queue-size", 100, "Maximum number of queued runs")
+	cmd.Flags().IntVar(&maxRuns, "max-runs", 100, "Maximum number of finished runs kept, the oldest ones are forgotten")
+	cmd.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	return cmd
+}
+
+func (s *runServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/runs", s.submitRun)
+	mux.HandleFunc("GET /api/v1/runs", s.listRuns)
+	mux.HandleFunc("GET /api/v1/runs/{uuid}", s.getRun)
+	mux.HandleFunc("GET /api/v1/runs/{uuid}/results", s.getResults)
+	mux.HandleFunc("GET /api/v1/runs/{uuid}/log", s.getLog)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (s *runServer) submitRun(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding run request: %v", err))
+		return
+	}
+	if req.Workload == "" {
+		writeError(w, http.StatusBadRequest, "workload is required")
+		return
+	}
+	if req.Flags == nil {
+		req.Flags = make(map[string]string)
+	}
+	if err := validateRunFlags(req.Workload, req.Flags); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateRunKubeconfig(req.Kubeconfig); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid kubeconfig: %v", err))
+		return
+	}
+	// The UUID identifies the run in the API
+	if req.Flags["uuid"] == "" {
+		req.Flags["uuid"] = uuid.NewString()
+	}
+	// It names the log file of the run
+	if !validRunUUID(req.Flags["uuid"]) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid uuid %s, it must be a UUID or a DNS label", req.Flags["uuid"]))
+		return
+	}
+	run := &serverRun{
+		UUID:            req.Flags["uuid"],
+		Workload:        req.Workload,
+		Status:          runQueued,
+		SubmitTimestamp: time.Now().UTC(),
+		request:         req,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.runs[run.UUID]; exists {
+		writeError(w, http.StatusConflict, fmt.Sprintf("run %s already exists", run.UUID))
+		return
+	}
+	select {
+	case s.queue <- run:
+	default:
+		writeError(w, http.StatusServiceUnavailable, "run queue is full")
+		return
+	}
+	s.runs[run.UUID] = run
+	log.Infof("Run %s of %s queued", run.UUID, run.Workload)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// validateRunFlags checks the given flags of a submitted run are either allowed global flags or flags of the workload
+// not taking files of the server
+func validateRunFlags(workload string, flags map[string]string) error {
+	workloadCmd, err := findWorkload(NewOpenShiftCmd(), workload)
+	if err != nil {
+		return err
+	}
+	for name := range flags {
+		if slices.Contains(remoteRunFlags, name) {
+			continue
+		}
+		if workloadCmd.LocalNonPersistentFlags().Lookup(name) != nil && !slices.Contains(remoteRunFileFlags, name) {
+			continue
+		}
+		return fmt.Errorf("flag --%s can't be set in the runs submitted to the server", name)
+	}
+	return nil
+}
+
+// validateRunKubeconfig checks the given kubeconfig of a submitted run embeds its credentials, rejecting the users running
+// commands in the server, like exec or auth-provider plugins, and the references to files of the server
+func validateRunKubeconfig(kubeconfig string) error {
+	if kubeconfig == "" {
+		return nil
+	}
+	config, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		return err
+	}
+	for name, user := range config.AuthInfos {
+		switch {
+		case user.Exec != nil:
+			return fmt.Errorf("user %s uses an exec plugin", name)
+		case user.AuthProvider != nil:
+			return fmt.Errorf("user %s uses an auth-provider plugin", name)
+		case user.ClientCertificate != "" || user.ClientKey != "" || user.TokenFile != "":
+			return fmt.Errorf("user %s references files, the credentials must be embedded", name)
+		}
+	}
+	for name, cluster := range config.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("cluster %s references files, the certificate authority must be embedded", name)
+		}
+	}
+	return nil
+}
+
+// validRunUUID returns whether the given run UUID is a UUID or a DNS label
+func validRunUUID(runUUID string) bool {
+	if _, err := uuid.Parse(runUUID); err == nil {
+		return true
+	}
+	return len(validation.IsDNS1123Label(runUUID)) == 0
+}
+
+func (s *runServer) listRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]serverRun, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, *run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].SubmitTimestamp.Before(runs[j].SubmitTimestamp) })
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// lookupRun returns a copy of the run of the request, writing a not found error when it doesn't exist
+func (s *runServer) lookupRun(w http.ResponseWriter, r *http.Request) (serverRun, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[r.PathValue("uuid")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("run %s not found", r.PathValue("uuid")))
+		return serverRun{}, false
+	}
+	return *run, true
+}
+
+func (s *runServer) getRun(w http.ResponseWriter, r *http.Request) {
+	if run, ok := s.lookupRun(w, r); ok {
+		writeJSON(w, http.StatusOK, run)
+	}
+}
+
+func (s *runServer) getResults(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookupRun(w, r)
+	if !ok {
+		return
+	}
+	if run.results == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("run %s is %s, results are only available once finished", run.UUID, run.Status))
+		return
+	}
+	writeJSON(w, http.StatusOK, run.results)
+}
+
+func (s *runServer) getLog(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookupRun(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	http.ServeFile(w, r, fmt.Sprintf("kube-burner-ocp-%s.log", run.UUID))
+}
+
+// worker executes the queued runs until the context is done
+func (s *runServer) worker(ctx context.Context) {
+	for {
+		select {
+		case run := <-s.queue:
+			s.execute(ctx, run)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *runServer) execute(ctx context.Context, run *serverRun) {
+	s.mu.Lock()
+	start := time.Now().UTC()
+	run.Status, run.StartTimestamp = runRunning, &start
+	s.mu.Unlock()
+	log.Infof("Starting run %s of %s", run.UUID, run.Workload)
+	opts := workloadFlags{name: run.request.Workload, CommonOptions: CommonOptions{Flags: run.request.Flags}}
+	result, err := func() (Result, error) {
+		// Runs use the logger of the server, which also writes the log of the run while it goes
+		logFile, err := os.Create(fmt.Sprintf("kube-burner-ocp-%s.log", run.UUID))
+		if err != nil {
+			return Result{}, err
+		}
+		defer logFile.Close()
+		logger := log.StandardLogger()
+		out := logger.Out
+		logger.SetOutput(io.MultiWriter(out, logFile))
+		defer logger.SetOutput(out)
+		if run.request.Kubeconfig == "" {
+			return Run(ctx, opts)
+		}
+		kubeconfigDir, err := os.MkdirTemp("", "kube-burner-ocp-")
+		if err != nil {
+			return Result{}, err
+		}
+		defer os.RemoveAll(kubeconfigDir)
+		opts.Kubeconfig = filepath.Join(kubeconfigDir, "kubeconfig")
+		if err := os.WriteFile(opts.Kubeconfig, []byte(run.request.Kubeconfig), 0600); err != nil {
+			return Result{}, err
+		}
+		return Run(ctx, opts)
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := time.Now().UTC()
+	run.EndTimestamp = &end
+	defer s.pruneRuns()
+	run.ReturnCode, run.Passed, run.ElapsedTime, run.Alerts = result.ReturnCode, result.Passed, result.ElapsedTime.Seconds(), result.Alerts
+	if err != nil {
+		run.Status, run.Error = runError, err.Error()
+		log.Errorf("Run %s of %s failed: %v", run.UUID, run.Workload, err)
+		return
+	}
+	run.Status = runFinished
+	run.results = &runResultsResponse{
+		UUID:             run.UUID,
+		MetricsDirectory: result.MetricsDirectory,
+		JobSummaries:     result.JobSummaries,
+		LatencyQuantiles: result.LatencyQuantiles,
+	}
+	log.Infof("Run %s of %s finished with return code %d", run.UUID, run.Workload, run.ReturnCode)
+}
+
+// pruneRuns forgets the oldest finished runs beyond the maximum number of runs kept, with the lock held
+func (s *runServer) pruneRuns() {
+	var finished []*serverRun
+	for _, run := range s.runs {
+		if run.EndTimestamp != nil {
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) <= s.maxRuns {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].EndTimestamp.Before(*finished[j].EndTimestamp) })
+	for _, run := range finished[:len(finished)-s.maxRuns] {
+		delete(s.runs, run.UUID)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const sloResultMetricName = "sloResult"
+
+// slo defines a service level objective evaluated at the end of the run.
+// It's evaluated either against a latency measurement or a PromQL expression
+type slo struct {
+	// Name of the SLO
+	Name string `yaml:"name"`
+	// Measurement name, i.e. podLatency
+	Measurement string `yaml:"measurement"`
+	// QuantileName of the measurement, i.e. Ready
+	QuantileName string `yaml:"quantileName"`
+	// Quantile to compare: P99, P95, P50, avg or max
+	Quantile string `yaml:"quantile"`
+	// PromQL expression, its maximum value during each job is compared
+	Expr string `yaml:"expr"`
+	// JobName restricts the SLO to the given job
+	JobName string `yaml:"jobName"`
+	// Threshold, a duration for measurements or a number for expressions
+	Threshold string `yaml:"threshold"`
+}
+
+// sloResult document indexed for each evaluated SLO
+type sloResult struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	UUID       string                 `json:"uuid"`
+	Name       string                 `json:"sloName"`
+	JobName    string                 `json:"jobName,omitempty"`
+	Value      float64                `json:"value"`
+	Threshold  float64                `json:"threshold"`
+	Passed     bool                   `json:"passed"`
+	MetricName string                 `json:"metricName"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// evaluateSLOs evaluates the given SLOs against the results of the run,
+// indexes a sloResult document per SLO and job, and returns the results
+func evaluateSLOs(wh *workloads.WorkloadHelper, slos []slo) ([]sloResult, error) {
+	defer StartSpan("slo")()
+	var sloResults []sloResult
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return nil, fmt.Errorf("SLO evaluation requires a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range slos {
+		var values map[string]float64
+		var threshold float64
+		if s.Expr != "" {
+			threshold, err = parseThreshold(s.Threshold, time.Second)
+			if err == nil {
+				values, err = evaluateExpr(wh, s, results)
+			}
+		} else {
+			threshold, err = parseThreshold(s.Threshold, time.Millisecond)
+			if err == nil {
+				values, err = evaluateMeasurement(s, results.latencyQuantiles)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating SLO %s: %v", s.Name, err)
+		}
+		if len(values) == 0 {
+			log.Warnf("❌ SLO %s: no data found", s.Name)
+			sloResults = append(sloResults, newSLOResult(wh, s.Name, s.JobName, math.NaN(), threshold))
+		}
+		for jobName, value := range values {
+			result := newSLOResult(wh, s.Name, jobName, value, threshold)
+			if result.Passed {
+				log.Infof("✅ SLO %s in job %s: %.2f <= %.2f", s.Name, jobName, value, threshold)
+			} else {
+				log.Errorf("❌ SLO %s in job %s: %.2f > %.2f", s.Name, jobName, value, threshold)
+			}
+			sloResults = append(sloResults, result)
+		}
+	}
+	var documents []interface{}
+	for _, result := range sloResults {
+		if math.IsNaN(result.Value) {
+			result.Value = 0
+		}
+		documents = append(documents, result)
+	}
+	if len(documents) > 0 {
+		indexDocuments(sloResultMetricName, documents)
+	}
+	return sloResults, nil
+}
+
+func readSLOFile(sloFile string) ([]slo, error) {
+	var slos []slo
+	data, err := os.ReadFile(sloFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SLO file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &slos); err != nil {
+		return nil, fmt.Errorf("error decoding SLO file %s: %v", sloFile, err)
+	}
+	return slos, nil
+}
+
+func newSLOResult(wh *workloads.WorkloadHelper, name, jobName string, value, threshold float64) sloResult {
+	return sloResult{
+		Timestamp:  time.Now().UTC(),
+		UUID:       wh.UUID,
+		Name:       name,
+		JobName:    jobName,
+		Value:      value,
+		Threshold:  threshold,
+		Passed:     !math.IsNaN(value) && value <= threshold,
+		MetricName: sloResultMetricName,
+		Metadata:   wh.MetricsMetadata,
+	}
+}
+
+// parseThreshold parses the threshold as a duration expressed in the given unit, or as a plain number
+func parseThreshold(threshold string, unit time.Duration) (float64, error) {
+	if d, err := time.ParseDuration(threshold); err == nil {
+		return float64(d) / float64(unit), nil
+	}
+	return strconv.ParseFloat(threshold, 64)
+}
+
+// evaluateMeasurement returns the configured quantile of the measurement, indexed by job name, in milliseconds
+func evaluateMeasurement(s slo, latencyQuantiles []metrics.LatencyQuantiles) (map[string]float64, error) {
+	values := make(map[string]float64)
+	for _, lq := range latencyQuantiles {
+		if lq.MetricName != s.Measurement+"QuantilesMeasurement" || lq.QuantileName != s.QuantileName {
+			continue
+		}
+		if s.JobName != "" && lq.JobName != s.JobName {
+			continue
+		}
+		switch s.Quantile {
+		case "P99":
+			values[lq.JobName] = float64(lq.P99)
+		case "P95":
+			values[lq.JobName] = float64(lq.P95)
+		case "P50":
+			values[lq.JobName] = float64(lq.P50)
+		case "avg":
+			values[lq.JobName] = float64(lq.Avg)
+		case "max":
+			values[lq.JobName] = float64(lq.Max)
+		default:
+			return nil, fmt.Errorf("unsupported quantile %s, supported options are: P99, P95, P50, avg or max", s.Quantile)
+		}
+	}
+	return values, nil
+}
+
+// newPrometheusClient returns a client of the Prometheus instance used by the workload, discovering it when alerting is disabled
+func newPrometheusClient(wh *workloads.WorkloadHelper) (*prometheus.Prometheus, error) {
+	var err error
+	prometheusURL, prometheusToken := wh.PrometheusURL, wh.PrometheusToken
+	if prometheusURL == "" {
+		prometheusURL, prometheusToken, err = wh.MetadataAgent.GetPrometheus()
+		if err != nil {
+			return nil, fmt.Errorf("error obtaining Prometheus information: %v", err)
+		}
+	}
+	return prometheus.NewClient(prometheusURL, prometheusToken, "", "", true)
+}
+
+// evaluateExpr returns the maximum value of the expression during each job
+func evaluateExpr(wh *workloads.WorkloadHelper, s slo, results runResults) (map[string]float64, error) {
+	values := make(map[string]float64)
+	p, err := newPrometheusClient(wh)
+	if err != nil {
+		return nil, err
+	}
+	for _, jobSummary := range results.jobSummaries {
+		if s.JobName != "" && jobSummary.JobConfig.Name != s.JobName {
+			continue
+		}
+		v, err := p.QueryRange(s.Expr, jobSummary.Timestamp, jobSummary.EndTimestamp, 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range v.(model.Matrix) {
+			for _, value := range sample.Values {
+				if current, ok := values[jobSummary.JobConfig.Name]; !ok || float64(value.Value) > current {
+					values[jobSummary.JobConfig.Name] = float64(value.Value)
+				}
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/client-go/config/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	clusterSnapshotMetric      = "clusterSnapshot"
+	clusterSnapshotDiffMetric  = "clusterSnapshotDiff"
+	clusterSettingChangeMetric = "clusterSettingChange"
+	// Page size of the object listings
+	snapshotPageSize = 500
+)
+
+// Groups and resources not counted, since they change regardless of the run or are expensive to list
+var (
+	snapshotExcludedGroups    = []string{"metrics.k8s.io", "packages.operators.coreos.com"}
+	snapshotExcludedResources = []string{"events", "componentstatuses"}
+)
+
+// clusterSnapshot holds the object counts by resource and namespace and the key settings of the cluster at a point in time
+type clusterSnapshot struct {
+	Timestamp time.Time `json:"timestamp"`
+	UUID      string    `json:"uuid"`
+	// Phase is either before or after the run
+	Phase string `json:"phase"`
+	// Objects is the total count of objects of each resource
+	Objects    map[string]int    `json:"objects"`
+	Settings   map[string]string `json:"settings"`
+	MetricName string            `json:"metricName"`
+	Metadata   interface{}       `json:"metadata,omitempty"`
+	// counts by resource and namespace, empty for the cluster scoped resources
+	counts map[string]map[string]int
+}
+
+// clusterSnapshotDiff document indexed for each resource and namespace whose object count changed during the run
+type clusterSnapshotDiff struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	Resource   string      `json:"resource"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Before     int         `json:"before"`
+	After      int         `json:"after"`
+	Delta      int         `json:"delta"`
+	MetricName string      `json:"metricName"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+// clusterSettingChange document indexed for each cluster setting changed during the run
+type clusterSettingChange struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	Setting    string      `json:"setting"`
+	Before     string      `json:"before"`
+	After      string      `json:"after"`
+	MetricName string      `json:"metricName"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+// takeSnapshot counts the objects of every listable resource by namespace, and records the key settings of the cluster
+func takeSnapshot(uuid, phase string) (*clusterSnapshot, error) {
+	defer StartSpan("snapshot " + phase)()
+	_, restConfig := newClientSet()
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &clusterSnapshot{
+		Timestamp:  time.Now().UTC(),
+		UUID:       uuid,
+		Phase:      phase,
+		Objects:    make(map[string]int),
+		MetricName: clusterSnapshotMetric,
+		counts:     make(map[string]map[string]int),
+	}
+	log.Infof("Taking a snapshot of the cluster %s the run", phase)
+	// Discovery returns the resources of the available API groups along with the error of the failing ones
+	resourceLists, err := discoveryClient().ServerPreferredResources()
+	if err != nil {
+		log.Warnf("Error discovering API resources: %v", err)
+	}
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || slices.Contains(snapshotExcludedGroups, gv.Group) {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") || !slices.Contains(resource.Verbs, "list") || slices.Contains(snapshotExcludedResources, resource.Name) {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			counts, err := countObjects(metadataClient, gvr)
+			if err != nil {
+				log.Debugf("Unable to list %s: %v", gvr.GroupResource(), err)
+				continue
+			}
+			name := gvr.GroupResource().String()
+			snapshot.counts[name] = counts
+			for _, count := range counts {
+				snapshot.Objects[name] += count
+			}
+		}
+	}
+	snapshot.Settings = clusterSettings(restConfig)
+	log.Infof("Cluster snapshot %s the run: %s", phase, snapshot)
+	return snapshot, nil
+}
+
+// countObjects returns the number of objects of the given resource in each namespace
+func countObjects(metadataClient metadata.Interface, gvr schema.GroupVersionResource) (map[string]int, error) {
+	counts := make(map[string]int)
+	opts := metav1.ListOptions{Limit: snapshotPageSize}
+	for {
+		objects, err := metadataClient.Resource(gvr).List(context.TODO(), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects.Items {
+			counts[object.Namespace]++
+		}
+		if opts.Continue = objects.Continue; opts.Continue == "" {
+			return counts, nil
+		}
+	}
+}
+
+// clusterSettings returns the version, network type, feature set, API server audit profile and the degraded or unavailable
+// cluster operators, along with the rendered configuration of each machine config pool
+func clusterSettings(restConfig *rest.Config) map[string]string {
+	settings := make(map[string]string)
+	openshiftClientset, err := versioned.NewForConfig(restConfig)
+	if err != nil {
+		log.Warnf("Error creating OpenShift clientset: %v", err)
+		return settings
+	}
+	if cv, err := openshiftClientset.ConfigV1().ClusterVersions().Get(context.TODO(), "version", metav1.GetOptions{}); err == nil {
+		settings["clusterVersion"] = cv.Status.Desired.Version
+	}
+	if network, err := openshiftClientset.ConfigV1().Networks().Get(context.TODO(), "cluster", metav1.GetOptions{}); err == nil {
+		settings["networkType"] = network.Status.NetworkType
+	}
+	if fg, err := openshiftClientset.ConfigV1().FeatureGates().Get(context.TODO(), "cluster", metav1.GetOptions{}); err == nil {
+		settings["featureSet"] = string(fg.Spec.FeatureSet)
+	}
+	if apiServer, err := openshiftClientset.ConfigV1().APIServers().Get(context.TODO(), "cluster", metav1.GetOptions{}); err == nil {
+		settings["auditProfile"] = string(apiServer.Spec.Audit.Profile)
+	}
+	if cos, err := openshiftClientset.ConfigV1().ClusterOperators().List(context.TODO(), metav1.ListOptions{}); err == nil {
+		var degraded, unavailable []string
+		for _, co := range cos.Items {
+			for _, condition := range co.Status.Conditions {
+				if condition.Type == configv1.OperatorDegraded && condition.Status == configv1.ConditionTrue {
+					degraded = append(degraded, co.Name)
+				}
+				if condition.Type == configv1.OperatorAvailable && condition.Status != configv1.ConditionTrue {
+					unavailable = append(unavailable, co.Name)
+				}
+			}
+		}
+		settings["degradedOperators"] = strings.Join(degraded, ",")
+		settings["unavailableOperators"] = strings.Join(unavailable, ",")
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return settings
+	}
+	mcps, err := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    "machineconfiguration.openshift.io",
+		Version:  "v1",
+		Resource: "machineconfigpools",
+	}).List(context.TODO(), metav1.ListOptions{})
+	if err == nil {
+		for _, mcp := range mcps.Items {
+			rendered, _, _ := unstructured.NestedString(mcp.Object, "spec", "configuration", "name")
+			settings["machineConfigPool/"+mcp.GetName()] = rendered
+		}
+	}
+	return settings
+}
+
+// indexSnapshotDiff indexes both snapshots and the differences between them, logging the objects left behind by the run
+func indexSnapshotDiff(wh *workloads.WorkloadHelper, before, after *clusterSnapshot) {
+	var diffs, changes []interface{}
+	for _, resource := range sortedKeys(before.counts, after.counts) {
+		for _, namespace := range sortedKeys(before.counts[resource], after.counts[resource]) {
+			beforeCount, afterCount := before.counts[resource][namespace], after.counts[resource][namespace]
+			if beforeCount == afterCount {
+				continue
+			}
+			diff := clusterSnapshotDiff{
+				Timestamp:  after.Timestamp,
+				UUID:       wh.UUID,
+				Resource:   resource,
+				Namespace:  namespace,
+				Before:     beforeCount,
+				After:      afterCount,
+				Delta:      afterCount - beforeCount,
+				MetricName: clusterSnapshotDiffMetric,
+				Metadata:   wh.MetricsMetadata,
+			}
+			if diff.Delta > 0 {
+				location := "cluster scoped"
+				if namespace != "" {
+					location = "in namespace " + namespace
+				}
+				log.Warnf("%d more %s %s after the run", diff.Delta, resource, location)
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	for _, setting := range sortedKeys(before.Settings, after.Settings) {
+		if before.Settings[setting] == after.Settings[setting] {
+			continue
+		}
+		log.Warnf("Cluster setting %s changed during the run: %q → %q", setting, before.Settings[setting], after.Settings[setting])
+		changes = append(changes, clusterSettingChange{
+			Timestamp:  after.Timestamp,
+			UUID:       wh.UUID,
+			Setting:    setting,
+			Before:     before.Settings[setting],
+			After:      after.Settings[setting],
+			MetricName: clusterSettingChangeMetric,
+			Metadata:   wh.MetricsMetadata,
+		})
+	}
+	if len(diffs) == 0 && len(changes) == 0 {
+		log.Info("No differences found between the cluster snapshots")
+	}
+	before.Metadata, after.Metadata = wh.MetricsMetadata, wh.MetricsMetadata
+	indexDocuments(clusterSnapshotMetric, []interface{}{*before, *after})
+	if len(diffs) > 0 {
+		indexDocuments(clusterSnapshotDiffMetric, diffs)
+	}
+	if len(changes) > 0 {
+		indexDocuments(clusterSettingChangeMetric, changes)
+	}
+}
+
+// String summarizes the snapshot for the logs
+func (s *clusterSnapshot) String() string {
+	var total int
+	for _, count := range s.Objects {
+		total += count
+	}
+	return fmt.Sprintf("%d objects of %d resources", total, len(s.Objects))
+}
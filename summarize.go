@@ -0,0 +1,120 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// summaryEnvironmentFields are the metadata fields describing the environment in the Markdown summary
+var summaryEnvironmentFields = []string{"ocpVersion", "k8sVersion", "platform", "clusterType", "sdnType", "masterNodesCount", "masterNodesType", "workerNodesCount", "workerNodesType", "infraNodesCount", "region", "fips", "ipsec"}
+
+// NewSummarize renders a Markdown summary of a run
+func NewSummarize() *cobra.Command {
+	var outputFile string
+	cmd := &cobra.Command{
+		Use:          "summarize <uuid or metrics directory>",
+		Short:        "Renders a Markdown summary of a run",
+		Long:         "Renders a short Markdown summary of a run, read from a local metrics directory or from Elasticsearch, suitable for bug reports and performance reviews",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			esServer, _ := cmd.Flags().GetString("es-server")
+			esIndex, _ := cmd.Flags().GetString("es-index")
+			results, err := loadResults(args[0], esServer, esIndex)
+			if err != nil {
+				return err
+			}
+			w := io.Writer(os.Stdout)
+			if outputFile != "" {
+				f, err := os.Create(outputFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			writeMarkdownSummary(w, results)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&outputFile, "output", "", "File to write the summary to, defaults to stdout")
+	return cmd
+}
+
+func writeMarkdownSummary(w io.Writer, results runResults) {
+	var anomalies []string
+	var uuid string
+	if len(results.jobSummaries) > 0 {
+		uuid = results.jobSummaries[0].UUID
+	}
+	fmt.Fprintf(w, "## kube-burner-ocp run %s\n\n", uuid)
+	fmt.Fprintf(w, "### Environment\n\n| Field | Value |\n| --- | --- |\n")
+	for _, field := range summaryEnvironmentFields {
+		if v, ok := results.metadata[field]; ok {
+			fmt.Fprintf(w, "| %s | %v |\n", field, v)
+		}
+	}
+	fmt.Fprintf(w, "\n### Jobs\n\n| Job | Iterations | QPS/Burst | Start | Duration | Result |\n| --- | --- | --- | --- | --- | --- |\n")
+	for _, jobSummary := range results.jobSummaries {
+		result := "✅ passed"
+		if !jobSummary.Passed {
+			result = "❌ failed"
+			anomalies = append(anomalies, fmt.Sprintf("Job %s failed: %s", jobSummary.JobConfig.Name, strings.TrimSpace(jobSummary.ExecutionErrors)))
+		}
+		fmt.Fprintf(w, "| %s | %d | %v/%d | %s | %v | %s |\n",
+			jobSummary.JobConfig.Name,
+			jobSummary.JobConfig.JobIterations,
+			jobSummary.JobConfig.QPS,
+			jobSummary.JobConfig.Burst,
+			jobSummary.Timestamp.UTC().Format(time.RFC3339),
+			time.Duration(jobSummary.ElapsedTime)*time.Second,
+			result)
+	}
+	if len(results.latencyQuantiles) > 0 {
+		fmt.Fprintf(w, "\n### Latencies\n\n| Measurement | Job | Quantile | P50 | P95 | P99 | Max |\n| --- | --- | --- | --- | --- | --- | --- |\n")
+		for _, lq := range results.latencyQuantiles {
+			fmt.Fprintf(w, "| %s | %s | %s | %v | %v | %v | %v |\n",
+				strings.TrimSuffix(lq.MetricName, "QuantilesMeasurement"),
+				lq.JobName,
+				lq.QuantileName,
+				time.Duration(lq.P50)*time.Millisecond,
+				time.Duration(lq.P95)*time.Millisecond,
+				time.Duration(lq.P99)*time.Millisecond,
+				time.Duration(lq.Max)*time.Millisecond)
+		}
+	}
+	for _, a := range results.alerts {
+		anomalies = append(anomalies, fmt.Sprintf("%s alert at %s: %s", a.Severity, a.Timestamp.UTC().Format(time.RFC3339), a.Description))
+	}
+	if drift, ok := results.metadata["metadataDrift"].(map[string]interface{}); ok {
+		for k := range drift {
+			anomalies = append(anomalies, fmt.Sprintf("Metadata %s differs from the reference", k))
+		}
+	}
+	fmt.Fprintf(w, "\n### Anomalies\n\n")
+	if len(anomalies) == 0 {
+		fmt.Fprintln(w, "None")
+	}
+	for _, anomaly := range anomalies {
+		fmt.Fprintf(w, "- %s\n", anomaly)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	"github.com/prometheus/common/model"
+)
+
+const apiErrorRateQuery = `sum(increase(apiserver_request_total{code=~"5.."}[%[1]s])) / sum(increase(apiserver_request_total[%[1]s])) * 100`
+
+// printSummary prints a table with the KPIs of each job and the alert counts
+func printSummary(w io.Writer, wh *workloads.WorkloadHelper, results runResults) {
+	var p *prometheus.Prometheus
+	// Only query Prometheus when it was already discovered
+	if wh.PrometheusURL != "" {
+		p, _ = prometheus.NewClient(wh.PrometheusURL, wh.PrometheusToken, "", "", true)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tITERATIONS\tDURATION\tPOD READY P50\tPOD READY P99\tAPI 5XX RATE\tRESULT")
+	for _, jobSummary := range results.jobSummaries {
+		p50, p99, apiErrorRate, result := "-", "-", "-", "passed"
+		for _, lq := range results.latencyQuantiles {
+			if lq.MetricName == "podLatencyQuantilesMeasurement" && lq.QuantileName == "Ready" && lq.JobName == jobSummary.JobConfig.Name {
+				p50 = (time.Duration(lq.P50) * time.Millisecond).String()
+				p99 = (time.Duration(lq.P99) * time.Millisecond).String()
+			}
+		}
+		if p != nil {
+			window := model.Duration(max(jobSummary.EndTimestamp.Sub(jobSummary.Timestamp).Round(time.Second), time.Minute))
+			v, err := p.Query(fmt.Sprintf(apiErrorRateQuery, window), jobSummary.EndTimestamp)
+			if vector, ok := v.(model.Vector); err == nil && ok && len(vector) > 0 {
+				apiErrorRate = fmt.Sprintf("%.3f%%", float64(vector[0].Value))
+			}
+		}
+		if !jobSummary.Passed {
+			result = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%s\t%s\t%s\t%s\n",
+			jobSummary.JobConfig.Name,
+			jobSummary.JobConfig.JobIterations,
+			time.Duration(jobSummary.ElapsedTime)*time.Second,
+			p50, p99, apiErrorRate, result)
+	}
+	tw.Flush()
+	alertCount := make(map[string]int)
+	for _, a := range results.alerts {
+		alertCount[a.Severity]++
+	}
+	fmt.Fprintf(w, "Alerts: %d warning, %d error, %d critical\n", alertCount["warning"], alertCount["error"], alertCount["critical"])
+}
+
+// printRunSummary prints the summary table of the results written by the local indexer
+func printRunSummary(wh *workloads.WorkloadHelper) error {
+	metricsDirectory := localMetricsDirectory()
+	if metricsDirectory == "" {
+		return fmt.Errorf("run summary requires a local indexer")
+	}
+	results, err := readResults(metricsDirectory)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	printSummary(os.Stdout, wh, results)
+	return nil
+}
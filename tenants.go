@@ -0,0 +1,220 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	namespaceTenantMetric = "namespaceTenant"
+	defaultTenantLabel    = "kube-burner.io/tenant"
+)
+
+// The iteration of the namespaces created with namespaced iterations is their suffix
+var namespaceIterationRegex = regexp.MustCompile(`-(\d+)$`)
+
+// tenantProfile is a kind of tenant, like a tier, with the labels of its namespaces. Its weight is the share of the namespaces
+// given to the profile, and they're spread across the given number of tenants
+type tenantProfile struct {
+	Name    string            `yaml:"name"`
+	Weight  int               `yaml:"weight"`
+	Tenants int               `yaml:"tenants"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// tenantDistribution is the content of the --tenant-distribution file
+type tenantDistribution struct {
+	// Label with the tenant name, <profile>-<tenant>
+	TenantLabel string          `yaml:"tenantLabel"`
+	Profiles    []tenantProfile `yaml:"profiles"`
+	// sequence holds the profile of each namespace of a round of the weights
+	sequence []int
+}
+
+// namespaceTenant holds the tenant a namespace was assigned to
+type namespaceTenant struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	UUID       string            `json:"uuid"`
+	Namespace  string            `json:"namespace"`
+	Profile    string            `json:"profile"`
+	Tenant     string            `json:"tenant"`
+	Labels     map[string]string `json:"labels"`
+	MetricName string            `json:"metricName"`
+	JobName    string            `json:"jobName,omitempty"`
+	Metadata   interface{}       `json:"metadata,omitempty"`
+}
+
+type tenantLabeler struct {
+	clientSet    kubernetes.Interface
+	distribution *tenantDistribution
+	stopCh       chan struct{}
+	namespaces   map[string]*namespaceTenant
+	mu           sync.Mutex
+}
+
+func readTenantDistribution(distributionFile string) (*tenantDistribution, error) {
+	var td tenantDistribution
+	data, err := os.ReadFile(distributionFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tenant distribution: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &td); err != nil {
+		return nil, fmt.Errorf("error decoding tenant distribution %s: %v", distributionFile, err)
+	}
+	if len(td.Profiles) == 0 {
+		return nil, fmt.Errorf("tenant distribution %s has no profiles", distributionFile)
+	}
+	if td.TenantLabel == "" {
+		td.TenantLabel = defaultTenantLabel
+	}
+	var total int
+	for i, profile := range td.Profiles {
+		if profile.Name == "" || profile.Weight < 1 {
+			return nil, fmt.Errorf("tenant profile %d: a name and a positive weight are required", i)
+		}
+		td.Profiles[i].Tenants = max(profile.Tenants, 1)
+		total += profile.Weight
+	}
+	// Smooth weighted round-robin, so the profiles are interleaved instead of assigned in blocks
+	current := make([]int, len(td.Profiles))
+	for range total {
+		next := 0
+		for i, profile := range td.Profiles {
+			current[i] += profile.Weight
+			if current[i] > current[next] {
+				next = i
+			}
+		}
+		current[next] -= total
+		td.sequence = append(td.sequence, next)
+	}
+	return &td, nil
+}
+
+// assign returns the profile and tenant of the namespace of the given iteration. The namespaces of a profile are given
+// to its tenants in turns, so the assignment only depends on the iteration
+func (td *tenantDistribution) assign(iteration int) (tenantProfile, string) {
+	round, position := iteration/len(td.sequence), iteration%len(td.sequence)
+	profile := td.Profiles[td.sequence[position]]
+	// Namespaces of the profile in the previous rounds and positions
+	previous := round * profile.Weight
+	for _, p := range td.sequence[:position] {
+		if p == td.sequence[position] {
+			previous++
+		}
+	}
+	return profile, fmt.Sprintf("%s-%d", profile.Name, previous%profile.Tenants)
+}
+
+// startTenantLabeler labels the namespaces created by the run with the given UUID with the labels of the tenant profile
+// given to their iteration by the distribution file, as soon as they're created
+func startTenantLabeler(uuid, distributionFile string) (*tenantLabeler, error) {
+	td, err := readTenantDistribution(distributionFile)
+	if err != nil {
+		return nil, err
+	}
+	clientSet, _ := newClientSet()
+	tl := &tenantLabeler{
+		clientSet:    clientSet,
+		distribution: td,
+		stopCh:       make(chan struct{}),
+		namespaces:   make(map[string]*namespaceTenant),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	_, nsController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.NewFilteredListWatchFromClient(clientSet.CoreV1().RESTClient(), "namespaces", metav1.NamespaceAll, labelSelector),
+		ObjectType:    &corev1.Namespace{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				tl.handleNamespace(obj.(*corev1.Namespace))
+			},
+		},
+	})
+	log.Infof("Labeling namespaces with the tenant distribution %s", distributionFile)
+	go nsController.Run(tl.stopCh)
+	return tl, nil
+}
+
+func (tl *tenantLabeler) handleNamespace(ns *corev1.Namespace) {
+	var iteration int
+	if match := namespaceIterationRegex.FindStringSubmatch(ns.Name); match != nil {
+		iteration, _ = strconv.Atoi(match[1])
+	}
+	profile, tenant := tl.distribution.assign(iteration)
+	labels := map[string]string{tl.distribution.TenantLabel: tenant}
+	for k, v := range profile.Labels {
+		labels[k] = v
+	}
+	data, _ := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}})
+	if _, err := tl.clientSet.CoreV1().Namespaces().Patch(context.TODO(), ns.Name, types.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+		log.Errorf("Error labeling namespace %s: %v", ns.Name, err)
+		return
+	}
+	log.Debugf("Namespace %s assigned to tenant %s", ns.Name, tenant)
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.namespaces[ns.Name] = &namespaceTenant{
+		Timestamp: ns.CreationTimestamp.UTC(),
+		Namespace: ns.Name,
+		Profile:   profile.Name,
+		Tenant:    tenant,
+		Labels:    labels,
+		JobName:   ns.Labels["kube-burner-job"],
+	}
+}
+
+// stop stops labeling the namespaces, and indexes the tenant of each labeled one
+func (tl *tenantLabeler) stop(wh *workloads.WorkloadHelper) {
+	close(tl.stopCh)
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	var docs []interface{}
+	namespaces := make(map[string]int)
+	for _, name := range sortedKeys(tl.namespaces) {
+		nt := tl.namespaces[name]
+		nt.UUID = wh.UUID
+		nt.MetricName = namespaceTenantMetric
+		nt.Metadata = wh.MetricsMetadata
+		namespaces[nt.Profile]++
+		docs = append(docs, nt)
+	}
+	if len(docs) == 0 {
+		log.Warn("No namespaces labeled with the tenant distribution")
+		return
+	}
+	for _, profile := range tl.distribution.Profiles {
+		log.Infof("Tenant profile %s: %d namespaces across %d tenants", profile.Name, namespaces[profile.Name], min(namespaces[profile.Name], profile.Tenants))
+	}
+	indexDocuments(namespaceTenantMetric, docs)
+}
@@ -84,6 +84,25 @@ teardown_file() {
   run_cmd kube-burner-ocp index --uuid="${UUID}" --metrics-endpoint metrics-endpoints.yaml --metrics-profile metrics.yml --es-server=https://search-perfscale-dev-chmf5l4sh66lvxbnadi4bznl3a.us-west-2.es.amazonaws.com:443 --es-index=ripsaw-kube-burner --user-metadata user-metadata.yml
 }
 
+@test "index: from-run=true; local-indexing=true" {
+  run_cmd kube-burner-ocp node-density --pods-per-node=75 --uuid=${UUID} --local-indexing
+  INDEX_UUID=$(uuidgen)
+  run_cmd kube-burner-ocp index --from-run=${UUID} --uuid=${INDEX_UUID} --metrics-profile custom-metrics.yml
+  check_file_list collected-metrics-${INDEX_UUID}/jobSummary.json collected-metrics-${INDEX_UUID}/prometheusRSS.json
+  run_cmd jq -e 'any(.[]; .jobConfig.name == "node-density")' collected-metrics-${INDEX_UUID}/jobSummary.json
+}
+
+@test "gc" {
+  run_cmd kube-burner-ocp cluster-density-v2 --iterations=2 --churn=false --gc=false --alerting=false --uuid=${UUID} ${RATE}
+  check_ns kube-burner-uuid=${UUID} 2
+  run_cmd kube-burner-ocp gc --uuid=${UUID} --gc-dry-run
+  check_ns kube-burner-uuid=${UUID} 2
+  run_cmd kube-burner-ocp gc --uuid=${UUID} --gc-label-selector=kube-burner-job=none
+  check_ns kube-burner-uuid=${UUID} 2
+  run_cmd kube-burner-ocp gc --uuid=${UUID}
+  check_destroyed_ns kube-burner-uuid=${UUID}
+}
+
 @test "networkpolicy-multitenant" {
   run_cmd kube-burner-ocp networkpolicy-multitenant --iterations 5 ${COMMON_FLAGS} --uuid=${UUID}
 }
@@ -0,0 +1,92 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"fmt"
+	"io"
+
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// thresholdCatalog holds the expected results of each workload, by cluster size
+type thresholdCatalog map[string][]struct {
+	MaxWorkerNodes int   `yaml:"maxWorkerNodes"`
+	SLOs           []slo `yaml:"slos"`
+}
+
+// thresholdCatalogSLOs returns the SLOs of the catalog matching the workload and the number of worker nodes of the cluster
+func thresholdCatalogSLOs(catalog, workload string, ocpConfig embed.FS, configDir string) ([]slo, error) {
+	var thresholds thresholdCatalog
+	if catalog == "" {
+		return nil, nil
+	}
+	f, err := util.GetReader(catalog, &ocpConfig, configDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading threshold catalog %s: %v", catalog, err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading threshold catalog %s: %v", catalog, err)
+	}
+	if err := yaml.Unmarshal(data, &thresholds); err != nil {
+		return nil, fmt.Errorf("error decoding threshold catalog %s: %v", catalog, err)
+	}
+	for _, entry := range thresholds[workload] {
+		if entry.MaxWorkerNodes == 0 || clusterMetadata.WorkerNodesCount <= entry.MaxWorkerNodes {
+			log.Infof("Evaluating %d SLOs from the %s threshold catalog for %d worker nodes", len(entry.SLOs), catalog, clusterMetadata.WorkerNodesCount)
+			return entry.SLOs, nil
+		}
+	}
+	return nil, nil
+}
+
+// evaluateThresholdCatalog evaluates the SLOs of the threshold catalog matching the run when its results are available locally,
+// and returns their results and whether they make the run fail, only when --fail-on-threshold-catalog is set
+func evaluateThresholdCatalog(cmd *cobra.Command, wh *workloads.WorkloadHelper) ([]sloResult, bool) {
+	catalog, _ := cmd.Root().PersistentFlags().GetString("threshold-catalog")
+	failOnThresholdCatalog, _ := cmd.Root().PersistentFlags().GetBool("fail-on-threshold-catalog")
+	slos, err := thresholdCatalogSLOs(catalog, cmd.Name(), ocpConfig, configDir)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, failOnThresholdCatalog
+	}
+	if len(slos) == 0 {
+		return nil, false
+	}
+	// The local results are only enabled for it when it makes the run fail
+	if localMetricsDirectory() == "" {
+		log.Infof("Skipping the threshold catalog %s, its evaluation requires a local indexer", catalog)
+		return nil, false
+	}
+	sloResults, err := evaluateSLOs(wh, slos)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, failOnThresholdCatalog
+	}
+	var failed bool
+	for _, result := range sloResults {
+		failed = failed || !result.Passed
+	}
+	if failed && !failOnThresholdCatalog {
+		log.Warn("The threshold catalog SLOs weren't met, set --fail-on-threshold-catalog to make the run fail")
+	}
+	return sloResults, failed && failOnThresholdCatalog
+}
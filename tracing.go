@@ -0,0 +1,122 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "kube-burner-ocp"
+
+// tracing holds the tracer provider exporting the spans of the run and its root span.
+// Without StartTracing, the global tracer provider discards the spans
+var tracing struct {
+	provider *sdktrace.TracerProvider
+	ctx      context.Context
+	root     trace.Span
+}
+
+// StartTracing exports the spans of the run phases to the given OTLP HTTP endpoint, under a root span named after the workload
+func StartTracing(endpoint, workload, uuid string) {
+	tracing.ctx = context.Background()
+	if endpoint == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(tracing.ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		log.Errorf("Error creating OTLP trace exporter, tracing disabled: %v", err)
+		return
+	}
+	tracing.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(tracerName),
+			attribute.String("uuid", uuid),
+		)),
+	)
+	otel.SetTracerProvider(tracing.provider)
+	tracing.ctx, tracing.root = otel.Tracer(tracerName).Start(tracing.ctx, workload, trace.WithAttributes(attribute.String("uuid", uuid)))
+	log.Infof("Exporting traces to %s", endpoint)
+}
+
+// StartSpan starts a span of a run phase, child of the root span, and returns the function ending it.
+// The phase is also the current phase of the run progress until then
+func StartSpan(name string) func() {
+	ctx := tracing.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, name)
+	restorePhase := setPhase(name)
+	return func() {
+		span.End()
+		restorePhase()
+	}
+}
+
+// traceJobs adds a span for each job of the run from the timestamps of its job summary, as the jobs are executed by kube-burner,
+// with a child span for the churn phase
+func traceJobs(uuid string) {
+	if tracing.provider == nil {
+		return
+	}
+	source := uuid
+	if metricsDirectory := localMetricsDirectory(); metricsDirectory != "" {
+		source = metricsDirectory
+	}
+	esServer, esIndex := esEndpoint()
+	results, err := loadResults(source, esServer, esIndex)
+	if err != nil {
+		log.Warnf("Unable to load the job summaries to trace the jobs: %v", err)
+		return
+	}
+	tracer := otel.Tracer(tracerName)
+	for _, jobSummary := range results.jobSummaries {
+		ctx, span := tracer.Start(tracing.ctx, jobSummary.JobConfig.Name,
+			trace.WithTimestamp(jobSummary.Timestamp),
+			trace.WithAttributes(
+				attribute.Int("iterations", jobSummary.JobConfig.JobIterations),
+				attribute.Bool("passed", jobSummary.Passed),
+			))
+		if jobSummary.ChurnStartTimestamp != nil && jobSummary.ChurnEndTimestamp != nil {
+			_, churnSpan := tracer.Start(ctx, "churn", trace.WithTimestamp(*jobSummary.ChurnStartTimestamp))
+			churnSpan.End(trace.WithTimestamp(*jobSummary.ChurnEndTimestamp))
+		}
+		span.End(trace.WithTimestamp(jobSummary.EndTimestamp))
+	}
+}
+
+// StopTracing ends the root span and flushes the pending spans
+func StopTracing() {
+	if tracing.provider == nil {
+		return
+	}
+	tracing.root.End()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := tracing.provider.Shutdown(ctx); err != nil {
+		log.Errorf("Error flushing traces: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	triageDir = "triage"
+	// Maximum time spent collecting the triage, as it can run while kube-burner is exiting
+	triageTimeout  = 2 * time.Minute
+	triageLogLines = 500
+)
+
+// triageCollector collects the describe output, events and container logs of a sample of the pods of the run that aren't ready
+// when it fails, to diagnose it from the artifacts without running it again
+type triageCollector struct {
+	uuid      string
+	directory string
+	sample    int
+	active    atomic.Bool
+	once      sync.Once
+}
+
+// startTriageCollector collects the triage of the run with the given UUID in the given directory when kube-burner exits on a
+// fatal error, like a timeout waiting for the pods to be ready, until it's stopped. It can also be collected on demand
+func startTriageCollector(uuid, directory string, sample int) *triageCollector {
+	tc := &triageCollector{
+		uuid:      uuid,
+		directory: path.Join(directory, triageDir),
+		sample:    sample,
+	}
+	tc.active.Store(true)
+	// Exit handlers can't be removed, the ones of a stopped collector do nothing
+	log.RegisterExitHandler(func() {
+		if tc.active.Load() {
+			tc.collect()
+		}
+	})
+	return tc
+}
+
+// stop stops collecting the triage on fatal errors
+func (tc *triageCollector) stop() {
+	tc.active.Store(false)
+}
+
+// collect collects the triage of the pods not ready once
+func (tc *triageCollector) collect() {
+	tc.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), triageTimeout)
+		defer cancel()
+		if err := tc.collectTriage(ctx); err != nil {
+			log.Errorf("Error collecting the triage of the pods not ready: %v", err)
+		}
+	})
+}
+
+func (tc *triageCollector) collectTriage(ctx context.Context) error {
+	defer StartSpan("triage")()
+	clientSet, _ := newClientSet()
+	listOptions := metav1.ListOptions{LabelSelector: "kube-burner-uuid=" + tc.uuid}
+	pods, err := clientSet.CoreV1().Pods(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return fmt.Errorf("error listing pods: %v", err)
+	}
+	// The deployments whose pods are rejected at admission have no pods to sample
+	deployments, err := clientSet.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		log.Warnf("Error listing deployments: %v", err)
+		deployments = &appsv1.DeploymentList{}
+	}
+	byReason := make(map[string][]corev1.Pod)
+	var unready int
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || podReady(&pod) {
+			continue
+		}
+		reason := podUnreadyReason(&pod)
+		byReason[reason] = append(byReason[reason], pod)
+		unready++
+	}
+	var failedDeployments []appsv1.Deployment
+	for _, deploy := range deployments.Items {
+		for _, c := range deploy.Status.Conditions {
+			if c.Type == appsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue {
+				failedDeployments = append(failedDeployments, deploy)
+			}
+		}
+	}
+	if unready == 0 && len(failedDeployments) == 0 {
+		log.Info("No pods not ready to triage")
+		return nil
+	}
+	if err := os.MkdirAll(tc.directory, 0755); err != nil {
+		return err
+	}
+	reasons := sortedKeys(byReason)
+	// The most common reasons first, sampling a pod of every reason in turns so the different failures are covered
+	sort.SliceStable(reasons, func(i, j int) bool {
+		return len(byReason[reasons[i]]) > len(byReason[reasons[j]])
+	})
+	var sampled []corev1.Pod
+	for i := 0; len(sampled) < min(tc.sample, unready); i++ {
+		for _, reason := range reasons {
+			if i < len(byReason[reason]) && len(sampled) < tc.sample {
+				sampled = append(sampled, byReason[reason][i])
+			}
+		}
+	}
+	log.Infof("Collecting the triage of %d of %d pods not ready in %s", len(sampled), unready, tc.directory)
+	if err := tc.writeSummary(byReason, reasons, unready, failedDeployments, sampled); err != nil {
+		return err
+	}
+	for _, pod := range sampled {
+		if ctx.Err() != nil {
+			return fmt.Errorf("triage not completed after %v", triageTimeout)
+		}
+		if err := collectPodTriage(ctx, clientSet, &pod, path.Join(tc.directory, pod.Namespace, pod.Name)); err != nil {
+			log.Warnf("Error collecting the triage of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeSummary writes the number of pods not ready by reason, the deployments failing to create their pods and the sampled pods
+func (tc *triageCollector) writeSummary(byReason map[string][]corev1.Pod, reasons []string, unready int, failedDeployments []appsv1.Deployment, sampled []corev1.Pod) error {
+	f, err := os.Create(path.Join(tc.directory, "summary.txt"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Pods not ready: %d\n\nREASON\tPODS\n", unready)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%s\t%d\n", reason, len(byReason[reason]))
+	}
+	if len(failedDeployments) > 0 {
+		fmt.Fprintf(w, "\nDEPLOYMENT\tREPLICAS\tMESSAGE\n")
+		for _, deploy := range failedDeployments {
+			for _, c := range deploy.Status.Conditions {
+				if c.Type == appsv1.DeploymentReplicaFailure {
+					fmt.Fprintf(w, "%s/%s\t%d/%d\t%s\n", deploy.Namespace, deploy.Name, deploy.Status.Replicas, ptr.Deref(deploy.Spec.Replicas, 1), c.Message)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "\nSAMPLED POD\tNODE\tREASON\n")
+	for _, pod := range sampled {
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\n", pod.Namespace, pod.Name, pod.Spec.NodeName, podUnreadyReason(&pod))
+	}
+	return w.Flush()
+}
+
+// collectPodTriage writes the describe output, the events and the logs of the containers of the given pod to the given directory.
+// The describe output requires oc, the pod is written as JSON instead when it isn't available
+func collectPodTriage(ctx context.Context, clientSet kubernetes.Interface, pod *corev1.Pod, directory string) error {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	if oc, err := exec.LookPath("oc"); err == nil {
+		output, err := exec.CommandContext(ctx, oc, "describe", "pod", "-n", pod.Namespace, pod.Name).CombinedOutput()
+		if err != nil {
+			log.Debugf("Error describing pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		if err := os.WriteFile(path.Join(directory, "describe.txt"), output, 0644); err != nil {
+			return err
+		}
+	} else {
+		data, _ := json.MarshalIndent(pod, "", "  ")
+		if err := os.WriteFile(path.Join(directory, "pod.json"), data, 0644); err != nil {
+			return err
+		}
+	}
+	events, err := clientSet.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: "involvedObject.name=" + pod.Name})
+	if err != nil {
+		return fmt.Errorf("error listing events: %v", err)
+	}
+	if err := writePodEvents(path.Join(directory, "events.txt"), events.Items); err != nil {
+		return err
+	}
+	restarts := make(map[string]int32)
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		restarts[cs.Name] = cs.RestartCount
+	}
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		writeContainerLogs(ctx, clientSet, pod, container.Name, false, path.Join(directory, container.Name+".log"))
+		// The logs of the last crash are usually the ones explaining it
+		if restarts[container.Name] > 0 {
+			writeContainerLogs(ctx, clientSet, pod, container.Name, true, path.Join(directory, container.Name+".previous.log"))
+		}
+	}
+	return nil
+}
+
+func writePodEvents(file string, events []corev1.Event) error {
+	sort.Slice(events, func(i, j int) bool {
+		return eventTimestamp(&events[i]).Before(eventTimestamp(&events[j]))
+	})
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TIME\tTYPE\tREASON\tCOUNT\tSOURCE\tMESSAGE\n")
+	for _, event := range events {
+		source := event.Source.Component
+		if source == "" {
+			source = event.ReportingController
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", eventTimestamp(&event).UTC().Format(time.RFC3339), event.Type, event.Reason, max(event.Count, 1), source, event.Message)
+	}
+	return w.Flush()
+}
+
+// writeContainerLogs writes the last lines of the logs of the given container, the containers that never started have none
+func writeContainerLogs(ctx context.Context, clientSet kubernetes.Interface, pod *corev1.Pod, container string, previous bool, file string) {
+	stream, err := clientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+		TailLines: ptr.To[int64](triageLogLines),
+	}).Stream(ctx)
+	if err != nil {
+		log.Debugf("No logs of container %s of pod %s/%s: %v", container, pod.Namespace, pod.Name, err)
+		return
+	}
+	defer stream.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		log.Warnf("Error writing logs of container %s of pod %s/%s: %v", container, pod.Namespace, pod.Name, err)
+		return
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, stream); err != nil {
+		log.Warnf("Error writing logs of container %s of pod %s/%s: %v", container, pod.Namespace, pod.Name, err)
+	}
+}
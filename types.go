@@ -17,8 +17,23 @@ package ocp
 type ProfileType string
 
 const (
-	Regular    ProfileType = "regular"
-	Reporting  ProfileType = "reporting"
-	Both       ProfileType = "both"
-	TenMinutes int64       = 600
+	Regular   ProfileType = "regular"
+	Reporting ProfileType = "reporting"
+	// MetricsReport is an alias of Reporting
+	MetricsReport ProfileType = "metrics-report"
+	Both          ProfileType = "both"
+	TenMinutes    int64       = 600
+)
+
+const (
+	// rcAlert is the return code when any alert with error severity fired, same as kube-burner
+	rcAlert = 3
+	// rcMeasurement is the return code when any measurement failed, same as kube-burner
+	rcMeasurement = 4
+	// rcSLO is the return code when any SLO is not met
+	rcSLO = 5
+	// rcRegression is the return code when any KPI regressed compared to the baseline
+	rcRegression = 6
+	// rcAborted is the return code when the run is aborted by a signal
+	rcAborted = 7
 )
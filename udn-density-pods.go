@@ -17,6 +17,7 @@ package ocp
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
@@ -24,33 +25,51 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Server and client deployments of each network
+const udnDeployments = 5
+
 // NewUDNDensityPods holds udn-density-pods workload
 func NewUDNDensityPods(wh *workloads.WorkloadHelper) *cobra.Command {
-	var churnPercent, churnCycles, iterations int
-	var churn, l3, simple, pprof bool
-	var churnDelay, churnDuration, podReadyThreshold time.Duration
-	var churnDeletionStrategy, jobPause string
+	var iterations, networks, podsPerNetwork int
+	var l3, simple, svcLatency, pprof, verifyIsolation bool
+	var podReadyThreshold time.Duration
+	var jobPause string
+	var churn *churnFlags
 	var metricsProfiles []string
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "udn-density-pods",
 		Short:        "Runs node-density-udn workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if networks > 0 {
+				iterations = networks
+			}
+			if iterations <= 0 {
+				return fmt.Errorf("--networks must be greater than 0")
+			}
+			if podsPerNetwork <= 0 || podsPerNetwork%udnDeployments != 0 {
+				return fmt.Errorf("--pods-per-network must be a multiple of %d, the pods of each network are spread across 3 server and 2 client deployments", udnDeployments)
+			}
+			os.Setenv("POD_REPLICAS", fmt.Sprint(podsPerNetwork/udnDeployments))
+			// The networks are verified after the workload, so they're garbage collected afterwards
+			if verifyIsolation {
+				os.Setenv("GC", "false")
+			}
 			os.Setenv("JOB_PAUSE", jobPause)
-			os.Setenv("PPROF", fmt.Sprint(pprof))
 			os.Setenv("SIMPLE", fmt.Sprint(simple))
-			os.Setenv("CHURN", fmt.Sprint(churn))
-			os.Setenv("CHURN_CYCLES", fmt.Sprintf("%v", churnCycles))
-			os.Setenv("CHURN_DURATION", fmt.Sprintf("%v", churnDuration))
-			os.Setenv("CHURN_DELAY", fmt.Sprintf("%v", churnDelay))
-			os.Setenv("CHURN_PERCENT", fmt.Sprint(churnPercent))
-			os.Setenv("CHURN_DELETION_STRATEGY", churnDeletionStrategy)
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(iterations))
 			os.Setenv("POD_READY_THRESHOLD", fmt.Sprintf("%v", podReadyThreshold))
+			os.Setenv("SVC_LATENCY", strconv.FormatBool(svcLatency))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
 			// Disable l3 when the user chooses l2
 			if l3 {
 				log.Info("Layer 3 is enabled")
@@ -59,7 +78,9 @@ func NewUDNDensityPods(wh *workloads.WorkloadHelper) *cobra.Command {
 				log.Info("Layer 2 is enabled")
 				os.Setenv("ENABLE_LAYER_3", "false")
 			}
-			rc = wh.Run("udn-density-pods")
+			var err error
+			rc, err = runWorkload(cmd, wh, "udn-density-pods")
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -67,16 +88,16 @@ func NewUDNDensityPods(wh *workloads.WorkloadHelper) *cobra.Command {
 	}
 	cmd.Flags().BoolVar(&l3, "layer3", true, "Layer3 UDN test")
 	cmd.Flags().StringVar(&jobPause, "job-pause", "1ms", "Time to pause after finishing the job")
-	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection")
+	cmd.Flags().BoolVar(&pprof, "pprof", false, "Enable pprof collection of the OVN components, same as --pprof-targets=ovn")
 	cmd.Flags().BoolVar(&simple, "simple", false, "only client and server pods to be deployed, no services and networkpolicies")
-	cmd.Flags().BoolVar(&churn, "churn", true, "Enable churning")
-	cmd.Flags().IntVar(&churnCycles, "churn-cycles", 0, "Churn cycles to execute")
-	cmd.Flags().DurationVar(&churnDuration, "churn-duration", 1*time.Hour, "Churn duration")
-	cmd.Flags().DurationVar(&churnDelay, "churn-delay", 2*time.Minute, "Time to wait between each churn")
-	cmd.Flags().IntVar(&churnPercent, "churn-percent", 10, "Percentage of job iterations that kube-burner will churn each round")
-	cmd.Flags().StringVar(&churnDeletionStrategy, "churn-deletion-strategy", "default", "Churn deletion strategy to use")
+	churn = addChurnFlags(cmd, true, time.Hour)
 	cmd.Flags().IntVar(&iterations, "iterations", 0, "Iterations")
+	cmd.Flags().MarkDeprecated("iterations", "use --networks instead")
+	cmd.Flags().IntVar(&networks, "networks", 0, "Number of user-defined networks, each one in its own namespace")
+	cmd.Flags().IntVar(&podsPerNetwork, "pods-per-network", 10, fmt.Sprintf("Pods of each network, a multiple of %d", udnDeployments))
+	cmd.Flags().BoolVar(&verifyIsolation, "verify-isolation", false, "Once the workload finishes, index the creation latency of the networks and verify traffic across them is denied")
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 1*time.Minute, "Pod ready timeout threshold")
+	cmd.Flags().BoolVar(&svcLatency, "service-latency", false, "Enable service latency measurement")
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
@@ -0,0 +1,285 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	udnCreationMetric          = "udnCreationLatency"
+	udnCreationQuantilesMetric = "udnCreationLatencyQuantiles"
+	udnIsolationProbeMetric    = "udnIsolationProbe"
+	// Probes run at once
+	udnProbeWorkers = 10
+)
+
+var udnGVR = schema.GroupVersionResource{Group: "k8s.ovn.org", Version: "v1", Resource: "userdefinednetworks"}
+
+// udnCreation holds the time since a UserDefinedNetwork was created until its network was, in milliseconds
+type udnCreation struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	UUID       string      `json:"uuid"`
+	Namespace  string      `json:"namespace"`
+	Name       string      `json:"udnName"`
+	Topology   string      `json:"topology"`
+	Created    bool        `json:"created"`
+	Latency    int         `json:"creationLatency"`
+	MetricName string      `json:"metricName"`
+	JobName    string      `json:"jobName,omitempty"`
+	Metadata   interface{} `json:"metadata,omitempty"`
+}
+
+// udnIsolationProbe holds the result of a connection from a client pod of a network to a server pod or service, which must be
+// allowed in the same network and denied across networks
+type udnIsolationProbe struct {
+	Timestamp       time.Time   `json:"timestamp"`
+	UUID            string      `json:"uuid"`
+	SourceNamespace string      `json:"sourceNamespace"`
+	SourcePod       string      `json:"sourcePod"`
+	TargetNamespace string      `json:"targetNamespace"`
+	Target          string      `json:"target"`
+	Address         string      `json:"address"`
+	Expected        string      `json:"expected"`
+	Result          string      `json:"result"`
+	Passed          bool        `json:"passed"`
+	Latency         int         `json:"latency"`
+	Error           string      `json:"error,omitempty"`
+	MetricName      string      `json:"metricName"`
+	Metadata        interface{} `json:"metadata,omitempty"`
+}
+
+// udnNetwork holds the pods and service of the namespace of a network the probes are run from and to
+type udnNetwork struct {
+	namespace string
+	client    corev1.Pod
+	servers   []corev1.Pod
+	service   *corev1.Service
+}
+
+// verifyUDNIsolation indexes the creation latency of the UserDefinedNetworks created by the run with the given UUID, and verifies the
+// isolation of their networks. From a client pod of each network, a server pod of the same network must be reachable through its
+// network IP, while a server pod and a service of the next network must not be, through their cluster default network IPs
+func verifyUDNIsolation(wh *workloads.WorkloadHelper) {
+	clientSet, restConfig := newClientSet()
+	udns, err := dynamic.NewForConfigOrDie(restConfig).Resource(udnGVR).List(context.TODO(), metav1.ListOptions{LabelSelector: "kube-burner-uuid=" + wh.UUID})
+	if err != nil {
+		log.Errorf("Error listing UserDefinedNetworks: %v", err)
+		return
+	}
+	if len(udns.Items) == 0 {
+		log.Warn("No UserDefinedNetworks found")
+		return
+	}
+	indexUDNCreation(wh, udns.Items)
+	var networks []udnNetwork
+	for _, udn := range udns.Items {
+		network, err := getUDNNetwork(clientSet, udn.GetNamespace())
+		if err != nil {
+			log.Warnf("Network of namespace %s not probed: %v", udn.GetNamespace(), err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	var probes []*udnIsolationProbe
+	for i, network := range networks {
+		probe := func(targetNamespace, kind, name, address, expected string) {
+			probes = append(probes, &udnIsolationProbe{
+				UUID:            wh.UUID,
+				SourceNamespace: network.namespace,
+				SourcePod:       network.client.Name,
+				TargetNamespace: targetNamespace,
+				Target:          kind + "/" + name,
+				Address:         address,
+				Expected:        expected,
+				MetricName:      udnIsolationProbeMetric,
+				Metadata:        wh.MetricsMetadata,
+			})
+		}
+		if ip := primaryNetworkIP(network.servers[0]); ip != "" {
+			probe(network.namespace, "pod", network.servers[0].Name, net.JoinHostPort(ip, "8080"), probeAllowed)
+		}
+		// The networks are probed in a ring, a single network has no other to probe
+		if len(networks) == 1 {
+			continue
+		}
+		next := networks[(i+1)%len(networks)]
+		probe(next.namespace, "pod", next.servers[0].Name, net.JoinHostPort(next.servers[0].Status.PodIP, "8080"), probeDenied)
+		if next.service != nil && len(next.service.Spec.Ports) > 0 {
+			probe(next.namespace, "service", next.service.Name, net.JoinHostPort(next.service.Spec.ClusterIP, fmt.Sprint(next.service.Spec.Ports[0].Port)), probeDenied)
+		}
+	}
+	log.Infof("Running %d isolation probes across %d networks", len(probes), len(networks))
+	sources := make(map[string]corev1.Pod, len(networks))
+	for _, network := range networks {
+		sources[network.namespace] = network.client
+	}
+	var wg sync.WaitGroup
+	probeCh := make(chan *udnIsolationProbe)
+	for range udnProbeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for probe := range probeCh {
+				runIsolationProbe(clientSet, restConfig, sources[probe.SourceNamespace], probe)
+			}
+		}()
+	}
+	for _, probe := range probes {
+		probeCh <- probe
+	}
+	close(probeCh)
+	wg.Wait()
+	var docs []interface{}
+	var failed int
+	for _, probe := range probes {
+		if !probe.Passed {
+			failed++
+			log.Warnf("Isolation probe from %s/%s to %s/%s at %s: expected %s, got %s", probe.SourceNamespace, probe.SourcePod, probe.TargetNamespace, probe.Target, probe.Address, probe.Expected, probe.Result)
+		}
+		docs = append(docs, probe)
+	}
+	if failed > 0 {
+		log.Errorf("%d/%d isolation probes failed", failed, len(docs))
+	} else {
+		log.Infof("%d isolation probes passed", len(docs))
+	}
+	indexDocuments(udnIsolationProbeMetric, docs)
+}
+
+// indexUDNCreation indexes the time each UserDefinedNetwork took to get its NetworkCreated condition, and their quantiles per job
+func indexUDNCreation(wh *workloads.WorkloadHelper, udns []unstructured.Unstructured) {
+	var docs, quantiles []interface{}
+	var jobNames []string
+	latencies := make(map[string][]float64)
+	for _, udn := range udns {
+		created := udn.GetCreationTimestamp().Time
+		doc := udnCreation{
+			Timestamp:  created.UTC(),
+			UUID:       wh.UUID,
+			Namespace:  udn.GetNamespace(),
+			Name:       udn.GetName(),
+			MetricName: udnCreationMetric,
+			JobName:    udn.GetLabels()["kube-burner-job"],
+			Metadata:   wh.MetricsMetadata,
+		}
+		doc.Topology, _, _ = unstructured.NestedString(udn.Object, "spec", "topology")
+		conditions, _, _ := unstructured.NestedSlice(udn.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok || condition["type"] != "NetworkCreated" || condition["status"] != "True" {
+				continue
+			}
+			// The transition time has a resolution of seconds
+			if lastTransitionTime, ok := condition["lastTransitionTime"].(string); ok {
+				if t, err := time.Parse(time.RFC3339, lastTransitionTime); err == nil {
+					doc.Created = true
+					doc.Latency = max(int(t.Sub(created).Milliseconds()), 0)
+				}
+			}
+		}
+		if !doc.Created {
+			log.Warnf("UserDefinedNetwork %s/%s not created", doc.Namespace, doc.Name)
+		} else {
+			if _, exists := latencies[doc.JobName]; !exists {
+				jobNames = append(jobNames, doc.JobName)
+			}
+			latencies[doc.JobName] = append(latencies[doc.JobName], float64(doc.Latency))
+		}
+		docs = append(docs, doc)
+	}
+	for _, jobName := range jobNames {
+		q := metrics.NewLatencySummary(latencies[jobName], "NetworkCreated")
+		q.UUID = wh.UUID
+		q.MetricName = udnCreationQuantilesMetric
+		q.JobName = jobName
+		q.Metadata = wh.MetricsMetadata
+		quantiles = append(quantiles, q)
+	}
+	indexDocuments(udnCreationMetric, docs)
+	indexDocuments(udnCreationQuantilesMetric, quantiles)
+}
+
+// getUDNNetwork returns a running client pod, the running server pods and a service of the given namespace
+func getUDNNetwork(clientSet kubernetes.Interface, namespace string) (udnNetwork, error) {
+	network := udnNetwork{namespace: namespace}
+	var err error
+	if network.client, err = getRunningPod(clientSet, namespace, "app=client"); err != nil {
+		return network, err
+	}
+	servers, err := clientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=nginx", FieldSelector: "status.phase=Running"})
+	if err != nil {
+		return network, err
+	}
+	if len(servers.Items) == 0 {
+		return network, fmt.Errorf("no running server pods found")
+	}
+	network.servers = servers.Items
+	// The services only exist without --simple
+	services, err := clientSet.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err == nil && len(services.Items) > 0 {
+		network.service = &services.Items[0]
+	}
+	return network, nil
+}
+
+// primaryNetworkIP returns the IP of the pod in its primary user-defined network, from the k8s.ovn.org/pod-networks annotation
+func primaryNetworkIP(pod corev1.Pod) string {
+	var podNetworks map[string]struct {
+		IPAddresses []string `json:"ip_addresses"`
+		Role        string   `json:"role"`
+	}
+	if err := json.Unmarshal([]byte(pod.Annotations["k8s.ovn.org/pod-networks"]), &podNetworks); err != nil {
+		return ""
+	}
+	for _, podNetwork := range podNetworks {
+		if podNetwork.Role == "primary" && len(podNetwork.IPAddresses) > 0 {
+			ip, _, _ := strings.Cut(podNetwork.IPAddresses[0], "/")
+			return ip
+		}
+	}
+	return ""
+}
+
+// runIsolationProbe connects from the given client pod to the address of the probe. Any HTTP response means the connection was allowed,
+// while curl failing to connect, exit code 7, or timing out, exit code 28, means it was denied
+func runIsolationProbe(clientSet kubernetes.Interface, restConfig *rest.Config, client corev1.Pod, probe *udnIsolationProbe) {
+	start := time.Now()
+	result, err := probeConnection(clientSet, restConfig, client, "client-app", probe.Address)
+	probe.Timestamp = start.UTC()
+	probe.Latency = int(time.Since(start).Milliseconds())
+	probe.Result = result
+	if err != nil {
+		probe.Error = err.Error()
+	}
+	probe.Passed = probe.Result == probe.Expected
+}
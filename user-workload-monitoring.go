@@ -0,0 +1,93 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kube-burner/kube-burner/pkg/prometheus"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	routeclient "github.com/openshift/client-go/route/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	monitoringNamespace             = "openshift-monitoring"
+	userWorkloadMonitoringNamespace = "openshift-user-workload-monitoring"
+	userWorkloadPrometheus          = "prometheus-user-workload"
+	thanosQuerierRoute              = "thanos-querier"
+	userWorkloadMonitoringJob       = "user-workload-monitoring"
+)
+
+// userWorkloadMonitoring holds the Thanos querier the user workload metrics are scraped from, set by
+// DiscoverUserWorkloadMonitoring when the user workload monitoring is enabled
+var userWorkloadMonitoring struct {
+	prometheusURL   string
+	prometheusToken string
+	metricsProfiles []string
+}
+
+// monitoringRouteURL returns the URL of the given route of the cluster monitoring namespace
+func monitoringRouteURL(restConfig *rest.Config, name string) (string, error) {
+	routeClient, err := routeclient.NewForConfig(restConfig)
+	if err != nil {
+		return "", err
+	}
+	route, err := routeClient.RouteV1().Routes(monitoringNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting route %s/%s: %v", monitoringNamespace, name, err)
+	}
+	return "https://" + route.Spec.Host, nil
+}
+
+// DiscoverUserWorkloadMonitoring looks for the user workload monitoring Prometheus, whose metrics are queried through the
+// Thanos querier along with the platform ones, as it isn't exposed on its own. Nothing is scraped when it isn't running
+func DiscoverUserWorkloadMonitoring(wh *workloads.WorkloadHelper, metricsProfiles []string) error {
+	if len(metricsProfiles) == 0 {
+		return nil
+	}
+	clientSet, restConfig := newClientSet()
+	prometheus, err := clientSet.AppsV1().StatefulSets(userWorkloadMonitoringNamespace).Get(context.TODO(), userWorkloadPrometheus, metav1.GetOptions{})
+	if err != nil || prometheus.Status.ReadyReplicas == 0 {
+		log.Debug("User workload monitoring isn't enabled, the user workload metrics won't be collected")
+		return nil
+	}
+	thanosURL, err := monitoringRouteURL(restConfig, thanosQuerierRoute)
+	if err != nil {
+		return err
+	}
+	// The Thanos querier accepts the token of the platform Prometheus
+	_, prometheusToken, err := wh.MetadataAgent.GetPrometheus()
+	if err != nil {
+		return fmt.Errorf("error obtaining the Prometheus token: %v", err)
+	}
+	userWorkloadMonitoring.prometheusURL, userWorkloadMonitoring.prometheusToken = thanosURL, prometheusToken
+	userWorkloadMonitoring.metricsProfiles = metricsProfiles
+	log.Infof("User workload monitoring enabled, collecting the user workload metrics from %s", thanosURL)
+	return nil
+}
+
+// scrapeUserWorkloadMetrics scrapes the user workload metrics profiles from the Thanos querier over the given jobs, indexing
+// them with the indexers of the workload
+func scrapeUserWorkloadMetrics(wh *workloads.WorkloadHelper, jobs []prometheus.Job) {
+	if userWorkloadMonitoring.prometheusURL == "" {
+		return
+	}
+	defer StartSpan("user-workload-metrics")()
+	scrapeProfiles(wh, userWorkloadMonitoring.prometheusURL, userWorkloadMonitoring.prometheusToken, userWorkloadMonitoring.metricsProfiles, nil, jobs)
+}
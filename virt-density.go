@@ -20,7 +20,6 @@ import (
 	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/workloads"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 )
@@ -29,24 +28,37 @@ import (
 func NewVirtDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 	var vmsPerNode int
 	var vmiRunningThreshold time.Duration
+	var vmImage, vmMemory string
+	var guestBootLatency bool
 	var metricsProfiles []string
+	var churn *churnFlags
 	var rc int
 	cmd := &cobra.Command{
 		Use:          "virt-density",
 		Short:        "Runs virt-density workload",
 		SilenceUsage: true,
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := churn.setEnv(); err != nil {
+				return err
+			}
 			totalVMs := clusterMetadata.WorkerNodesCount * vmsPerNode
 			vmCount, err := wh.MetadataAgent.GetCurrentVMICount()
 			if err != nil {
-				log.Fatal(err.Error())
+				return err
 			}
 			os.Setenv("JOB_ITERATIONS", fmt.Sprint(totalVMs-vmCount))
 			os.Setenv("VMI_RUNNING_THRESHOLD", fmt.Sprintf("%v", vmiRunningThreshold))
+			os.Setenv("VM_IMAGE", vmImage)
+			os.Setenv("VM_MEMORY", vmMemory)
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
@@ -54,6 +66,10 @@ func NewVirtDensity(wh *workloads.WorkloadHelper) *cobra.Command {
 	}
 	cmd.Flags().IntVar(&vmsPerNode, "vms-per-node", 245, "VMs per node")
 	cmd.Flags().DurationVar(&vmiRunningThreshold, "vmi-ready-threshold", 25*time.Second, "VMI ready timeout threshold")
+	cmd.Flags().StringVar(&vmImage, "vm-image", "quay.io/rsevilla/cirros:0.6.3", "VM containerDisk image")
+	cmd.Flags().StringVar(&vmMemory, "vm-memory", "32Mi", "VM memory request")
+	cmd.Flags().BoolVar(&guestBootLatency, "guest-boot-latency", false, "Measure the time until the guest OS booted, signaled by the QEMU guest agent connection, which requires a VM image running it")
+	churn = addChurnFlags(cmd, false, time.Hour)
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
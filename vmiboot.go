@@ -0,0 +1,192 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	vmiBootMetric          = "vmiBootMeasurement"
+	vmiBootQuantilesMetric = "vmiBootQuantilesMeasurement"
+)
+
+var (
+	vmGVR  = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}
+	vmiGVR = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}
+)
+
+// vmiBoot holds the latencies of a VM since its creation until its VMI is running and its guest OS booted, in milliseconds
+type vmiBoot struct {
+	Timestamp          time.Time   `json:"timestamp"`
+	UUID               string      `json:"uuid"`
+	Namespace          string      `json:"namespace"`
+	Name               string      `json:"vmName"`
+	NodeName           string      `json:"nodeName"`
+	VMIRunningLatency  int         `json:"vmiRunningLatency"`
+	GuestBootedLatency int         `json:"guestBootedLatency"`
+	MetricName         string      `json:"metricName"`
+	JobName            string      `json:"jobName,omitempty"`
+	Metadata           interface{} `json:"metadata,omitempty"`
+}
+
+// vmTimestamps holds the timestamps of the boot milestones of a VM
+type vmTimestamps struct {
+	namespace, name, nodeName, jobName string
+	created, running, booted           time.Time
+}
+
+type vmiBootWatcher struct {
+	stopCh chan struct{}
+	vms    map[string]*vmTimestamps
+	mu     sync.Mutex
+}
+
+// startVMIBootWatcher watches the VMs created by the run with the given UUID and their VMIs to record when the VMI is running
+// and when the guest OS booted, which is when the QEMU guest agent running in the guest connects
+func startVMIBootWatcher(uuid string) *vmiBootWatcher {
+	_, restConfig := newClientSet()
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	vbw := &vmiBootWatcher{
+		stopCh: make(chan struct{}),
+		vms:    make(map[string]*vmTimestamps),
+	}
+	labelSelector := func(options *metav1.ListOptions) {
+		options.LabelSelector = "kube-burner-uuid=" + uuid
+	}
+	for gvr, handle := range map[schema.GroupVersionResource]func(*unstructured.Unstructured){
+		vmGVR:  vbw.handleVM,
+		vmiGVR: vbw.handleVMI,
+	} {
+		informer := dynamicinformer.NewFilteredDynamicInformer(dynamicClient, gvr, metav1.NamespaceAll, 0, cache.Indexers{}, labelSelector).Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				handle(obj.(*unstructured.Unstructured))
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				handle(obj.(*unstructured.Unstructured))
+			},
+		})
+		go informer.Run(vbw.stopCh)
+	}
+	log.Info("Watching VMI boot latency")
+	return vbw
+}
+
+func (vbw *vmiBootWatcher) timestamps(namespace, name string) *vmTimestamps {
+	key := namespace + "/" + name
+	vt, exists := vbw.vms[key]
+	if !exists {
+		vt = &vmTimestamps{namespace: namespace, name: name}
+		vbw.vms[key] = vt
+	}
+	return vt
+}
+
+func (vbw *vmiBootWatcher) handleVM(vm *unstructured.Unstructured) {
+	vbw.mu.Lock()
+	defer vbw.mu.Unlock()
+	vt := vbw.timestamps(vm.GetNamespace(), vm.GetName())
+	vt.created = vm.GetCreationTimestamp().Time
+	vt.jobName = vm.GetLabels()["kube-burner-job"]
+}
+
+// handleVMI records the VMI milestones, VMIs are named after their VM
+func (vbw *vmiBootWatcher) handleVMI(vmi *unstructured.Unstructured) {
+	vbw.mu.Lock()
+	defer vbw.mu.Unlock()
+	vt := vbw.timestamps(vmi.GetNamespace(), vmi.GetName())
+	vt.nodeName, _, _ = unstructured.NestedString(vmi.Object, "status", "nodeName")
+	if phase, _, _ := unstructured.NestedString(vmi.Object, "status", "phase"); phase == "Running" && vt.running.IsZero() {
+		vt.running = time.Now()
+	}
+	conditions, _, _ := unstructured.NestedSlice(vmi.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "AgentConnected" || condition["status"] != "True" || !vt.booted.IsZero() {
+			continue
+		}
+		// The transition time isn't always set, so fall back to the time the condition is observed
+		vt.booted = time.Now()
+		if lastTransitionTime, ok := condition["lastTransitionTime"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, lastTransitionTime); err == nil {
+				vt.booted = t
+			}
+		}
+	}
+}
+
+// stop stops watching and indexes the boot latencies of the running VMIs, and their quantiles per job
+func (vbw *vmiBootWatcher) stop(wh *workloads.WorkloadHelper) {
+	close(vbw.stopCh)
+	vbw.mu.Lock()
+	defer vbw.mu.Unlock()
+	var docs, quantiles []interface{}
+	var jobNames []string
+	latencies := make(map[string]map[string][]float64)
+	for _, vt := range vbw.vms {
+		if vt.created.IsZero() || vt.running.IsZero() {
+			continue
+		}
+		vb := vmiBoot{
+			Timestamp:         vt.created.UTC(),
+			UUID:              wh.UUID,
+			Namespace:         vt.namespace,
+			Name:              vt.name,
+			NodeName:          vt.nodeName,
+			VMIRunningLatency: max(int(vt.running.Sub(vt.created).Milliseconds()), 0),
+			MetricName:        vmiBootMetric,
+			JobName:           vt.jobName,
+			Metadata:          wh.MetricsMetadata,
+		}
+		if _, exists := latencies[vb.JobName]; !exists {
+			jobNames = append(jobNames, vb.JobName)
+			latencies[vb.JobName] = make(map[string][]float64)
+		}
+		latencies[vb.JobName]["VMIRunning"] = append(latencies[vb.JobName]["VMIRunning"], float64(vb.VMIRunningLatency))
+		if !vt.booted.IsZero() {
+			vb.GuestBootedLatency = max(int(vt.booted.Sub(vt.created).Milliseconds()), 0)
+			latencies[vb.JobName]["GuestBooted"] = append(latencies[vb.JobName]["GuestBooted"], float64(vb.GuestBootedLatency))
+		}
+		docs = append(docs, vb)
+	}
+	if len(docs) == 0 {
+		log.Warn("No running VMIs found")
+		return
+	}
+	for _, jobName := range jobNames {
+		for condition, values := range latencies[jobName] {
+			q := metrics.NewLatencySummary(values, condition)
+			q.UUID = wh.UUID
+			q.MetricName = vmiBootQuantilesMetric
+			q.JobName = jobName
+			q.Metadata = wh.MetricsMetadata
+			quantiles = append(quantiles, q)
+		}
+	}
+	indexDocuments(vmiBootMetric, docs)
+	indexDocuments(vmiBootQuantilesMetric, quantiles)
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/workloads"
+	log "github.com/sirupsen/logrus"
+)
+
+// runWarmup runs the given iterations of the workload before the measured run, so the images are pulled and the caches of
+// the cluster are warm. The warm-up doesn't churn nor index anything, and its resources are garbage collected once it
+// finishes. Only the workloads sized by JOB_ITERATIONS support it, and it's skipped in the rest
+func runWarmup(wh *workloads.WorkloadHelper, workload string, iterations int) error {
+	if _, ok := os.LookupEnv("JOB_ITERATIONS"); !ok {
+		log.Warnf("%s doesn't support warm-up iterations, skipping the warm-up", workload)
+		return nil
+	}
+	defer StartSpan("warmup")()
+	qps, _ := strconv.Atoi(os.Getenv("QPS"))
+	burst, _ := strconv.Atoi(os.Getenv("BURST"))
+	// A single step, without ramp-up nor churn
+	jobSteps, _ := json.Marshal(rampSchedule(iterations, qps, burst, 0, 0, 0))
+	overrides := map[string]string{
+		"JOB_ITERATIONS": fmt.Sprint(iterations),
+		"JOB_STEPS":      string(jobSteps),
+		"CHURN":          "false",
+		"GC":             "true",
+		"GC_METRICS":     "false",
+		"ES_SERVER":      "",
+		"LOCAL_INDEXING": "false",
+		"LOCAL_RESULTS":  "false",
+		"PPROF_TARGETS":  "",
+	}
+	for name, value := range overrides {
+		previous, set := os.LookupEnv(name)
+		os.Setenv(name, value)
+		if set {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+	// The metrics endpoints given by --metrics-endpoint are used regardless of the configuration
+	metricsEndpoint := wh.MetricsEndpoint
+	wh.MetricsEndpoint = ""
+	defer func() { wh.MetricsEndpoint = metricsEndpoint }()
+	log.Infof("Running %d warm-up iterations of %s", iterations, workload)
+	start := time.Now()
+	// The warm-up measures a cold cluster, so its rc, which accounts the thresholds of the measurements, is ignored
+	rc, err := runKubeBurner(wh, workload)
+	if err != nil {
+		return err
+	}
+	if rc != 0 {
+		log.Warnf("Warm-up finished with rc %d, running the workload anyway", rc)
+	}
+	log.Infof("Warm-up took %v", time.Since(start).Round(time.Second))
+	return nil
+}
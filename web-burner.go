@@ -25,7 +25,7 @@ import (
 
 // NewClusterDensity holds cluster-density workload
 func NewWebBurner(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
-	var limitcount, scale int
+	var limitcount, scale, lbPods, appPods, normalPods int
 	var bfd, crd, icni, probe, sriov bool
 	var bridge string
 	var podReadyThreshold time.Duration
@@ -34,7 +34,14 @@ func NewWebBurner(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   variant,
 		Short: fmt.Sprintf("Runs %v workload", variant),
-		PreRun: func(cmd *cobra.Command, args []string) {
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// The gateway IPs of the lb pods are allocated from the third octet 219 to 223 of their /21 network
+			if lbPods < 1 || lbPods > 5 {
+				return fmt.Errorf("--lb-pods must be between 1 and 5")
+			}
+			os.Setenv("LB_PODS", fmt.Sprint(lbPods))
+			os.Setenv("APP_PODS", fmt.Sprint(appPods))
+			os.Setenv("NORMAL_PODS", fmt.Sprint(normalPods))
 			os.Setenv("BFD", fmt.Sprint(bfd))
 			os.Setenv("BRIDGE", fmt.Sprint(bridge))
 			os.Setenv("CRD", fmt.Sprintf("%v", crd))
@@ -44,24 +51,40 @@ func NewWebBurner(wh *workloads.WorkloadHelper, variant string) *cobra.Command {
 			os.Setenv("PROBE", fmt.Sprint(probe))
 			os.Setenv("SCALE", fmt.Sprint(scale))
 			os.Setenv("SRIOV", fmt.Sprint(sriov))
+			return nil
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			setMetrics(cmd, metricsProfiles)
-			rc = wh.Run(cmd.Name())
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setMetrics(cmd, metricsProfiles); err != nil {
+				return err
+			}
+			var err error
+			rc, err = runWorkload(cmd, wh, cmd.Name())
+			return err
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			os.Exit(rc)
 		},
 	}
 	cmd.Flags().DurationVar(&podReadyThreshold, "pod-ready-threshold", 2*time.Minute, "Pod ready timeout threshold")
-	cmd.Flags().IntVar(&limitcount, "limitcount", 1, "Limitcount")
-	cmd.Flags().IntVar(&scale, "scale", 1, "Scale")
+	cmd.Flags().IntVar(&limitcount, "limitcount", 1, "Namespaces of each kind per scale unit, 35 in a full size lab")
+	cmd.Flags().IntVar(&scale, "scale", 1, "Scale factor, multiplying the namespaces given by --limitcount")
 	cmd.Flags().BoolVar(&bfd, "bfd", true, "Enable BFD")
 	cmd.Flags().BoolVar(&crd, "crd", true, "Enable AdminPolicyBasedExternalRoute CR")
 	cmd.Flags().BoolVar(&icni, "icni", true, "Enable ICNI functionality")
 	cmd.Flags().BoolVar(&probe, "probe", false, "Enable readiness probes")
-	cmd.Flags().BoolVar(&sriov, "sriov", true, "Enable SRIOV")
+	cmd.Flags().BoolVar(&sriov, "sriov", true, "Attach the lb pods to SR-IOV networks, or to macvlan networks when disabled")
 	cmd.Flags().StringVar(&bridge, "bridge", "br-ex", "Data-plane bridge")
+	// Sizing of the variant, whose defaults are the ones of a full size lab
+	lbPods = 4
+	switch variant {
+	case "web-burner-init":
+		cmd.Flags().IntVar(&lbPods, "lb-pods", 4, "Emulated SPK/ICNI lb pods of each lb namespace, up to 5")
+	case "web-burner-node-density":
+		cmd.Flags().IntVar(&appPods, "app-pods", 3, "App pods of each served namespace")
+		cmd.Flags().IntVar(&normalPods, "normal-pods", 60, "Normal pods of each served namespace, endpoints of its service")
+	case "web-burner-cluster-density":
+		cmd.Flags().IntVar(&normalPods, "normal-pods", 25, "Normal pods of each served namespace, each one with its service")
+	}
 	cmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics.yml"}, "Comma separated list of metrics profiles to use")
 	return cmd
 }
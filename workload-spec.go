@@ -0,0 +1,80 @@
+// Copyright 2024 The Kube-burner Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocp
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/kube-burner/kube-burner/pkg/util"
+	"gopkg.in/yaml.v3"
+)
+
+// workloadSpec holds the jobs of a rendered workload configuration, with the fields kube-burner-ocp reads ahead of the run
+type workloadSpec struct {
+	Jobs []workloadJob `yaml:"jobs"`
+}
+
+type workloadJob struct {
+	Name    string           `yaml:"name"`
+	JobType string           `yaml:"jobType"`
+	Objects []workloadObject `yaml:"objects"`
+}
+
+type workloadObject struct {
+	ObjectTemplate string                 `yaml:"objectTemplate"`
+	Replicas       int                    `yaml:"replicas"`
+	RunOnce        bool                   `yaml:"runOnce"`
+	InputVars      map[string]interface{} `yaml:"inputVars"`
+}
+
+// workloadFS returns where the configuration of the given workload and its object templates are read from. Like kube-burner,
+// a configuration file named after the workload in the current directory, i.e. an extracted one, takes precedence
+func workloadFS(workload string) (*embed.FS, string) {
+	if _, err := os.Stat(workload + ".yml"); err == nil {
+		return nil, ""
+	}
+	return &ocpConfig, path.Join(configDir, workload)
+}
+
+// readWorkloadFile reads the configuration or an object template of the given workload
+func readWorkloadFile(workload, name string) ([]byte, error) {
+	embedFS, embedFSDir := workloadFS(workload)
+	f, err := util.GetReader(name, embedFS, embedFSDir)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// renderWorkloadSpec renders the configuration of the given workload with the environment variables, overridden by the given ones
+func renderWorkloadSpec(workload string, cfg []byte, overrides map[string]interface{}) (workloadSpec, error) {
+	var spec workloadSpec
+	inputData := util.EnvToMap()
+	for k, v := range overrides {
+		inputData[k] = v
+	}
+	rendered, err := util.RenderTemplate(cfg, inputData, util.MissingKeyError)
+	if err != nil {
+		return spec, fmt.Errorf("error rendering configuration of %s: %v", workload, err)
+	}
+	if err := yaml.Unmarshal(rendered, &spec); err != nil {
+		return spec, fmt.Errorf("error decoding configuration of %s: %v", workload, err)
+	}
+	return spec, nil
+}